@@ -0,0 +1,196 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/service"
+	"github.com/Financial-Times/neo-model-utils-go/mapper"
+)
+
+// ConceptType describes how the writer handles the concepts of one type
+type ConceptType struct {
+	Name string `json:"name"`
+	// DirectTypes are the direct types accepted for the concept type, any direct type is accepted if empty
+	DirectTypes []string `json:"directTypes,omitempty"`
+	// RequiredFields are the payload fields which must be present and not empty
+	RequiredFields []string `json:"requiredFields,omitempty"`
+	// ExtraFields are the optional fields indexed on top of those written by the converter of the type
+	ExtraFields []string `json:"extraFields,omitempty"`
+	Sync        bool     `json:"sync"`
+	Bulk        bool     `json:"bulk"`
+	// Cleanup is true when the concorded concepts are deleted after a write
+	Cleanup bool `json:"cleanup"`
+}
+
+// AllowsDirectType tells whether a concept with the given direct type can be written
+func (t *ConceptType) AllowsDirectType(directType string) bool {
+	if len(t.DirectTypes) == 0 {
+		return true
+	}
+	for _, allowed := range t.DirectTypes {
+		if allowed == directType {
+			return true
+		}
+	}
+	return false
+}
+
+// ConceptTypes is the configuration of every concept type the writer handles
+type ConceptTypes struct {
+	Types []ConceptType `json:"types"`
+	// Source is the file the configuration has been loaded from, empty if it comes from the whitelist
+	Source string `json:"source,omitempty"`
+
+	byName map[string]*ConceptType
+}
+
+// Get returns the configuration of a concept type, or nil if the type is not handled
+func (c *ConceptTypes) Get(name string) *ConceptType {
+	return c.byName[name]
+}
+
+// Names returns the names of the handled concept types, sorted
+func (c *ConceptTypes) Names() []string {
+	var names []string
+	for _, t := range c.Types {
+		names = append(names, t.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newConceptTypes(types []ConceptType, source string) *ConceptTypes {
+	c := &ConceptTypes{Types: types, Source: source, byName: make(map[string]*ConceptType)}
+	for i := range c.Types {
+		c.byName[c.Types[i].Name] = &c.Types[i]
+	}
+	return c
+}
+
+// FromWhitelist returns the configuration of the given concept types with the default behaviour: any direct type,
+// no required field besides those of the concept models, sync and bulk writes and cleanup allowed
+func FromWhitelist(names []string) *ConceptTypes {
+	var types []ConceptType
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		types = append(types, ConceptType{Name: name, Sync: true, Bulk: true, Cleanup: true})
+	}
+	return newConceptTypes(types, "")
+}
+
+func conceptTypesFromFile(file []conceptTypeEntry) (*ConceptTypes, error) {
+	var types []ConceptType
+	for _, t := range file {
+		types = append(types, ConceptType{
+			Name:           t.Name,
+			DirectTypes:    t.DirectTypes,
+			RequiredFields: t.RequiredFields,
+			ExtraFields:    t.ExtraFields,
+			Sync:           boolOrDefault(t.Sync),
+			Bulk:           boolOrDefault(t.Bulk),
+			Cleanup:        boolOrDefault(t.Cleanup),
+		})
+	}
+
+	if err := validate(types); err != nil {
+		return nil, err
+	}
+	return newConceptTypes(types, ""), nil
+}
+
+// LoadConceptTypes reads and validates the concept types of a YAML or JSON configuration file
+func LoadConceptTypes(path string) (*ConceptTypes, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.ConceptTypes, nil
+}
+
+// ParseConceptTypes parses and validates the concept types of a YAML or JSON configuration
+func ParseConceptTypes(data []byte) (*ConceptTypes, error) {
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.ConceptTypes, nil
+}
+
+func boolOrDefault(b *bool) bool {
+	return b == nil || *b
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+func validate(types []ConceptType) error {
+	if len(types) == 0 {
+		return errors.New("no concept types configured")
+	}
+
+	fields := payloadFields()
+	seen := make(map[string]bool)
+	verr := &ValidationError{}
+	for i, t := range types {
+		if t.Name == "" {
+			verr.Problems = append(verr.Problems, fmt.Sprintf("type %d has no name", i))
+			continue
+		}
+		if seen[t.Name] {
+			verr.Problems = append(verr.Problems, fmt.Sprintf("%s: configured more than once", t.Name))
+		}
+		seen[t.Name] = true
+
+		for _, directType := range t.DirectTypes {
+			if len(mapper.TypeURIs([]string{directType})) == 0 {
+				verr.Problems = append(verr.Problems, fmt.Sprintf("%s: unknown direct type %s", t.Name, directType))
+			}
+		}
+		for _, field := range t.RequiredFields {
+			if !fields[field] {
+				verr.Problems = append(verr.Problems, fmt.Sprintf("%s: unknown required field %s", t.Name, field))
+			}
+		}
+		for _, field := range t.ExtraFields {
+			if !service.IsExtraField(field) {
+				verr.Problems = append(verr.Problems, fmt.Sprintf("%s: field %s cannot be indexed, expected one of %s", t.Name, field, strings.Join(service.ExtraFields(), ", ")))
+			}
+		}
+		if !t.Sync && !t.Bulk {
+			verr.Problems = append(verr.Problems, fmt.Sprintf("%s: neither sync nor bulk writes are allowed", t.Name))
+		}
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// payloadFields returns the JSON fields of both concept models
+func payloadFields() map[string]bool {
+	fields := make(map[string]bool)
+	for _, model := range []interface{}{service.ConceptModel{}, service.AggregateConceptModel{}} {
+		t := reflect.TypeOf(model)
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+			if name != "" && name != "-" {
+				fields[name] = true
+			}
+		}
+	}
+	return fields
+}
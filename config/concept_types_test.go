@@ -0,0 +1,110 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConceptTypesYAML(t *testing.T) {
+	types, err := ParseConceptTypes([]byte(`
+types:
+  - name: organisations
+    directTypes: [Organisation, PublicCompany]
+    requiredFields: [prefLabel]
+    extraFields: [countryCode]
+    bulk: false
+  - name: genres
+    cleanup: false
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"genres", "organisations"}, types.Names())
+	assert.Equal(t, &ConceptType{
+		Name:           "organisations",
+		DirectTypes:    []string{"Organisation", "PublicCompany"},
+		RequiredFields: []string{"prefLabel"},
+		ExtraFields:    []string{"countryCode"},
+		Sync:           true,
+		Bulk:           false,
+		Cleanup:        true,
+	}, types.Get("organisations"))
+	assert.Equal(t, &ConceptType{Name: "genres", Sync: true, Bulk: true, Cleanup: false}, types.Get("genres"))
+	assert.Nil(t, types.Get("people"))
+}
+
+func TestParseConceptTypesJSON(t *testing.T) {
+	types, err := ParseConceptTypes([]byte(`{"types":[{"name":"people","directTypes":["Person"],"sync":true}]}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, &ConceptType{Name: "people", DirectTypes: []string{"Person"}, Sync: true, Bulk: true, Cleanup: true}, types.Get("people"))
+}
+
+func TestParseConceptTypesReportsEveryProblem(t *testing.T) {
+	_, err := ParseConceptTypes([]byte(`
+types:
+  - name: organisations
+    directTypes: [Spaceship]
+    requiredFields: [colour]
+    extraFields: [scopeNote]
+  - name: organisations
+    sync: false
+    bulk: false
+  - directTypes: [Person]
+`))
+	require.Error(t, err)
+	verr, ok := err.(*ValidationError)
+	require.True(t, ok)
+	assert.Equal(t, []string{
+		"organisations: unknown direct type Spaceship",
+		"organisations: unknown required field colour",
+		"organisations: field scopeNote cannot be indexed, expected one of countryCode, countryOfIncorporation",
+		"organisations: configured more than once",
+		"organisations: neither sync nor bulk writes are allowed",
+		"type 2 has no name",
+	}, verr.Problems)
+}
+
+func TestParseConceptTypesRejectsUnknownSettings(t *testing.T) {
+	_, err := ParseConceptTypes([]byte(`
+types:
+  - name: genres
+    synch: false
+`))
+	assert.Error(t, err)
+
+	_, err = ParseConceptTypes([]byte(`types: []`))
+	assert.EqualError(t, err, "no concept types configured")
+}
+
+func TestLoadConceptTypes(t *testing.T) {
+	types, err := LoadConceptTypes("../concept-types.yml")
+	require.NoError(t, err)
+
+	assert.Equal(t, "../concept-types.yml", types.Source)
+	assert.Equal(t, []string{"alphaville-series", "brands", "genres", "locations", "memberships", "organisations", "people", "sections", "subjects", "topics"}, types.Names())
+
+	_, err = LoadConceptTypes("missing.yml")
+	assert.Error(t, err)
+}
+
+func TestFromWhitelist(t *testing.T) {
+	types := FromWhitelist([]string{"genres", " people", ""})
+
+	assert.Equal(t, []string{"genres", "people"}, types.Names())
+	assert.Equal(t, &ConceptType{Name: "people", Sync: true, Bulk: true, Cleanup: true}, types.Get("people"))
+	assert.True(t, types.Get("people").AllowsDirectType("Anything"))
+
+	data, err := json.Marshal(types)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"types":[{"name":"genres","sync":true,"bulk":true,"cleanup":true},{"name":"people","sync":true,"bulk":true,"cleanup":true}]}`, string(data))
+}
+
+func TestAllowsDirectType(t *testing.T) {
+	organisations := &ConceptType{Name: "organisations", DirectTypes: []string{"Organisation", "PublicCompany"}}
+
+	assert.True(t, organisations.AllowsDirectType("PublicCompany"))
+	assert.False(t, organisations.AllowsDirectType("Person"))
+}
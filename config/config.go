@@ -0,0 +1,159 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of the service which can be changed without a restart
+type Config struct {
+	ConceptTypes *ConceptTypes
+	// BulkProcessor overrides the bulk processor settings of the command line, if set
+	BulkProcessor BulkProcessor
+	// BulkLanes overrides the bulk processor settings of the lanes of the workloads, if set
+	BulkLanes map[string]BulkProcessor
+	// LogLevel overrides the log level of the command line, if set
+	LogLevel string
+}
+
+// BulkProcessor holds the bulk processor settings. Zero values are not set.
+type BulkProcessor struct {
+	Workers       int           `yaml:"workers"`
+	Requests      int           `yaml:"requests"`
+	Size          int           `yaml:"size"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+}
+
+// UnmarshalJSON reads the flush interval as a duration string, e.g. 5s, as in YAML
+func (b *BulkProcessor) UnmarshalJSON(data []byte) error {
+	var settings struct {
+		Workers       int    `json:"workers"`
+		Requests      int    `json:"requests"`
+		Size          int    `json:"size"`
+		FlushInterval string `json:"flushInterval"`
+	}
+	if err := decodeJSONStrict(data, &settings); err != nil {
+		return err
+	}
+
+	*b = BulkProcessor{Workers: settings.Workers, Requests: settings.Requests, Size: settings.Size}
+	if settings.FlushInterval == "" {
+		return nil
+	}
+	interval, err := time.ParseDuration(settings.FlushInterval)
+	if err != nil {
+		return fmt.Errorf("invalid flush interval %s: %w", settings.FlushInterval, err)
+	}
+	b.FlushInterval = interval
+	return nil
+}
+
+// bulkLaneWorkloads are the workloads which can have a bulk processor of their own
+var bulkLaneWorkloads = map[string]bool{"sync": true, "bulk": true, "metrics": true}
+
+// configFile is the format of the configuration file
+type configFile struct {
+	Types         []conceptTypeEntry       `yaml:"types" json:"types"`
+	BulkProcessor BulkProcessor            `yaml:"bulkProcessor" json:"bulkProcessor"`
+	BulkLanes     map[string]BulkProcessor `yaml:"bulkLanes" json:"bulkLanes"`
+	LogLevel      string                   `yaml:"logLevel" json:"logLevel"`
+}
+
+// conceptTypeEntry is the configuration of a concept type in the file. The flags are pointers so that they can default to true.
+type conceptTypeEntry struct {
+	Name           string   `yaml:"name" json:"name"`
+	DirectTypes    []string `yaml:"directTypes" json:"directTypes"`
+	RequiredFields []string `yaml:"requiredFields" json:"requiredFields"`
+	ExtraFields    []string `yaml:"extraFields" json:"extraFields"`
+	Sync           *bool    `yaml:"sync" json:"sync"`
+	Bulk           *bool    `yaml:"bulk" json:"bulk"`
+	Cleanup        *bool    `yaml:"cleanup" json:"cleanup"`
+}
+
+// Load reads and validates a YAML or JSON configuration file
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %w", err)
+	}
+
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %w", path, err)
+	}
+	cfg.ConceptTypes.Source = path
+	return cfg, nil
+}
+
+// Parse parses and validates a YAML or JSON configuration. A configuration starting with { is JSON.
+func Parse(data []byte) (*Config, error) {
+	var file configFile
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := decodeJSONStrict(trimmed, &file); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+
+	types, err := conceptTypesFromFile(file.Types)
+	if err != nil {
+		return nil, err
+	}
+
+	verr := &ValidationError{}
+	if file.LogLevel != "" {
+		if _, err := logrus.ParseLevel(file.LogLevel); err != nil {
+			verr.Problems = append(verr.Problems, fmt.Sprintf("invalid log level %s", file.LogLevel))
+		}
+	}
+	if file.BulkProcessor.negative() {
+		verr.Problems = append(verr.Problems, "bulk processor settings cannot be negative")
+	}
+	for _, workload := range sortedKeys(file.BulkLanes) {
+		if !bulkLaneWorkloads[workload] {
+			verr.Problems = append(verr.Problems, fmt.Sprintf("unknown bulk lane %s", workload))
+		} else if file.BulkLanes[workload].negative() {
+			verr.Problems = append(verr.Problems, fmt.Sprintf("bulk processor settings of the %s lane cannot be negative", workload))
+		}
+	}
+	if len(verr.Problems) > 0 {
+		return nil, verr
+	}
+
+	return &Config{ConceptTypes: types, BulkProcessor: file.BulkProcessor, BulkLanes: file.BulkLanes, LogLevel: file.LogLevel}, nil
+}
+
+// decodeJSONStrict decodes a JSON document, refusing the unknown fields as yaml.UnmarshalStrict does
+func decodeJSONStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after the JSON document")
+	}
+	return nil
+}
+
+func (b BulkProcessor) negative() bool {
+	return b.Workers < 0 || b.Requests < 0 || b.Size < 0 || b.FlushInterval < 0
+}
+
+// sortedKeys returns the workloads of the lanes in order, so that the problems are reported in the same order
+func sortedKeys(lanes map[string]BulkProcessor) []string {
+	keys := make([]string, 0, len(lanes))
+	for k := range lanes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+types:
+  - name: genres
+bulkProcessor:
+  workers: 4
+  flushInterval: 5s
+bulkLanes:
+  metrics:
+    requests: 100
+    flushInterval: 1s
+logLevel: debug
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"genres"}, cfg.ConceptTypes.Names())
+	assert.Equal(t, BulkProcessor{Workers: 4, FlushInterval: 5 * time.Second}, cfg.BulkProcessor)
+	assert.Equal(t, map[string]BulkProcessor{"metrics": {Requests: 100, FlushInterval: time.Second}}, cfg.BulkLanes)
+	assert.Equal(t, "debug", cfg.LogLevel)
+}
+
+func TestParseJSON(t *testing.T) {
+	cfg, err := Parse([]byte("{\n\t\"types\": [\n\t\t{\"name\": \"genres\", \"sync\": false}\n\t],\n" +
+		"\t\"bulkProcessor\": {\"workers\": 4, \"flushInterval\": \"5s\"},\n" +
+		"\t\"bulkLanes\": {\"metrics\": {\"requests\": 100}},\n" +
+		"\t\"logLevel\": \"debug\"\n}\n"))
+	require.NoError(t, err, "a tab-indented JSON configuration should be accepted")
+
+	assert.Equal(t, []string{"genres"}, cfg.ConceptTypes.Names())
+	assert.False(t, cfg.ConceptTypes.Get("genres").Sync)
+	assert.Equal(t, BulkProcessor{Workers: 4, FlushInterval: 5 * time.Second}, cfg.BulkProcessor)
+	assert.Equal(t, map[string]BulkProcessor{"metrics": {Requests: 100}}, cfg.BulkLanes)
+	assert.Equal(t, "debug", cfg.LogLevel)
+
+	_, err = Parse([]byte("{\n\t\"types\": [{\"name\": \"genres\", \"bulkOnly\": true}]\n}"))
+	assert.Error(t, err, "the unknown fields should be refused")
+	_, err = Parse([]byte("{\"types\": [{\"name\": \"genres\"}], \"bulkProcessor\": {\"flushInterval\": \"soon\"}}"))
+	assert.Error(t, err, "the flush interval should be a duration")
+}
+
+func TestParseWithoutOverrides(t *testing.T) {
+	cfg, err := Parse([]byte(`{"types":[{"name":"genres"}]}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, BulkProcessor{}, cfg.BulkProcessor)
+	assert.Empty(t, cfg.BulkLanes)
+	assert.Empty(t, cfg.LogLevel)
+}
+
+func TestParseRejectsInvalidSettings(t *testing.T) {
+	_, err := Parse([]byte(`
+types:
+  - name: genres
+bulkProcessor:
+  size: -1
+bulkLanes:
+  sync:
+    workers: -1
+  backfill:
+    workers: 1
+logLevel: chatty
+`))
+	require.Error(t, err)
+	verr, ok := err.(*ValidationError)
+	require.True(t, ok)
+	assert.Equal(t, []string{
+		"invalid log level chatty",
+		"bulk processor settings cannot be negative",
+		"unknown bulk lane backfill",
+		"bulk processor settings of the sync lane cannot be negative",
+	}, verr.Problems)
+}
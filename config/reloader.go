@@ -0,0 +1,97 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+)
+
+// Reloader loads the configuration file again when it changes, when a signal is received or on demand,
+// and hands the new configuration to the apply function. An invalid configuration is reported and ignored,
+// so the service keeps running with the last valid one.
+type Reloader struct {
+	path  string
+	apply func(*Config)
+
+	lock     sync.Mutex
+	checksum [sha256.Size]byte
+}
+
+// NewReloader returns a reloader of the configuration file, which has already been applied
+func NewReloader(path string, apply func(*Config)) *Reloader {
+	r := &Reloader{path: path, apply: apply}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		r.checksum = sha256.Sum256(data)
+	}
+	return r
+}
+
+// Reload loads and applies the configuration file, even if it has not changed
+func (r *Reloader) Reload() error {
+	return r.reload(true)
+}
+
+func (r *Reloader) reload(force bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256(data)
+	if !force && checksum == r.checksum {
+		return nil
+	}
+
+	cfg, err := Parse(data)
+	if err != nil {
+		// the file is not read again until it changes
+		r.checksum = checksum
+		return err
+	}
+	cfg.ConceptTypes.Source = r.path
+
+	r.apply(cfg)
+	r.checksum = checksum
+	log.WithField("path", r.path).Info("Configuration reloaded")
+	return nil
+}
+
+// Watch checks the configuration file for changes at every interval, until the context is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reload(false); err != nil {
+				log.WithError(err).WithField("path", r.path).Error("Failed to reload the configuration, keeping the current one")
+			}
+		}
+	}
+}
+
+// ReloadOn reloads the configuration whenever a signal is received, e.g. SIGHUP, until the context is done
+func (r *Reloader) ReloadOn(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			log.WithField("signal", sig.String()).Info("Reloading the configuration")
+			if err := r.Reload(); err != nil {
+				log.WithError(err).WithField("path", r.path).Error("Failed to reload the configuration, keeping the current one")
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type appliedConfigs struct {
+	sync.Mutex
+	configs []*Config
+}
+
+func (a *appliedConfigs) apply(cfg *Config) {
+	a.Lock()
+	defer a.Unlock()
+	a.configs = append(a.configs, cfg)
+}
+
+func (a *appliedConfigs) count() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.configs)
+}
+
+func (a *appliedConfigs) last() *Config {
+	a.Lock()
+	defer a.Unlock()
+	return a.configs[len(a.configs)-1]
+}
+
+func waitForApplied(t *testing.T, applied *appliedConfigs, count int) {
+	deadline := time.Now().Add(time.Second)
+	for applied.count() < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d configurations to be applied, got %d", count, applied.count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func writeConfig(t *testing.T, path string, content string) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+}
+
+func tempConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "concept-types.yml")
+	writeConfig(t, path, content)
+	return path
+}
+
+func TestReload(t *testing.T) {
+	path := tempConfig(t, `types: [{name: genres}]`)
+	applied := &appliedConfigs{}
+	r := NewReloader(path, applied.apply)
+
+	require.NoError(t, r.Reload())
+	require.Equal(t, 1, applied.count())
+	assert.Equal(t, []string{"genres"}, applied.last().ConceptTypes.Names())
+	assert.Equal(t, path, applied.last().ConceptTypes.Source)
+
+	writeConfig(t, path, `types: [{name: genres}, {name: people}]`)
+	require.NoError(t, r.Reload())
+	require.Equal(t, 2, applied.count())
+	assert.Equal(t, []string{"genres", "people"}, applied.last().ConceptTypes.Names())
+}
+
+func TestReloadKeepsTheCurrentConfigurationWhenInvalid(t *testing.T) {
+	path := tempConfig(t, `types: [{name: genres}]`)
+	applied := &appliedConfigs{}
+	r := NewReloader(path, applied.apply)
+
+	writeConfig(t, path, `types: []`)
+	assert.EqualError(t, r.Reload(), "no concept types configured")
+	assert.Equal(t, 0, applied.count())
+
+	require.NoError(t, os.Remove(path))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, 0, applied.count())
+}
+
+func TestWatch(t *testing.T) {
+	path := tempConfig(t, `types: [{name: genres}]`)
+	applied := &appliedConfigs{}
+	r := NewReloader(path, applied.apply)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, applied.count(), "an unchanged file should not be applied")
+
+	writeConfig(t, path, `types: [{name: people}]`)
+	waitForApplied(t, applied, 1)
+	assert.Equal(t, []string{"people"}, applied.last().ConceptTypes.Names())
+}
+
+func TestReloadOn(t *testing.T) {
+	path := tempConfig(t, `types: [{name: genres}]`)
+	applied := &appliedConfigs{}
+	r := NewReloader(path, applied.apply)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	go r.ReloadOn(ctx, signals)
+
+	signals <- syscall.SIGHUP
+	waitForApplied(t, applied, 1)
+	assert.Equal(t, []string{"genres"}, applied.last().ConceptTypes.Names())
+}
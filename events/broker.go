@@ -0,0 +1,99 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// StreamedEvent is a change event with the sequence number assigned to it by the Broker
+type StreamedEvent struct {
+	ID    uint64
+	Event ChangeEvent
+}
+
+// Subscription receives the events published to a Broker after it has been created.
+// The Events channel is closed when the subscription is cancelled, or when the subscriber falls too far behind.
+type Subscription struct {
+	Events <-chan StreamedEvent
+	events chan StreamedEvent
+}
+
+// Broker fans change events out to live subscribers and keeps a bounded buffer of the most recent events,
+// so that subscribers can resume from the last event they have seen.
+type Broker struct {
+	sync.Mutex
+	lastID           uint64
+	replay           []StreamedEvent // ring buffer of the most recent events, the oldest one at replayHead once it is full
+	replayHead       int
+	replaySize       int
+	subscriberBuffer int
+	subscribers      map[*Subscription]struct{}
+}
+
+func NewBroker(replaySize int, subscriberBuffer int) *Broker {
+	return &Broker{
+		replaySize:       replaySize,
+		subscriberBuffer: subscriberBuffer,
+		subscribers:      make(map[*Subscription]struct{}),
+	}
+}
+
+func (b *Broker) Publish(ctx context.Context, event ChangeEvent) error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.lastID++
+	streamed := StreamedEvent{ID: b.lastID, Event: event}
+
+	switch {
+	case len(b.replay) < b.replaySize:
+		b.replay = append(b.replay, streamed)
+	case b.replaySize > 0:
+		b.replay[b.replayHead] = streamed
+		b.replayHead = (b.replayHead + 1) % b.replaySize
+	}
+
+	for s := range b.subscribers {
+		select {
+		case s.events <- streamed:
+		default:
+			// the subscriber cannot keep up, it has to reconnect and resume from the replay buffer
+			b.cancel(s)
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a new subscriber. It returns the buffered events published after lastEventID,
+// which the subscriber should process before reading from the subscription.
+func (b *Broker) Subscribe(lastEventID uint64) (*Subscription, []StreamedEvent) {
+	b.Lock()
+	defer b.Unlock()
+
+	var missed []StreamedEvent
+	for i := range b.replay {
+		if e := b.replay[(b.replayHead+i)%len(b.replay)]; e.ID > lastEventID {
+			missed = append(missed, e)
+		}
+	}
+
+	events := make(chan StreamedEvent, b.subscriberBuffer)
+	s := &Subscription{Events: events, events: events}
+	b.subscribers[s] = struct{}{}
+	return s, missed
+}
+
+// Unsubscribe cancels the subscription and closes its Events channel
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.cancel(s)
+}
+
+func (b *Broker) cancel(s *Subscription) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
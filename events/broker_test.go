@@ -0,0 +1,106 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokerDeliversEventsToSubscribers(t *testing.T) {
+	b := NewBroker(10, 10)
+	s1, missed1 := b.Subscribe(0)
+	s2, missed2 := b.Subscribe(0)
+	assert.Empty(t, missed1)
+	assert.Empty(t, missed2)
+
+	require.NoError(t, b.Publish(context.Background(), ChangeEvent{Type: Created, UUID: "uuid-1"}))
+
+	for _, s := range []*Subscription{s1, s2} {
+		e := <-s.Events
+		assert.Equal(t, uint64(1), e.ID)
+		assert.Equal(t, "uuid-1", e.Event.UUID)
+	}
+}
+
+func TestBrokerReplaysEventsAfterLastEventID(t *testing.T) {
+	b := NewBroker(3, 10)
+	for _, uuid := range []string{"uuid-1", "uuid-2", "uuid-3", "uuid-4", "uuid-5"} {
+		require.NoError(t, b.Publish(context.Background(), ChangeEvent{Type: Updated, UUID: uuid}))
+	}
+
+	_, missed := b.Subscribe(3)
+	require.Len(t, missed, 2)
+	assert.Equal(t, uint64(4), missed[0].ID)
+	assert.Equal(t, "uuid-4", missed[0].Event.UUID)
+	assert.Equal(t, uint64(5), missed[1].ID)
+
+	_, missed = b.Subscribe(0)
+	require.Len(t, missed, 3, "only the most recent events are kept")
+	assert.Equal(t, uint64(3), missed[0].ID)
+}
+
+func TestBrokerReplaysEventsInOrderOnceTheBufferWrapsAround(t *testing.T) {
+	b := NewBroker(3, 10)
+	for i := 1; i <= 8; i++ {
+		require.NoError(t, b.Publish(context.Background(), ChangeEvent{Type: Updated}))
+
+		_, missed := b.Subscribe(0)
+		var ids []uint64
+		for _, e := range missed {
+			ids = append(ids, e.ID)
+		}
+		var expected []uint64
+		for id := i - 2; id <= i; id++ {
+			if id > 0 {
+				expected = append(expected, uint64(id))
+			}
+		}
+		assert.Equal(t, expected, ids)
+	}
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	b := NewBroker(0, 1)
+	s, _ := b.Subscribe(0)
+
+	require.NoError(t, b.Publish(context.Background(), ChangeEvent{UUID: "uuid-1"}))
+	require.NoError(t, b.Publish(context.Background(), ChangeEvent{UUID: "uuid-2"}))
+
+	e, ok := <-s.Events
+	assert.True(t, ok)
+	assert.Equal(t, "uuid-1", e.Event.UUID)
+	_, ok = <-s.Events
+	assert.False(t, ok, "subscription should have been closed")
+}
+
+func TestBrokerUnsubscribe(t *testing.T) {
+	b := NewBroker(0, 1)
+	s, _ := b.Subscribe(0)
+	b.Unsubscribe(s)
+	b.Unsubscribe(s)
+
+	require.NoError(t, b.Publish(context.Background(), ChangeEvent{UUID: "uuid-1"}))
+	_, ok := <-s.Events
+	assert.False(t, ok)
+}
+
+func TestMultiPublisherPublishesToAll(t *testing.T) {
+	first := NewInMemoryPublisher()
+	second := NewInMemoryPublisher()
+	m := NewMultiPublisher(first, failingPublisher{}, second)
+
+	err := m.Publish(context.Background(), ChangeEvent{UUID: "uuid-1"})
+
+	assert.EqualError(t, err, "failed to publish change event: computer says no")
+	assert.Len(t, first.Events(), 1)
+	assert.Len(t, second.Events(), 1)
+}
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(ctx context.Context, event ChangeEvent) error {
+	return errors.New("computer says no")
+}
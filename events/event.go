@@ -0,0 +1,38 @@
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Type describes what happened to a concept document
+type Type string
+
+const (
+	Created   Type = "created"
+	Updated   Type = "updated"
+	Deleted   Type = "deleted"
+	Concorded Type = "concorded"
+)
+
+// FieldChange holds the previous and current value of a single document field
+type FieldChange struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// ChangeEvent announces a successful change to a concept document in the index
+type ChangeEvent struct {
+	Type          Type                   `json:"type"`
+	UUID          string                 `json:"uuid"`
+	ConceptType   string                 `json:"conceptType"`
+	TransactionID string                 `json:"transactionId"`
+	PrefUUID      string                 `json:"prefUUID,omitempty"` // set for concorded events, the concept the uuid was concorded into
+	Time          time.Time              `json:"time"`
+	Changes       map[string]FieldChange `json:"changes,omitempty"`
+}
+
+// Publisher delivers change events to interested parties
+type Publisher interface {
+	Publish(ctx context.Context, event ChangeEvent) error
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// InMemoryPublisher keeps every published event in memory, it is intended for tests
+type InMemoryPublisher struct {
+	sync.Mutex
+	events []ChangeEvent
+}
+
+func NewInMemoryPublisher() *InMemoryPublisher {
+	return &InMemoryPublisher{}
+}
+
+func (p *InMemoryPublisher) Publish(ctx context.Context, event ChangeEvent) error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Events returns a copy of the events published so far
+func (p *InMemoryPublisher) Events() []ChangeEvent {
+	p.Lock()
+	defer p.Unlock()
+
+	events := make([]ChangeEvent, len(p.events))
+	copy(events, p.events)
+	return events
+}
+
+// Reset discards all the events published so far
+func (p *InMemoryPublisher) Reset() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.events = nil
+}
@@ -0,0 +1,37 @@
+package events
+
+import (
+	"context"
+	"strings"
+)
+
+// MultiPublisher publishes every event to all of its publishers
+type MultiPublisher []Publisher
+
+func NewMultiPublisher(publishers ...Publisher) MultiPublisher {
+	return MultiPublisher(publishers)
+}
+
+// Publish hands the event to every publisher, even if some of them fail
+func (m MultiPublisher) Publish(ctx context.Context, event ChangeEvent) error {
+	var msgs []string
+	for _, p := range m {
+		if err := p.Publish(ctx, event); err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+
+	if len(msgs) > 0 {
+		return &MultiPublisherError{msgs}
+	}
+	return nil
+}
+
+// MultiPublisherError collects the errors returned by the publishers of a MultiPublisher
+type MultiPublisherError struct {
+	msgs []string
+}
+
+func (e *MultiPublisherError) Error() string {
+	return "failed to publish change event: " + strings.Join(e.msgs, "; ")
+}
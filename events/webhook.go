@@ -0,0 +1,108 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	log "github.com/Financial-Times/go-logger"
+	tid "github.com/Financial-Times/transactionid-utils-go"
+)
+
+var (
+	ErrPublisherQueueFull = errors.New("change event queue is full")
+	ErrPublisherClosed    = errors.New("change event publisher is closed")
+)
+
+// WebhookPublisher POSTs every change event as JSON to a configured URL.
+// Events are queued and delivered in the background so that a slow webhook does not delay writes.
+type WebhookPublisher struct {
+	sync.RWMutex
+	url        string
+	httpClient *http.Client
+	queue      chan ChangeEvent
+	done       chan struct{}
+	closed     bool
+}
+
+func NewWebhookPublisher(url string, httpClient *http.Client, queueSize int) *WebhookPublisher {
+	p := &WebhookPublisher{
+		url:        url,
+		httpClient: httpClient,
+		queue:      make(chan ChangeEvent, queueSize),
+		done:       make(chan struct{}),
+	}
+	go p.deliver()
+	return p
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, event ChangeEvent) error {
+	p.RLock()
+	defer p.RUnlock()
+
+	if p.closed {
+		return ErrPublisherClosed
+	}
+
+	select {
+	case p.queue <- event:
+		return nil
+	default:
+		return ErrPublisherQueueFull
+	}
+}
+
+// Close stops accepting events and waits until the queued ones have been delivered
+func (p *WebhookPublisher) Close() {
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.queue)
+	p.Unlock()
+
+	<-p.done
+}
+
+func (p *WebhookPublisher) deliver() {
+	defer close(p.done)
+	for event := range p.queue {
+		if err := p.post(event); err != nil {
+			log.WithError(err).
+				WithTransactionID(event.TransactionID).
+				WithField("uuid", event.UUID).
+				Error("Failed to deliver change event to webhook")
+		}
+	}
+}
+
+func (p *WebhookPublisher) post(event ChangeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(tid.TransactionIDHeader, event.TransactionID)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
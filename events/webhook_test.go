@@ -0,0 +1,79 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	tid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPublisherDeliversEvents(t *testing.T) {
+	var mu sync.Mutex
+	var received []ChangeEvent
+	var transactionIDs []string
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var event ChangeEvent
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, event)
+		transactionIDs = append(transactionIDs, r.Header.Get(tid.TransactionIDHeader))
+	}))
+	defer webhook.Close()
+
+	p := NewWebhookPublisher(webhook.URL, http.DefaultClient, 10)
+
+	created := ChangeEvent{Type: Created, UUID: "uuid-1", ConceptType: "people", TransactionID: "tid_1", Time: time.Now().UTC()}
+	deleted := ChangeEvent{Type: Deleted, UUID: "uuid-2", ConceptType: "genres", TransactionID: "tid_2", Time: time.Now().UTC()}
+	require.NoError(t, p.Publish(context.Background(), created))
+	require.NoError(t, p.Publish(context.Background(), deleted))
+	p.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 2)
+	assert.Equal(t, created.UUID, received[0].UUID)
+	assert.Equal(t, Created, received[0].Type)
+	assert.Equal(t, deleted.UUID, received[1].UUID)
+	assert.Equal(t, Deleted, received[1].Type)
+	assert.Equal(t, []string{"tid_1", "tid_2"}, transactionIDs)
+}
+
+func TestWebhookPublisherRejectsEventsWhenQueueIsFull(t *testing.T) {
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer webhook.Close()
+
+	p := NewWebhookPublisher(webhook.URL, http.DefaultClient, 1)
+
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = p.Publish(context.Background(), ChangeEvent{Type: Updated, UUID: "uuid"})
+	}
+	assert.Equal(t, ErrPublisherQueueFull, err)
+
+	close(release)
+	p.Close()
+}
+
+func TestWebhookPublisherRejectsEventsWhenClosed(t *testing.T) {
+	p := NewWebhookPublisher("http://localhost", http.DefaultClient, 1)
+	p.Close()
+
+	err := p.Publish(context.Background(), ChangeEvent{Type: Updated, UUID: "uuid"})
+	assert.Equal(t, ErrPublisherClosed, err)
+}
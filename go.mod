@@ -1,6 +1,6 @@
 module github.com/Financial-Times/concept-rw-elasticsearch
 
-go 1.14
+go 1.16
 
 require (
 	github.com/Financial-Times/go-fthealth v0.0.0-20171204124831-1b007e2b37b7
@@ -9,26 +9,23 @@ require (
 	github.com/Financial-Times/neo-model-utils-go v0.0.0-20170405082310-1a5407658c84
 	github.com/Financial-Times/service-status-go v0.0.0-20160323111542-3f5199736a3d
 	github.com/Financial-Times/transactionid-utils-go v0.2.0
+	github.com/aws/aws-sdk-go v1.34.34
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/gorilla/context v1.1.1 // indirect
 	github.com/gorilla/mux v1.6.2
 	github.com/hashicorp/go-version v0.0.0-20180716215031-270f2f71b1ee // indirect
 	github.com/jawher/mow.cli v1.0.4
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
-	github.com/onsi/ginkgo v1.12.0 // indirect
-	github.com/onsi/gomega v1.9.0 // indirect
-	github.com/pkg/errors v0.8.1
-	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/pkg/errors v0.9.1
 	github.com/rcrowley/go-metrics v0.0.0-20180503174638-e2704e165165
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.0.6
-	github.com/smartystreets/go-aws-auth v0.0.0-20180515143844-0c1422d1fdb9
-	github.com/smartystreets/gunit v1.1.3 // indirect
 	github.com/stretchr/objx v0.1.1 // indirect
 	github.com/stretchr/testify v1.2.2
-	golang.org/x/crypto v0.0.0-20180820150726-614d502a4dac // indirect
+	golang.org/x/sys v0.0.0-20191120155948-bd437916bb0e // indirect
 	gopkg.in/airbrake/gobrake.v2 v2.0.9 // indirect
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
 	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
 	gopkg.in/olivere/elastic.v5 v5.0.84
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -1,6 +1,7 @@
 package health
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -12,6 +13,17 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+var (
+	// errStorageUnsupported is returned by the service when the storage of the concepts is not an Elasticsearch index
+	errStorageUnsupported = service.ErrStorageUnsupported
+	connected             = service.ConnectionConnected
+	circuitClosed         = service.CircuitClosed
+	circuitDisabled       = service.CircuitDisabled
+)
+
+// checkTimeout bounds the calls to the cluster of a check, so that they stop once the check has timed out
+const checkTimeout = 10 * time.Second
+
 type HealthService struct {
 	esHealthService service.EsService
 }
@@ -30,20 +42,20 @@ func (service *HealthService) HealthCheckHandler() func(http.ResponseWriter, *ht
 			Description: "Concept RW ElasticSearch is an application that writes concepts into Amazon Elasticsearch cluster in batches",
 			Checks:      service.checks(true),
 		},
-		Timeout: 10 * time.Second,
+		Timeout: checkTimeout,
 	}
 
 	return fthealth.Handler(hc)
 }
 
-func (service *HealthService) checks(includeReadOnlyCheck bool) []fthealth.Check {
+func (service *HealthService) checks(includeIndexChecks bool) []fthealth.Check {
 	checks := []fthealth.Check{
 		service.esConnectivityHealthyCheck(),
 		service.esClusterIsHealthyCheck(),
 	}
 
-	if includeReadOnlyCheck {
-		checks = append(checks, service.indexIsWriteableCheck())
+	if includeIndexChecks {
+		checks = append(checks, service.connectionSupervisionCheck(), service.circuitBreakerCheck(), service.indexIsWriteableCheck(), service.indexMappingCheck())
 	}
 
 	return checks
@@ -62,7 +74,10 @@ func (service *HealthService) esClusterIsHealthyCheck() fthealth.Check {
 }
 
 func (service *HealthService) healthChecker() (string, error) {
-	output, err := service.esHealthService.GetClusterHealth()
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	output, err := service.esHealthService.GetClusterHealth(ctx)
 	if err != nil {
 		return "Cluster is not healthy: ", err
 	} else if output.Status != "green" {
@@ -85,13 +100,69 @@ func (service *HealthService) esConnectivityHealthyCheck() fthealth.Check {
 }
 
 func (service *HealthService) esConnectivityChecker() (string, error) {
-	_, err := service.esHealthService.GetClusterHealth()
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	_, err := service.esHealthService.GetClusterHealth(ctx)
 	if err != nil {
 		return "Could not connect to elasticsearch", err
 	}
 	return "Successfully connected to the cluster", nil
 }
 
+func (service *HealthService) connectionSupervisionCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:             "check-elasticsearch-connection-state",
+		BusinessImpact: "Concepts could not be read from or written to Elasticsearch until the connection is rebuilt",
+		Name:           "Check the state of the connection to Elasticsearch",
+		PanicGuide:     "https://runbooks.in.ft.com/up-crwes",
+		Severity:       2,
+		TechnicalSummary: `The probes of the Elasticsearch cluster are failing, or the client is being rebuilt.
+		The client is rebuilt after sustained probe failures, refused credentials or changed credential files,
+		and the connection attempts are retried with exponential backoff. The output shows the state of the connection.`,
+		Checker: service.connectionChecker,
+	}
+}
+
+func (service *HealthService) connectionChecker() (string, error) {
+	status, err := service.esHealthService.ConnectionStatus()
+	if err == errStorageUnsupported {
+		return "The storage of the concepts needs no connection", nil
+	}
+	if err != nil {
+		return "The state of the connection could not be checked", err
+	}
+
+	output, _ := json.Marshal(status)
+	if status.State != connected {
+		return string(output), fmt.Errorf("the connection to Elasticsearch is %s: %s", status.State, status.LastError)
+	}
+	return string(output), nil
+}
+
+func (service *HealthService) circuitBreakerCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:             "check-elasticsearch-circuit-breaker",
+		BusinessImpact: "Concepts are not read from or written to Elasticsearch until the circuit breaker closes",
+		Name:           "Check the circuit breaker of the Elasticsearch calls",
+		PanicGuide:     "https://runbooks.in.ft.com/up-crwes",
+		Severity:       1,
+		TechnicalSummary: `Too many calls to Elasticsearch have failed, and the circuit breaker refuses the reads, writes and deletes
+		with 503 rather than waiting for the timeouts of the cluster. It half-opens after a while to let trial calls through,
+		and closes once they succeed. The output shows the state of the circuit breaker.`,
+		Checker: service.circuitBreakerChecker,
+	}
+}
+
+func (service *HealthService) circuitBreakerChecker() (string, error) {
+	status := service.esHealthService.CircuitBreakerStatus()
+	output, _ := json.Marshal(status)
+	if status.State != circuitClosed && status.State != circuitDisabled {
+		return string(output), fmt.Errorf("the circuit breaker of the Elasticsearch calls is %s", status.State)
+	}
+	return string(output), nil
+}
+
 func (service *HealthService) indexIsWriteableCheck() fthealth.Check {
 	return fthealth.Check{
 		ID:             "check-elasticsearch-index-writeable",
@@ -107,7 +178,10 @@ func (service *HealthService) indexIsWriteableCheck() fthealth.Check {
 }
 
 func (service *HealthService) readOnlyChecker() (string, error) {
-	readOnly, indexName, err := service.esHealthService.IsIndexReadOnly()
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	readOnly, indexName, err := service.esHealthService.IsIndexReadOnly(ctx)
 	if err != nil {
 		return "Could not connect to elasticsearch", err
 	}
@@ -120,7 +194,44 @@ func (service *HealthService) readOnlyChecker() (string, error) {
 	return fmt.Sprintf("Elasticsearch index [%v] is writeable", indexName), nil
 }
 
+func (service *HealthService) indexMappingCheck() fthealth.Check {
+	return fthealth.Check{
+		ID:             "check-elasticsearch-index-mapping",
+		BusinessImpact: "Concepts may not be searchable by the fields missing from the mapping of the index",
+		Name:           "Check index mapping",
+		PanicGuide:     "https://runbooks.in.ft.com/up-crwes",
+		Severity:       2,
+		TechnicalSummary: `The mapping of the Elasticsearch index differs from the mapping embedded in the service.
+		The differences are listed on /__mapping. Missing types and fields can be added with PUT /__mapping/{index},
+		conflicting fields require a reindex into a new index.`,
+		Checker: service.mappingChecker,
+	}
+}
+
+func (service *HealthService) mappingChecker() (string, error) {
+	status, err := service.esHealthService.MappingStatus()
+	if err == errStorageUnsupported {
+		return "The storage of the concepts has no mapping", nil
+	}
+	if err != nil {
+		return "The mapping of the index could not be checked", err
+	}
+
+	if !status.InSync() {
+		err = fmt.Errorf("Elasticsearch index [%v] mapping differs from the embedded mapping: %d missing types, %d missing fields, %d conflicts",
+			status.Index, len(status.MissingTypes), len(status.MissingFields), len(status.Conflicts))
+		return err.Error(), err
+	}
+
+	return fmt.Sprintf("Elasticsearch index [%v] mapping matches the embedded mapping", status.Index), nil
+}
+
 func (service *HealthService) GTG() gtg.Status {
+	// the circuit breaker is checked first, as it refuses the calls to the cluster
+	if status := gtgCheck(service.circuitBreakerChecker); !status.GoodToGo {
+		return status
+	}
+
 	var statusChecker []gtg.StatusChecker
 	for _, c := range service.checks(false) {
 		checkFunc := func() gtg.Status {
@@ -143,7 +254,10 @@ func (service *HealthService) HealthDetails(writer http.ResponseWriter, req *htt
 
 	writer.Header().Set("Content-Type", "application/json")
 
-	output, err := service.esHealthService.GetClusterHealth()
+	ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+	defer cancel()
+
+	output, err := service.esHealthService.GetClusterHealth(ctx)
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
@@ -20,6 +20,9 @@ import (
 var (
 	happyESCluster   = &elastic.ClusterHealthResponse{Status: "green"}
 	unhappyESCluster = &elastic.ClusterHealthResponse{Status: "red"}
+	syncedMapping    = service.MappingStatus{Index: "indexName", Exists: true}
+	connectedStatus  = service.ConnectionStatus{State: service.ConnectionConnected}
+	closedCircuit    = service.CircuitBreakerStatus{State: service.CircuitClosed}
 )
 
 func TestHealthDetailsHealthyCluster(t *testing.T) {
@@ -111,6 +114,7 @@ func TestGoodToGoUnhealthyESCluster(t *testing.T) {
 	}
 	esService := new(EsServiceMock)
 	esService.On("GetClusterHealth").Return(unhappyESCluster, errors.New("computer says no"))
+	esService.On("CircuitBreakerStatus").Return(closedCircuit)
 	healthService := NewHealthService(esService)
 
 	//create a responseRecorder
@@ -141,6 +145,7 @@ func TestHappyGoodToGo(t *testing.T) {
 
 	esService := new(EsServiceMock)
 	esService.On("GetClusterHealth").Return(happyESCluster, nil)
+	esService.On("CircuitBreakerStatus").Return(closedCircuit)
 	healthService := NewHealthService(esService)
 
 	//create a responseRecorder
@@ -168,6 +173,9 @@ func TestHappyHealthCheck(t *testing.T) {
 	esService := new(EsServiceMock)
 	esService.On("GetClusterHealth").Return(happyESCluster, nil)
 	esService.On("IsIndexReadOnly").Return(false, "indexName", nil)
+	esService.On("MappingStatus").Return(syncedMapping, nil)
+	esService.On("ConnectionStatus").Return(connectedStatus, nil)
+	esService.On("CircuitBreakerStatus").Return(closedCircuit)
 	healthService := NewHealthService(esService)
 
 	rr := httptest.NewRecorder()
@@ -193,6 +201,9 @@ func TestHealthCheckUnhealthyESCluster(t *testing.T) {
 	esService := new(EsServiceMock)
 	esService.On("GetClusterHealth").Return(unhappyESCluster, nil)
 	esService.On("IsIndexReadOnly").Return(false, "indexName", nil)
+	esService.On("MappingStatus").Return(syncedMapping, nil)
+	esService.On("ConnectionStatus").Return(connectedStatus, nil)
+	esService.On("CircuitBreakerStatus").Return(closedCircuit)
 	healthService := NewHealthService(esService)
 
 	rr := httptest.NewRecorder()
@@ -223,6 +234,9 @@ func TestHealthCheckNoESClusterConnection(t *testing.T) {
 	esService := new(EsServiceMock)
 	esService.On("GetClusterHealth").Return(unhappyESCluster, errors.New("computer says no"))
 	esService.On("IsIndexReadOnly").Return(false, "indexName", nil)
+	esService.On("MappingStatus").Return(syncedMapping, nil)
+	esService.On("ConnectionStatus").Return(connectedStatus, nil)
+	esService.On("CircuitBreakerStatus").Return(closedCircuit)
 	healthService := NewHealthService(esService)
 
 	rr := httptest.NewRecorder()
@@ -253,6 +267,9 @@ func TestHealthCheckReadOnlyIndex(t *testing.T) {
 	esService := new(EsServiceMock)
 	esService.On("GetClusterHealth").Return(happyESCluster, nil)
 	esService.On("IsIndexReadOnly").Return(true, "indexName", nil)
+	esService.On("MappingStatus").Return(syncedMapping, nil)
+	esService.On("ConnectionStatus").Return(connectedStatus, nil)
+	esService.On("CircuitBreakerStatus").Return(closedCircuit)
 
 	healthService := NewHealthService(esService)
 
@@ -277,6 +294,203 @@ func TestHealthCheckReadOnlyIndex(t *testing.T) {
 
 }
 
+func TestHealthCheckIndexMapping(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mapping service.MappingStatus
+		err     error
+		ok      bool
+		output  string
+	}{
+		{
+			name:    "In sync",
+			mapping: syncedMapping,
+			ok:      true,
+			output:  "Elasticsearch index [indexName] mapping matches the embedded mapping",
+		},
+		{
+			name:    "Out of sync",
+			mapping: service.MappingStatus{Index: "indexName", Exists: true, MissingFields: []string{"people.isFTAuthor"}, UnexpectedFields: []string{"people.legacy"}},
+			output:  "Elasticsearch index [indexName] mapping differs from the embedded mapping: 0 missing types, 1 missing fields, 0 conflicts",
+		},
+		{
+			name:   "Not checked",
+			err:    service.ErrMappingNotChecked,
+			output: "the mapping of the index has not been checked yet",
+		},
+		{
+			name:   "No mapping",
+			err:    service.ErrStorageUnsupported,
+			ok:     true,
+			output: "The storage of the concepts has no mapping",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			esService := new(EsServiceMock)
+			esService.On("GetClusterHealth").Return(happyESCluster, nil)
+			esService.On("IsIndexReadOnly").Return(false, "indexName", nil)
+			esService.On("MappingStatus").Return(test.mapping, test.err)
+			esService.On("ConnectionStatus").Return(connectedStatus, nil)
+			esService.On("CircuitBreakerStatus").Return(closedCircuit)
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(NewHealthService(esService).HealthCheckHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/__health", nil))
+			assert.Equal(t, http.StatusOK, rr.Code, "HealthCheck should return HTTP 200 OK")
+
+			checks, err := parseHealthcheck(rr.Body.String())
+			assert.NoError(t, err, "HealthCheck Response Body should be consistent")
+
+			for _, check := range checks {
+				if check.ID == "check-elasticsearch-index-mapping" {
+					assert.Equal(t, test.ok, check.Ok)
+					assert.Equal(t, test.output, check.CheckOutput)
+				} else {
+					assert.True(t, check.Ok)
+				}
+			}
+			esService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHealthCheckConnectionState(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status service.ConnectionStatus
+		err    error
+		ok     bool
+		output string
+	}{
+		{
+			name:   "Connected",
+			status: connectedStatus,
+			ok:     true,
+			output: `{"state":"connected","consecutiveFailures":0,"reconnects":0}`,
+		},
+		{
+			name:   "Degraded",
+			status: service.ConnectionStatus{State: service.ConnectionDegraded, ConsecutiveFailures: 2, LastError: "no available connection", Reconnects: 1},
+			output: "the connection to Elasticsearch is degraded: no available connection",
+		},
+		{
+			name:   "Reconnecting",
+			status: service.ConnectionStatus{State: service.ConnectionReconnecting, ConsecutiveFailures: 3, LastError: "elastic: Error 401 (Unauthorized)"},
+			output: "the connection to Elasticsearch is reconnecting: elastic: Error 401 (Unauthorized)",
+		},
+		{
+			name:   "No connection",
+			err:    service.ErrStorageUnsupported,
+			ok:     true,
+			output: "The storage of the concepts needs no connection",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			esService := new(EsServiceMock)
+			esService.On("GetClusterHealth").Return(happyESCluster, nil)
+			esService.On("IsIndexReadOnly").Return(false, "indexName", nil)
+			esService.On("MappingStatus").Return(syncedMapping, nil)
+			esService.On("ConnectionStatus").Return(test.status, test.err)
+			esService.On("CircuitBreakerStatus").Return(closedCircuit)
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(NewHealthService(esService).HealthCheckHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/__health", nil))
+			assert.Equal(t, http.StatusOK, rr.Code, "HealthCheck should return HTTP 200 OK")
+
+			checks, err := parseHealthcheck(rr.Body.String())
+			assert.NoError(t, err, "HealthCheck Response Body should be consistent")
+
+			for _, check := range checks {
+				if check.ID == "check-elasticsearch-connection-state" {
+					assert.Equal(t, test.ok, check.Ok)
+					assert.Equal(t, test.output, check.CheckOutput)
+				} else {
+					assert.True(t, check.Ok)
+				}
+			}
+			esService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGoodToGoOpenCircuit(t *testing.T) {
+	for _, state := range []string{service.CircuitOpen, service.CircuitHalfOpen} {
+		esService := new(EsServiceMock)
+		esService.On("CircuitBreakerStatus").Return(service.CircuitBreakerStatus{State: state})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(status.NewGoodToGoHandler(NewHealthService(esService).GTG)).ServeHTTP(rr, httptest.NewRequest("GET", "/__gtg", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "the circuit breaker of the Elasticsearch calls is "+state, rr.Body.String())
+		esService.AssertExpectations(t)
+		esService.AssertNotCalled(t, "GetClusterHealth")
+	}
+}
+
+func TestHealthCheckCircuitBreaker(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status service.CircuitBreakerStatus
+		ok     bool
+		output string
+	}{
+		{
+			name:   "Closed",
+			status: service.CircuitBreakerStatus{State: service.CircuitClosed, FailureRate: 0.1, Calls: 10},
+			ok:     true,
+			output: `{"state":"closed","failureRate":0.1,"calls":10,"rejected":0}`,
+		},
+		{
+			name:   "Disabled",
+			status: service.CircuitBreakerStatus{State: service.CircuitDisabled},
+			ok:     true,
+			output: `{"state":"disabled","failureRate":0,"calls":0,"rejected":0}`,
+		},
+		{
+			name:   "Open",
+			status: service.CircuitBreakerStatus{State: service.CircuitOpen, Rejected: 12},
+			output: "the circuit breaker of the Elasticsearch calls is open",
+		},
+		{
+			name:   "Half-open",
+			status: service.CircuitBreakerStatus{State: service.CircuitHalfOpen},
+			output: "the circuit breaker of the Elasticsearch calls is half-open",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			esService := new(EsServiceMock)
+			esService.On("GetClusterHealth").Return(happyESCluster, nil)
+			esService.On("IsIndexReadOnly").Return(false, "indexName", nil)
+			esService.On("MappingStatus").Return(syncedMapping, nil)
+			esService.On("ConnectionStatus").Return(connectedStatus, nil)
+			esService.On("CircuitBreakerStatus").Return(test.status)
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(NewHealthService(esService).HealthCheckHandler()).ServeHTTP(rr, httptest.NewRequest("GET", "/__health", nil))
+			assert.Equal(t, http.StatusOK, rr.Code, "HealthCheck should return HTTP 200 OK")
+
+			checks, err := parseHealthcheck(rr.Body.String())
+			assert.NoError(t, err, "HealthCheck Response Body should be consistent")
+
+			for _, check := range checks {
+				if check.ID == "check-elasticsearch-circuit-breaker" {
+					assert.Equal(t, test.ok, check.Ok)
+					assert.Equal(t, test.output, check.CheckOutput)
+				} else {
+					assert.True(t, check.Ok)
+				}
+			}
+			esService.AssertExpectations(t)
+		})
+	}
+}
+
 type EsServiceMock struct {
 	mock.Mock
 }
@@ -286,8 +500,18 @@ func (m *EsServiceMock) LoadData(ctx context.Context, conceptType string, uuid s
 	return args.Bool(0), args.Get(1).(*elastic.IndexResponse), args.Error(1)
 }
 
-func (m *EsServiceMock) ReadData(conceptType string, uuid string) (*elastic.GetResult, error) {
-	args := m.Called(conceptType, uuid)
+func (m *EsServiceMock) PlanLoadData(ctx context.Context, conceptType string, uuid string, payload service.EsModel) (*service.WritePlan, error) {
+	args := m.Called(ctx, conceptType, uuid, payload)
+	return args.Get(0).(*service.WritePlan), args.Error(1)
+}
+
+func (m *EsServiceMock) DiffData(ctx context.Context, conceptType string, uuid string, payload service.EsModel) (*service.DocumentDiff, error) {
+	args := m.Called(ctx, conceptType, uuid, payload)
+	return args.Get(0).(*service.DocumentDiff), args.Error(1)
+}
+
+func (m *EsServiceMock) ReadData(ctx context.Context, conceptType string, uuid string) (*elastic.GetResult, error) {
+	args := m.Called(ctx, conceptType, uuid)
 	return args.Get(0).(*elastic.GetResult), args.Error(1)
 }
 
@@ -296,8 +520,9 @@ func (m *EsServiceMock) DeleteData(ctx context.Context, conceptType string, uuid
 	return args.Get(0).(*elastic.DeleteResponse), args.Error(1)
 }
 
-func (m *EsServiceMock) LoadBulkData(conceptType string, uuid string, payload interface{}) {
-	m.Called(conceptType, uuid, payload)
+func (m *EsServiceMock) LoadBulkData(ctx context.Context, conceptType string, uuid string, payload interface{}) error {
+	args := m.Called(ctx, conceptType, uuid, payload)
+	return args.Error(0)
 }
 
 func (m *EsServiceMock) PatchUpdateConcept(ctx context.Context, conceptType string, uuid string, payload service.PayloadPatch) {
@@ -308,17 +533,32 @@ func (m *EsServiceMock) CleanupData(ctx context.Context, concept service.Concept
 	m.Called(ctx, concept)
 }
 
+func (m *EsServiceMock) PlanCleanupData(ctx context.Context, concept service.Concept) ([]service.PlannedDelete, error) {
+	args := m.Called(ctx, concept)
+	return args.Get(0).([]service.PlannedDelete), args.Error(1)
+}
+
+func (m *EsServiceMock) SetBulkProcessorConfig(config *service.BulkProcessorConfig) error {
+	args := m.Called(config)
+	return args.Error(0)
+}
+
+func (m *EsServiceMock) SetBulkLaneConfig(workload service.Workload, config *service.BulkProcessorConfig) error {
+	args := m.Called(workload, config)
+	return args.Error(0)
+}
+
 func (m *EsServiceMock) CloseBulkProcessor() error {
 	args := m.Called()
 	return args.Error(0)
 }
 
-func (m *EsServiceMock) GetClusterHealth() (*elastic.ClusterHealthResponse, error) {
+func (m *EsServiceMock) GetClusterHealth(ctx context.Context) (*elastic.ClusterHealthResponse, error) {
 	args := m.Called()
 	return args.Get(0).(*elastic.ClusterHealthResponse), args.Error(1)
 }
 
-func (m *EsServiceMock) IsIndexReadOnly() (bool, string, error) {
+func (m *EsServiceMock) IsIndexReadOnly(ctx context.Context) (bool, string, error) {
 	args := m.Called()
 	return args.Bool(0), args.String(1), args.Error(2)
 }
@@ -336,3 +576,68 @@ func (m *EsServiceMock) GetAllIds(ctx context.Context) chan service.EsIDTypePair
 	args := m.Called()
 	return args.Get(0).(chan service.EsIDTypePair)
 }
+
+func (m *EsServiceMock) DualWriteStatus() service.DualWriteStatus {
+	args := m.Called()
+	return args.Get(0).(service.DualWriteStatus)
+}
+
+func (m *EsServiceMock) StopDualWrite() service.DualWriteStatus {
+	args := m.Called()
+	return args.Get(0).(service.DualWriteStatus)
+}
+
+func (m *EsServiceMock) StartReindex(target string) (service.ReindexStatus, error) {
+	args := m.Called(target)
+	return args.Get(0).(service.ReindexStatus), args.Error(1)
+}
+
+func (m *EsServiceMock) ReindexStatus() (service.ReindexStatus, error) {
+	args := m.Called()
+	return args.Get(0).(service.ReindexStatus), args.Error(1)
+}
+
+func (m *EsServiceMock) CancelReindex() (service.ReindexStatus, error) {
+	args := m.Called()
+	return args.Get(0).(service.ReindexStatus), args.Error(1)
+}
+
+func (m *EsServiceMock) MappingStatus() (service.MappingStatus, error) {
+	args := m.Called()
+	return args.Get(0).(service.MappingStatus), args.Error(1)
+}
+
+func (m *EsServiceMock) ConnectionStatus() (service.ConnectionStatus, error) {
+	args := m.Called()
+	return args.Get(0).(service.ConnectionStatus), args.Error(1)
+}
+
+func (m *EsServiceMock) CircuitBreakerStatus() service.CircuitBreakerStatus {
+	args := m.Called()
+	return args.Get(0).(service.CircuitBreakerStatus)
+}
+
+func (m *EsServiceMock) CheckMapping(ctx context.Context, index string) (service.MappingStatus, error) {
+	args := m.Called(ctx, index)
+	return args.Get(0).(service.MappingStatus), args.Error(1)
+}
+
+func (m *EsServiceMock) ApplyMapping(ctx context.Context, index string) (service.MappingStatus, error) {
+	args := m.Called(ctx, index)
+	return args.Get(0).(service.MappingStatus), args.Error(1)
+}
+
+func (m *EsServiceMock) StartReconciliation(reference service.ReferenceSource, deleteExtras bool) (service.ReconciliationReport, error) {
+	args := m.Called(reference, deleteExtras)
+	return args.Get(0).(service.ReconciliationReport), args.Error(1)
+}
+
+func (m *EsServiceMock) ReconciliationReport() (service.ReconciliationReport, error) {
+	args := m.Called()
+	return args.Get(0).(service.ReconciliationReport), args.Error(1)
+}
+
+func (m *EsServiceMock) CancelReconciliation() (service.ReconciliationReport, error) {
+	args := m.Called()
+	return args.Get(0).(service.ReconciliationReport), args.Error(1)
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/Financial-Times/concept-rw-elasticsearch/config"
+	"github.com/Financial-Times/concept-rw-elasticsearch/events"
 	"github.com/Financial-Times/concept-rw-elasticsearch/health"
 	"github.com/Financial-Times/concept-rw-elasticsearch/resources"
 	"github.com/Financial-Times/concept-rw-elasticsearch/service"
@@ -35,7 +41,7 @@ func main() {
 	})
 	accessKey := app.String(cli.StringOpt{
 		Name:   "aws-access-key",
-		Desc:   "AWS ACCESS KEY",
+		Desc:   "AWS ACCESS KEY, the credentials of the AWS credential chain are used if empty",
 		EnvVar: "AWS_ACCESS_KEY_ID",
 	})
 	secretKey := app.String(cli.StringOpt{
@@ -43,6 +49,52 @@ func main() {
 		Desc:   "AWS SECRET ACCESS KEY",
 		EnvVar: "AWS_SECRET_ACCESS_KEY",
 	})
+	sessionToken := app.String(cli.StringOpt{
+		Name:   "aws-session-token",
+		Desc:   "AWS SESSION TOKEN of a temporary access key",
+		EnvVar: "AWS_SESSION_TOKEN",
+	})
+	esRoleARN := app.String(cli.StringOpt{
+		Name:   "elasticsearch-role-arn",
+		Desc:   "The ARN of the role assumed to sign the requests to AES, if any",
+		EnvVar: "ELASTICSEARCH_ROLE_ARN",
+	})
+	esRoleSessionName := app.String(cli.StringOpt{
+		Name:   "elasticsearch-role-session-name",
+		Value:  "concept-rw-elasticsearch",
+		Desc:   "The name of the session of the assumed role",
+		EnvVar: "ELASTICSEARCH_ROLE_SESSION_NAME",
+	})
+	esUsername := app.String(cli.StringOpt{
+		Name:   "elasticsearch-username",
+		Desc:   "The username of the basic authentication to a self-managed or Elastic Cloud cluster, if any",
+		EnvVar: "ELASTICSEARCH_USERNAME",
+	})
+	esPassword := app.String(cli.StringOpt{
+		Name:   "elasticsearch-password",
+		Desc:   "The password of the basic authentication",
+		EnvVar: "ELASTICSEARCH_PASSWORD",
+	})
+	esAPIKey := app.String(cli.StringOpt{
+		Name:   "elasticsearch-api-key",
+		Desc:   "The encoded API key to authenticate to a self-managed or Elastic Cloud cluster, if any",
+		EnvVar: "ELASTICSEARCH_API_KEY",
+	})
+	esCABundle := app.String(cli.StringOpt{
+		Name:   "elasticsearch-ca-bundle",
+		Desc:   "The path of the PEM bundle of the CA certificates trusted in addition to the system ones",
+		EnvVar: "ELASTICSEARCH_CA_BUNDLE",
+	})
+	esClientCert := app.String(cli.StringOpt{
+		Name:   "elasticsearch-client-cert",
+		Desc:   "The path of the PEM client certificate presented to the cluster, if any",
+		EnvVar: "ELASTICSEARCH_CLIENT_CERT",
+	})
+	esClientKey := app.String(cli.StringOpt{
+		Name:   "elasticsearch-client-key",
+		Desc:   "The path of the PEM key of the client certificate",
+		EnvVar: "ELASTICSEARCH_CLIENT_KEY",
+	})
 	esEndpoint := app.String(cli.StringOpt{
 		Name:   "elasticsearch-endpoint",
 		Value:  "http://localhost:9200",
@@ -96,6 +148,204 @@ func main() {
 		Desc:   "List which are currently supported by elasticsearch (already have mapping associated)",
 		EnvVar: "ELASTICSEARCH_WHITELISTED_CONCEPTS",
 	})
+	conceptTypesConfig := app.String(cli.StringOpt{
+		Name:   "concept-types-config",
+		Value:  "",
+		Desc:   "Path of the YAML or JSON configuration of the supported concept types. The whitelisted concepts are used if empty",
+		EnvVar: "CONCEPT_TYPES_CONFIG",
+	})
+	writeBlockCheckInterval := app.Int(cli.IntOpt{
+		Name:   "write-block-check-interval",
+		Value:  30,
+		Desc:   "How frequently, in seconds, the write block of the index is checked. Writes are not parked if 0",
+		EnvVar: "WRITE_BLOCK_CHECK_INTERVAL",
+	})
+	maxParkedWrites := app.Int(cli.IntOpt{
+		Name:   "max-parked-writes",
+		Value:  service.DefaultMaxParkedWrites,
+		Desc:   "Maximum number of bulk and metrics writes kept while the index is blocked for writes",
+		EnvVar: "MAX_PARKED_WRITES",
+	})
+	probeInterval := app.Int(cli.IntOpt{
+		Name:   "elasticsearch-probe-interval",
+		Value:  30,
+		Desc:   "How frequently, in seconds, the connection to the cluster is probed",
+		EnvVar: "ELASTICSEARCH_PROBE_INTERVAL",
+	})
+	probeFailureThreshold := app.Int(cli.IntOpt{
+		Name:   "elasticsearch-probe-failures",
+		Value:  3,
+		Desc:   "Number of consecutive failed probes after which the client of the cluster is rebuilt",
+		EnvVar: "ELASTICSEARCH_PROBE_FAILURES",
+	})
+	maxReconnectBackoff := app.Int(cli.IntOpt{
+		Name:   "elasticsearch-max-reconnect-backoff",
+		Value:  60,
+		Desc:   "Maximum time, in seconds, between two attempts to connect to the cluster. The time doubles from 1 second after every failed attempt",
+		EnvVar: "ELASTICSEARCH_MAX_RECONNECT_BACKOFF",
+	})
+	retryMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "retry-max-attempts",
+		Value:  5,
+		Desc:   "Maximum number of attempts of the reads, writes, deletes and bulk requests failing with a retryable status",
+		EnvVar: "RETRY_MAX_ATTEMPTS",
+	})
+	retryInitialBackoff := app.Int(cli.IntOpt{
+		Name:   "retry-initial-backoff",
+		Value:  100,
+		Desc:   "Maximum time, in milliseconds, before the first retry. The time doubles for every other retry",
+		EnvVar: "RETRY_INITIAL_BACKOFF",
+	})
+	retryMaxBackoff := app.Int(cli.IntOpt{
+		Name:   "retry-max-backoff",
+		Value:  5000,
+		Desc:   "Maximum time, in milliseconds, before any retry",
+		EnvVar: "RETRY_MAX_BACKOFF",
+	})
+	retryableStatuses := app.String(cli.StringOpt{
+		Name:   "retryable-statuses",
+		Value:  "429,502,503,504",
+		Desc:   "Comma separated HTTP statuses of Elasticsearch after which the operations are retried",
+		EnvVar: "RETRYABLE_STATUSES",
+	})
+	readTimeout := app.Int(cli.IntOpt{
+		Name:   "read-timeout",
+		Value:  5,
+		Desc:   "Time, in seconds, a read or a diff of a concept waits for Elasticsearch before failing with a 504. No deadline if 0",
+		EnvVar: "READ_TIMEOUT",
+	})
+	writeTimeout := app.Int(cli.IntOpt{
+		Name:   "write-timeout",
+		Value:  15,
+		Desc:   "Time, in seconds, a write of a concept, including the cleanup of its concorded concepts, waits for Elasticsearch before failing with a 504. No deadline if 0",
+		EnvVar: "WRITE_TIMEOUT",
+	})
+	deleteTimeout := app.Int(cli.IntOpt{
+		Name:   "delete-timeout",
+		Value:  10,
+		Desc:   "Time, in seconds, a delete of a concept waits for Elasticsearch before failing with a 504. No deadline if 0",
+		EnvVar: "DELETE_TIMEOUT",
+	})
+	circuitBreakerFailureRate := app.Int(cli.IntOpt{
+		Name:   "circuit-breaker-failure-rate",
+		Value:  50,
+		Desc:   "Percentage of failed calls to Elasticsearch among the last ones after which the calls are refused for a while. The circuit breaker is disabled if 0",
+		EnvVar: "CIRCUIT_BREAKER_FAILURE_RATE",
+	})
+	circuitBreakerWindow := app.Int(cli.IntOpt{
+		Name:   "circuit-breaker-window",
+		Value:  20,
+		Desc:   "Number of the last calls to Elasticsearch the failure rate is measured on",
+		EnvVar: "CIRCUIT_BREAKER_WINDOW",
+	})
+	circuitBreakerMinCalls := app.Int(cli.IntOpt{
+		Name:   "circuit-breaker-min-calls",
+		Value:  10,
+		Desc:   "Minimum number of calls to Elasticsearch before the circuit breaker can open",
+		EnvVar: "CIRCUIT_BREAKER_MIN_CALLS",
+	})
+	circuitBreakerOpenDuration := app.Int(cli.IntOpt{
+		Name:   "circuit-breaker-open-duration",
+		Value:  30,
+		Desc:   "Time, in seconds, the calls to Elasticsearch are refused once the circuit breaker opens, before trial calls are let through",
+		EnvVar: "CIRCUIT_BREAKER_OPEN_DURATION",
+	})
+	circuitBreakerHalfOpenCalls := app.Int(cli.IntOpt{
+		Name:   "circuit-breaker-half-open-calls",
+		Value:  3,
+		Desc:   "Number of trial calls which have to succeed for the circuit breaker to close again",
+		EnvVar: "CIRCUIT_BREAKER_HALF_OPEN_CALLS",
+	})
+	admissionClientHeader := app.String(cli.StringOpt{
+		Name:   "admission-client-header",
+		Value:  "",
+		Desc:   "Header identifying the client of a write request for its rate limit, only to be set if it is set by a trusted proxy. The source IP is used without it",
+		EnvVar: "ADMISSION_CLIENT_HEADER",
+	})
+	clientRateLimit := app.Int(cli.IntOpt{
+		Name:   "client-rate-limit",
+		Value:  0,
+		Desc:   "Number of write requests per second a client can send on average before getting a 429. No limit if 0",
+		EnvVar: "CLIENT_RATE_LIMIT",
+	})
+	clientBurst := app.Int(cli.IntOpt{
+		Name:   "client-burst",
+		Value:  400,
+		Desc:   "Number of write requests a client can send at once",
+		EnvVar: "CLIENT_BURST",
+	})
+	typeRateLimit := app.Int(cli.IntOpt{
+		Name:   "type-rate-limit",
+		Value:  0,
+		Desc:   "Number of write requests per second a concept type can receive on average before getting a 429. No limit if 0",
+		EnvVar: "TYPE_RATE_LIMIT",
+	})
+	typeBurst := app.Int(cli.IntOpt{
+		Name:   "type-burst",
+		Value:  0,
+		Desc:   "Number of write requests a concept type can receive at once",
+		EnvVar: "TYPE_BURST",
+	})
+	maxInFlightWrites := app.Int(cli.IntOpt{
+		Name:   "max-in-flight-writes",
+		Value:  0,
+		Desc:   "Maximum number of write requests in progress, after which they get a 429. No limit if 0",
+		EnvVar: "MAX_IN_FLIGHT_WRITES",
+	})
+	bulkLanes := app.String(cli.StringOpt{
+		Name:   "bulk-lanes",
+		Value:  "sync,metrics",
+		Desc:   "Comma separated workloads (sync, bulk, metrics) sent by a bulk processor of their own, with the bulk processor settings. The other workloads share the default bulk processor",
+		EnvVar: "BULK_LANES",
+	})
+	bulkQueueLimit := app.Int(cli.IntOpt{
+		Name:   "bulk-queue-limit",
+		Value:  10000,
+		Desc:   "Number of requests waiting in the bulk processor after which the bulk loads get a 429. No limit if 0",
+		EnvVar: "BULK_QUEUE_LIMIT",
+	})
+	secondaryIndexName := app.String(cli.StringOpt{
+		Name:   "secondary-index-name",
+		Value:  "",
+		Desc:   "Name of the index the writes are also applied to during an index migration, until the alias of the index is switched to it. No writes are duplicated if empty",
+		EnvVar: "SECONDARY_INDEX_NAME",
+	})
+	referenceSourceURL := app.String(cli.StringOpt{
+		Name:   "reconciliation-source-url",
+		Value:  "",
+		Desc:   "URL of the reference set of concepts the index is reconciled with when none is uploaded to /__reconcile, one {\"uuid\",\"type\"} object per line",
+		EnvVar: "RECONCILIATION_SOURCE_URL",
+	})
+	adminAPIToken := app.String(cli.StringOpt{
+		Name:   "admin-api-token",
+		Value:  "",
+		Desc:   "Bearer token of the administration endpoints starting or cancelling a reindex or a reconciliation, applying a mapping and stopping the dual write. They are not served if empty",
+		EnvVar: "ADMIN_API_TOKEN",
+	})
+	reconciliationMaxDeletePercentage := app.Int(cli.IntOpt{
+		Name:   "reconciliation-max-delete-percentage",
+		Value:  10,
+		Desc:   "Percentage of the documents of the index above which the extra documents found by a reconciliation are not deleted",
+		EnvVar: "RECONCILIATION_MAX_DELETE_PERCENTAGE",
+	})
+	elasticsearchBackend := app.String(cli.StringOpt{
+		Name:   "elasticsearch-backend",
+		Value:  "v5",
+		Desc:   "How the concepts are stored: v5 for Elasticsearch 5 and 6 mapping types, typeless for Elasticsearch 7, 8 and OpenSearch",
+		EnvVar: "ELASTICSEARCH_BACKEND",
+	})
+	storage := app.String(cli.StringOpt{
+		Name:   "storage",
+		Value:  "elasticsearch",
+		Desc:   "Where the concepts are kept: elasticsearch, or memory for development, in which case they are lost on restart",
+		EnvVar: "STORAGE",
+	})
+	configReloadInterval := app.Int(cli.IntOpt{
+		Name:   "config-reload-interval",
+		Value:  30,
+		Desc:   "How frequently, in seconds, the concept types configuration file is checked for changes",
+		EnvVar: "CONFIG_RELOAD_INTERVAL",
+	})
 
 	esTraceLogging := app.Bool(cli.BoolOpt{
 		Name:   "elasticsearch-trace",
@@ -103,6 +353,18 @@ func main() {
 		Desc:   "Whether to log ElasticSearch HTTP requests and responses",
 		EnvVar: "ELASTICSEARCH_TRACE",
 	})
+	changeEventsWebhook := app.String(cli.StringOpt{
+		Name:   "change-events-webhook",
+		Value:  "",
+		Desc:   "URL which concept change events are POSTed to. Change events are not published if empty",
+		EnvVar: "CHANGE_EVENTS_WEBHOOK",
+	})
+	changeStreamReplaySize := app.Int(cli.IntOpt{
+		Name:   "change-stream-replay-size",
+		Value:  1000,
+		Desc:   "Number of recent change events kept for clients resuming the /__changes stream",
+		EnvVar: "CHANGE_STREAM_REPLAY_SIZE",
+	})
 	logLevel := app.String(cli.StringOpt{
 		Name:   "logLevel",
 		Value:  "info",
@@ -110,41 +372,170 @@ func main() {
 		EnvVar: "LOG_LEVEL",
 	})
 
-	accessConfig := service.NewAccessConfig(*accessKey, *secretKey, *esEndpoint, *esTraceLogging)
+	accessConfig := service.NewAccessConfig(*accessKey, *secretKey, *esEndpoint, *esTraceLogging).
+		WithSessionToken(*sessionToken).
+		WithAssumedRole(*esRoleARN, *esRoleSessionName).
+		WithBasicAuth(*esUsername, *esPassword).
+		WithAPIKey(*esAPIKey).
+		WithTLS(*esCABundle, *esClientCert, *esClientKey)
 
 	logger.InitLogger(*appSystemCode, *logLevel)
-	logger.Infof("[Startup] The writer handles the following concept types: %v\n", *elasticsearchWhitelistedConceptTypes)
 
 	// It seems that once we have a connection, we can lose and reconnect to Elastic OK
 	// so just keep going until successful
 	app.Action = func() {
+		setLogLevel(*logLevel)
+
+		var options []service.EsServiceOption
 		ecc := make(chan *elastic.Client)
-		go func() {
-			defer close(ecc)
-			for {
-				ec, err := service.NewElasticClient(*esRegion, accessConfig)
-				if err == nil {
-					logger.Info("connected to ElasticSearch")
-					ecc <- ec
-					return
-				}
-				logger.Errorf("could not connect to ElasticSearch: %s", err.Error())
-				time.Sleep(time.Minute)
-			}
-		}()
+		switch *storage {
+		case "memory":
+			logger.Warn("the concepts are kept in memory, and are lost on restart")
+			options = append(options, service.WithStorage(service.NewMemoryStorage(*indexName)))
+			close(ecc)
+		case "elasticsearch":
+			supervisor := service.NewConnectionSupervisor(*esRegion, accessConfig,
+				service.WithProbeInterval(time.Duration(*probeInterval)*time.Second),
+				service.WithFailureThreshold(*probeFailureThreshold),
+				service.WithReconnectBackoff(time.Second, time.Duration(*maxReconnectBackoff)*time.Second))
+			options = append(options, service.WithConnectionSupervisor(supervisor))
+			go supervisor.Run(context.Background(), ecc)
+		default:
+			logger.Fatalf("invalid storage %q, expected elasticsearch or memory", *storage)
+		}
 
 		//create writer service
 		bulkProcessorConfig := service.NewBulkProcessorConfig(*nrOfElasticsearchWorkers, *nrOfElasticsearchRequests, *elasticsearchBulkSize, time.Duration(*elasticsearchFlushInterval)*time.Second)
 
-		esService := service.NewEsService(ecc, *indexName, &bulkProcessorConfig)
+		changeBroker := events.NewBroker(*changeStreamReplaySize, 100)
+		publishers := events.NewMultiPublisher(changeBroker)
+		if *changeEventsWebhook != "" {
+			webhookPublisher := events.NewWebhookPublisher(*changeEventsWebhook, &http.Client{Timeout: 10 * time.Second}, 1000)
+			defer webhookPublisher.Close()
+			publishers = append(publishers, webhookPublisher)
+		}
 
-		allowedConceptTypes := strings.Split(*elasticsearchWhitelistedConceptTypes, ",")
-		handler := resources.NewHandler(esService, allowedConceptTypes)
+		var statuses []int
+		for _, status := range strings.Split(*retryableStatuses, ",") {
+			if status = strings.TrimSpace(status); status == "" {
+				continue
+			}
+			code, err := strconv.Atoi(status)
+			if err != nil {
+				logger.Fatalf("invalid retryable status %q", status)
+			}
+			statuses = append(statuses, code)
+		}
+		retryPolicy := service.NewRetryPolicy(*retryMaxAttempts, time.Duration(*retryInitialBackoff)*time.Millisecond, time.Duration(*retryMaxBackoff)*time.Millisecond, statuses...)
+		circuitBreakerConfig := service.NewCircuitBreakerConfig(float64(*circuitBreakerFailureRate)/100, *circuitBreakerWindow, *circuitBreakerMinCalls,
+			time.Duration(*circuitBreakerOpenDuration)*time.Second, *circuitBreakerHalfOpenCalls)
+
+		var workloads []service.Workload
+		for _, name := range strings.Split(*bulkLanes, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			workload, err := service.ParseWorkload(name)
+			if err != nil {
+				logger.Fatalf("invalid bulk lane: %v", err)
+			}
+			workloads = append(workloads, workload)
+			options = append(options, service.WithBulkLane(bulkProcessorConfig, workload))
+		}
+
+		backend, err := service.ParseBackend(*elasticsearchBackend)
+		if err != nil {
+			logger.Fatalf("invalid elasticsearch backend: %v", err)
+		}
+
+		var referenceSource service.ReferenceSource
+		if *referenceSourceURL != "" {
+			referenceSource = service.NewHTTPReferenceSource(*referenceSourceURL, &http.Client{Timeout: 10 * time.Minute})
+		}
+
+		options = append(options,
+			service.WithChangePublisher(publishers),
+			service.WithWriteBlockCheck(time.Duration(*writeBlockCheckInterval)*time.Second, *maxParkedWrites),
+			service.WithSecondaryIndex(*secondaryIndexName),
+			service.WithReferenceSource(referenceSource),
+			service.WithReconciliationDeleteLimit(float64(*reconciliationMaxDeletePercentage)/100),
+			service.WithBackend(backend),
+			service.WithRetryPolicy(retryPolicy),
+			service.WithCircuitBreaker(circuitBreakerConfig),
+			service.WithBulkQueueLimit(*bulkQueueLimit))
+		esService := service.NewEsService(ecc, *indexName, &bulkProcessorConfig, options...)
+
+		timeouts := resources.Timeouts{
+			Read:   time.Duration(*readTimeout) * time.Second,
+			Write:  time.Duration(*writeTimeout) * time.Second,
+			Delete: time.Duration(*deleteTimeout) * time.Second,
+		}
+		handler := resources.NewHandler(esService, strings.Split(*elasticsearchWhitelistedConceptTypes, ","), resources.WithTimeouts(timeouts))
 		defer handler.Close()
 
+		var configHandler *resources.ConfigHandler
+		if *conceptTypesConfig != "" {
+			cfg, err := config.Load(*conceptTypesConfig)
+			if err != nil {
+				logger.Fatalf("Failed to load the concept types configuration: %v", err)
+			}
+
+			// settings missing from the configuration file fall back to the command line
+			apply := func(cfg *config.Config) {
+				handler.SetConceptTypes(cfg.ConceptTypes)
+
+				b := cfg.BulkProcessor
+				bulkConfig := bulkProcessorConfig.With(b.Workers, b.Requests, b.Size, b.FlushInterval)
+				if err := esService.SetBulkProcessorConfig(&bulkConfig); err != nil {
+					logger.Errorf("Creating bulk processor failed with error=[%v]", err)
+				}
+				for name := range cfg.BulkLanes {
+					if !hasWorkload(workloads, name) {
+						logger.WithField("lane", name).Warn("The bulk lane is not enabled by the bulk-lanes setting, its settings are ignored")
+					}
+				}
+				for _, workload := range workloads {
+					b := cfg.BulkLanes[string(workload)]
+					laneConfig := bulkConfig.With(b.Workers, b.Requests, b.Size, b.FlushInterval)
+					if err := esService.SetBulkLaneConfig(workload, &laneConfig); err != nil {
+						logger.WithField("lane", workload).Errorf("Creating bulk processor failed with error=[%v]", err)
+					}
+				}
+
+				level := *logLevel
+				if cfg.LogLevel != "" {
+					level = cfg.LogLevel
+				}
+				setLogLevel(level)
+			}
+			apply(cfg)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			reloader := config.NewReloader(*conceptTypesConfig, apply)
+			go reloader.Watch(ctx, time.Duration(*configReloadInterval)*time.Second)
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGHUP)
+			go reloader.ReloadOn(ctx, signals)
+
+			configHandler = resources.NewConfigHandler(reloader)
+		}
+		logger.Infof("[Startup] The writer handles the following concept types: %v\n", strings.Join(handler.ConceptTypes().Names(), ","))
+
 		//create health service
 		healthService := health.NewHealthService(esService)
-		routeRequests(port, handler, healthService)
+		changesHandler := resources.NewChangesHandler(changeBroker, 15*time.Second)
+		adminHandler := resources.NewAdminHandler(esService)
+		adminAuth := resources.NewAdminAuth(*adminAPIToken)
+		if !adminAuth.Enabled() {
+			logger.Warn("no admin API token is set, the administration endpoints changing the index are not served")
+		}
+		admission := resources.NewAdmissionControl(*admissionClientHeader,
+			resources.RateLimit{Rate: float64(*clientRateLimit), Burst: *clientBurst},
+			resources.RateLimit{Rate: float64(*typeRateLimit), Burst: *typeBurst},
+			*maxInFlightWrites)
+		routeRequests(port, handler, admission, changesHandler, configHandler, adminHandler, adminAuth, healthService)
 	}
 
 	err := app.Run(os.Args)
@@ -154,13 +545,42 @@ func main() {
 	}
 }
 
-func routeRequests(port *string, handler *resources.Handler, healthService *health.HealthService) {
+func setLogLevel(level string) {
+	parsedLevel, err := log.ParseLevel(level)
+	if err != nil {
+		logger.WithError(err).Errorf("Incorrect log level %s, keeping the current one", level)
+		return
+	}
+	logger.Logger().SetLevel(parsedLevel)
+}
+
+func routeRequests(port *string, handler *resources.Handler, admission *resources.AdmissionControl, changesHandler *resources.ChangesHandler, configHandler *resources.ConfigHandler, adminHandler *resources.AdminHandler, adminAuth *resources.AdminAuth, healthService *health.HealthService) {
 	servicesRouter := mux.NewRouter()
-	servicesRouter.HandleFunc("/bulk/{concept-type}/{id}", handler.LoadBulkData).Methods("PUT")
-	servicesRouter.HandleFunc("/{concept-type}/{id}/metrics", handler.LoadMetrics).Methods("PUT")
-	servicesRouter.HandleFunc("/{concept-type}/{id}", handler.LoadData).Methods("PUT")
+	servicesRouter.HandleFunc("/__changes", changesHandler.StreamChanges).Methods("GET")
+	servicesRouter.HandleFunc("/__config/types", handler.GetConceptTypes).Methods("GET")
+	if configHandler != nil {
+		servicesRouter.HandleFunc("/__config/reload", configHandler.Reload).Methods("POST")
+	}
+	servicesRouter.HandleFunc("/__dual-write", adminHandler.GetDualWrite).Methods("GET")
+	servicesRouter.HandleFunc("/__reindex", adminHandler.GetReindex).Methods("GET")
+	servicesRouter.HandleFunc("/__mapping", adminHandler.GetMapping).Methods("GET")
+	servicesRouter.HandleFunc("/__mapping/{index}", adminHandler.GetMapping).Methods("GET")
+	servicesRouter.HandleFunc("/__reconcile", adminHandler.GetReconciliation).Methods("GET")
+	// the endpoints changing the index are only served to the holders of the admin token
+	if adminAuth.Enabled() {
+		servicesRouter.HandleFunc("/__dual-write", adminAuth.Require(adminHandler.StopDualWrite)).Methods("DELETE")
+		servicesRouter.HandleFunc("/__reindex", adminAuth.Require(adminHandler.StartReindex)).Methods("POST")
+		servicesRouter.HandleFunc("/__reindex", adminAuth.Require(adminHandler.CancelReindex)).Methods("DELETE")
+		servicesRouter.HandleFunc("/__mapping/{index}", adminAuth.Require(adminHandler.ApplyMapping)).Methods("PUT")
+		servicesRouter.HandleFunc("/__reconcile", adminAuth.Require(adminHandler.StartReconciliation)).Methods("POST")
+		servicesRouter.HandleFunc("/__reconcile", adminAuth.Require(adminHandler.CancelReconciliation)).Methods("DELETE")
+	}
+	servicesRouter.HandleFunc("/bulk/{concept-type}/{id}", admission.Admit(handler.LoadBulkData)).Methods("PUT")
+	servicesRouter.HandleFunc("/{concept-type}/{id}/metrics", admission.Admit(handler.LoadMetrics)).Methods("PUT")
+	servicesRouter.HandleFunc("/{concept-type}/{id}/__diff", handler.DiffData).Methods("POST")
+	servicesRouter.HandleFunc("/{concept-type}/{id}", admission.Admit(handler.LoadData)).Methods("PUT")
 	servicesRouter.HandleFunc("/{concept-type}/{id}", handler.ReadData).Methods("GET")
-	servicesRouter.HandleFunc("/{concept-type}/{id}", handler.DeleteData).Methods("DELETE")
+	servicesRouter.HandleFunc("/{concept-type}/{id}", admission.Admit(handler.DeleteData)).Methods("DELETE")
 	servicesRouter.HandleFunc("/__ids", handler.GetAllIds).Methods("GET")
 
 	var monitoringRouter http.Handler = servicesRouter
@@ -178,3 +598,12 @@ func routeRequests(port *string, handler *resources.Handler, healthService *heal
 		logger.Fatalf("Unable to start: %v", err)
 	}
 }
+
+func hasWorkload(workloads []service.Workload, name string) bool {
+	for _, w := range workloads {
+		if string(w) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package resources
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/service"
+	log "github.com/Financial-Times/go-logger"
+	"github.com/gorilla/mux"
+)
+
+// DualWriter controls the writes to the secondary index during an index migration
+type DualWriter interface {
+	DualWriteStatus() service.DualWriteStatus
+	StopDualWrite() service.DualWriteStatus
+}
+
+// Reindexer runs the copy of the index into another index
+type Reindexer interface {
+	StartReindex(target string) (service.ReindexStatus, error)
+	ReindexStatus() (service.ReindexStatus, error)
+	CancelReindex() (service.ReindexStatus, error)
+}
+
+// Mapper compares the mapping of the indices with the mapping embedded in the service, and applies it
+type Mapper interface {
+	MappingStatus() (service.MappingStatus, error)
+	CheckMapping(ctx context.Context, index string) (service.MappingStatus, error)
+	ApplyMapping(ctx context.Context, index string) (service.MappingStatus, error)
+}
+
+// Reconciler compares the documents of the index with a reference set of concepts
+type Reconciler interface {
+	StartReconciliation(reference service.ReferenceSource, deleteExtras bool) (service.ReconciliationReport, error)
+	ReconciliationReport() (service.ReconciliationReport, error)
+	CancelReconciliation() (service.ReconciliationReport, error)
+}
+
+// IndexAdmin administers the indices
+type IndexAdmin interface {
+	DualWriter
+	Reindexer
+	Mapper
+	Reconciler
+}
+
+// AdminHandler handles the administration of the indices
+type AdminHandler struct {
+	admin IndexAdmin
+}
+
+func NewAdminHandler(admin IndexAdmin) *AdminHandler {
+	return &AdminHandler{admin: admin}
+}
+
+// GetDualWrite returns the status of the writes to the secondary index
+func (h *AdminHandler) GetDualWrite(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.admin.DualWriteStatus(), http.StatusOK)
+}
+
+// StopDualWrite stops applying the writes to the secondary index. It cannot be resumed without a restart.
+func (h *AdminHandler) StopDualWrite(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.admin.StopDualWrite(), http.StatusOK)
+}
+
+type reindexRequest struct {
+	TargetIndex string `json:"targetIndex"`
+}
+
+// StartReindex starts copying every document of the index into the target index of the request body
+func (h *AdminHandler) StartReindex(w http.ResponseWriter, r *http.Request) {
+	var req reindexRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, newProblem(codeInvalidRequest, http.StatusBadRequest, "Invalid reindex request: "+err.Error()))
+		return
+	}
+
+	status, err := h.admin.StartReindex(req.TargetIndex)
+	if err != nil {
+		log.WithError(err).Warn("Failed to start the reindex")
+		writeProblem(w, r, reindexProblem(err))
+		return
+	}
+	writeJSON(w, status, http.StatusAccepted)
+}
+
+// GetReindex returns the status of the current or last reindex
+func (h *AdminHandler) GetReindex(w http.ResponseWriter, r *http.Request) {
+	status, err := h.admin.ReindexStatus()
+	if err != nil {
+		writeProblem(w, r, reindexProblem(err))
+		return
+	}
+	writeJSON(w, status, http.StatusOK)
+}
+
+// CancelReindex stops the current reindex
+func (h *AdminHandler) CancelReindex(w http.ResponseWriter, r *http.Request) {
+	status, err := h.admin.CancelReindex()
+	if err != nil {
+		writeProblem(w, r, reindexProblem(err))
+		return
+	}
+	writeJSON(w, status, http.StatusOK)
+}
+
+func reindexProblem(err error) *Problem {
+	switch err {
+	case service.ErrReindexRunning:
+		return newProblem(codeReindexRunning, http.StatusConflict, err.Error())
+	case service.ErrNoReindex:
+		return newProblem(codeReindexNotFound, http.StatusNotFound, err.Error())
+	case service.ErrInvalidReindexTarget:
+		return newProblem(codeInvalidRequest, http.StatusBadRequest, err.Error())
+	}
+	return esProblem(err, "Failed to start the reindex")
+}
+
+// GetMapping returns the differences between the mapping of an index and the embedded mapping.
+// The status of the index the service writes to, as of its last check, is returned if the path has no index.
+func (h *AdminHandler) GetMapping(w http.ResponseWriter, r *http.Request) {
+	var status service.MappingStatus
+	var err error
+	if index := mux.Vars(r)["index"]; index != "" {
+		status, err = h.admin.CheckMapping(r.Context(), index)
+	} else {
+		status, err = h.admin.MappingStatus()
+	}
+
+	if err != nil {
+		writeProblem(w, r, mappingProblem(err, status, "Failed to check the mapping"))
+		return
+	}
+	writeJSON(w, status, http.StatusOK)
+}
+
+// ApplyMapping creates the index of the path with the embedded mapping, or adds the types and fields it misses
+func (h *AdminHandler) ApplyMapping(w http.ResponseWriter, r *http.Request) {
+	index := mux.Vars(r)["index"]
+	status, err := h.admin.ApplyMapping(r.Context(), index)
+	if err != nil {
+		log.WithError(err).WithField("index", index).Warn("Failed to apply the mapping")
+		writeProblem(w, r, mappingProblem(err, status, "Failed to apply the mapping"))
+		return
+	}
+	writeJSON(w, status, http.StatusOK)
+}
+
+func mappingProblem(err error, status service.MappingStatus, detail string) *Problem {
+	switch err {
+	case service.ErrMappingNotChecked:
+		return newProblem(codeESUnavailable, http.StatusServiceUnavailable, err.Error())
+	case service.ErrMappingConflict:
+		p := newProblem(codeMappingConflict, http.StatusConflict, err.Error())
+		for _, c := range status.Conflicts {
+			p.Violations = append(p.Violations, Violation{Field: c.Field, Message: fmt.Sprintf("mapped as %s instead of %s", c.Actual, c.Expected)})
+		}
+		return p
+	}
+	return esProblem(err, detail)
+}
+
+// StartReconciliation starts comparing the documents of the index with the reference set uploaded in the request body,
+// or fetched from the configured reference source if the body is empty. The extra documents are deleted if the deleteExtras parameter is true.
+func (h *AdminHandler) StartReconciliation(w http.ResponseWriter, r *http.Request) {
+	deleteExtras := strings.ToLower(r.URL.Query().Get("deleteExtras")) == "true"
+
+	var reference service.ReferenceSource
+	body := bufio.NewReader(r.Body)
+	if _, err := body.Peek(1); err != io.EOF {
+		set, err := service.ParseReferenceSet(body)
+		if err != nil {
+			writeProblem(w, r, newProblem(codeInvalidRequest, http.StatusBadRequest, "Invalid reference set: "+err.Error()))
+			return
+		}
+		reference = set
+	}
+
+	report, err := h.admin.StartReconciliation(reference, deleteExtras)
+	if err != nil {
+		log.WithError(err).Warn("Failed to start the reconciliation")
+		writeProblem(w, r, reconciliationProblem(err))
+		return
+	}
+	writeJSON(w, report, http.StatusAccepted)
+}
+
+// GetReconciliation returns the report of the current or last reconciliation
+func (h *AdminHandler) GetReconciliation(w http.ResponseWriter, r *http.Request) {
+	report, err := h.admin.ReconciliationReport()
+	if err != nil {
+		writeProblem(w, r, reconciliationProblem(err))
+		return
+	}
+	writeJSON(w, report, http.StatusOK)
+}
+
+// CancelReconciliation stops the current reconciliation
+func (h *AdminHandler) CancelReconciliation(w http.ResponseWriter, r *http.Request) {
+	report, err := h.admin.CancelReconciliation()
+	if err != nil {
+		writeProblem(w, r, reconciliationProblem(err))
+		return
+	}
+	writeJSON(w, report, http.StatusOK)
+}
+
+func reconciliationProblem(err error) *Problem {
+	switch err {
+	case service.ErrReconciliationRunning:
+		return newProblem(codeReconcileRunning, http.StatusConflict, err.Error())
+	case service.ErrNoReconciliation:
+		return newProblem(codeReconcileNotFound, http.StatusNotFound, err.Error())
+	case service.ErrNoReferenceSource:
+		return newProblem(codeInvalidRequest, http.StatusBadRequest, err.Error())
+	}
+	return esProblem(err, "Failed to start the reconciliation")
+}
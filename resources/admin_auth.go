@@ -0,0 +1,44 @@
+package resources
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth lets the requests of the administration endpoints through only if they carry the admin token
+type AdminAuth struct {
+	token string
+}
+
+// NewAdminAuth returns the authentication of the administration endpoints with the token given
+func NewAdminAuth(token string) *AdminAuth {
+	return &AdminAuth{token: token}
+}
+
+// Enabled tells whether a token has been set. The endpoints cannot be authenticated without one.
+func (a *AdminAuth) Enabled() bool {
+	return a.token != ""
+}
+
+// Require calls the handler if the request has the token as a bearer token in its Authorization header, or responds with a 401
+func (a *AdminAuth) Require(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, bearer := bearerToken(r)
+		if !a.Enabled() || !bearer || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, r, newProblem(codeUnauthorized, http.StatusUnauthorized, "The request does not carry the admin token"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(header, prefix), true
+}
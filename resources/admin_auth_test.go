@@ -0,0 +1,47 @@
+package resources
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAuth(t *testing.T) {
+	testCases := []struct {
+		name          string
+		token         string
+		authorization string
+		status        int
+	}{
+		{name: "Admin token", token: "secret", authorization: "Bearer secret", status: http.StatusOK},
+		{name: "Wrong token", token: "secret", authorization: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "Token without scheme", token: "secret", authorization: "secret", status: http.StatusUnauthorized},
+		{name: "No token", token: "secret", status: http.StatusUnauthorized},
+		{name: "Authentication disabled", authorization: "Bearer ", status: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			auth := NewAdminAuth(tc.token)
+			handler := auth.Require(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/__reindex", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			if tc.status == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", rr.Header().Get("WWW-Authenticate"))
+				assert.Contains(t, rr.Body.String(), `"code":"unauthorized"`)
+			}
+		})
+	}
+	assert.False(t, NewAdminAuth("").Enabled())
+}
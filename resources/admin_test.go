@@ -0,0 +1,353 @@
+package resources
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type dummyIndexAdmin struct {
+	dualWrite service.DualWriteStatus
+	reindex   service.ReindexStatus
+	mapping   service.MappingStatus
+	reconcile service.ReconciliationReport
+	reference service.ReferenceSource
+	err       error
+}
+
+func (d *dummyIndexAdmin) DualWriteStatus() service.DualWriteStatus {
+	return d.dualWrite
+}
+
+func (d *dummyIndexAdmin) StopDualWrite() service.DualWriteStatus {
+	d.dualWrite.Active = false
+	d.dualWrite.StoppedReason = "stopped by an administrator"
+	return d.dualWrite
+}
+
+func (d *dummyIndexAdmin) StartReindex(target string) (service.ReindexStatus, error) {
+	if d.err != nil {
+		return service.ReindexStatus{}, d.err
+	}
+	d.reindex = service.ReindexStatus{SourceIndex: "concepts", TargetIndex: target, State: service.ReindexRunning}
+	return d.reindex, nil
+}
+
+func (d *dummyIndexAdmin) ReindexStatus() (service.ReindexStatus, error) {
+	return d.reindex, d.err
+}
+
+func (d *dummyIndexAdmin) CancelReindex() (service.ReindexStatus, error) {
+	return d.reindex, d.err
+}
+
+func (d *dummyIndexAdmin) MappingStatus() (service.MappingStatus, error) {
+	return d.mapping, d.err
+}
+
+func (d *dummyIndexAdmin) CheckMapping(ctx context.Context, index string) (service.MappingStatus, error) {
+	d.mapping.Index = index
+	return d.mapping, d.err
+}
+
+func (d *dummyIndexAdmin) ApplyMapping(ctx context.Context, index string) (service.MappingStatus, error) {
+	d.mapping.Index = index
+	if d.err == nil {
+		d.mapping.Exists = true
+	}
+	return d.mapping, d.err
+}
+
+func (d *dummyIndexAdmin) StartReconciliation(reference service.ReferenceSource, deleteExtras bool) (service.ReconciliationReport, error) {
+	if d.err != nil {
+		return service.ReconciliationReport{}, d.err
+	}
+	d.reference = reference
+	d.reconcile = service.ReconciliationReport{State: service.ReconciliationRunning, DeleteExtras: deleteExtras}
+	return d.reconcile, nil
+}
+
+func (d *dummyIndexAdmin) ReconciliationReport() (service.ReconciliationReport, error) {
+	return d.reconcile, d.err
+}
+
+func (d *dummyIndexAdmin) CancelReconciliation() (service.ReconciliationReport, error) {
+	return d.reconcile, d.err
+}
+
+func TestDualWrite(t *testing.T) {
+	admin := &dummyIndexAdmin{dualWrite: service.DualWriteStatus{SecondaryIndex: "concepts-0.0.5", Active: true, DivergentWrites: 2}}
+	handler := NewAdminHandler(admin)
+
+	rr := httptest.NewRecorder()
+	handler.GetDualWrite(rr, httptest.NewRequest("GET", "/__dual-write", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"secondaryIndex":"concepts-0.0.5","active":true,"divergentWrites":2,"divergentDeletes":0,"divergentBulkRequests":0}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handler.StopDualWrite(rr, httptest.NewRequest("DELETE", "/__dual-write", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"secondaryIndex":"concepts-0.0.5","active":false,"stoppedReason":"stopped by an administrator","divergentWrites":2,"divergentDeletes":0,"divergentBulkRequests":0}`, rr.Body.String())
+}
+
+func TestStartReindex(t *testing.T) {
+	testCases := []struct {
+		name   string
+		body   string
+		err    error
+		status int
+		msg    string
+	}{
+		{
+			name:   "Started",
+			body:   `{"targetIndex":"concepts-0.0.6"}`,
+			status: http.StatusAccepted,
+			msg:    `{"sourceIndex":"concepts","targetIndex":"concepts-0.0.6","state":"running","startedAt":"0001-01-01T00:00:00Z","total":0,"read":0,"written":0,"failed":0,"documentsPerSecond":0}`,
+		},
+		{
+			name:   "Invalid body",
+			body:   `{`,
+			status: http.StatusBadRequest,
+			msg:    `{"code":"invalid-request","detail":"Invalid reindex request: unexpected EOF"}`,
+		},
+		{
+			name:   "Invalid target",
+			body:   `{}`,
+			err:    service.ErrInvalidReindexTarget,
+			status: http.StatusBadRequest,
+			msg:    `{"code":"invalid-request","detail":"the target index is required, and must differ from the index"}`,
+		},
+		{
+			name:   "Already running",
+			body:   `{"targetIndex":"concepts-0.0.6"}`,
+			err:    service.ErrReindexRunning,
+			status: http.StatusConflict,
+			msg:    `{"code":"reindex-running","detail":"a reindex is already running"}`,
+		},
+		{
+			name:   "No client",
+			body:   `{"targetIndex":"concepts-0.0.6"}`,
+			err:    service.ErrNoElasticClient,
+			status: http.StatusServiceUnavailable,
+			msg:    `{"code":"es-unavailable","detail":"ES unavailable"}`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/__reindex", strings.NewReader(test.body))
+			rr := httptest.NewRecorder()
+
+			NewAdminHandler(&dummyIndexAdmin{err: test.err}).StartReindex(rr, req)
+
+			assert.Equal(t, test.status, rr.Code)
+			if rr.Code == http.StatusAccepted {
+				assert.JSONEq(t, test.msg, rr.Body.String())
+				return
+			}
+			assert.JSONEq(t, test.msg, responseBody(t, rr))
+		})
+	}
+}
+
+func TestGetReindex(t *testing.T) {
+	rr := httptest.NewRecorder()
+	NewAdminHandler(&dummyIndexAdmin{err: service.ErrNoReindex}).GetReindex(rr, httptest.NewRequest("GET", "/__reindex", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.JSONEq(t, `{"code":"reindex-not-found","detail":"no reindex has been started"}`, responseBody(t, rr))
+
+	admin := &dummyIndexAdmin{reindex: service.ReindexStatus{SourceIndex: "concepts", TargetIndex: "concepts-0.0.6", State: service.ReindexCompleted, Total: 2, Read: 2, Written: 1, Failed: 1, Errors: []string{"people 1: failure"}}}
+	rr = httptest.NewRecorder()
+	NewAdminHandler(admin).GetReindex(rr, httptest.NewRequest("GET", "/__reindex", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"sourceIndex":"concepts","targetIndex":"concepts-0.0.6","state":"completed","startedAt":"0001-01-01T00:00:00Z","total":2,"read":2,"written":1,"failed":1,"documentsPerSecond":0,"errors":["people 1: failure"]}`, rr.Body.String())
+}
+
+func TestCancelReindex(t *testing.T) {
+	admin := &dummyIndexAdmin{reindex: service.ReindexStatus{State: service.ReindexRunning}}
+	rr := httptest.NewRecorder()
+	NewAdminHandler(admin).CancelReindex(rr, httptest.NewRequest("DELETE", "/__reindex", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	NewAdminHandler(&dummyIndexAdmin{err: service.ErrNoReindex}).CancelReindex(rr, httptest.NewRequest("DELETE", "/__reindex", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestGetMapping(t *testing.T) {
+	admin := &dummyIndexAdmin{mapping: service.MappingStatus{Index: "concepts", Exists: true, MissingFields: []string{"people.isFTAuthor"}}}
+	rr := httptest.NewRecorder()
+	NewAdminHandler(admin).GetMapping(rr, httptest.NewRequest("GET", "/__mapping", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"index":"concepts","exists":true,"checkedAt":"0001-01-01T00:00:00Z","missingFields":["people.isFTAuthor"]}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/__mapping/concepts-0.0.6", nil), map[string]string{"index": "concepts-0.0.6"})
+	NewAdminHandler(&dummyIndexAdmin{}).GetMapping(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"index":"concepts-0.0.6","exists":false,"checkedAt":"0001-01-01T00:00:00Z"}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	NewAdminHandler(&dummyIndexAdmin{err: service.ErrMappingNotChecked}).GetMapping(rr, httptest.NewRequest("GET", "/__mapping", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `{"code":"es-unavailable","detail":"the mapping of the index has not been checked yet"}`, responseBody(t, rr))
+}
+
+func TestApplyMapping(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mapping service.MappingStatus
+		err     error
+		status  int
+		msg     string
+	}{
+		{
+			name:   "Applied",
+			status: http.StatusOK,
+			msg:    `{"index":"concepts-0.0.6","exists":true,"checkedAt":"0001-01-01T00:00:00Z"}`,
+		},
+		{
+			name:    "Conflict",
+			mapping: service.MappingStatus{Conflicts: []service.MappingConflict{{Field: "people.isFTAuthor", Expected: "keyword", Actual: "boolean"}}},
+			err:     service.ErrMappingConflict,
+			status:  http.StatusConflict,
+			msg:     `{"code":"mapping-conflict","detail":"the mapping of the index conflicts with the embedded mapping","violations":[{"field":"people.isFTAuthor","message":"mapped as boolean instead of keyword"}]}`,
+		},
+		{
+			name:   "No client",
+			err:    service.ErrNoElasticClient,
+			status: http.StatusServiceUnavailable,
+			msg:    `{"code":"es-unavailable","detail":"ES unavailable"}`,
+		},
+		{
+			name:   "Memory storage",
+			err:    service.ErrStorageUnsupported,
+			status: http.StatusNotImplemented,
+			msg:    `{"code":"not-supported","detail":"The operation needs Elasticsearch, the concepts are kept in another storage"}`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := mux.SetURLVars(httptest.NewRequest("PUT", "/__mapping/concepts-0.0.6", nil), map[string]string{"index": "concepts-0.0.6"})
+			rr := httptest.NewRecorder()
+
+			NewAdminHandler(&dummyIndexAdmin{mapping: test.mapping, err: test.err}).ApplyMapping(rr, req)
+
+			assert.Equal(t, test.status, rr.Code)
+			if rr.Code == http.StatusOK {
+				assert.JSONEq(t, test.msg, rr.Body.String())
+				return
+			}
+			assert.JSONEq(t, test.msg, responseBody(t, rr))
+		})
+	}
+}
+
+func TestStartReconciliation(t *testing.T) {
+	testCases := []struct {
+		name      string
+		url       string
+		body      string
+		err       error
+		status    int
+		msg       string
+		reference service.ReferenceSource
+	}{
+		{
+			name:      "Uploaded",
+			url:       "/__reconcile?deleteExtras=true",
+			body:      `{"uuid":"1","type":"people"}` + "\n" + `{"uuid":"2","type":"brands"}`,
+			status:    http.StatusAccepted,
+			msg:       `{"state":"running","startedAt":"0001-01-01T00:00:00Z","deleteExtras":true,"referenced":0,"indexed":0,"missingCount":0,"extraCount":0,"wrongTypeCount":0,"deleted":0}`,
+			reference: service.ReferenceSet{"1": "people", "2": "brands"},
+		},
+		{
+			name:   "Configured source",
+			url:    "/__reconcile",
+			status: http.StatusAccepted,
+			msg:    `{"state":"running","startedAt":"0001-01-01T00:00:00Z","deleteExtras":false,"referenced":0,"indexed":0,"missingCount":0,"extraCount":0,"wrongTypeCount":0,"deleted":0}`,
+		},
+		{
+			name:   "Invalid reference set",
+			url:    "/__reconcile",
+			body:   `{"uuid":"1"}`,
+			status: http.StatusBadRequest,
+			msg:    `{"code":"invalid-request","detail":"Invalid reference set: line 1: the uuid and the type are required"}`,
+		},
+		{
+			name:   "No reference source",
+			url:    "/__reconcile",
+			err:    service.ErrNoReferenceSource,
+			status: http.StatusBadRequest,
+			msg:    `{"code":"invalid-request","detail":"no reference set has been uploaded, and no reference source is configured"}`,
+		},
+		{
+			name:   "Already running",
+			url:    "/__reconcile",
+			err:    service.ErrReconciliationRunning,
+			status: http.StatusConflict,
+			msg:    `{"code":"reconciliation-running","detail":"a reconciliation is already running"}`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", test.url, strings.NewReader(test.body))
+			rr := httptest.NewRecorder()
+			admin := &dummyIndexAdmin{err: test.err}
+
+			NewAdminHandler(admin).StartReconciliation(rr, req)
+
+			assert.Equal(t, test.status, rr.Code)
+			if rr.Code == http.StatusAccepted {
+				assert.JSONEq(t, test.msg, rr.Body.String())
+				assert.Equal(t, test.reference, admin.reference)
+				return
+			}
+			assert.JSONEq(t, test.msg, responseBody(t, rr))
+		})
+	}
+}
+
+func TestGetReconciliation(t *testing.T) {
+	rr := httptest.NewRecorder()
+	NewAdminHandler(&dummyIndexAdmin{err: service.ErrNoReconciliation}).GetReconciliation(rr, httptest.NewRequest("GET", "/__reconcile", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.JSONEq(t, `{"code":"reconciliation-not-found","detail":"no reconciliation has been started"}`, responseBody(t, rr))
+
+	admin := &dummyIndexAdmin{reconcile: service.ReconciliationReport{
+		State:          service.ReconciliationCompleted,
+		Referenced:     2,
+		Indexed:        2,
+		Missing:        []service.EsIDTypePair{{ID: "1", Type: "people"}},
+		MissingCount:   1,
+		Extra:          []service.EsIDTypePair{{ID: "3", Type: "brands"}},
+		ExtraCount:     1,
+		WrongType:      []service.WrongTypeDocument{{ID: "2", Type: "people", IndexedTypes: []string{"organisations"}}},
+		WrongTypeCount: 1,
+	}}
+	rr = httptest.NewRecorder()
+	NewAdminHandler(admin).GetReconciliation(rr, httptest.NewRequest("GET", "/__reconcile", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"state":"completed","startedAt":"0001-01-01T00:00:00Z","deleteExtras":false,"referenced":2,"indexed":2,
+		"missing":[{"id":"1","type":"people"}],"missingCount":1,"extra":[{"id":"3","type":"brands"}],"extraCount":1,
+		"wrongType":[{"id":"2","type":"people","indexedTypes":["organisations"]}],"wrongTypeCount":1,"deleted":0}`, rr.Body.String())
+}
+
+func TestCancelReconciliation(t *testing.T) {
+	admin := &dummyIndexAdmin{reconcile: service.ReconciliationReport{State: service.ReconciliationRunning}}
+	rr := httptest.NewRecorder()
+	NewAdminHandler(admin).CancelReconciliation(rr, httptest.NewRequest("DELETE", "/__reconcile", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	NewAdminHandler(&dummyIndexAdmin{err: service.ErrNoReconciliation}).CancelReconciliation(rr, httptest.NewRequest("DELETE", "/__reconcile", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
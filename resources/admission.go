@@ -0,0 +1,177 @@
+package resources
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	"github.com/gorilla/mux"
+	"github.com/rcrowley/go-metrics"
+)
+
+// inFlightRetryAfter is when the requests refused because too many are in progress can be retried
+const inFlightRetryAfter = time.Second
+
+// idleLimiterSweep is how often the limits of the clients and types which have not sent requests for a while are forgotten
+const idleLimiterSweep = time.Minute
+
+var (
+	clientRejections   = metrics.GetOrRegisterCounter("concept.admission.rejected.client", metrics.DefaultRegistry)
+	typeRejections     = metrics.GetOrRegisterCounter("concept.admission.rejected.type", metrics.DefaultRegistry)
+	inFlightRejections = metrics.GetOrRegisterCounter("concept.admission.rejected.in-flight", metrics.DefaultRegistry)
+)
+
+// RateLimit lets Burst requests through at once, and Rate requests per second on average. No limit if the rate is 0.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// AdmissionControl refuses the write requests of the clients or the concept types exceeding their rate limit,
+// and the ones arriving while too many write requests are in progress
+type AdmissionControl struct {
+	clientHeader string
+	clients      *rateLimiter
+	types        *rateLimiter
+	inFlight     chan struct{}
+}
+
+// NewAdmissionControl returns an admission control limiting the rate of the requests of every client, identified by the header given
+// or by the source IP without it, the rate of the requests of every concept type, and the number of requests in progress, if positive
+func NewAdmissionControl(clientHeader string, perClient RateLimit, perType RateLimit, maxInFlight int) *AdmissionControl {
+	a := &AdmissionControl{
+		clientHeader: clientHeader,
+		clients:      newRateLimiter(perClient),
+		types:        newRateLimiter(perType),
+	}
+	if maxInFlight > 0 {
+		a.inFlight = make(chan struct{}, maxInFlight)
+	}
+	return a
+}
+
+// Admit calls the handler unless the request is refused, with a 429 and the time after which it can be retried
+func (a *AdmissionControl) Admit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.inFlight != nil {
+			select {
+			case a.inFlight <- struct{}{}:
+				defer func() { <-a.inFlight }()
+			default:
+				inFlightRejections.Inc(1)
+				a.refuse(w, r, "Too many write requests are in progress", inFlightRetryAfter)
+				return
+			}
+		}
+
+		client := a.client(r)
+		if wait, ok := a.clients.take(client); !ok {
+			clientRejections.Inc(1)
+			a.refuse(w, r, "Too many write requests from the client", wait)
+			return
+		}
+		if wait, ok := a.types.take(mux.Vars(r)["concept-type"]); !ok {
+			typeRejections.Inc(1)
+			a.refuse(w, r, "Too many write requests for the concept type", wait)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (a *AdmissionControl) refuse(w http.ResponseWriter, r *http.Request, detail string, retryAfter time.Duration) {
+	log.WithField("client", a.client(r)).WithField("path", r.URL.Path).Warn(detail)
+	p := newProblem(codeRateLimited, http.StatusTooManyRequests, detail)
+	p.retryAfter = retryAfter
+	writeProblem(w, r, p)
+}
+
+// client returns the value of the client header of the request, or its source IP without it
+func (a *AdmissionControl) client(r *http.Request) string {
+	if a.clientHeader != "" {
+		if client := r.Header.Get(a.clientHeader); client != "" {
+			return client
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tokenBucket holds the tokens of a key, as of the last time they were taken
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per key. A nil limiter lets every request through.
+type rateLimiter struct {
+	sync.Mutex
+	limit     RateLimit
+	now       func() time.Time
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &rateLimiter{limit: limit, now: time.Now, buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+// take takes a token of the key, or returns the time until one is available
+func (l *rateLimiter) take(key string) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / l.limit.Rate * float64(time.Second)), false
+	}
+	bucket.tokens--
+	return 0, true
+}
+
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.last).Seconds()*l.limit.Rate
+	if burst := float64(l.limit.Burst); tokens > burst {
+		return burst
+	}
+	return tokens
+}
+
+// sweep forgets the buckets which have refilled, so that the buckets of the clients which have gone away do not pile up.
+// It must be called with the lock held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleLimiterSweep {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if l.refill(bucket, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
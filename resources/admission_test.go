@@ -0,0 +1,140 @@
+package resources
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRateLimiter returns a limiter whose clock is set by the test
+func testRateLimiter(limit RateLimit) (*rateLimiter, *time.Time) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := newRateLimiter(limit)
+	l.now = func() time.Time { return now }
+	l.lastSweep = now
+	return l, &now
+}
+
+func TestRateLimiter(t *testing.T) {
+	l, now := testRateLimiter(RateLimit{Rate: 2, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		_, ok := l.take("client")
+		assert.True(t, ok, "the burst should be let through")
+	}
+	wait, ok := l.take("client")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	_, ok = l.take("other-client")
+	assert.True(t, ok, "every key should have its own limit")
+
+	*now = now.Add(250 * time.Millisecond)
+	wait, ok = l.take("client")
+	assert.False(t, ok)
+	assert.Equal(t, 250*time.Millisecond, wait)
+
+	*now = now.Add(250 * time.Millisecond)
+	_, ok = l.take("client")
+	assert.True(t, ok, "a token should have been added after 500ms")
+}
+
+func TestRateLimiterForgetsIdleKeys(t *testing.T) {
+	l, now := testRateLimiter(RateLimit{Rate: 1, Burst: 1})
+	l.take("idle")
+	l.take("busy")
+	assert.Len(t, l.buckets, 2)
+
+	*now = now.Add(idleLimiterSweep)
+	l.take("busy")
+	assert.Len(t, l.buckets, 1, "the bucket of the idle client should be forgotten")
+	_, found := l.buckets["busy"]
+	assert.True(t, found)
+}
+
+func TestNoRateLimit(t *testing.T) {
+	l := newRateLimiter(RateLimit{})
+	assert.Nil(t, l)
+	for i := 0; i < 100; i++ {
+		_, ok := l.take("client")
+		assert.True(t, ok)
+	}
+}
+
+func serveAdmitted(a *AdmissionControl, handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/{concept-type}/{id}", a.Admit(handler)).Methods("PUT")
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func writeRequest(conceptType string, client string, remoteAddr string) *http.Request {
+	req := httptest.NewRequest("PUT", "/"+conceptType+"/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", nil)
+	if client != "" {
+		req.Header.Set("X-Client-Id", client)
+	}
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestAdmissionPerClient(t *testing.T) {
+	a := NewAdmissionControl("X-Client-Id", RateLimit{Rate: 0.5, Burst: 1}, RateLimit{}, 0)
+
+	assert.Equal(t, http.StatusOK, serveAdmitted(a, ok, writeRequest("people", "publisher", "10.0.0.1:1234")).Code)
+	rr := serveAdmitted(a, ok, writeRequest("people", "publisher", "10.0.0.2:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code, "the client should be identified by the header")
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"code":"rate-limited","detail":"Too many write requests from the client"}`, responseBody(t, rr))
+
+	assert.Equal(t, http.StatusOK, serveAdmitted(a, ok, writeRequest("people", "", "10.0.0.1:1234")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveAdmitted(a, ok, writeRequest("people", "", "10.0.0.1:5678")).Code,
+		"the client without header should be identified by its IP")
+	assert.Equal(t, http.StatusOK, serveAdmitted(a, ok, writeRequest("people", "", "10.0.0.2:1234")).Code)
+}
+
+func TestAdmissionPerType(t *testing.T) {
+	a := NewAdmissionControl("X-Client-Id", RateLimit{}, RateLimit{Rate: 1, Burst: 2}, 0)
+
+	assert.Equal(t, http.StatusOK, serveAdmitted(a, ok, writeRequest("people", "a", "10.0.0.1:1234")).Code)
+	assert.Equal(t, http.StatusOK, serveAdmitted(a, ok, writeRequest("people", "b", "10.0.0.1:1234")).Code)
+	rr := serveAdmitted(a, ok, writeRequest("people", "c", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"code":"rate-limited","detail":"Too many write requests for the concept type"}`, responseBody(t, rr))
+
+	assert.Equal(t, http.StatusOK, serveAdmitted(a, ok, writeRequest("brands", "c", "10.0.0.1:1234")).Code)
+}
+
+func TestAdmissionInFlight(t *testing.T) {
+	a := NewAdmissionControl("", RateLimit{}, RateLimit{}, 1)
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- serveAdmitted(a, slow, writeRequest("people", "", "10.0.0.1:1234")).Code
+	}()
+	<-started
+
+	rr := serveAdmitted(a, ok, writeRequest("brands", "", "10.0.0.2:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"code":"rate-limited","detail":"Too many write requests are in progress"}`, responseBody(t, rr))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, serveAdmitted(a, ok, writeRequest("brands", "", "10.0.0.2:1234")).Code, "the request should be let through once the other one is done")
+}
@@ -0,0 +1,113 @@
+package resources
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/events"
+	log "github.com/Financial-Times/go-logger"
+)
+
+const lastEventIDHeader = "Last-Event-ID"
+
+// ChangesHandler streams concept change events to clients as server-sent events
+type ChangesHandler struct {
+	broker            *events.Broker
+	heartbeatInterval time.Duration
+}
+
+func NewChangesHandler(broker *events.Broker, heartbeatInterval time.Duration) *ChangesHandler {
+	return &ChangesHandler{broker: broker, heartbeatInterval: heartbeatInterval}
+}
+
+// StreamChanges sends every change event published after the request has been received.
+// Clients can restrict the stream to some concept types with the type query parameter,
+// and resume a stream by sending the id of the last event they have seen in the Last-Event-ID header.
+func (h *ChangesHandler) StreamChanges(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, r, newProblem(codeInternalError, http.StatusInternalServerError, "Streaming is not supported"))
+		return
+	}
+
+	var lastEventID uint64
+	if id := r.Header.Get(lastEventIDHeader); id != "" {
+		var err error
+		lastEventID, err = strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			writeProblem(w, r, newProblem(codeInvalidRequest, http.StatusBadRequest, fmt.Sprintf("Invalid %s header", lastEventIDHeader)))
+			return
+		}
+	}
+
+	conceptTypes := conceptTypeFilter(r)
+
+	subscription, missed := h.broker.Subscribe(lastEventID)
+	defer h.broker.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range missed {
+		if err := writeEvent(w, e, conceptTypes); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-subscription.Events:
+			if !ok {
+				log.Warn("Change stream subscriber fell behind, closing the stream")
+				return
+			}
+			if err := writeEvent(w, e, conceptTypes); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func conceptTypeFilter(r *http.Request) map[string]bool {
+	filter := make(map[string]bool)
+	for _, v := range r.URL.Query()["type"] {
+		for _, conceptType := range strings.Split(v, ",") {
+			if conceptType = strings.TrimSpace(conceptType); conceptType != "" {
+				filter[conceptType] = true
+			}
+		}
+	}
+	return filter
+}
+
+func writeEvent(w http.ResponseWriter, e events.StreamedEvent, conceptTypes map[string]bool) error {
+	if len(conceptTypes) > 0 && !conceptTypes[e.Event.ConceptType] {
+		return nil
+	}
+
+	data, err := json.Marshal(e.Event)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal change event")
+		return nil
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Event.Type, data)
+	return err
+}
@@ -0,0 +1,117 @@
+package resources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/events"
+	"github.com/Financial-Times/concept-rw-elasticsearch/service"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+func TestStreamChangesReplaysAndFiltersEvents(t *testing.T) {
+	broker := events.NewBroker(10, 10)
+	publish(t, broker, events.ChangeEvent{Type: events.Created, UUID: "uuid-1", ConceptType: "people"})
+	publish(t, broker, events.ChangeEvent{Type: events.Created, UUID: "uuid-2", ConceptType: "genres"})
+	publish(t, broker, events.ChangeEvent{Type: events.Deleted, UUID: "uuid-3", ConceptType: "people"})
+
+	server := httptest.NewServer(http.HandlerFunc(NewChangesHandler(broker, time.Minute).StreamChanges))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/__changes?type=people", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal(t, []string{"id: 3", "event: deleted", `data: {"type":"deleted","uuid":"uuid-3","conceptType":"people","transactionId":"","time":"0001-01-01T00:00:00Z"}`}, readEvent(t, reader))
+
+	publish(t, broker, events.ChangeEvent{Type: events.Updated, UUID: "uuid-4", ConceptType: "genres"})
+	publish(t, broker, events.ChangeEvent{Type: events.Updated, UUID: "uuid-5", ConceptType: "people"})
+
+	lines := readEvent(t, reader)
+	require.Len(t, lines, 3)
+	assert.Equal(t, "id: 5", lines[0])
+	assert.Equal(t, "event: updated", lines[1])
+	assert.Contains(t, lines[2], `"uuid":"uuid-5"`)
+}
+
+func TestStreamChangesAnnouncesBulkWrites(t *testing.T) {
+	broker := events.NewBroker(10, 10)
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := service.NewBulkProcessorConfig(1, 1, 1000000, time.Hour)
+	es := service.NewEsService(ecc, "concepts", &bulkProcessorConfig, service.WithStorage(service.NewMemoryStorage("concepts")), service.WithChangePublisher(broker))
+	defer es.CloseBulkProcessor()
+
+	server := httptest.NewServer(http.HandlerFunc(NewChangesHandler(broker, time.Minute).StreamChanges))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest("GET", server.URL+"/__changes?type=genres", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/bulk/{concept-type}/{id}", NewHandler(es, []string{"genres"}).LoadBulkData).Methods("PUT")
+	write := httptest.NewRequest("PUT", "/bulk/genres/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", bytes.NewReader([]byte(`{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Market Report","type":"Genre"}`)))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, write)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	lines := readEvent(t, bufio.NewReader(resp.Body))
+	require.Len(t, lines, 3)
+	assert.Equal(t, "id: 1", lines[0])
+	assert.Equal(t, "event: created", lines[1])
+	assert.Contains(t, lines[2], `"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580"`)
+	assert.Contains(t, lines[2], `"prefLabel":{"after":"Market Report"}`)
+}
+
+func TestStreamChangesInvalidLastEventID(t *testing.T) {
+	h := NewChangesHandler(events.NewBroker(10, 10), time.Minute)
+
+	req := httptest.NewRequest("GET", "/__changes", nil)
+	req.Header.Set("Last-Event-ID", "not-a-number")
+	rr := httptest.NewRecorder()
+	h.StreamChanges(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"code":"invalid-request","detail":"Invalid Last-Event-ID header"}`, responseBody(t, rr))
+}
+
+func publish(t *testing.T, broker *events.Broker, event events.ChangeEvent) {
+	require.NoError(t, broker.Publish(context.Background(), event))
+}
+
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
@@ -0,0 +1,32 @@
+package resources
+
+import (
+	"net/http"
+
+	log "github.com/Financial-Times/go-logger"
+)
+
+// ConfigReloader reloads the configuration of the service
+type ConfigReloader interface {
+	Reload() error
+}
+
+// ConfigHandler handles the administration of the service configuration
+type ConfigHandler struct {
+	reloader ConfigReloader
+}
+
+func NewConfigHandler(reloader ConfigReloader) *ConfigHandler {
+	return &ConfigHandler{reloader: reloader}
+}
+
+// Reload loads the configuration file again and applies it. The current configuration is kept if the file is invalid.
+func (h *ConfigHandler) Reload(w http.ResponseWriter, r *http.Request) {
+	if err := h.reloader.Reload(); err != nil {
+		log.WithError(err).Warn("Failed to reload the configuration.")
+		writeProblem(w, r, newProblem(codeInvalidConfig, http.StatusUnprocessableEntity, "Failed to reload the configuration: "+err.Error()))
+		return
+	}
+
+	writeMessage(w, "Configuration reloaded", http.StatusOK)
+}
@@ -0,0 +1,55 @@
+package resources
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type dummyReloader struct {
+	err     error
+	reloads int
+}
+
+func (r *dummyReloader) Reload() error {
+	r.reloads++
+	return r.err
+}
+
+func TestReloadConfig(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		status int
+		msg    string
+	}{
+		{
+			name:   "Reloaded",
+			status: http.StatusOK,
+			msg:    `{"message":"Configuration reloaded"}`,
+		},
+		{
+			name:   "Invalid configuration",
+			err:    errors.New("no concept types configured"),
+			status: http.StatusUnprocessableEntity,
+			msg:    `{"code":"invalid-configuration","detail":"Failed to reload the configuration: no concept types configured"}`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			reloader := &dummyReloader{err: test.err}
+			req := httptest.NewRequest("POST", "/__config/reload", nil)
+			rr := httptest.NewRecorder()
+
+			NewConfigHandler(reloader).Reload(rr, req)
+
+			assert.Equal(t, 1, reloader.reloads)
+			assert.Equal(t, test.status, rr.Code)
+			assert.JSONEq(t, test.msg, responseBody(t, rr))
+		})
+	}
+}
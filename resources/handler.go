@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Financial-Times/concept-rw-elasticsearch/config"
 	"github.com/Financial-Times/concept-rw-elasticsearch/service"
 	log "github.com/Financial-Times/go-logger"
 	tid "github.com/Financial-Times/transactionid-utils-go"
@@ -24,92 +28,221 @@ var (
 
 // Handler handles http calls
 type Handler struct {
-	elasticService      service.EsService
-	allowedConceptTypes map[string]bool
+	elasticService service.EsService
+	timeouts       Timeouts
+
+	typesLock    sync.RWMutex
+	conceptTypes *config.ConceptTypes
+}
+
+// Timeouts are the deadlines of the operations of the data endpoints, from the start of the request. A zero timeout sets no deadline.
+type Timeouts struct {
+	// Read bounds the reads and the diffs
+	Read time.Duration
+	// Write bounds the writes, including the reads before them and the cleanup of the concorded concepts
+	Write time.Duration
+	// Delete bounds the deletes
+	Delete time.Duration
+}
+
+// HandlerOption configures optional behaviour of the Handler
+type HandlerOption func(*Handler)
+
+// WithTimeouts bounds the time the data endpoints wait for Elasticsearch. The requests exceeding it fail with a 504.
+func WithTimeouts(timeouts Timeouts) HandlerOption {
+	return func(h *Handler) {
+		h.timeouts = timeouts
+	}
+}
+
+// NewHandler returns a handler of the whitelisted concept types, with the default behaviour for each of them
+func NewHandler(elasticService service.EsService, allowedConceptTypes []string, options ...HandlerOption) *Handler {
+	return NewConfiguredHandler(elasticService, config.FromWhitelist(allowedConceptTypes), options...)
+}
+
+// NewConfiguredHandler returns a handler of the configured concept types
+func NewConfiguredHandler(elasticService service.EsService, conceptTypes *config.ConceptTypes, options ...HandlerOption) *Handler {
+	h := &Handler{elasticService: elasticService, conceptTypes: conceptTypes}
+	for _, option := range options {
+		option(h)
+	}
+	return h
 }
 
-func NewHandler(elasticService service.EsService, allowedConceptTypes []string) *Handler {
-	allowedTypes := make(map[string]bool)
-	for _, v := range allowedConceptTypes {
-		allowedTypes[v] = true
+// requestContext returns the context of the request with its transaction ID, which is done once the request is cancelled
+// or the timeout given, if any, has elapsed
+func requestContext(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := tid.TransactionAwareContext(r.Context(), tid.GetTransactionIDFromRequest(r))
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// SetConceptTypes replaces the configuration of the handled concept types
+func (h *Handler) SetConceptTypes(conceptTypes *config.ConceptTypes) {
+	h.typesLock.Lock()
+	defer h.typesLock.Unlock()
 
-	return &Handler{elasticService: elasticService, allowedConceptTypes: allowedTypes}
+	h.conceptTypes = conceptTypes
+}
+
+// ConceptTypes returns the configuration of the handled concept types
+func (h *Handler) ConceptTypes() *config.ConceptTypes {
+	return h.types()
+}
+
+func (h *Handler) types() *config.ConceptTypes {
+	h.typesLock.RLock()
+	defer h.typesLock.RUnlock()
+
+	return h.conceptTypes
+}
+
+// GetConceptTypes returns the configuration of the handled concept types
+func (h *Handler) GetConceptTypes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.types(), http.StatusOK)
 }
 
 // LoadData processes a single ES concept entity
 func (h *Handler) LoadData(w http.ResponseWriter, r *http.Request) {
-	transactionID := tid.GetTransactionIDFromRequest(r)
-	ctx := tid.TransactionAwareContext(r.Context(), transactionID)
+	ctx, cancel := requestContext(r, h.timeouts.Write)
+	defer cancel()
 
-	conceptType, concept, esModel, err := h.processPayload(r.WithContext(ctx))
+	if t := h.types().Get(mux.Vars(r)["concept-type"]); t != nil && !t.Sync {
+		writeProblem(w, r, newProblem(codeWriteForbidden, http.StatusForbidden, "Sync writes are not allowed for this concept type"))
+		return
+	}
 
+	conceptType, concept, esModel, err := h.processPayload(r.WithContext(ctx))
 	if err != nil {
-		var errStatus int
-		switch err {
-		case errUnsupportedConceptType:
-			errStatus = http.StatusNotFound
-		default:
-			errStatus = http.StatusBadRequest
+		writeProblem(w, r, processingProblem(err))
+		return
+	}
+
+	if isDryRun(r) {
+		plan, err := h.elasticService.PlanLoadData(ctx, conceptType, concept.PreferredUUID(), esModel)
+		if err != nil {
+			writeLoadDataError(w, r, err)
+			return
 		}
-		writeMessage(w, err.Error(), errStatus)
+		h.writeDryRun(ctx, w, r, conceptType, concept, plan)
 		return
 	}
 
 	up, _, err := h.elasticService.LoadData(ctx, conceptType, concept.PreferredUUID(), esModel)
 
 	if err != nil {
-		if err == service.ErrNoElasticClient {
-			writeMessage(w, "ES unavailable", http.StatusServiceUnavailable)
-			return
-		}
-
-		log.WithError(err).Warn("Failed to write data to elasticsearch.")
-		writeMessage(w, "Failed to write data to ES", http.StatusInternalServerError)
+		writeLoadDataError(w, r, err)
 		return
 	}
 
 	if !up {
-		writeMessage(w, "Concept dropped", http.StatusNotModified)
+		// a 304 has no body, the concept is either unchanged or dropped
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	h.elasticService.CleanupData(ctx, concept)
+	h.cleanup(ctx, conceptType, concept)
 	writeMessage(w, "Concept written successfully", http.StatusOK)
 }
 
 // LoadBulkData write a concept to ES via the ES Bulk API
 func (h *Handler) LoadBulkData(w http.ResponseWriter, r *http.Request) {
-	transactionID := tid.GetTransactionIDFromRequest(r)
-	ctx := tid.TransactionAwareContext(r.Context(), transactionID)
+	ctx, cancel := requestContext(r, h.timeouts.Write)
+	defer cancel()
 
-	conceptType, concept, payload, err := h.processPayload(r.WithContext(ctx))
-	if err == errUnsupportedConceptType {
-		writeMessage(w, err.Error(), http.StatusNotFound)
+	if t := h.types().Get(mux.Vars(r)["concept-type"]); t != nil && !t.Bulk {
+		writeProblem(w, r, newProblem(codeWriteForbidden, http.StatusForbidden, "Bulk writes are not allowed for this concept type"))
 		return
 	}
 
+	conceptType, concept, payload, err := h.processPayload(r.WithContext(ctx))
 	if err != nil {
-		writeMessage(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, r, processingProblem(err))
+		return
+	}
+
+	if isDryRun(r) {
+		h.writeDryRun(ctx, w, r, conceptType, concept, service.PlanBulkLoad(conceptType, concept.PreferredUUID(), payload))
 		return
 	}
 
-	h.elasticService.LoadBulkData(conceptType, concept.PreferredUUID(), payload)
-	h.elasticService.CleanupData(ctx, concept)
+	if err := h.elasticService.LoadBulkData(ctx, conceptType, concept.PreferredUUID(), payload); err != nil {
+		writeProblem(w, r, esProblem(err, "Failed to queue the bulk write"))
+		return
+	}
+	h.cleanup(ctx, conceptType, concept)
 	writeMessage(w, "Concept written successfully", http.StatusOK)
 }
 
+// DiffData compares the stored document of a concept with the one a write of the payload would produce
+func (h *Handler) DiffData(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := requestContext(r, h.timeouts.Read)
+	defer cancel()
+
+	conceptType, concept, esModel, err := h.processPayload(r.WithContext(ctx))
+	if err != nil {
+		writeProblem(w, r, processingProblem(err))
+		return
+	}
+
+	diff, err := h.elasticService.DiffData(ctx, conceptType, concept.PreferredUUID(), esModel)
+	if err != nil {
+		if err != service.ErrNoElasticClient {
+			log.WithError(err).Warn("Failed to read data from elasticsearch.")
+		}
+		writeProblem(w, r, esProblem(err, "Failed to read data from ES"))
+		return
+	}
+
+	writeJSON(w, diff, http.StatusOK)
+}
+
+// cleanup deletes the concorded concepts, unless the concept type is configured not to
+func (h *Handler) cleanup(ctx context.Context, conceptType string, concept service.Concept) {
+	if t := h.types().Get(conceptType); t != nil && t.Cleanup {
+		h.elasticService.CleanupData(ctx, concept)
+	}
+}
+
+// writeDryRun completes the plan of a write with the cleanup of concorded concepts and returns it to the client
+func (h *Handler) writeDryRun(ctx context.Context, w http.ResponseWriter, r *http.Request, conceptType string, concept service.Concept, plan *service.WritePlan) {
+	if t := h.types().Get(conceptType); !plan.Dropped && t != nil && t.Cleanup {
+		deletes, err := h.elasticService.PlanCleanupData(ctx, concept)
+		if err != nil {
+			log.WithError(err).Warn("Failed to find concorded concepts in elasticsearch.")
+			writeProblem(w, r, esProblem(err, "Failed to read concorded concepts from ES"))
+			return
+		}
+		plan.Deletes = deletes
+	}
+
+	writeJSON(w, plan, http.StatusOK)
+}
+
+func writeLoadDataError(w http.ResponseWriter, r *http.Request, err error) {
+	if err != service.ErrNoElasticClient {
+		log.WithError(err).Warn("Failed to write data to elasticsearch.")
+	}
+	writeProblem(w, r, esProblem(err, "Failed to write data to ES"))
+}
+
+func isDryRun(r *http.Request) bool {
+	return strings.ToLower(r.URL.Query().Get("dryRun")) == "true"
+}
+
 // LoadMetrics updates a concept with new metric data
 func (h *Handler) LoadMetrics(w http.ResponseWriter, r *http.Request) {
-	transactionID := tid.GetTransactionIDFromRequest(r)
-	ctx := tid.TransactionAwareContext(context.Background(), transactionID)
+	ctx, cancel := requestContext(r, h.timeouts.Write)
+	defer cancel()
 
 	vars := mux.Vars(r)
 	uuid := vars["id"]
 	conceptType := vars["concept-type"]
 
-	if !h.allowedConceptTypes[conceptType] {
-		writeMessage(w, errUnsupportedConceptType.Error(), http.StatusNotFound)
+	if h.types().Get(conceptType) == nil {
+		writeProblem(w, r, processingProblem(errUnsupportedConceptType))
 		return
 	}
 
@@ -119,12 +252,12 @@ func (h *Handler) LoadMetrics(w http.ResponseWriter, r *http.Request) {
 	err := dec.Decode(&metrics)
 
 	if err != nil {
-		writeMessage(w, err.Error(), http.StatusBadRequest)
+		writeProblem(w, r, newProblem(codeInvalidModel, http.StatusBadRequest, err.Error()))
 		return
 	}
 
 	if metrics.Metrics == nil {
-		writeMessage(w, "Please supply metrics as a JSON object with a single property 'metrics'", http.StatusBadRequest)
+		writeProblem(w, r, newProblem(codeInvalidModel, http.StatusBadRequest, "Please supply metrics as a JSON object with a single property 'metrics'"))
 		return
 	}
 
@@ -137,15 +270,19 @@ func (h *Handler) processPayload(r *http.Request) (conceptType string, concept s
 	uuid := vars["id"]
 	conceptType = vars["concept-type"]
 
-	if !h.allowedConceptTypes[conceptType] {
+	typeConfig := h.types().Get(conceptType)
+	if typeConfig == nil {
 		return "", nil, nil, errUnsupportedConceptType
 	}
 
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
 	if err != nil {
 		log.WithError(err).Error("Failed to read request body")
 		return "", nil, nil, errProcessingBody
 	}
+	if len(body) > maxBodySize {
+		return conceptType, nil, nil, &validationError{violations: []Violation{{Message: fmt.Sprintf("the payload is larger than %d bytes", maxBodySize)}}}
+	}
 
 	aggConceptModel, err := isAggregateConceptModel(body)
 	if err != nil {
@@ -154,28 +291,40 @@ func (h *Handler) processPayload(r *http.Request) (conceptType string, concept s
 	}
 
 	if aggConceptModel {
-		concept, esModel, err = processAggregateConceptModel(r.Context(), uuid, conceptType, body)
+		var aggConcept service.AggregateConceptModel
+		aggConcept, esModel, err = processAggregateConceptModel(r.Context(), uuid, conceptType, typeConfig, body)
+		if err == nil {
+			service.AddExtraFields(esModel, aggConcept, typeConfig.ExtraFields)
+		}
+		concept = aggConcept
 	} else {
-		concept, esModel, err = processConceptModel(r.Context(), uuid, conceptType, body)
+		concept, esModel, err = processConceptModel(r.Context(), uuid, conceptType, typeConfig, body)
+	}
+	if verr, ok := err.(*validationError); ok {
+		log.WithField("prefUUID", uuid).WithField("violations", verr.violations).Info("Concept model failed validation.")
 	}
-
 	return conceptType, concept, esModel, err
 }
 
-func processConceptModel(ctx context.Context, uuid string, conceptType string, body []byte) (concept service.ConceptModel, payload service.EsModel, err error) {
+func processConceptModel(ctx context.Context, uuid string, conceptType string, typeConfig *config.ConceptType, body []byte) (concept service.ConceptModel, payload service.EsModel, err error) {
 	err = json.Unmarshal(body, &concept)
 	if err != nil {
 		log.WithError(err).Info("Failed to unmarshal body into concept model.")
 		return concept, payload, errProcessingBody
 	}
 
-	if concept.UUID != uuid {
-		return concept, payload, errPathUUID
-	}
-
-	if concept.DirectType == "" || concept.PrefLabel == "" {
-		return concept, payload, errInvalidConceptModel
+	err = validateConcept(uuid, conceptType, typeConfig, conceptPayload{
+		uuidField:  "uuid",
+		uuid:       concept.UUID,
+		directType: concept.DirectType,
+		prefLabel:  concept.PrefLabel,
+		aliases:    concept.Aliases,
+		scopeNote:  concept.ScopeNote,
+	}, body)
+	if err != nil {
+		return concept, payload, err
 	}
+	concept.Aliases = uniqueAliases(concept.Aliases)
 
 	transactionID, err := tid.GetTransactionIDFromContext(ctx)
 
@@ -189,20 +338,38 @@ func processConceptModel(ctx context.Context, uuid string, conceptType string, b
 	return concept, payload, err
 }
 
-func processAggregateConceptModel(ctx context.Context, uuid string, conceptType string, body []byte) (concept service.AggregateConceptModel, esModel service.EsModel, err error) {
+func processAggregateConceptModel(ctx context.Context, uuid string, conceptType string, typeConfig *config.ConceptType, body []byte) (concept service.AggregateConceptModel, esModel service.EsModel, err error) {
 	err = json.Unmarshal(body, &concept)
 	if err != nil {
 		log.WithError(err).Info("Failed to unmarshal body into aggregate concept model.")
 		return concept, nil, errProcessingBody
 	}
 
-	if concept.PrefUUID != uuid {
-		return concept, nil, errPathUUID
+	var sourceUUIDs []string
+	for _, source := range concept.SourceRepresentations {
+		sourceUUIDs = append(sourceUUIDs, source.UUID)
+	}
+	var modelErr error
+	if concept.DirectType != "" {
+		modelErr = service.ConverterFor(conceptType, concept.DirectType).Validate(concept)
 	}
 
-	if concept.DirectType == "" || concept.PrefLabel == "" {
-		return concept, nil, errInvalidConceptModel
+	err = validateConcept(uuid, conceptType, typeConfig, conceptPayload{
+		uuidField:        "prefUUID",
+		uuid:             concept.PrefUUID,
+		directType:       concept.DirectType,
+		prefLabel:        concept.PrefLabel,
+		aliases:          concept.Aliases,
+		scopeNote:        concept.ScopeNote,
+		sourceUUIDs:      sourceUUIDs,
+		personUUID:       concept.PersonUUID,
+		organisationUUID: concept.OrganisationUUID,
+		modelErr:         modelErr,
+	}, body)
+	if err != nil {
+		return concept, nil, err
 	}
+	concept.Aliases = uniqueAliases(concept.Aliases)
 
 	transactionID, err := tid.GetTransactionIDFromContext(ctx)
 
@@ -216,25 +383,22 @@ func processAggregateConceptModel(ctx context.Context, uuid string, conceptType
 }
 
 func (h *Handler) ReadData(writer http.ResponseWriter, request *http.Request) {
+	ctx, cancel := requestContext(request, h.timeouts.Read)
+	defer cancel()
+
 	uuid := mux.Vars(request)["id"]
 	conceptType := mux.Vars(request)["concept-type"]
 
-	getResult, err := h.elasticService.ReadData(conceptType, uuid)
+	getResult, err := h.elasticService.ReadData(ctx, conceptType, uuid)
 
 	if err != nil {
 		log.Error(err.Error())
-
-		if err == service.ErrNoElasticClient {
-			writer.WriteHeader(http.StatusServiceUnavailable)
-		} else {
-			writer.WriteHeader(http.StatusInternalServerError)
-		}
-
+		writeProblem(writer, request, esProblem(err, "Failed to read data from ES"))
 		return
 	}
 
 	if !getResult.Found {
-		writer.WriteHeader(http.StatusNotFound)
+		writeProblem(writer, request, newProblem(codeNotFound, http.StatusNotFound, "Concept not found"))
 		return
 	}
 
@@ -245,8 +409,8 @@ func (h *Handler) ReadData(writer http.ResponseWriter, request *http.Request) {
 
 // DeleteData handles a delete for a concept
 func (h *Handler) DeleteData(writer http.ResponseWriter, request *http.Request) {
-	transactionID := tid.GetTransactionIDFromRequest(request)
-	ctx := tid.TransactionAwareContext(context.Background(), transactionID)
+	ctx, cancel := requestContext(request, h.timeouts.Delete)
+	defer cancel()
 
 	uuid := mux.Vars(request)["id"]
 	conceptType := mux.Vars(request)["concept-type"]
@@ -255,12 +419,12 @@ func (h *Handler) DeleteData(writer http.ResponseWriter, request *http.Request)
 
 	if err != nil {
 		log.Errorf(err.Error())
-		writer.WriteHeader(http.StatusInternalServerError)
+		writeProblem(writer, request, esProblem(err, "Failed to delete data from ES"))
 		return
 	}
 
 	if !res.Found {
-		writer.WriteHeader(http.StatusNotFound)
+		writeProblem(writer, request, newProblem(codeNotFound, http.StatusNotFound, "Concept not found"))
 		return
 	}
 
@@ -268,8 +432,9 @@ func (h *Handler) DeleteData(writer http.ResponseWriter, request *http.Request)
 }
 
 func (h *Handler) GetAllIds(writer http.ResponseWriter, request *http.Request) {
-	transactionID := tid.GetTransactionIDFromRequest(request)
-	ctx := tid.TransactionAwareContext(context.Background(), transactionID)
+	// the ids are streamed for as long as it takes, until the client goes away
+	ctx, cancel := requestContext(request, 0)
+	defer cancel()
 
 	includeTypes := strings.ToLower(request.URL.Query().Get("includeTypes")) == "true"
 
@@ -303,6 +468,15 @@ func writeMessage(w http.ResponseWriter, msg string, status int) {
 	w.Write(data)
 }
 
+func writeJSON(w http.ResponseWriter, v interface{}, status int) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(v); err != nil {
+		log.WithError(err).Error("Failed to write response body")
+	}
+}
+
 func isAggregateConceptModel(body []byte) (bool, error) {
 	data := make(map[string]interface{})
 	err := json.Unmarshal(body, &data)
@@ -3,13 +3,17 @@ package resources
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"context"
 
+	"github.com/Financial-Times/concept-rw-elasticsearch/config"
 	"github.com/Financial-Times/concept-rw-elasticsearch/service"
 	"github.com/Financial-Times/go-logger"
 	tid "github.com/Financial-Times/transactionid-utils-go"
@@ -35,26 +39,27 @@ func TestCreateNewESWriter(t *testing.T) {
 
 	allowedTypes := []string{"organisations", "genres"}
 	writerService := NewHandler(dummyEsService, allowedTypes)
-	assert.True(t, writerService.allowedConceptTypes["organisations"])
-	assert.True(t, writerService.allowedConceptTypes["genres"])
-	assert.False(t, writerService.allowedConceptTypes["something else"])
+	assert.NotNil(t, writerService.conceptTypes.Get("organisations"))
+	assert.NotNil(t, writerService.conceptTypes.Get("genres"))
+	assert.Nil(t, writerService.conceptTypes.Get("something else"))
 }
 
 func TestCreateNewESWriterWithEmptyWhitelist(t *testing.T) {
 	dummyEsService := &dummyEsService{}
 	var allowedTypes []string
 	writerService := NewHandler(dummyEsService, allowedTypes)
-	assert.Equal(t, 0, len(writerService.allowedConceptTypes))
+	assert.Empty(t, writerService.conceptTypes.Names())
 }
 
 func TestLoadData(t *testing.T) {
 	testCases := []struct {
-		name    string
-		path    string
-		payload string
-		status  int
-		msg     string
-		noop    bool
+		name      string
+		path      string
+		payload   string
+		status    int
+		msg       string
+		noop      bool
+		unchanged bool
 	}{
 		{
 			name:    "Successful write",
@@ -74,64 +79,70 @@ func TestLoadData(t *testing.T) {
 			name:    "Model dropped",
 			payload: `{"prefUUID":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Smartlogics Brands PrefLabel","type":"Brand","strapline":"Some strapline","descriptionXML":"Some description","_imageUrl":"Some image url","sourceRepresentations":[{"uuid":"4ebbd9c4-3bb7-4d18-a14c-4c45aac5d966","prefLabel":"TMEs PrefLabel","type":"Brand","authority":"TME","authorityValue":"745212"},{"uuid":"56388858-38d6-4dfc-a001-506394259b51","prefLabel":"Smartlogics Brands PrefLabel","type":"Brand","authority":"Smartlogic","authorityValue":"123456789","lastModifiedEpoch":1498127042,"strapline":"Some strapline","descriptionXML":"Some description","_imageUrl":"Some image url"}]}`,
 			status:  http.StatusNotModified,
-			msg:     `{"message":"Concept dropped"}`,
 			noop:    true,
 			path:    "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
+		{
+			name:      "Model unchanged",
+			payload:   `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","alternativeIdentifiers":{"TME":["Mg==-R2VucmVz"],"uuids":["8ff7dfef-0330-3de0-b37a-2d6aa9c98580"]},"prefLabel":"Market Report","type":"Genre"}`,
+			status:    http.StatusNotModified,
+			unchanged: true,
+			path:      "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
+		},
 		{
 			name:    "Path contains different uuid to body",
 			payload: `{"uuid":"different-uuid","alternativeIdentifiers":{"TME":["Mg==-R2VucmVz"],"uuids":["8ff7dfef-0330-3de0-b37a-2d6aa9c98580"]},"prefLabel":"Market Report","type":"Genre"}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Provided path UUID does not match request body"}`,
+			msg:     `{"code":"uuid-mismatch","detail":"Provided path UUID does not match request body","violations":[{"field":"uuid","message":"does not match the path UUID"}]}`,
 			path:    "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Path contains unsupported concept type",
 			payload: `{"uuid":"different-uuid","alternativeIdentifiers":{"TME":["Mg==-R2VucmVz"],"uuids":["8ff7dfef-0330-3de0-b37a-2d6aa9c98580"]},"prefLabel":"Market Report","type":"Genre"}`,
 			status:  http.StatusNotFound,
-			msg:     `{"message":"Unsupported or invalid concept type"}`,
+			msg:     `{"code":"unsupported-type","detail":"Unsupported or invalid concept type"}`,
 			path:    "/invalid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Body contains empty type",
 			payload: `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","alternativeIdentifiers":{"TME":["Mg==-R2VucmVz"],"uuids":["8ff7dfef-0330-3de0-b37a-2d6aa9c98580"]},"prefLabel":"Market Report"}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Invalid or incomplete concept model"}`,
+			msg:     `{"code":"invalid-model","detail":"Invalid or incomplete concept model","violations":[{"field":"type","message":"is required"}]}`,
 			path:    "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Body contains empty prefLabel",
 			payload: `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","alternativeIdentifiers":{"TME":["Mg==-R2VucmVz"],"uuids":["8ff7dfef-0330-3de0-b37a-2d6aa9c98580"]},"type":"Genre"}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Invalid or incomplete concept model"}`,
+			msg:     `{"code":"invalid-model","detail":"Invalid or incomplete concept model","violations":[{"field":"prefLabel","message":"is required"}]}`,
 			path:    "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Path contains different uuid to aggregate model body",
 			payload: `{"prefUUID":"different-uuid","prefLabel":"Smartlogics Brands PrefLabel","strapline":"Some strapline","descriptionXML":"Some description","_imageUrl":"Some image url","sourceRepresentations":[{"uuid":"4ebbd9c4-3bb7-4d18-a14c-4c45aac5d966","prefLabel":"TMEs PrefLabel","type":"Brand","authority":"TME","authorityValue":"745212"},{"uuid":"56388858-38d6-4dfc-a001-506394259b51","prefLabel":"Smartlogics Brands PrefLabel","type":"Brand","authority":"Smartlogic","authorityValue":"123456789","lastModifiedEpoch":1498127042,"strapline":"Some strapline","descriptionXML":"Some description","_imageUrl":"Some image url"}]}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Provided path UUID does not match request body"}`,
+			msg:     `{"code":"uuid-mismatch","detail":"Provided path UUID does not match request body","violations":[{"field":"prefUUID","message":"does not match the path UUID"},{"field":"type","message":"is required"}]}`,
 			path:    "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Aggregate model body contains empty type",
 			payload: `{"prefUUID":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Smartlogics Brands PrefLabel","strapline":"Some strapline","descriptionXML":"Some description","_imageUrl":"Some image url","sourceRepresentations":[{"uuid":"4ebbd9c4-3bb7-4d18-a14c-4c45aac5d966","prefLabel":"TMEs PrefLabel","type":"Brand","authority":"TME","authorityValue":"745212"},{"uuid":"56388858-38d6-4dfc-a001-506394259b51","prefLabel":"Smartlogics Brands PrefLabel","type":"Brand","authority":"Smartlogic","authorityValue":"123456789","lastModifiedEpoch":1498127042,"strapline":"Some strapline","descriptionXML":"Some description","_imageUrl":"Some image url"}]}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Invalid or incomplete concept model"}`,
+			msg:     `{"code":"invalid-model","detail":"Invalid or incomplete concept model","violations":[{"field":"type","message":"is required"}]}`,
 			path:    "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Aggregate model body contains empty prefLabel",
 			payload: `{"prefUUID":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","type":"Brands","strapline":"Some strapline","descriptionXML":"Some description","_imageUrl":"Some image url","sourceRepresentations":[{"uuid":"4ebbd9c4-3bb7-4d18-a14c-4c45aac5d966","prefLabel":"TMEs PrefLabel","type":"Brand","authority":"TME","authorityValue":"745212"},{"uuid":"56388858-38d6-4dfc-a001-506394259b51","prefLabel":"Smartlogics Brands PrefLabel","type":"Brand","authority":"Smartlogic","authorityValue":"123456789","lastModifiedEpoch":1498127042,"strapline":"Some strapline","descriptionXML":"Some description","_imageUrl":"Some image url"}]}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Invalid or incomplete concept model"}`,
+			msg:     `{"code":"invalid-model","detail":"Invalid or incomplete concept model","violations":[{"field":"prefLabel","message":"is required"}]}`,
 			path:    "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Body contains invalid json",
 			payload: `{wrong data}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Request body is not in the expected concept model format"}`,
+			msg:     `{"code":"invalid-model","detail":"Request body is not in the expected concept model format"}`,
 			path:    "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
@@ -145,21 +156,21 @@ func TestLoadData(t *testing.T) {
 			name:    "Bulk request body contains invalid json",
 			payload: `{wrong data}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Request body is not in the expected concept model format"}`,
+			msg:     `{"code":"invalid-model","detail":"Request body is not in the expected concept model format"}`,
 			path:    "/bulk/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Bulk request unsupported concept type",
 			payload: `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","alternativeIdentifiers":{"TME":["Mg==-R2VucmVz"],"uuids":["8ff7dfef-0330-3de0-b37a-2d6aa9c98580"]},"prefLabel":"Market Report","type":"Genre"}`,
 			status:  http.StatusNotFound,
-			msg:     `{"message":"Unsupported or invalid concept type"}`,
+			msg:     `{"code":"unsupported-type","detail":"Unsupported or invalid concept type"}`,
 			path:    "/bulk/invalid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Bulk path contains different uuid to body",
 			payload: `{"uuid":"different-uuid","alternativeIdentifiers":{"TME":["Mg==-R2VucmVz"],"uuids":["8ff7dfef-0330-3de0-b37a-2d6aa9c98580"]},"prefLabel":"Market Report","type":"Genre"}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Provided path UUID does not match request body"}`,
+			msg:     `{"code":"uuid-mismatch","detail":"Provided path UUID does not match request body","violations":[{"field":"uuid","message":"does not match the path UUID"}]}`,
 			path:    "/bulk/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
@@ -173,14 +184,14 @@ func TestLoadData(t *testing.T) {
 			name:    "Metrics are not written for invalid type",
 			payload: `{"metrics":{"annotationsCount": 796, "prevWeekAnnotationsCount": 79}`,
 			status:  http.StatusNotFound,
-			msg:     `{"message":"Unsupported or invalid concept type"}`,
+			msg:     `{"code":"unsupported-type","detail":"Unsupported or invalid concept type"}`,
 			path:    "/metrics/invalid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 		{
 			name:    "Metrics are only written if they are supplied correctly",
 			payload: `{"somethingDodgy":{"annotationsCount": 796, "prevWeekAnnotationsCount": 79}}`,
 			status:  http.StatusBadRequest,
-			msg:     `{"message":"Please supply metrics as a JSON object with a single property 'metrics'"}`,
+			msg:     `{"code":"invalid-model","detail":"Please supply metrics as a JSON object with a single property 'metrics'"}`,
 			path:    "/metrics/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
 		},
 	}
@@ -192,7 +203,7 @@ func TestLoadData(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 
-			dummyEsService := &dummyEsService{noop: tc.noop}
+			dummyEsService := &dummyEsService{noop: tc.noop, unchanged: tc.unchanged}
 			writerService := NewHandler(dummyEsService, []string{"valid-type"})
 
 			servicesRouter := mux.NewRouter()
@@ -202,11 +213,159 @@ func TestLoadData(t *testing.T) {
 			servicesRouter.ServeHTTP(rr, req)
 
 			assert.Equal(t, tc.status, rr.Code, `Current test "%v"`, tc.name)
-			assert.JSONEq(t, tc.msg, rr.Body.String(), `Current test "%v"`, tc.name)
+			if tc.status == http.StatusNotModified {
+				assert.Empty(t, rr.Body.String(), `Current test "%v"`, tc.name)
+				return
+			}
+			assert.JSONEq(t, tc.msg, responseBody(t, rr), `Current test "%v"`, tc.name)
+		})
+	}
+}
+
+func TestLoadDataIncompleteMembership(t *testing.T) {
+	payload := `{"prefUUID":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Journalist","type":"Membership","organisationUUID":"7bcfe07b-0fb1-49ce-a5fa-e51d5c01c3e0","membershipRoles":[{"membershipRoleUUID":"33ee38a4-c677-4952-a141-2ae14da3aedd"}],"sourceRepresentations":[{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","authority":"Smartlogic"}]}`
+	req, err := http.NewRequest("PUT", "/memberships/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", bytes.NewReader([]byte(payload)))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	dummyEsService := &dummyEsService{}
+	writerService := NewHandler(dummyEsService, []string{"memberships"})
+
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/{concept-type}/{id}", writerService.LoadData).Methods("PUT")
+	servicesRouter.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"code":"invalid-model","detail":"Invalid or incomplete concept model","violations":[{"message":"membership without person or organisation"}]}`, responseBody(t, rr))
+	assert.Equal(t, 0, dummyEsService.writes)
+}
+
+func TestLoadDataWithConceptTypesConfig(t *testing.T) {
+	conceptTypes, err := config.ParseConceptTypes([]byte(`
+types:
+  - name: organisations
+    directTypes: [Organisation, PublicCompany]
+    requiredFields: [aliases]
+    extraFields: [countryCode]
+    cleanup: false
+  - name: genres
+    sync: false
+  - name: brands
+    bulk: false
+`))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		path     string
+		payload  string
+		status   int
+		msg      string
+		writes   int
+		cleanups int
+	}{
+		{
+			name:    "Write without cleanup",
+			path:    "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
+			payload: `{"prefUUID":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Apple","type":"PublicCompany","aliases":["Apple Inc"],"countryCode":"US","sourceRepresentations":[{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","authority":"Smartlogic"},{"uuid":"4ebbd9c4-3bb7-4d18-a14c-4c45aac5d966","authority":"FACTSET"}]}`,
+			status:  http.StatusOK,
+			msg:     `{"message":"Concept written successfully"}`,
+			writes:  1,
+		},
+		{
+			name:    "Direct type not allowed",
+			path:    "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
+			payload: `{"prefUUID":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Apple","type":"PrivateCompany","aliases":["Apple Inc"],"sourceRepresentations":[]}`,
+			status:  http.StatusUnprocessableEntity,
+			msg:     `{"code":"invalid-model","detail":"Concept model failed validation","violations":[{"field":"type","message":"PrivateCompany is not one of the direct types allowed for organisations: Organisation, PublicCompany"}]}`,
+		},
+		{
+			name:    "Required field missing",
+			path:    "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
+			payload: `{"prefUUID":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Apple","type":"PublicCompany","aliases":[],"sourceRepresentations":[]}`,
+			status:  http.StatusBadRequest,
+			msg:     `{"code":"invalid-model","detail":"Invalid or incomplete concept model","violations":[{"field":"aliases","message":"is required for organisations"}]}`,
+		},
+		{
+			name:    "Sync write not allowed",
+			path:    "/genres/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
+			payload: `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Market Report","type":"Genre"}`,
+			status:  http.StatusForbidden,
+			msg:     `{"code":"write-forbidden","detail":"Sync writes are not allowed for this concept type"}`,
+		},
+		{
+			name:    "Bulk write not allowed",
+			path:    "/bulk/brands/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
+			payload: `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Lex","type":"Brand"}`,
+			status:  http.StatusForbidden,
+			msg:     `{"code":"write-forbidden","detail":"Bulk writes are not allowed for this concept type"}`,
+		},
+		{
+			name:     "Bulk write with cleanup",
+			path:     "/bulk/genres/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
+			payload:  `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Market Report","type":"Genre"}`,
+			status:   http.StatusOK,
+			msg:      `{"message":"Concept written successfully"}`,
+			writes:   1,
+			cleanups: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("PUT", tc.path, bytes.NewReader([]byte(tc.payload)))
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			dummyEsService := &dummyEsService{}
+			writerService := NewConfiguredHandler(dummyEsService, conceptTypes)
+
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/{concept-type}/{id}", writerService.LoadData).Methods("PUT")
+			servicesRouter.HandleFunc("/bulk/{concept-type}/{id}", writerService.LoadBulkData).Methods("PUT")
+			servicesRouter.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.JSONEq(t, tc.msg, responseBody(t, rr))
+			assert.Equal(t, tc.writes, dummyEsService.writes)
+			assert.Equal(t, tc.cleanups, dummyEsService.cleanups)
 		})
 	}
 }
 
+func TestLoadDataIndexesExtraFields(t *testing.T) {
+	conceptTypes, err := config.ParseConceptTypes([]byte(`{"types":[{"name":"organisations","extraFields":["countryCode"]}]}`))
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("PUT", "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580?dryRun=true", bytes.NewReader([]byte(`{"prefUUID":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Acme","type":"PrivateCompany","countryCode":"GB","countryOfIncorporation":"GB","sourceRepresentations":[]}`)))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/{concept-type}/{id}", NewConfiguredHandler(&dummyEsService{}, conceptTypes).LoadData).Methods("PUT")
+	servicesRouter.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var plan struct {
+		Index struct {
+			Document map[string]interface{} `json:"document"`
+		} `json:"index"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &plan))
+	assert.Equal(t, "GB", plan.Index.Document["countryCode"])
+	assert.NotContains(t, plan.Index.Document, "countryOfIncorporation", "only configured fields are indexed for private companies")
+}
+
+func TestGetConceptTypes(t *testing.T) {
+	req := httptest.NewRequest("GET", "/__config/types", nil)
+	rr := httptest.NewRecorder()
+
+	NewHandler(&dummyEsService{}, []string{"genres"}).GetConceptTypes(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"types":[{"name":"genres","sync":true,"bulk":true,"cleanup":true}]}`, rr.Body.String())
+}
+
 func TestLoadDataEsClientServerErrors(t *testing.T) {
 	testCases := []struct {
 		err    error
@@ -216,12 +375,27 @@ func TestLoadDataEsClientServerErrors(t *testing.T) {
 		{
 			err:    errTest,
 			status: http.StatusInternalServerError,
-			msg:    `{"message":"Failed to write data to ES"}`,
+			msg:    `{"code":"internal-error","detail":"Failed to write data to ES"}`,
 		},
 		{
 			err:    service.ErrNoElasticClient,
 			status: http.StatusServiceUnavailable,
-			msg:    `{"message":"ES unavailable"}`,
+			msg:    `{"code":"es-unavailable","detail":"ES unavailable"}`,
+		},
+		{
+			err:    &elastic.Error{Status: http.StatusForbidden, Details: &elastic.ErrorDetails{Type: "cluster_block_exception", Reason: "blocked by: [FORBIDDEN/12/index read-only / allow delete (api)];"}},
+			status: http.StatusServiceUnavailable,
+			msg:    `{"code":"index-read-only","detail":"The index does not accept writes"}`,
+		},
+		{
+			err:    &elastic.Error{Status: http.StatusConflict, Details: &elastic.ErrorDetails{Type: "version_conflict_engine_exception", Reason: "version conflict"}},
+			status: http.StatusConflict,
+			msg:    `{"code":"stale-version","detail":"The concept has been changed by another write"}`,
+		},
+		{
+			err:    &service.ConceptTypeConflictError{UUID: "8ff7dfef-0330-3de0-b37a-2d6aa9c98580", ConceptType: "valid-type", StoredType: "people"},
+			status: http.StatusConflict,
+			msg:    `{"code":"concept-type-conflict","detail":"The concept is stored as people"}`,
 		},
 	}
 
@@ -240,7 +414,154 @@ func TestLoadDataEsClientServerErrors(t *testing.T) {
 			servicesRouter.ServeHTTP(rr, req)
 
 			assert.Equal(t, tc.status, rr.Code)
-			assert.JSONEq(t, tc.msg, rr.Body.String())
+			assert.JSONEq(t, tc.msg, responseBody(t, rr))
+		})
+	}
+}
+
+func TestLoadDataDryRun(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		noop     bool
+		status   int
+		expected string
+	}{
+		{
+			name:     "Sync write",
+			path:     "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580?dryRun=true",
+			status:   http.StatusOK,
+			expected: `{"dropped":false,"unchanged":false,"index":{"conceptType":"valid-type","uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","document":{"id":"http://api.ft.com/things/8ff7dfef-0330-3de0-b37a-2d6aa9c98580","apiUrl":"http://api.ft.com/brands/8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Smartlogics Brands PrefLabel","types":["http://www.ft.com/ontology/core/Thing","http://www.ft.com/ontology/concept/Concept","http://www.ft.com/ontology/classification/Classification","http://www.ft.com/ontology/product/Brand"],"authorities":["TME","Smartlogic"],"directType":"http://www.ft.com/ontology/product/Brand","lastModified":"<lastModified>","publishReference":"tid_dryrun"}},"deletes":[{"conceptType":"valid-type","uuid":"4ebbd9c4-3bb7-4d18-a14c-4c45aac5d966"}]}`,
+		},
+		{
+			name:     "Bulk write",
+			path:     "/bulk/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580?dryRun=true",
+			status:   http.StatusOK,
+			expected: `{"dropped":false,"unchanged":false,"index":{"conceptType":"valid-type","uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","document":{"id":"http://api.ft.com/things/8ff7dfef-0330-3de0-b37a-2d6aa9c98580","apiUrl":"http://api.ft.com/brands/8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Smartlogics Brands PrefLabel","types":["http://www.ft.com/ontology/core/Thing","http://www.ft.com/ontology/concept/Concept","http://www.ft.com/ontology/classification/Classification","http://www.ft.com/ontology/product/Brand"],"authorities":["TME","Smartlogic"],"directType":"http://www.ft.com/ontology/product/Brand","lastModified":"<lastModified>","publishReference":"tid_dryrun"}},"deletes":[{"conceptType":"valid-type","uuid":"4ebbd9c4-3bb7-4d18-a14c-4c45aac5d966"}]}`,
+		},
+		{
+			name:     "Dropped sync write",
+			path:     "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580?dryRun=true",
+			noop:     true,
+			status:   http.StatusOK,
+			expected: `{"dropped":true,"unchanged":false}`,
+		},
+	}
+
+	payload := `{"prefUUID":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Smartlogics Brands PrefLabel","type":"Brand","sourceRepresentations":[{"uuid":"4ebbd9c4-3bb7-4d18-a14c-4c45aac5d966","prefLabel":"TMEs PrefLabel","type":"Brand","authority":"TME","authorityValue":"745212"},{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Smartlogics Brands PrefLabel","type":"Brand","authority":"Smartlogic","authorityValue":"123456789"}]}`
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("PUT", tc.path, bytes.NewReader([]byte(payload)))
+			require.NoError(t, err)
+			req.Header.Set(tid.TransactionIDHeader, "tid_dryrun")
+
+			rr := httptest.NewRecorder()
+			dummyEsService := &dummyEsService{noop: tc.noop}
+			writerService := NewHandler(dummyEsService, []string{"valid-type"})
+
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/{concept-type}/{id}", writerService.LoadData).Methods("PUT")
+			servicesRouter.HandleFunc("/bulk/{concept-type}/{id}", writerService.LoadBulkData).Methods("PUT")
+			servicesRouter.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.Equal(t, 0, dummyEsService.writes, "dry run should not write")
+
+			var actual map[string]interface{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &actual))
+			if index, ok := actual["index"].(map[string]interface{}); ok {
+				document := index["document"].(map[string]interface{})
+				assert.NotEmpty(t, document["lastModified"])
+				document["lastModified"] = "<lastModified>"
+			}
+			actualJSON, err := json.Marshal(actual)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(actualJSON))
+		})
+	}
+}
+
+func TestLoadDataDryRunEsUnavailable(t *testing.T) {
+	req, err := http.NewRequest("PUT", "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580?dryRun=true", bytes.NewReader([]byte(`{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Market Report","type":"Genre"}`)))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	writerService := NewHandler(&dummyEsService{returnsError: service.ErrNoElasticClient}, []string{"valid-type"})
+
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/{concept-type}/{id}", writerService.LoadData).Methods("PUT")
+	servicesRouter.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `{"code":"es-unavailable","detail":"ES unavailable"}`, responseBody(t, rr))
+}
+
+func TestLoadBulkDataQueueFull(t *testing.T) {
+	req, err := http.NewRequest("PUT", "/bulk/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", bytes.NewReader([]byte(`{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Market Report","type":"Genre"}`)))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	writerService := NewHandler(&dummyEsService{returnsError: &service.BulkQueueFullError{Queued: 1000, RetryAfter: 5 * time.Second}}, []string{"valid-type"})
+
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/bulk/{concept-type}/{id}", writerService.LoadBulkData).Methods("PUT")
+	servicesRouter.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "5", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"code":"bulk-queue-full","detail":"Too many bulk writes are waiting to be sent to ES"}`, responseBody(t, rr))
+}
+
+func TestDiffData(t *testing.T) {
+	testCases := []struct {
+		name     string
+		esError  error
+		found    bool
+		status   int
+		expected string
+	}{
+		{
+			name:     "New concept",
+			status:   http.StatusOK,
+			expected: `{"conceptType":"valid-type","uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","dropped":false,"found":false,"differences":[{"path":"prefLabel","stored":null,"candidate":"Label"}]}`,
+		},
+		{
+			name:     "Unchanged concept",
+			found:    true,
+			status:   http.StatusOK,
+			expected: `{"conceptType":"valid-type","uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","dropped":false,"found":true,"differences":[]}`,
+		},
+		{
+			name:     "ES unavailable",
+			esError:  service.ErrNoElasticClient,
+			status:   http.StatusServiceUnavailable,
+			expected: `{"code":"es-unavailable","detail":"ES unavailable"}`,
+		},
+		{
+			name:     "ES error",
+			esError:  errors.New("computer says no"),
+			status:   http.StatusInternalServerError,
+			expected: `{"code":"internal-error","detail":"Failed to read data from ES"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/valid-type/8ff7dfef-0330-3de0-b37a-2d6aa9c98580/__diff", bytes.NewReader([]byte(`{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"Label","type":"Genre"}`)))
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			dummyEsService := &dummyEsService{returnsError: tc.esError, found: tc.found}
+			writerService := NewHandler(dummyEsService, []string{"valid-type"})
+
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/{concept-type}/{id}/__diff", writerService.DiffData).Methods("POST")
+			servicesRouter.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.JSONEq(t, tc.expected, responseBody(t, rr))
+			assert.Equal(t, 0, dummyEsService.writes, "diff should not write")
 		})
 	}
 }
@@ -311,7 +632,7 @@ func TestReadDataNotFound(t *testing.T) {
 			status, http.StatusNotFound)
 	}
 
-	assert.Nil(t, rr.Body.Bytes(), "Response body should be empty")
+	assert.JSONEq(t, `{"code":"not-found","detail":"Concept not found"}`, responseBody(t, rr))
 }
 
 func TestReadDataEsServerError(t *testing.T) {
@@ -335,7 +656,7 @@ func TestReadDataEsServerError(t *testing.T) {
 			status, http.StatusInternalServerError)
 	}
 
-	assert.Nil(t, rr.Body.Bytes(), "Response body should be empty")
+	assert.JSONEq(t, `{"code":"internal-error","detail":"Failed to read data from ES"}`, responseBody(t, rr))
 }
 
 func TestReadDataEsServerUnavailable(t *testing.T) {
@@ -401,7 +722,7 @@ func TestDeleteDataNotFound(t *testing.T) {
 			status, http.StatusNotFound)
 	}
 
-	assert.Nil(t, rr.Body.Bytes(), "Response body should be empty")
+	assert.JSONEq(t, `{"code":"not-found","detail":"Concept not found"}`, responseBody(t, rr))
 }
 
 func TestDeleteDataEsServerError(t *testing.T) {
@@ -425,7 +746,51 @@ func TestDeleteDataEsServerError(t *testing.T) {
 			status, http.StatusInternalServerError)
 	}
 
-	assert.Nil(t, rr.Body.Bytes(), "Response body should be empty")
+	assert.JSONEq(t, `{"code":"internal-error","detail":"Failed to delete data from ES"}`, responseBody(t, rr))
+}
+
+func TestTimeouts(t *testing.T) {
+	testCases := []struct {
+		name    string
+		method  string
+		body    string
+		handler func(h *Handler) http.HandlerFunc
+	}{
+		{"Read", "GET", "", func(h *Handler) http.HandlerFunc { return h.ReadData }},
+		{"Write", "PUT", `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","type":"Organisation","prefLabel":"Test"}`, func(h *Handler) http.HandlerFunc { return h.LoadData }},
+		{"Delete", "DELETE", "", func(h *Handler) http.HandlerFunc { return h.DeleteData }},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", strings.NewReader(test.body))
+			rr := httptest.NewRecorder()
+			dummyEsService := &dummyEsService{slow: true}
+			h := NewHandler(dummyEsService, []string{"organisations"}, WithTimeouts(Timeouts{Read: 10 * time.Millisecond, Write: 10 * time.Millisecond, Delete: 10 * time.Millisecond}))
+
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/{concept-type}/{id}", test.handler(h)).Methods(test.method)
+			servicesRouter.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+			assert.Equal(t, context.DeadlineExceeded, dummyEsService.ctxErr)
+			assert.JSONEq(t, `{"code":"es-timeout","detail":"ES did not respond before the deadline of the request"}`, responseBody(t, rr))
+		})
+	}
+}
+
+func TestCancelledRequestStopsCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	dummyEsService := &dummyEsService{slow: true}
+
+	servicesRouter := mux.NewRouter()
+	servicesRouter.HandleFunc("/{concept-type}/{id}", NewHandler(dummyEsService, []string{"organisations"}).ReadData).Methods("GET")
+	cancel()
+	servicesRouter.ServeHTTP(rr, req)
+
+	assert.Equal(t, context.Canceled, dummyEsService.ctxErr, "the call should stop with the request")
 }
 
 func TestProcessConceptModelWithoutTransactionID(t *testing.T) {
@@ -433,7 +798,7 @@ func TestProcessConceptModelWithoutTransactionID(t *testing.T) {
 	testUUID := "8ff7dfef-0330-3de0-b37a-2d6aa9c98580"
 	testBody := []byte(`{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","alternativeIdentifiers":{"TME":["Mg==-R2VucmVz"],"uuids":["8ff7dfef-0330-3de0-b37a-2d6aa9c98580"]},"prefLabel":"Market Report","type":"Genre"}`)
 
-	_, payload, err := processConceptModel(context.Background(), testUUID, "genres", testBody)
+	_, payload, err := processConceptModel(context.Background(), testUUID, "genres", config.FromWhitelist([]string{"genres"}).Get("genres"), testBody)
 	assert.NoError(t, err)
 	assert.NotNil(t, payload)
 	assert.NotEmpty(t, payload.(*service.EsConceptModel).PublishReference)
@@ -504,27 +869,83 @@ func TestIDsEndpointReturnsTypes(t *testing.T) {
 }
 
 type dummyEsService struct {
+	writes       int
+	cleanups     int
 	noop         bool
+	unchanged    bool
 	returnsError error
 	found        bool
 	source       *json.RawMessage
 	ids          chan service.EsIDTypePair
+	// slow calls wait until their context is done
+	slow bool
+	// ctxErr is the error of the context of the last slow call
+	ctxErr error
 }
 
-func (service *dummyEsService) LoadData(ctx context.Context, conceptType string, uuid string, payload service.EsModel) (bool, *elastic.IndexResponse, error) {
-	if service.returnsError != nil {
-		return false, nil, service.returnsError
+func (dummy *dummyEsService) wait(ctx context.Context) error {
+	if !dummy.slow {
+		return nil
 	}
-	if service.noop {
+	<-ctx.Done()
+	dummy.ctxErr = ctx.Err()
+	return fmt.Errorf("calling ES: %w", ctx.Err())
+}
+
+func (dummy *dummyEsService) LoadData(ctx context.Context, conceptType string, uuid string, payload service.EsModel) (bool, *elastic.IndexResponse, error) {
+	dummy.writes++
+	if err := dummy.wait(ctx); err != nil {
+		return false, nil, err
+	}
+	if dummy.returnsError != nil {
+		return false, nil, dummy.returnsError
+	}
+	if dummy.noop {
 		return false, nil, nil
 	}
+	if dummy.unchanged {
+		return false, &elastic.IndexResponse{Result: service.UnchangedResult}, nil
+	}
 	return true, &elastic.IndexResponse{}, nil
 }
 
-func (service *dummyEsService) CleanupData(ctx context.Context, concept service.Concept) {
+func (dummy *dummyEsService) PlanLoadData(ctx context.Context, conceptType string, uuid string, payload service.EsModel) (*service.WritePlan, error) {
+	if dummy.returnsError != nil {
+		return nil, dummy.returnsError
+	}
+	if dummy.noop {
+		return &service.WritePlan{Dropped: true}, nil
+	}
+	return &service.WritePlan{Index: &service.PlannedIndex{ConceptType: conceptType, UUID: uuid, Document: payload}}, nil
+}
+
+func (dummy *dummyEsService) DiffData(ctx context.Context, conceptType string, uuid string, payload service.EsModel) (*service.DocumentDiff, error) {
+	if dummy.returnsError != nil {
+		return nil, dummy.returnsError
+	}
+	diff := &service.DocumentDiff{ConceptType: conceptType, UUID: uuid, Found: dummy.found, Differences: []service.FieldDifference{}}
+	if !dummy.found {
+		diff.Differences = append(diff.Differences, service.FieldDifference{Path: "prefLabel", Candidate: "Label"})
+	}
+	return diff, nil
+}
+
+func (dummy *dummyEsService) CleanupData(ctx context.Context, concept service.Concept) {
+	dummy.cleanups++
+}
+
+func (dummy *dummyEsService) PlanCleanupData(ctx context.Context, concept service.Concept) ([]service.PlannedDelete, error) {
+	var deletes []service.PlannedDelete
+	for _, uuid := range concept.ConcordedUUIDs() {
+		deletes = append(deletes, service.PlannedDelete{ConceptType: "valid-type", UUID: uuid})
+	}
+	return deletes, nil
 }
 
-func (service *dummyEsService) ReadData(conceptType string, uuid string) (*elastic.GetResult, error) {
+func (service *dummyEsService) ReadData(ctx context.Context, conceptType string, uuid string) (*elastic.GetResult, error) {
+	if err := service.wait(ctx); err != nil {
+		return nil, err
+	}
 	if service.returnsError != nil {
 		return nil, service.returnsError
 	}
@@ -532,24 +953,36 @@ func (service *dummyEsService) ReadData(conceptType string, uuid string) (*elast
 }
 
 func (service *dummyEsService) DeleteData(ctx context.Context, conceptType string, uuid string) (*elastic.DeleteResponse, error) {
+	if err := service.wait(ctx); err != nil {
+		return nil, err
+	}
 	if service.returnsError != nil {
 		return nil, service.returnsError
 	}
 	return &elastic.DeleteResponse{Found: service.found}, nil
 }
 
-func (service *dummyEsService) LoadBulkData(conceptType string, uuid string, payload interface{}) {
-
+func (service *dummyEsService) LoadBulkData(ctx context.Context, conceptType string, uuid string, payload interface{}) error {
+	service.writes++
+	return service.returnsError
 }
 
 func (service *dummyEsService) PatchUpdateConcept(ctx context.Context, conceptType string, uuid string, payload service.PayloadPatch) {
 
 }
 
-func (service *dummyEsService) IsIndexReadOnly() (bool, string, error) {
+func (service *dummyEsService) IsIndexReadOnly(ctx context.Context) (bool, string, error) {
 	return true, "", nil
 }
 
+func (dummy *dummyEsService) SetBulkProcessorConfig(config *service.BulkProcessorConfig) error {
+	return dummy.returnsError
+}
+
+func (dummy *dummyEsService) SetBulkLaneConfig(workload service.Workload, config *service.BulkProcessorConfig) error {
+	return dummy.returnsError
+}
+
 func (service *dummyEsService) CloseBulkProcessor() error {
 	if service.returnsError != nil {
 		return service.returnsError
@@ -557,10 +990,81 @@ func (service *dummyEsService) CloseBulkProcessor() error {
 	return nil
 }
 
-func (service *dummyEsService) GetClusterHealth() (*elastic.ClusterHealthResponse, error) {
+func (service *dummyEsService) GetClusterHealth(ctx context.Context) (*elastic.ClusterHealthResponse, error) {
 	return nil, nil
 }
 
 func (service *dummyEsService) GetAllIds(ctx context.Context) chan service.EsIDTypePair {
 	return service.ids
 }
+
+func (dummy *dummyEsService) DualWriteStatus() service.DualWriteStatus {
+	return service.DualWriteStatus{}
+}
+
+func (dummy *dummyEsService) StopDualWrite() service.DualWriteStatus {
+	return service.DualWriteStatus{}
+}
+
+func (dummy *dummyEsService) StartReindex(target string) (service.ReindexStatus, error) {
+	return service.ReindexStatus{}, dummy.returnsError
+}
+
+func (dummy *dummyEsService) ReindexStatus() (service.ReindexStatus, error) {
+	return service.ReindexStatus{}, dummy.returnsError
+}
+
+func (dummy *dummyEsService) CancelReindex() (service.ReindexStatus, error) {
+	return service.ReindexStatus{}, dummy.returnsError
+}
+
+func (dummy *dummyEsService) MappingStatus() (service.MappingStatus, error) {
+	return service.MappingStatus{}, dummy.returnsError
+}
+
+func (dummy *dummyEsService) ConnectionStatus() (service.ConnectionStatus, error) {
+	return service.ConnectionStatus{State: service.ConnectionConnected}, dummy.returnsError
+}
+
+func (dummy *dummyEsService) CircuitBreakerStatus() service.CircuitBreakerStatus {
+	return service.CircuitBreakerStatus{State: service.CircuitClosed}
+}
+
+func (dummy *dummyEsService) CheckMapping(ctx context.Context, index string) (service.MappingStatus, error) {
+	return service.MappingStatus{Index: index}, dummy.returnsError
+}
+
+func (dummy *dummyEsService) ApplyMapping(ctx context.Context, index string) (service.MappingStatus, error) {
+	return service.MappingStatus{Index: index}, dummy.returnsError
+}
+
+func (dummy *dummyEsService) StartReconciliation(reference service.ReferenceSource, deleteExtras bool) (service.ReconciliationReport, error) {
+	return service.ReconciliationReport{}, dummy.returnsError
+}
+
+func (dummy *dummyEsService) ReconciliationReport() (service.ReconciliationReport, error) {
+	return service.ReconciliationReport{}, dummy.returnsError
+}
+
+func (dummy *dummyEsService) CancelReconciliation() (service.ReconciliationReport, error) {
+	return service.ReconciliationReport{}, dummy.returnsError
+}
+
+func TestSetConceptTypes(t *testing.T) {
+	handler := NewHandler(&dummyEsService{}, []string{"genres"})
+	conceptTypes, err := config.ParseConceptTypes([]byte(`types: [{name: people}]`))
+	require.NoError(t, err)
+
+	handler.SetConceptTypes(conceptTypes)
+
+	req := httptest.NewRequest("GET", "/__config/types", nil)
+	rr := httptest.NewRecorder()
+	handler.GetConceptTypes(rr, req)
+	assert.JSONEq(t, `{"types":[{"name":"people","sync":true,"bulk":true,"cleanup":true}]}`, rr.Body.String())
+
+	req = httptest.NewRequest("PUT", "/genres/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", bytes.NewReader([]byte(`{}`)))
+	req = mux.SetURLVars(req, map[string]string{"concept-type": "genres", "id": "8ff7dfef-0330-3de0-b37a-2d6aa9c98580"})
+	rr = httptest.NewRecorder()
+	handler.LoadData(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,165 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/service"
+	log "github.com/Financial-Times/go-logger"
+	tid "github.com/Financial-Times/transactionid-utils-go"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:concept-rw-es:problem:"
+)
+
+// The problem codes are stable identifiers of the causes of the errors, which clients can act upon
+const (
+	codeUnsupportedType   = "unsupported-type"
+	codeUUIDMismatch      = "uuid-mismatch"
+	codeInvalidModel      = "invalid-model"
+	codeWriteForbidden    = "write-forbidden"
+	codeNotFound          = "not-found"
+	codeESUnavailable     = "es-unavailable"
+	codeIndexReadOnly     = "index-read-only"
+	codeStaleVersion      = "stale-version"
+	codeTypeConflict      = "concept-type-conflict"
+	codeInvalidRequest    = "invalid-request"
+	codeInvalidConfig     = "invalid-configuration"
+	codeReindexRunning    = "reindex-running"
+	codeReindexNotFound   = "reindex-not-found"
+	codeMappingConflict   = "mapping-conflict"
+	codeReconcileRunning  = "reconciliation-running"
+	codeReconcileNotFound = "reconciliation-not-found"
+	codeNotSupported      = "not-supported"
+	codeCircuitOpen       = "circuit-open"
+	codeESTimeout         = "es-timeout"
+	codeRateLimited       = "rate-limited"
+	codeBulkQueueFull     = "bulk-queue-full"
+	codeUnauthorized      = "unauthorized"
+	codeInternalError     = "internal-error"
+)
+
+var problemTitles = map[string]string{
+	codeUnsupportedType:   "Unsupported concept type",
+	codeUUIDMismatch:      "UUID mismatch",
+	codeInvalidModel:      "Invalid concept model",
+	codeWriteForbidden:    "Write not allowed",
+	codeNotFound:          "Concept not found",
+	codeESUnavailable:     "Elasticsearch unavailable",
+	codeIndexReadOnly:     "Index read-only",
+	codeStaleVersion:      "Stale version",
+	codeTypeConflict:      "Concept type conflict",
+	codeInvalidRequest:    "Invalid request",
+	codeInvalidConfig:     "Invalid configuration",
+	codeReindexRunning:    "Reindex running",
+	codeReindexNotFound:   "No reindex",
+	codeMappingConflict:   "Mapping conflict",
+	codeReconcileRunning:  "Reconciliation running",
+	codeReconcileNotFound: "No reconciliation",
+	codeNotSupported:      "Not supported by the storage",
+	codeCircuitOpen:       "Elasticsearch failing",
+	codeESTimeout:         "Elasticsearch timeout",
+	codeRateLimited:       "Too many requests",
+	codeBulkQueueFull:     "Bulk queue full",
+	codeUnauthorized:      "Unauthorized",
+	codeInternalError:     "Internal error",
+}
+
+// Problem is an RFC 7807 problem details response
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code is the last segment of the type
+	Code          string      `json:"code"`
+	TransactionID string      `json:"transactionID,omitempty"`
+	Violations    []Violation `json:"violations,omitempty"`
+
+	// retryAfter is returned in the Retry-After header, if set
+	retryAfter time.Duration
+}
+
+func newProblem(code string, status int, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypePrefix + code,
+		Title:  problemTitles[code],
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// esProblem returns the problem of a failed operation to elasticsearch
+func esProblem(err error, detail string) *Problem {
+	var blockedErr *service.WriteBlockedError
+	var circuitErr *service.CircuitOpenError
+	var queueErr *service.BulkQueueFullError
+	var typeErr *service.ConceptTypeConflictError
+	switch {
+	case errors.As(err, &blockedErr):
+		p := newProblem(codeIndexReadOnly, http.StatusServiceUnavailable, "The index does not accept writes")
+		p.retryAfter = blockedErr.RetryAfter
+		return p
+	case errors.As(err, &circuitErr):
+		p := newProblem(codeCircuitOpen, http.StatusServiceUnavailable, "Too many calls to ES have failed, the calls are refused until it recovers")
+		p.retryAfter = circuitErr.RetryAfter
+		return p
+	case errors.As(err, &queueErr):
+		p := newProblem(codeBulkQueueFull, http.StatusTooManyRequests, "Too many bulk writes are waiting to be sent to ES")
+		p.retryAfter = queueErr.RetryAfter
+		return p
+	case errors.Is(err, context.DeadlineExceeded):
+		return newProblem(codeESTimeout, http.StatusGatewayTimeout, "ES did not respond before the deadline of the request")
+	case err == service.ErrNoElasticClient:
+		return newProblem(codeESUnavailable, http.StatusServiceUnavailable, "ES unavailable")
+	case err == service.ErrStorageUnsupported:
+		return newProblem(codeNotSupported, http.StatusNotImplemented, "The operation needs Elasticsearch, the concepts are kept in another storage")
+	case service.IsWriteBlockError(err):
+		return newProblem(codeIndexReadOnly, http.StatusServiceUnavailable, "The index does not accept writes")
+	case errors.As(err, &typeErr):
+		return newProblem(codeTypeConflict, http.StatusConflict, fmt.Sprintf("The concept is stored as %s", typeErr.StoredType))
+	case service.IsStaleVersion(err):
+		return newProblem(codeStaleVersion, http.StatusConflict, "The concept has been changed by another write")
+	}
+	return newProblem(codeInternalError, http.StatusInternalServerError, detail)
+}
+
+// processingProblem returns the problem of a request whose concept payload could not be processed
+func processingProblem(err error) *Problem {
+	if verr, ok := err.(*validationError); ok {
+		p := newProblem(verr.code(), verr.status(), verr.message())
+		p.Violations = verr.violations
+		return p
+	}
+
+	if err == errUnsupportedConceptType {
+		return newProblem(codeUnsupportedType, http.StatusNotFound, err.Error())
+	}
+	return newProblem(codeInvalidModel, http.StatusBadRequest, err.Error())
+}
+
+// writeProblem completes the problem with the request details and writes it
+func writeProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	p.Instance = r.URL.Path
+	p.TransactionID = tid.GetTransactionIDFromRequest(r)
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set(tid.TransactionIDHeader, p.TransactionID)
+	if p.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(p.retryAfter.Seconds()))))
+	}
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.WithError(err).Error("Failed to write response body")
+	}
+}
@@ -0,0 +1,154 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/service"
+	tid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// responseBody returns the body of a response. The details of the request are checked and left out of problems.
+func responseBody(t *testing.T, rr *httptest.ResponseRecorder) string {
+	if rr.Header().Get("Content-Type") != problemContentType {
+		return rr.Body.String()
+	}
+
+	var problem Problem
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.Equal(t, rr.Code, problem.Status)
+	assert.Equal(t, problemTypePrefix+problem.Code, problem.Type)
+	assert.NotEmpty(t, problem.Title)
+	assert.Equal(t, problemTitles[problem.Code], problem.Title)
+	assert.NotEmpty(t, problem.Instance)
+	assert.NotEmpty(t, problem.TransactionID)
+	assert.Equal(t, problem.TransactionID, rr.Header().Get(tid.TransactionIDHeader))
+
+	data, err := json.Marshal(&struct {
+		Code       string      `json:"code"`
+		Detail     string      `json:"detail,omitempty"`
+		Violations []Violation `json:"violations,omitempty"`
+	}{problem.Code, problem.Detail, problem.Violations})
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestWriteProblem(t *testing.T) {
+	req := httptest.NewRequest("PUT", "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", nil)
+	req.Header.Set(tid.TransactionIDHeader, "tid_problem")
+	rr := httptest.NewRecorder()
+
+	writeProblem(rr, req, processingProblem(&validationError{violations: []Violation{
+		{Field: "uuid", Message: "does not match the path UUID", malformed: true, mismatch: true},
+	}}))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type":"urn:concept-rw-es:problem:uuid-mismatch",
+		"title":"UUID mismatch",
+		"status":400,
+		"detail":"Provided path UUID does not match request body",
+		"instance":"/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580",
+		"code":"uuid-mismatch",
+		"transactionID":"tid_problem",
+		"violations":[{"field":"uuid","message":"does not match the path UUID"}]
+	}`, rr.Body.String())
+}
+
+func TestESProblem(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{
+			name:   "No client",
+			err:    service.ErrNoElasticClient,
+			status: http.StatusServiceUnavailable,
+			code:   codeESUnavailable,
+		},
+		{
+			name:   "Not supported by the storage",
+			err:    service.ErrStorageUnsupported,
+			status: http.StatusNotImplemented,
+			code:   codeNotSupported,
+		},
+		{
+			name:   "Read-only index",
+			err:    &elastic.Error{Status: http.StatusForbidden, Details: &elastic.ErrorDetails{Type: "cluster_block_exception"}},
+			status: http.StatusServiceUnavailable,
+			code:   codeIndexReadOnly,
+		},
+		{
+			name:   "Version conflict",
+			err:    &elastic.Error{Status: http.StatusConflict, Details: &elastic.ErrorDetails{Type: "version_conflict_engine_exception"}},
+			status: http.StatusConflict,
+			code:   codeStaleVersion,
+		},
+		{
+			name:   "Concept type conflict",
+			err:    &service.ConceptTypeConflictError{UUID: "1", ConceptType: "people", StoredType: "organisations"},
+			status: http.StatusConflict,
+			code:   codeTypeConflict,
+		},
+		{
+			name:   "Deadline exceeded",
+			err:    fmt.Errorf("Get \"http://localhost:9200/concept/people/1\": %w", context.DeadlineExceeded),
+			status: http.StatusGatewayTimeout,
+			code:   codeESTimeout,
+		},
+		{
+			name:   "Bulk queue full",
+			err:    &service.BulkQueueFullError{Queued: 1000, RetryAfter: time.Second},
+			status: http.StatusTooManyRequests,
+			code:   codeBulkQueueFull,
+		},
+		{
+			name:   "Other error",
+			err:    errTest,
+			status: http.StatusInternalServerError,
+			code:   codeInternalError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			problem := esProblem(tc.err, "Failed to write data to ES")
+			assert.Equal(t, tc.status, problem.Status)
+			assert.Equal(t, tc.code, problem.Code)
+			assert.NotEmpty(t, problem.Title)
+		})
+	}
+}
+
+func TestWriteBlockedProblemRetryAfter(t *testing.T) {
+	req := httptest.NewRequest("PUT", "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", nil)
+	rr := httptest.NewRecorder()
+
+	writeProblem(rr, req, esProblem(&service.WriteBlockedError{RetryAfter: 1500 * time.Millisecond}, "Failed to write data to ES"))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"code":"index-read-only","detail":"The index does not accept writes"}`, responseBody(t, rr))
+}
+
+func TestCircuitOpenProblemRetryAfter(t *testing.T) {
+	req := httptest.NewRequest("GET", "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", nil)
+	rr := httptest.NewRecorder()
+
+	writeProblem(rr, req, esProblem(fmt.Errorf("reading: %w", &service.CircuitOpenError{RetryAfter: 30 * time.Second}), "Failed to read data from ES"))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"code":"circuit-open","detail":"Too many calls to ES have failed, the calls are refused until it recovers"}`, responseBody(t, rr))
+}
@@ -0,0 +1,272 @@
+package resources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/config"
+	"github.com/Financial-Times/neo-model-utils-go/mapper"
+)
+
+const (
+	maxBodySize           = 1 << 20
+	maxLabelLength        = 512
+	maxAliases            = 1000
+	maxScopeNoteLength    = 8192
+	maxSourceConceptCount = 1000
+)
+
+var (
+	errConceptValidation = errors.New("Concept model failed validation")
+
+	uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+	// conceptTypeFamilies maps the concept types of the paths to the root of the direct types they accept.
+	// The direct type of a concept must be the root or one of its descendants, e.g. PublicCompany for organisations.
+	conceptTypeFamilies = map[string]string{
+		"alphaville-series": "AlphavilleSeries",
+		"brands":            "Brand",
+		"genres":            "Genre",
+		"locations":         "Location",
+		"memberships":       "Membership",
+		"organisations":     "Organisation",
+		"people":            "Person",
+		"sections":          "Section",
+		"subjects":          "Subject",
+		"topics":            "Topic",
+	}
+)
+
+// Violation is a problem found in a concept payload
+type Violation struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+
+	// malformed is true when the payload is incomplete or does not match the request, rather than unacceptable
+	malformed bool
+	mismatch  bool
+}
+
+// validationError lists every violation found in a concept payload
+type validationError struct {
+	violations []Violation
+}
+
+func (e *validationError) Error() string {
+	return e.message()
+}
+
+// status is 400 if the payload is malformed or incomplete, 422 if it is complete but cannot be accepted
+func (e *validationError) status() int {
+	for _, v := range e.violations {
+		if v.malformed {
+			return http.StatusBadRequest
+		}
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// code is uuid-mismatch if the payload UUID differs from the path one, invalid-model otherwise
+func (e *validationError) code() string {
+	if e.mismatch() {
+		return codeUUIDMismatch
+	}
+	return codeInvalidModel
+}
+
+func (e *validationError) message() string {
+	if e.mismatch() {
+		return errPathUUID.Error()
+	}
+	if e.status() == http.StatusBadRequest {
+		return errInvalidConceptModel.Error()
+	}
+	return errConceptValidation.Error()
+}
+
+func (e *validationError) mismatch() bool {
+	for _, v := range e.violations {
+		if v.mismatch {
+			return true
+		}
+	}
+	return false
+}
+
+// conceptPayload holds the fields of either concept model which are validated
+type conceptPayload struct {
+	uuidField   string
+	uuid        string
+	directType  string
+	prefLabel   string
+	aliases     []string
+	scopeNote   string
+	sourceUUIDs []string
+	// the concepts a membership relates, if any
+	personUUID       string
+	organisationUUID string
+	// modelErr is the error of the validation specific to the concept type, if any
+	modelErr error
+}
+
+// validateConcept checks a concept payload against the path it has been sent to and the configuration of its type.
+// It returns a *validationError listing every violation found, or nil.
+func validateConcept(pathUUID string, conceptType string, typeConfig *config.ConceptType, c conceptPayload, body []byte) error {
+	verr := &validationError{}
+	malformed := func(field, format string, args ...interface{}) {
+		verr.violations = append(verr.violations, Violation{Field: field, Message: fmt.Sprintf(format, args...), malformed: true})
+	}
+	invalid := func(field, format string, args ...interface{}) {
+		verr.violations = append(verr.violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !uuidPattern.MatchString(pathUUID) {
+		malformed("", "path UUID %s is not a valid UUID", pathUUID)
+	}
+	if c.uuid != pathUUID {
+		verr.violations = append(verr.violations, Violation{Field: c.uuidField, Message: "does not match the path UUID", malformed: true, mismatch: true})
+	}
+
+	if c.directType == "" {
+		malformed("type", "is required")
+	} else {
+		validateDirectType(conceptType, typeConfig, c.directType, invalid)
+	}
+
+	if c.prefLabel == "" {
+		malformed("prefLabel", "is required")
+	} else if len(c.prefLabel) > maxLabelLength {
+		invalid("prefLabel", "is longer than %d bytes", maxLabelLength)
+	}
+
+	for _, field := range missingFields(body, typeConfig.RequiredFields) {
+		malformed(field, "is required for %s", conceptType)
+	}
+	if c.modelErr != nil {
+		malformed("", "%v", c.modelErr)
+	}
+
+	validateAliases(c.aliases, invalid)
+
+	if len(c.scopeNote) > maxScopeNoteLength {
+		invalid("scopeNote", "is longer than %d bytes", maxScopeNoteLength)
+	}
+
+	if len(c.sourceUUIDs) > maxSourceConceptCount {
+		invalid("sourceRepresentations", "has more than %d concepts", maxSourceConceptCount)
+	}
+	for i, uuid := range c.sourceUUIDs {
+		if !uuidPattern.MatchString(uuid) {
+			invalid(fmt.Sprintf("sourceRepresentations[%d].uuid", i), "%s is not a valid UUID", uuid)
+		}
+	}
+	if c.personUUID != "" && !uuidPattern.MatchString(c.personUUID) {
+		invalid("personUUID", "%s is not a valid UUID", c.personUUID)
+	}
+	if c.organisationUUID != "" && !uuidPattern.MatchString(c.organisationUUID) {
+		invalid("organisationUUID", "%s is not a valid UUID", c.organisationUUID)
+	}
+
+	if len(verr.violations) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// validateDirectType checks the direct type is allowed by the configuration of the concept type and, if it is a type known
+// to the writer, that it belongs to the family of the concept type
+func validateDirectType(conceptType string, typeConfig *config.ConceptType, directType string, invalid func(field, format string, args ...interface{})) {
+	if !typeConfig.AllowsDirectType(directType) {
+		invalid("type", "%s is not one of the direct types allowed for %s: %s", directType, conceptType, strings.Join(typeConfig.DirectTypes, ", "))
+		return
+	}
+
+	if len(mapper.TypeURIs([]string{directType})) == 0 {
+		// the family of the types unknown to the writer cannot be checked, the configuration is trusted
+		return
+	}
+	if family, found := conceptTypeFamilies[conceptType]; found && !isInFamily(directType, family) {
+		invalid("type", "%s is not a kind of %s, it cannot be written to %s", directType, family, conceptType)
+	}
+}
+
+// isInFamily tells whether the type is the root type or one of its descendants
+func isInFamily(t string, root string) bool {
+	for ; t != ""; t = mapper.ParentType(t) {
+		if t == root {
+			return true
+		}
+	}
+	return false
+}
+
+func validateAliases(aliases []string, invalid func(field, format string, args ...interface{})) {
+	if len(aliases) > maxAliases {
+		invalid("aliases", "has more than %d aliases", maxAliases)
+		return
+	}
+
+	for i, alias := range aliases {
+		field := fmt.Sprintf("aliases[%d]", i)
+		switch {
+		case strings.TrimSpace(alias) == "":
+			invalid(field, "is blank")
+		case len(alias) > maxLabelLength:
+			invalid(field, "is longer than %d bytes", maxLabelLength)
+		}
+	}
+}
+
+// uniqueAliases returns the aliases without their duplicates, in the order they are first found
+func uniqueAliases(aliases []string) []string {
+	if len(aliases) == 0 {
+		return aliases
+	}
+	seen := make(map[string]bool, len(aliases))
+	unique := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		if !seen[alias] {
+			seen[alias] = true
+			unique = append(unique, alias)
+		}
+	}
+	return unique
+}
+
+// missingFields returns the fields which are missing from the payload, or are empty
+func missingFields(body []byte, fields []string) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	payload := make(map[string]interface{})
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return fields
+	}
+
+	var missing []string
+	for _, field := range fields {
+		if isEmptyValue(payload[field]) {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	}
+	return false
+}
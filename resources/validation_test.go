@@ -0,0 +1,192 @@
+package resources
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validationUUID = "8ff7dfef-0330-3de0-b37a-2d6aa9c98580"
+
+func TestValidateConcept(t *testing.T) {
+	conceptTypes, err := config.ParseConceptTypes([]byte(`
+types:
+  - name: organisations
+    directTypes: [PublicCompany, PrivateCompany]
+    requiredFields: [aliases]
+  - name: genres
+  - name: valid-type
+`))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		conceptType string
+		pathUUID    string
+		concept     conceptPayload
+		body        string
+		status      int
+		violations  []Violation
+	}{
+		{
+			name:        "Valid concept",
+			conceptType: "organisations",
+			concept:     conceptPayload{uuid: validationUUID, directType: "PublicCompany", prefLabel: "Apple", aliases: []string{"Apple Inc"}, sourceUUIDs: []string{validationUUID}},
+			body:        `{"aliases":["Apple Inc"]}`,
+		},
+		{
+			name:        "Descendant of the family",
+			conceptType: "genres",
+			concept:     conceptPayload{uuid: validationUUID, directType: "Genre", prefLabel: "News"},
+		},
+		{
+			name:        "Type outside of the family",
+			conceptType: "genres",
+			concept:     conceptPayload{uuid: validationUUID, directType: "Person", prefLabel: "John Smith"},
+			status:      http.StatusUnprocessableEntity,
+			violations:  []Violation{{Field: "type", Message: "Person is not a kind of Genre, it cannot be written to genres"}},
+		},
+		{
+			name:        "Concept type without a family",
+			conceptType: "valid-type",
+			concept:     conceptPayload{uuid: validationUUID, directType: "Person", prefLabel: "John Smith"},
+		},
+		{
+			name:        "Type unknown to the writer",
+			conceptType: "valid-type",
+			concept:     conceptPayload{uuid: validationUUID, directType: "Spaceship", prefLabel: "Enterprise"},
+		},
+		{
+			name:        "Type unknown to the writer not allowed by the configuration",
+			conceptType: "organisations",
+			concept:     conceptPayload{uuid: validationUUID, directType: "Spaceship", prefLabel: "Enterprise", aliases: []string{"Enterprise"}},
+			body:        `{"aliases":["Enterprise"]}`,
+			status:      http.StatusUnprocessableEntity,
+			violations:  []Violation{{Field: "type", Message: "Spaceship is not one of the direct types allowed for organisations: PublicCompany, PrivateCompany"}},
+		},
+		{
+			name:        "Duplicate aliases",
+			conceptType: "genres",
+			concept:     conceptPayload{uuid: validationUUID, directType: "Genre", prefLabel: "News", aliases: []string{"News", "News"}},
+		},
+		{
+			name:        "Every violation",
+			conceptType: "organisations",
+			pathUUID:    "not-a-uuid",
+			concept: conceptPayload{
+				uuidField:        "prefUUID",
+				uuid:             validationUUID,
+				directType:       "Organisation",
+				aliases:          []string{"Apple", " ", "Apple", strings.Repeat("a", maxLabelLength+1)},
+				scopeNote:        strings.Repeat("a", maxScopeNoteLength+1),
+				sourceUUIDs:      []string{validationUUID, "tme-id"},
+				personUUID:       "person",
+				organisationUUID: validationUUID,
+				modelErr:         errors.New("membership without person or organisation"),
+			},
+			body:   `{"aliases":[]}`,
+			status: http.StatusBadRequest,
+			violations: []Violation{
+				{Message: "path UUID not-a-uuid is not a valid UUID"},
+				{Field: "prefUUID", Message: "does not match the path UUID"},
+				{Field: "type", Message: "Organisation is not one of the direct types allowed for organisations: PublicCompany, PrivateCompany"},
+				{Field: "prefLabel", Message: "is required"},
+				{Field: "aliases", Message: "is required for organisations"},
+				{Message: "membership without person or organisation"},
+				{Field: "aliases[1]", Message: "is blank"},
+				{Field: "aliases[3]", Message: "is longer than 512 bytes"},
+				{Field: "scopeNote", Message: "is longer than 8192 bytes"},
+				{Field: "sourceRepresentations[1].uuid", Message: "tme-id is not a valid UUID"},
+				{Field: "personUUID", Message: "person is not a valid UUID"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pathUUID := tc.pathUUID
+			if pathUUID == "" {
+				pathUUID = validationUUID
+			}
+			body := tc.body
+			if body == "" {
+				body = `{}`
+			}
+
+			err := validateConcept(pathUUID, tc.conceptType, conceptTypes.Get(tc.conceptType), tc.concept, []byte(body))
+			if tc.violations == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			verr, ok := err.(*validationError)
+			require.True(t, ok, "expected a validation error, got %v", err)
+			assert.Equal(t, tc.status, verr.status())
+			var messages []Violation
+			for _, v := range verr.violations {
+				messages = append(messages, Violation{Field: v.Field, Message: v.Message})
+			}
+			assert.Equal(t, tc.violations, messages)
+		})
+	}
+}
+
+func TestLoadDataValidation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		payload string
+		status  int
+		msg     string
+	}{
+		{
+			name:    "Direct type outside of the concept type family",
+			payload: `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"John Smith","type":"Person","aliases":["John"," "]}`,
+			status:  http.StatusUnprocessableEntity,
+			msg:     `{"code":"invalid-model","detail":"Concept model failed validation","violations":[{"field":"type","message":"Person is not a kind of Organisation, it cannot be written to organisations"},{"field":"aliases[1]","message":"is blank"}]}`,
+		},
+		{
+			name:    "Payload too large",
+			payload: `{"uuid":"8ff7dfef-0330-3de0-b37a-2d6aa9c98580","prefLabel":"` + strings.Repeat("a", maxBodySize) + `","type":"Organisation"}`,
+			status:  http.StatusUnprocessableEntity,
+			msg:     `{"code":"invalid-model","detail":"Concept model failed validation","violations":[{"message":"the payload is larger than 1048576 bytes"}]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/organisations/8ff7dfef-0330-3de0-b37a-2d6aa9c98580", bytes.NewReader([]byte(tc.payload)))
+			rr := httptest.NewRecorder()
+			dummyEsService := &dummyEsService{}
+
+			servicesRouter := mux.NewRouter()
+			servicesRouter.HandleFunc("/{concept-type}/{id}", NewHandler(dummyEsService, []string{"organisations"}).LoadData).Methods("PUT")
+			servicesRouter.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.JSONEq(t, tc.msg, responseBody(t, rr))
+			assert.Equal(t, 0, dummyEsService.writes)
+		})
+	}
+}
+
+func TestUniqueAliases(t *testing.T) {
+	assert.Equal(t, []string{"Apple", "Apple Inc"}, uniqueAliases([]string{"Apple", "Apple Inc", "Apple"}))
+	assert.Nil(t, uniqueAliases(nil))
+}
+
+func TestValidationErrorCode(t *testing.T) {
+	mismatch := &validationError{violations: []Violation{{Field: "prefLabel", Message: "is required", malformed: true}, {Field: "uuid", Message: "does not match the path UUID", malformed: true, mismatch: true}}}
+	assert.Equal(t, codeUUIDMismatch, mismatch.code())
+	assert.Equal(t, errPathUUID.Error(), mismatch.message())
+
+	invalid := &validationError{violations: []Violation{{Field: "prefLabel", Message: "is required", malformed: true}}}
+	assert.Equal(t, codeInvalidModel, invalid.code())
+}
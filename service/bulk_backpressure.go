@@ -0,0 +1,100 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// minBulkRetryAfter is the shortest time after which the refused bulk loads can be retried
+const minBulkRetryAfter = time.Second
+
+var (
+	bulkQueued   = metrics.GetOrRegisterGauge("concept.bulk.queued", metrics.DefaultRegistry)
+	bulkRejected = metrics.GetOrRegisterCounter("concept.bulk.rejected", metrics.DefaultRegistry)
+)
+
+// BulkQueueFullError is returned by the bulk loads refused while too many bulk requests are queued
+type BulkQueueFullError struct {
+	Queued int
+	// RetryAfter is when the queued requests should have been sent
+	RetryAfter time.Duration
+}
+
+func (e *BulkQueueFullError) Error() string {
+	return fmt.Sprintf("%d bulk requests are queued, retry after %v", e.Queued, e.RetryAfter)
+}
+
+// WithBulkQueueLimit refuses the bulk loads once the requests queued in their bulk processor reach the limit,
+// until enough of them have been sent. The writes and metrics updates are still queued. No limit if 0.
+func WithBulkQueueLimit(limit int) EsServiceOption {
+	return func(es *esService) {
+		es.bulkQueueLimit = limit
+	}
+}
+
+// pendingCounter counts the requests queued in a bulk processor, until the outcome of their bulk request is known
+type pendingCounter struct {
+	sync.Mutex
+	queued int
+	// gauge reports the number of queued requests, if set
+	gauge metrics.Gauge
+}
+
+func (c *pendingCounter) add(requests int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.queued += requests
+	if c.gauge != nil {
+		c.gauge.Update(int64(c.queued))
+	}
+}
+
+// full returns the number of queued requests, and whether it has reached the limit
+func (c *pendingCounter) full(limit int) (int, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.queued, limit > 0 && c.queued >= limit
+}
+
+// enqueue sends a request to the bulk processor of the lane, or the default one without lane or if the one of the lane
+// could not be created, and counts it until its bulk request has been sent. It must be called with the lock held.
+func (es *esService) enqueue(lane *bulkLane, r elastic.BulkableRequest) error {
+	if lane != nil && lane.processor != nil {
+		lane.pending.add(1)
+		lane.processor.Add(r)
+		return nil
+	}
+	if es.bulkProcessor == nil {
+		// the bulk processor is created once the client is available
+		return ErrNoElasticClient
+	}
+	es.pendingBulk.add(1)
+	es.bulkProcessor.Add(r)
+	return nil
+}
+
+// checkBulkQueue returns an error if the bulk loads are refused because too many requests are queued in their bulk processor,
+// the one of their lane or the default one as enqueue picks it. It must be called with the lock held.
+func (es *esService) checkBulkQueue() error {
+	pending, config := &es.pendingBulk, es.bulkProcessorConfig
+	if lane := es.laneOf(BulkLoads); lane != nil && lane.processor != nil {
+		pending, config = &lane.pending, &lane.config
+	}
+	queued, full := pending.full(es.bulkQueueLimit)
+	if !full {
+		return nil
+	}
+
+	bulkRejected.Inc(1)
+	retryAfter := minBulkRetryAfter
+	if config != nil && config.flushInterval > retryAfter {
+		retryAfter = config.flushInterval
+	}
+	return &BulkQueueFullError{Queued: queued, RetryAfter: retryAfter}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+func TestBulkQueueLimit(t *testing.T) {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithStorage(NewMemoryStorage(indexName)), WithBulkQueueLimit(2)).(*esService)
+	defer es.CloseBulkProcessor()
+	rejected := bulkRejected.Count()
+
+	require.NoError(t, es.LoadBulkData(context.Background(), "brands", "1", EsConceptModel{Id: "1"}))
+	require.NoError(t, es.LoadBulkData(context.Background(), "brands", "2", EsConceptModel{Id: "2"}))
+
+	err := es.LoadBulkData(newTestContext(), "brands", "3", EsConceptModel{Id: "3"})
+	var fullErr *BulkQueueFullError
+	require.True(t, errors.As(err, &fullErr))
+	assert.Equal(t, 2, fullErr.Queued)
+	assert.Equal(t, time.Hour, fullErr.RetryAfter, "the queue should be sent at the next flush")
+	assert.Equal(t, int64(1), bulkRejected.Count()-rejected)
+
+	es.PatchUpdateConcept(newTestContext(), "brands", "1", &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 1}})
+	queued, _ := es.pendingBulk.full(es.bulkQueueLimit)
+	assert.Equal(t, 3, queued, "the metrics updates should not be held back")
+
+	require.NoError(t, es.bulkProcessor.Flush())
+	queued, full := es.pendingBulk.full(es.bulkQueueLimit)
+	assert.Equal(t, 0, queued)
+	assert.False(t, full)
+	assert.NoError(t, es.LoadBulkData(context.Background(), "brands", "3", EsConceptModel{Id: "3"}))
+}
+
+func TestBulkQueueUnlimited(t *testing.T) {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithStorage(NewMemoryStorage(indexName))).(*esService)
+	defer es.CloseBulkProcessor()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, es.LoadBulkData(context.Background(), "brands", "1", EsConceptModel{Id: "1"}))
+	}
+}
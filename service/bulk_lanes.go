@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/Financial-Times/go-logger"
+	"github.com/rcrowley/go-metrics"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// defaultBulkProcessorName is the name of the bulk processor of the writes without a lane of their own
+const defaultBulkProcessorName = "BackgroundWorker-1"
+
+// Workload is a class of the writes sent to Elasticsearch in the background, by a bulk processor
+type Workload string
+
+const (
+	// SyncFollowUps are the updates following the sync writes, e.g. the person updated after a membership is written
+	SyncFollowUps Workload = "sync"
+	// BulkLoads are the writes of the bulk endpoint
+	BulkLoads Workload = "bulk"
+	// MetricPatches are the updates of the metrics of the concepts
+	MetricPatches Workload = "metrics"
+)
+
+// Workloads lists every workload
+var Workloads = []Workload{SyncFollowUps, BulkLoads, MetricPatches}
+
+// ParseWorkload returns the workload of the name given
+func ParseWorkload(name string) (Workload, error) {
+	for _, w := range Workloads {
+		if string(w) == name {
+			return w, nil
+		}
+	}
+	return "", fmt.Errorf("unknown workload %s", name)
+}
+
+// bulkLane is a bulk processor of its own for some workloads, so that their writes are not held back by the others
+type bulkLane struct {
+	name      string
+	config    BulkProcessorConfig
+	processor bulkQueue
+	pending   pendingCounter
+}
+
+// laneRequest is a parked request of a lane, so that it is sent by the bulk processor of its lane once the block is lifted
+type laneRequest struct {
+	elastic.BulkableRequest
+	lane *bulkLane
+}
+
+// WithBulkLane sends the writes of the workloads with a bulk processor of their own, using the settings given.
+// The writes of the other workloads share the default bulk processor.
+func WithBulkLane(config BulkProcessorConfig, workloads ...Workload) EsServiceOption {
+	return func(es *esService) {
+		if len(workloads) == 0 {
+			return
+		}
+		names := make([]string, 0, len(workloads))
+		for _, w := range workloads {
+			names = append(names, string(w))
+		}
+		name := strings.Join(names, "-")
+		lane := &bulkLane{
+			name:    name,
+			config:  config,
+			pending: pendingCounter{gauge: metrics.GetOrRegisterGauge("concept.bulk."+name+".queued", metrics.DefaultRegistry)},
+		}
+
+		if es.lanes == nil {
+			es.lanes = make(map[Workload]*bulkLane)
+		}
+		for _, w := range workloads {
+			es.lanes[w] = lane
+		}
+	}
+}
+
+// laneOf returns the lane of the workload, or nil if its writes are sent by the default bulk processor
+func (es *esService) laneOf(workload Workload) *bulkLane {
+	return es.lanes[workload]
+}
+
+// dedicatedLanes returns every lane once, in the order of the workloads
+func (es *esService) dedicatedLanes() []*bulkLane {
+	var lanes []*bulkLane
+	seen := make(map[*bulkLane]bool)
+	for _, w := range Workloads {
+		if lane := es.lanes[w]; lane != nil && !seen[lane] {
+			seen[lane] = true
+			lanes = append(lanes, lane)
+		}
+	}
+	return lanes
+}
+
+// SetBulkLaneConfig replaces the bulk processor of the lane of the workload with one using the new settings.
+// The requests queued in the current bulk processor are flushed first.
+func (es *esService) SetBulkLaneConfig(workload Workload, config *BulkProcessorConfig) error {
+	es.Lock()
+	defer es.Unlock()
+
+	lane := es.laneOf(workload)
+	if lane == nil {
+		return fmt.Errorf("the %s workload has no bulk lane of its own", workload)
+	}
+	if lane.config == *config {
+		return nil
+	}
+	lane.config = *config
+
+	if es.checkStorage() != nil {
+		// the bulk processor is created once the client is available
+		return nil
+	}
+	return es.resetLane(lane)
+}
+
+// resetLane closes the bulk processor of the lane and creates a new one with the current client and settings.
+// It must be called with the write lock held.
+func (es *esService) resetLane(lane *bulkLane) error {
+	if lane.processor != nil {
+		if err := lane.processor.Close(); err != nil {
+			log.WithField("lane", lane.name).Errorf("Error closing bulk processor: %v", err)
+		}
+	}
+
+	processor, err := es.newBulkQueue("BackgroundWorker-"+lane.name, &lane.config, es.afterLaneBulk(lane))
+	if err != nil {
+		lane.processor = nil
+		return err
+	}
+	lane.processor = processor
+	return nil
+}
+
+// newBulkQueue returns a bulk processor with the current client and the settings given
+func (es *esService) newBulkQueue(name string, config *BulkProcessorConfig, after elastic.BulkAfterFunc) (bulkQueue, error) {
+	if es.storage != nil {
+		return newStorageBulkProcessor(es.storage, config, after), nil
+	}
+
+	bulkProcessor, err := bulkProcessorService(es.elasticClient, name, config).
+		Backoff(bulkBackoff{es.retryPolicy}).
+		After(after).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return bulkProcessor, nil
+}
+
+// parked returns the request to park, so that it is sent by the bulk processor of the lane once the block is lifted
+func (lane *bulkLane) parked(r elastic.BulkableRequest) elastic.BulkableRequest {
+	if lane == nil {
+		return r
+	}
+	return &laneRequest{BulkableRequest: r, lane: lane}
+}
+
+func (lane *bulkLane) parkedAll(requests []elastic.BulkableRequest) []elastic.BulkableRequest {
+	if lane == nil {
+		return requests
+	}
+	parked := make([]elastic.BulkableRequest, 0, len(requests))
+	for _, r := range requests {
+		parked = append(parked, lane.parked(r))
+	}
+	return parked
+}
+
+// unpark returns the lane of a parked request, and the request to send
+func unpark(r elastic.BulkableRequest) (*bulkLane, elastic.BulkableRequest) {
+	if lr, ok := r.(*laneRequest); ok {
+		return lr.lane, lr.BulkableRequest
+	}
+	return nil, r
+}
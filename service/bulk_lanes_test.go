@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+func newLanesTestService(t *testing.T, storage Storage, options ...EsServiceOption) *esService {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	options = append([]EsServiceOption{WithStorage(storage)}, options...)
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, options...).(*esService)
+	t.Cleanup(func() { es.CloseBulkProcessor() })
+	return es
+}
+
+func readTestMetrics(t *testing.T, es *esService, conceptType string, uuid string) *ConceptMetrics {
+	read, err := es.ReadData(context.Background(), conceptType, uuid)
+	require.NoError(t, err)
+	require.True(t, read.Found)
+	var concept EsConceptModel
+	require.NoError(t, json.Unmarshal(*read.Source, &concept))
+	return concept.Metrics
+}
+
+func TestBulkLanesRouteWorkloads(t *testing.T) {
+	es := newLanesTestService(t, NewMemoryStorage(indexName), WithBulkLane(NewBulkProcessorConfig(1, 1, 1000000, time.Hour), MetricPatches))
+	_, _, _, err := writeTestDocument(es, "people", memoryUUID)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, es.LoadBulkData(context.Background(), "brands", "bulk-brand", EsConceptModel{Id: "bulk-brand"}))
+	}
+	es.PatchUpdateConcept(newTestContext(), "people", memoryUUID, &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 4}})
+
+	lane := es.laneOf(MetricPatches)
+	require.NotNil(t, lane)
+	require.NoError(t, lane.processor.Flush())
+	metrics := readTestMetrics(t, es, "people", memoryUUID)
+	require.NotNil(t, metrics, "the metrics should not wait for the bulk loads")
+	assert.Equal(t, 4, metrics.AnnotationsCount)
+
+	queued, _ := es.pendingBulk.full(0)
+	assert.Equal(t, 10, queued, "the bulk loads should be queued in the default bulk processor")
+	queued, _ = lane.pending.full(0)
+	assert.Equal(t, 0, queued)
+	assert.Nil(t, es.laneOf(BulkLoads))
+}
+
+func TestBulkLaneQueueLimit(t *testing.T) {
+	es := newLanesTestService(t, NewMemoryStorage(indexName),
+		WithBulkLane(NewBulkProcessorConfig(1, 100, 1000000, 5*time.Second), BulkLoads),
+		WithBulkQueueLimit(1))
+
+	es.PatchUpdateConcept(newTestContext(), "brands", "1", &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 1}})
+	es.PatchUpdateConcept(newTestContext(), "brands", "2", &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 1}})
+	require.NoError(t, es.LoadBulkData(context.Background(), "brands", "1", EsConceptModel{Id: "1"}), "the metrics updates should not count for the bulk loads")
+
+	err := es.LoadBulkData(context.Background(), "brands", "2", EsConceptModel{Id: "2"})
+	var fullErr *BulkQueueFullError
+	require.True(t, errors.As(err, &fullErr))
+	assert.Equal(t, 1, fullErr.Queued)
+	assert.Equal(t, 5*time.Second, fullErr.RetryAfter, "the queue of the lane should be sent at its flush interval")
+}
+
+func TestBulkLaneParkedRequests(t *testing.T) {
+	storage := NewMemoryStorage(indexName)
+	es := newLanesTestService(t, storage,
+		WithWriteBlockCheck(time.Hour, 10),
+		WithBulkLane(NewBulkProcessorConfig(1, 100, 1000000, time.Hour), BulkLoads))
+	lane := es.laneOf(BulkLoads)
+
+	require.NoError(t, storage.SetWriteBlock(indexName, true))
+	require.NoError(t, es.LoadBulkData(context.Background(), "brands", "parked-brand", EsConceptModel{Id: "parked-brand"}))
+	require.NoError(t, lane.processor.Flush())
+	assert.Equal(t, 1, es.writeBlock.parkedCount(), "the bulk request refused by the block should be parked")
+
+	require.NoError(t, storage.SetWriteBlock(indexName, false))
+	require.NoError(t, es.RefreshWriteBlock(context.Background()))
+	queued, _ := lane.pending.full(0)
+	assert.Equal(t, 1, queued, "the parked request should be sent by the bulk processor of its lane")
+
+	require.NoError(t, lane.processor.Flush())
+	read, err := es.ReadData(context.Background(), "brands", "parked-brand")
+	require.NoError(t, err)
+	assert.True(t, read.Found)
+}
+
+func TestSetBulkLaneConfig(t *testing.T) {
+	es := newLanesTestService(t, NewMemoryStorage(indexName), WithBulkLane(NewBulkProcessorConfig(1, 100, 1000000, time.Hour), SyncFollowUps, MetricPatches))
+	lane := es.laneOf(MetricPatches)
+	assert.Equal(t, "sync-metrics", lane.name)
+	assert.Equal(t, lane, es.laneOf(SyncFollowUps), "the workloads should share the lane")
+	processor := lane.processor
+
+	config := NewBulkProcessorConfig(1, 10, 1000000, time.Second)
+	require.NoError(t, es.SetBulkLaneConfig(SyncFollowUps, &config))
+	assert.Equal(t, config, lane.config)
+	assert.True(t, processor != lane.processor, "the bulk processor should be replaced")
+
+	assert.Error(t, es.SetBulkLaneConfig(BulkLoads, &config), "the bulk loads have no lane of their own")
+}
+
+func TestParseWorkload(t *testing.T) {
+	w, err := ParseWorkload("metrics")
+	require.NoError(t, err)
+	assert.Equal(t, MetricPatches, w)
+
+	_, err = ParseWorkload("backfill")
+	assert.Error(t, err)
+}
+
+func TestBulkLaneWithoutProcessor(t *testing.T) {
+	es := newLanesTestService(t, NewMemoryStorage(indexName), WithBulkLane(NewBulkProcessorConfig(1, 100, 1000000, time.Hour), MetricPatches))
+	lane := es.laneOf(MetricPatches)
+	require.NoError(t, lane.processor.Close())
+	lane.processor = nil
+
+	es.PatchUpdateConcept(newTestContext(), "brands", "1", &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 1}})
+	queued, _ := es.pendingBulk.full(0)
+	assert.Equal(t, 1, queued, "the update should be sent by the default bulk processor while the lane has none")
+
+	require.NoError(t, es.bulkProcessor.Close())
+	es.bulkProcessor = nil
+	assert.Equal(t, ErrNoElasticClient, es.LoadBulkData(context.Background(), "brands", "1", EsConceptModel{Id: "1"}))
+	es.PatchUpdateConcept(newTestContext(), "brands", "1", &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 1}})
+	assert.NoError(t, es.CloseBulkProcessor())
+}
+
+func TestBulkQueueLimitOfLaneWithoutProcessor(t *testing.T) {
+	es := newLanesTestService(t, NewMemoryStorage(indexName), WithBulkLane(NewBulkProcessorConfig(1, 100, 1000000, time.Minute), BulkLoads), WithBulkQueueLimit(2))
+	lane := es.laneOf(BulkLoads)
+	require.NoError(t, lane.processor.Close())
+	lane.processor = nil
+
+	require.NoError(t, es.LoadBulkData(context.Background(), "brands", "1", EsConceptModel{Id: "1"}))
+	require.NoError(t, es.LoadBulkData(context.Background(), "brands", "2", EsConceptModel{Id: "2"}))
+	err := es.LoadBulkData(context.Background(), "brands", "3", EsConceptModel{Id: "3"})
+	var fullErr *BulkQueueFullError
+	require.True(t, errors.As(err, &fullErr), "the loads sent by the default bulk processor should be held back at its limit")
+	assert.Equal(t, 2, fullErr.Queued)
+	assert.Equal(t, time.Hour, fullErr.RetryAfter, "the retry should follow the flush interval of the default bulk processor")
+}
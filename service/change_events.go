@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/events"
+	log "github.com/Financial-Times/go-logger"
+	tid "github.com/Financial-Times/transactionid-utils-go"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// createdResult is the result of a write which created the document
+const createdResult = "created"
+
+// changeEventKeyFields are the document fields reported in the before/after diff of a change event
+var changeEventKeyFields = []string{
+	"prefLabel",
+	"directType",
+	"types",
+	"authorities",
+	"aliases",
+	"isDeprecated",
+	"scopeNote",
+	"countryCode",
+	"countryOfIncorporation",
+	"isFTAuthor",
+	"metrics",
+}
+
+// WithChangePublisher sets the publisher used to announce every successful change to the index
+func WithChangePublisher(publisher events.Publisher) EsServiceOption {
+	return func(es *esService) {
+		es.changePublisher = publisher
+	}
+}
+
+func (es *esService) publishChange(ctx context.Context, event events.ChangeEvent) {
+	if es.changePublisher == nil {
+		return
+	}
+
+	transactionID, err := tid.GetTransactionIDFromContext(ctx)
+	if err != nil {
+		transactionID = tidNotFound
+	}
+	event.TransactionID = transactionID
+	event.Time = es.getCurrentTime()
+
+	if err := es.changePublisher.Publish(ctx, event); err != nil {
+		log.WithError(err).
+			WithTransactionID(transactionID).
+			WithField(uuidField, event.UUID).
+			WithField(conceptTypeField, event.ConceptType).
+			Error("Failed to publish change event")
+	}
+}
+
+// changeRequest is a bulk request whose change event is published once Elasticsearch has applied it
+type changeRequest struct {
+	elastic.BulkableRequest
+	transactionID string
+	event         events.ChangeEvent
+}
+
+// withChangeEvent returns the request announcing the event once it has been applied, or the request itself without a publisher
+func (es *esService) withChangeEvent(ctx context.Context, r elastic.BulkableRequest, event events.ChangeEvent) elastic.BulkableRequest {
+	if es.changePublisher == nil {
+		return r
+	}
+	transactionID, err := tid.GetTransactionIDFromContext(ctx)
+	if err != nil {
+		transactionID = tidNotFound
+	}
+	return &changeRequest{BulkableRequest: r, transactionID: transactionID, event: event}
+}
+
+// publishBulkChanges publishes the events of the requests applied by a bulk request.
+// The updates which left the document as it was, reported as noop by Elasticsearch, are not announced, and the writes
+// which created the document are announced as such.
+func (es *esService) publishBulkChanges(requests []elastic.BulkableRequest, response *elastic.BulkResponse) {
+	if response == nil {
+		return
+	}
+	for i, item := range response.Items {
+		if i >= len(requests) {
+			return
+		}
+		request := requests[i]
+		if paired, ok := request.(*primaryRequest); ok {
+			request = paired.BulkableRequest
+		}
+		r, ok := request.(*changeRequest)
+		if !ok {
+			continue
+		}
+		for _, result := range item {
+			if result.Error != nil || result.Result == UnchangedResult {
+				continue
+			}
+			event := r.event
+			if result.Result == createdResult {
+				event.Type = events.Created
+			}
+			es.publishChange(tid.TransactionAwareContext(context.Background(), r.transactionID), event)
+		}
+	}
+}
+
+// writeEventType tells apart the creation of a new document from the update of an existing one
+func writeEventType(readResult *elastic.GetResult) events.Type {
+	if readResult != nil && !readResult.Found {
+		return events.Created
+	}
+	return events.Updated
+}
+
+// storedSource returns the source of a previously read document, if any
+func storedSource(readResult *elastic.GetResult) interface{} {
+	if readResult == nil || !readResult.Found || readResult.Source == nil {
+		return nil
+	}
+	return readResult.Source
+}
+
+// diffKeyFields compares the key fields of two documents. The optional patches are applied on top of the
+// after document, in order, so that the diff reflects what is stored once the write has completed.
+func diffKeyFields(before interface{}, after interface{}, patches ...interface{}) map[string]events.FieldChange {
+	beforeFields := toFieldMap(before)
+	afterFields := toFieldMap(after)
+	for _, p := range patches {
+		for k, v := range toFieldMap(p) {
+			afterFields[k] = v
+		}
+	}
+
+	changes := make(map[string]events.FieldChange)
+	for _, field := range changeEventKeyFields {
+		b, a := beforeFields[field], afterFields[field]
+		if !reflect.DeepEqual(b, a) {
+			changes[field] = events.FieldChange{Before: b, After: a}
+		}
+	}
+	return changes
+}
+
+func toFieldMap(doc interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if doc == nil || reflect.ValueOf(doc).Kind() == reflect.Ptr && reflect.ValueOf(doc).IsNil() {
+		return fields
+	}
+
+	var data []byte
+	switch d := doc.(type) {
+	case *json.RawMessage:
+		data = *d
+	case json.RawMessage:
+		data = d
+	default:
+		var err error
+		if data, err = json.Marshal(doc); err != nil {
+			return fields
+		}
+	}
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return make(map[string]interface{})
+	}
+	return fields
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/concept-rw-elasticsearch/events"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+func TestDiffKeyFields(t *testing.T) {
+	stored := json.RawMessage(`{"prefLabel":"Old label","aliases":["Old label"],"directType":"http://www.ft.com/ontology/person/Person","isFTAuthor":"true","metrics":{"annotationsCount":10,"prevWeekAnnotationsCount":1},"lastModified":"2020-03-06T13:57:57+02:00"}`)
+	payload := &EsPersonConceptModel{
+		EsConceptModel: &EsConceptModel{
+			PrefLabel:    "New label",
+			Aliases:      []string{"Old label"},
+			DirectType:   "http://www.ft.com/ontology/person/Person",
+			LastModified: "2020-03-07T13:57:57+02:00",
+		},
+		IsFTAuthor: "false",
+	}
+	patch := &EsPersonConceptPatch{Metrics: &ConceptMetrics{AnnotationsCount: 10, PrevWeekAnnotationsCount: 1}, IsFTAuthor: "true"}
+
+	changes := diffKeyFields(&stored, payload, patch)
+
+	assert.Equal(t, map[string]events.FieldChange{
+		"prefLabel": {Before: "Old label", After: "New label"},
+	}, changes)
+}
+
+func TestDiffKeyFieldsForNewDocument(t *testing.T) {
+	changes := diffKeyFields(nil, &EsConceptModel{PrefLabel: "Label", IsDeprecated: true})
+
+	assert.Equal(t, events.FieldChange{After: "Label"}, changes["prefLabel"])
+	assert.Equal(t, events.FieldChange{After: true}, changes["isDeprecated"])
+	assert.NotContains(t, changes, "scopeNote")
+}
+
+func TestDeletePublishesDeletedEvent(t *testing.T) {
+	es := newDeleteESMock(true)
+	defer es.Close()
+
+	publisher := events.NewInMemoryPublisher()
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now, changePublisher: publisher}
+
+	testUUID := uuid.NewV4().String()
+	resp, err := service.DeleteData(newTestContext(), organisationsType, testUUID)
+	require.NoError(t, err)
+	require.True(t, resp.Found)
+
+	published := publisher.Events()
+	require.Len(t, published, 1)
+	assert.Equal(t, events.Deleted, published[0].Type)
+	assert.Equal(t, testUUID, published[0].UUID)
+	assert.Equal(t, organisationsType, published[0].ConceptType)
+	assert.Equal(t, testTID, published[0].TransactionID)
+}
+
+func TestDeleteNotFoundDoesNotPublishEvent(t *testing.T) {
+	es := newDeleteESMock(false)
+	defer es.Close()
+
+	publisher := events.NewInMemoryPublisher()
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now, changePublisher: publisher}
+
+	resp, err := service.DeleteData(newTestContext(), organisationsType, uuid.NewV4().String())
+	require.NoError(t, err)
+	assert.False(t, resp.Found)
+	assert.Empty(t, publisher.Events())
+}
+
+func TestCleanupPublishesConcordedEvents(t *testing.T) {
+	prefUUID := uuid.NewV4().String()
+	concordedUUID := uuid.NewV4().String()
+
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodDelete:
+			json.NewEncoder(w).Encode(elastic.DeleteResponse{Found: true, Result: "deleted"})
+		default:
+			json.NewEncoder(w).Encode(elastic.SearchResult{Hits: &elastic.SearchHits{
+				TotalHits: 1,
+				Hits:      []*elastic.SearchHit{{Id: concordedUUID, Type: organisationsType}},
+			}})
+		}
+	}))
+	defer es.Close()
+
+	publisher := events.NewInMemoryPublisher()
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now, changePublisher: publisher}
+
+	concept := AggregateConceptModel{PrefUUID: prefUUID, SourceRepresentations: []SourceConcept{{UUID: prefUUID}, {UUID: concordedUUID}}}
+	service.CleanupData(newTestContext(), concept)
+
+	published := publisher.Events()
+	require.Len(t, published, 1)
+	assert.Equal(t, events.Concorded, published[0].Type)
+	assert.Equal(t, concordedUUID, published[0].UUID)
+	assert.Equal(t, prefUUID, published[0].PrefUUID)
+	assert.Equal(t, organisationsType, published[0].ConceptType)
+}
+
+func newDeleteESMock(found bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		json.NewEncoder(w).Encode(elastic.DeleteResponse{Found: found})
+	}))
+}
+
+func TestPatchPublishesEventOnceApplied(t *testing.T) {
+	publisher := events.NewInMemoryPublisher()
+	es := newLanesTestService(t, NewMemoryStorage(indexName), WithChangePublisher(publisher))
+	_, _, _, err := writeTestDocument(es, "people", memoryUUID)
+	require.NoError(t, err)
+	publisher.Reset()
+
+	patch := &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 4}}
+	es.PatchUpdateConcept(newTestContext(), "people", memoryUUID, patch)
+	assert.Empty(t, publisher.Events(), "the update should not be announced before it is applied")
+
+	require.NoError(t, es.bulkProcessor.Flush())
+	published := publisher.Events()
+	require.Len(t, published, 1)
+	assert.Equal(t, events.Updated, published[0].Type)
+	assert.Equal(t, memoryUUID, published[0].UUID)
+	assert.Equal(t, testTID, published[0].TransactionID)
+	assert.Contains(t, published[0].Changes, "metrics")
+
+	es.PatchUpdateConcept(newTestContext(), "people", memoryUUID, patch)
+	es.PatchUpdateConcept(newTestContext(), "people", "missing-uuid", patch)
+	require.NoError(t, es.bulkProcessor.Flush())
+	assert.Len(t, publisher.Events(), 1, "the unchanged and the failed updates should not be announced")
+}
+
+func TestBulkLoadPublishesEventOnceApplied(t *testing.T) {
+	publisher := events.NewInMemoryPublisher()
+	es := newLanesTestService(t, NewMemoryStorage(indexName), WithChangePublisher(publisher))
+
+	require.NoError(t, es.LoadBulkData(newTestContext(), "brands", "bulk-brand", EsConceptModel{Id: "bulk-brand", PrefLabel: "Bulk Brand"}))
+	assert.Empty(t, publisher.Events(), "the write should not be announced before it is applied")
+
+	require.NoError(t, es.bulkProcessor.Flush())
+	published := publisher.Events()
+	require.Len(t, published, 1)
+	assert.Equal(t, events.Created, published[0].Type)
+	assert.Equal(t, "bulk-brand", published[0].UUID)
+	assert.Equal(t, "brands", published[0].ConceptType)
+	assert.Equal(t, testTID, published[0].TransactionID)
+	assert.Equal(t, "Bulk Brand", published[0].Changes["prefLabel"].After)
+
+	publisher.Reset()
+	require.NoError(t, es.LoadBulkData(newTestContext(), "brands", "bulk-brand", EsConceptModel{Id: "bulk-brand", PrefLabel: "Renamed Brand"}))
+	require.NoError(t, es.bulkProcessor.Flush())
+	published = publisher.Events()
+	require.Len(t, published, 1)
+	assert.Equal(t, events.Updated, published[0].Type)
+	assert.Equal(t, "Renamed Brand", published[0].Changes["prefLabel"].After)
+}
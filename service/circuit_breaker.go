@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	"github.com/rcrowley/go-metrics"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// The states of the circuit breaker
+const (
+	// CircuitDisabled is the state of a service without circuit breaker
+	CircuitDisabled = "disabled"
+	// CircuitClosed is the state while the calls are let through
+	CircuitClosed = "closed"
+	// CircuitOpen is the state while the calls are refused, once too many of them have failed
+	CircuitOpen = "open"
+	// CircuitHalfOpen is the state while a few trial calls probe whether the cluster has recovered
+	CircuitHalfOpen = "half-open"
+)
+
+// halfOpenRetryAfter is when the calls refused while the trial calls are running can be retried
+const halfOpenRetryAfter = time.Second
+
+var (
+	circuitOpened   = metrics.GetOrRegisterCounter("concept.circuit.opened", metrics.DefaultRegistry)
+	circuitRejected = metrics.GetOrRegisterCounter("concept.circuit.rejected", metrics.DefaultRegistry)
+)
+
+// CircuitOpenError is returned by the calls refused while the circuit breaker is open
+type CircuitOpenError struct {
+	// RetryAfter is when the circuit breaker lets calls through again
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("the circuit breaker is open, retry after %v", e.RetryAfter)
+}
+
+// CircuitBreakerConfig configures when the circuit breaker opens, and how it recovers
+type CircuitBreakerConfig struct {
+	failureRate   float64
+	window        int
+	minCalls      int
+	openDuration  time.Duration
+	halfOpenCalls int
+}
+
+// NewCircuitBreakerConfig returns the config of a circuit breaker which opens once the failure rate, between 0 and 1,
+// of the last window calls reaches the rate given, provided there are at least minCalls of them. It half-opens after
+// the open duration, lets halfOpenCalls trial calls through, and closes once they have all succeeded.
+func NewCircuitBreakerConfig(failureRate float64, window int, minCalls int, openDuration time.Duration, halfOpenCalls int) CircuitBreakerConfig {
+	if minCalls > window {
+		minCalls = window
+	}
+	if minCalls < 1 {
+		minCalls = 1
+	}
+	if halfOpenCalls < 1 {
+		halfOpenCalls = 1
+	}
+	return CircuitBreakerConfig{failureRate: failureRate, window: window, minCalls: minCalls, openDuration: openDuration, halfOpenCalls: halfOpenCalls}
+}
+
+// CircuitBreakerStatus describes the state of the circuit breaker
+type CircuitBreakerStatus struct {
+	State string `json:"state"`
+	// the failure rate of the calls of the window, while the circuit is closed
+	FailureRate float64    `json:"failureRate"`
+	Calls       int        `json:"calls"`
+	OpenedAt    *time.Time `json:"openedAt,omitempty"`
+	// the calls refused since the service started
+	Rejected int64 `json:"rejected"`
+}
+
+// callOutcome is what a call tells about the health of the cluster
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	// callIgnored is the outcome of the calls which tell nothing about the cluster, e.g. cancelled by the caller
+	callIgnored
+)
+
+// circuitBreaker refuses the calls to the cluster while it keeps failing, rather than waiting for its timeouts.
+// The outcomes of the last calls are kept in a ring. A call counts as failed if the cluster is unavailable,
+// overloaded or times out, not if it refuses the request.
+type circuitBreaker struct {
+	sync.Mutex
+	config CircuitBreakerConfig
+	now    func() time.Time
+
+	state    string
+	outcomes []bool
+	next     int
+	calls    int
+	failures int
+
+	openedAt   time.Time
+	trials     int
+	succeeded  int
+	generation int
+	rejected   int64
+}
+
+// WithCircuitBreaker refuses the reads, writes and deletes while the cluster keeps failing. A zero failure rate disables it.
+func WithCircuitBreaker(config CircuitBreakerConfig) EsServiceOption {
+	return func(es *esService) {
+		if config.failureRate <= 0 || config.window <= 0 {
+			es.breaker = nil
+			return
+		}
+		es.breaker = newCircuitBreaker(config)
+	}
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{config: config, now: time.Now, state: CircuitClosed, outcomes: make([]bool, config.window)}
+}
+
+// guard calls the operation unless the circuit breaker refuses it, and records its outcome
+func (b *circuitBreaker) guard(operation func() error) error {
+	if b == nil {
+		return operation()
+	}
+	done, err := b.allow()
+	if err != nil {
+		return err
+	}
+	err = operation()
+	done(outcomeOf(err))
+	return err
+}
+
+// allow returns the function recording the outcome of the call, or an error if the call is refused
+func (b *circuitBreaker) allow() (func(outcome callOutcome), error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.state == CircuitOpen {
+		if wait := b.openedAt.Add(b.config.openDuration).Sub(b.now()); wait > 0 {
+			return nil, b.reject(wait)
+		}
+		b.state = CircuitHalfOpen
+		b.trials, b.succeeded = 0, 0
+		b.generation++
+		log.Info("The circuit breaker is half-open, trial calls are let through")
+	}
+
+	generation := b.generation
+	if b.state == CircuitHalfOpen {
+		if b.trials >= b.config.halfOpenCalls {
+			return nil, b.reject(halfOpenRetryAfter)
+		}
+		b.trials++
+	}
+	return func(outcome callOutcome) {
+		b.record(generation, outcome)
+	}, nil
+}
+
+// reject must be called with the lock held
+func (b *circuitBreaker) reject(retryAfter time.Duration) error {
+	b.rejected++
+	circuitRejected.Inc(1)
+	return &CircuitOpenError{RetryAfter: retryAfter}
+}
+
+func (b *circuitBreaker) record(generation int, outcome callOutcome) {
+	b.Lock()
+	defer b.Unlock()
+
+	// the outcomes of the calls started before the state changed are ignored
+	if generation != b.generation {
+		return
+	}
+
+	if outcome == callIgnored {
+		if b.state == CircuitHalfOpen {
+			// another call can take the trial slot
+			b.trials--
+		}
+		return
+	}
+	failed := outcome == callFailed
+	if b.state == CircuitHalfOpen {
+		if failed {
+			b.open("a trial call failed")
+			return
+		}
+		b.succeeded++
+		if b.succeeded >= b.config.halfOpenCalls {
+			b.close()
+		}
+		return
+	}
+
+	if b.calls == len(b.outcomes) {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+	} else {
+		b.calls++
+	}
+	b.outcomes[b.next] = failed
+	b.next = (b.next + 1) % len(b.outcomes)
+	if failed {
+		b.failures++
+	}
+
+	if b.calls >= b.config.minCalls && b.failureRate() >= b.config.failureRate {
+		b.open(fmt.Sprintf("%d of the last %d calls failed", b.failures, b.calls))
+	}
+}
+
+// open must be called with the lock held
+func (b *circuitBreaker) open(reason string) {
+	b.state = CircuitOpen
+	b.openedAt = b.now()
+	b.generation++
+	circuitOpened.Inc(1)
+	log.Warnf("The circuit breaker is open for %v: %s", b.config.openDuration, reason)
+}
+
+// close must be called with the lock held
+func (b *circuitBreaker) close() {
+	b.state = CircuitClosed
+	b.generation++
+	b.calls, b.failures, b.next = 0, 0, 0
+	for i := range b.outcomes {
+		b.outcomes[i] = false
+	}
+	log.Info("The circuit breaker is closed, the cluster has recovered")
+}
+
+// failureRate must be called with the lock held
+func (b *circuitBreaker) failureRate() float64 {
+	if b.calls == 0 {
+		return 0
+	}
+	return float64(b.failures) / float64(b.calls)
+}
+
+func (b *circuitBreaker) status() CircuitBreakerStatus {
+	if b == nil {
+		return CircuitBreakerStatus{State: CircuitDisabled}
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	status := CircuitBreakerStatus{State: b.state, FailureRate: b.failureRate(), Calls: b.calls, Rejected: b.rejected}
+	if b.state != CircuitClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// outcomeOf returns the outcome of a call failing with the error. The calls cancelled by the caller, who went away, tell nothing
+// about the cluster and are ignored, while the calls exceeding their deadline count as failed: a slow cluster only shows as such.
+func outcomeOf(err error) callOutcome {
+	switch {
+	case err == nil:
+		return callSucceeded
+	case errors.Is(err, context.Canceled):
+		return callIgnored
+	case errors.Is(err, context.DeadlineExceeded) || isClusterFailure(err):
+		return callFailed
+	}
+	return callSucceeded
+}
+
+// isClusterFailure returns true if the error shows that the cluster is failing: unreachable, unavailable, overloaded or timing out.
+// The requests refused by the cluster, e.g. because the document is missing or the index is blocked, are not failures.
+func isClusterFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var esErr *elastic.Error
+	if errors.As(err, &esErr) {
+		return esErr.Status >= http.StatusInternalServerError || esErr.Status == http.StatusTooManyRequests || esErr.Status == http.StatusRequestTimeout
+	}
+	if errors.Is(err, elastic.ErrNoClient) || errors.Is(err, elastic.ErrRetry) || errors.Is(err, elastic.ErrTimeout) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var errUnavailable = &elastic.Error{Status: http.StatusServiceUnavailable}
+
+// testBreaker returns a breaker opening once half of at least 4 of the last 10 calls have failed, whose clock is set by the test
+func testBreaker() (*circuitBreaker, *time.Time) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(NewCircuitBreakerConfig(0.5, 10, 4, 30*time.Second, 2))
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func call(b *circuitBreaker, err error) error {
+	return b.guard(func() error { return err })
+}
+
+func openBreaker(t *testing.T, b *circuitBreaker) {
+	for i := 0; i < 4; i++ {
+		require.Equal(t, errUnavailable, call(b, errUnavailable))
+	}
+	require.Equal(t, CircuitOpen, b.status().State)
+}
+
+func TestCircuitBreakerOpensAtFailureRate(t *testing.T) {
+	b, _ := testBreaker()
+	opened := circuitOpened.Count()
+
+	for _, err := range []error{nil, errUnavailable, nil, nil, errUnavailable} {
+		assert.Equal(t, err, call(b, err))
+	}
+	status := b.status()
+	assert.Equal(t, CircuitClosed, status.State)
+	assert.Equal(t, 0.4, status.FailureRate)
+	assert.Equal(t, 5, status.Calls)
+	assert.Nil(t, status.OpenedAt)
+
+	assert.Equal(t, errUnavailable, call(b, errUnavailable))
+	status = b.status()
+	assert.Equal(t, CircuitOpen, status.State)
+	assert.NotNil(t, status.OpenedAt)
+	assert.Equal(t, int64(1), circuitOpened.Count()-opened)
+}
+
+func TestCircuitBreakerWaitsForMinCalls(t *testing.T) {
+	b, _ := testBreaker()
+	for i := 0; i < 3; i++ {
+		call(b, errUnavailable)
+	}
+	assert.Equal(t, CircuitClosed, b.status().State, "3 calls are too few to open the circuit")
+}
+
+func TestCircuitBreakerForgetsOldCalls(t *testing.T) {
+	b, _ := testBreaker()
+	call(b, errUnavailable)
+	for i := 0; i < 10; i++ {
+		call(b, nil)
+	}
+	status := b.status()
+	assert.Equal(t, 0.0, status.FailureRate, "the failures should have left the window")
+	assert.Equal(t, 10, status.Calls)
+}
+
+func TestCircuitBreakerIgnoresRefusedRequests(t *testing.T) {
+	b, _ := testBreaker()
+	for _, err := range []error{
+		&elastic.Error{Status: http.StatusNotFound},
+		&elastic.Error{Status: http.StatusConflict},
+		&elastic.Error{Status: http.StatusBadRequest},
+		fmt.Errorf("reading: %w", context.Canceled),
+	} {
+		call(b, err)
+	}
+	status := b.status()
+	assert.Equal(t, CircuitClosed, status.State)
+	assert.Equal(t, 0.0, status.FailureRate)
+}
+
+func TestIsClusterFailure(t *testing.T) {
+	assert.False(t, isClusterFailure(nil))
+	assert.False(t, isClusterFailure(&elastic.Error{Status: http.StatusForbidden}))
+	assert.True(t, isClusterFailure(&elastic.Error{Status: http.StatusTooManyRequests}))
+	assert.True(t, isClusterFailure(&elastic.Error{Status: http.StatusGatewayTimeout}), "the timeouts reported by the cluster should be failures")
+	assert.True(t, isClusterFailure(fmt.Errorf("writing: %w", errUnavailable)))
+	assert.True(t, isClusterFailure(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}))
+	assert.True(t, isClusterFailure(fmt.Errorf("no available connection: %w", elastic.ErrNoClient)))
+	assert.False(t, isClusterFailure(errors.New("invalid character in the response")))
+}
+
+func TestOutcomeOf(t *testing.T) {
+	assert.Equal(t, callSucceeded, outcomeOf(nil))
+	assert.Equal(t, callSucceeded, outcomeOf(&elastic.Error{Status: http.StatusNotFound}))
+	assert.Equal(t, callFailed, outcomeOf(errUnavailable))
+	assert.Equal(t, callIgnored, outcomeOf(fmt.Errorf("reading: %w", context.Canceled)))
+	assert.Equal(t, callFailed, outcomeOf(&url.Error{Op: "Get", URL: "http://localhost:9200", Err: context.DeadlineExceeded}), "a call exceeding its deadline should be a failure")
+}
+
+func TestCircuitBreakerIgnoresCallsCancelledByTheCaller(t *testing.T) {
+	b, _ := testBreaker()
+	for i := 0; i < 10; i++ {
+		call(b, fmt.Errorf("reading: %w", context.Canceled))
+	}
+	status := b.status()
+	assert.Equal(t, CircuitClosed, status.State)
+	assert.Equal(t, 0, status.Calls, "the calls cancelled by the caller should not be recorded")
+}
+
+func TestCircuitBreakerOpensOnTimedOutCalls(t *testing.T) {
+	b, _ := testBreaker()
+	for i := 0; i < 4; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		err := b.guard(func() error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		cancel()
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	}
+	status := b.status()
+	assert.Equal(t, CircuitOpen, status.State, "the calls timing out on a slow cluster should open the circuit")
+}
+
+func TestCircuitBreakerReleasesTrialOfIgnoredCall(t *testing.T) {
+	b, now := testBreaker()
+	openBreaker(t, b)
+	*now = now.Add(30 * time.Second)
+
+	first, err := b.allow()
+	require.NoError(t, err)
+	_, err = b.allow()
+	require.NoError(t, err)
+	first(callIgnored)
+
+	_, err = b.allow()
+	assert.NoError(t, err, "the trial slot of the cancelled call should be released")
+	assert.Equal(t, CircuitHalfOpen, b.status().State)
+}
+
+func TestCircuitBreakerRejectsWhileOpen(t *testing.T) {
+	b, now := testBreaker()
+	openBreaker(t, b)
+	rejected := circuitRejected.Count()
+
+	*now = now.Add(10 * time.Second)
+	called := false
+	err := b.guard(func() error {
+		called = true
+		return nil
+	})
+	assert.False(t, called, "the call should be refused")
+
+	var openErr *CircuitOpenError
+	require.True(t, errors.As(err, &openErr))
+	assert.Equal(t, 20*time.Second, openErr.RetryAfter)
+	assert.Equal(t, int64(1), b.status().Rejected)
+	assert.Equal(t, int64(1), circuitRejected.Count()-rejected)
+}
+
+func TestCircuitBreakerClosesAfterSuccessfulTrials(t *testing.T) {
+	b, now := testBreaker()
+	openBreaker(t, b)
+	*now = now.Add(30 * time.Second)
+
+	first, err := b.allow()
+	require.NoError(t, err)
+	assert.Equal(t, CircuitHalfOpen, b.status().State)
+	second, err := b.allow()
+	require.NoError(t, err)
+
+	_, err = b.allow()
+	var openErr *CircuitOpenError
+	require.True(t, errors.As(err, &openErr), "only 2 trial calls should be let through")
+	assert.Equal(t, halfOpenRetryAfter, openErr.RetryAfter)
+
+	first(callSucceeded)
+	assert.Equal(t, CircuitHalfOpen, b.status().State)
+	second(callSucceeded)
+	status := b.status()
+	assert.Equal(t, CircuitClosed, status.State)
+	assert.Equal(t, 0, status.Calls, "the window should start afresh")
+}
+
+func TestCircuitBreakerReopensOnFailedTrial(t *testing.T) {
+	b, now := testBreaker()
+	openBreaker(t, b)
+	*now = now.Add(30 * time.Second)
+
+	assert.Equal(t, errUnavailable, call(b, errUnavailable))
+	status := b.status()
+	assert.Equal(t, CircuitOpen, status.State)
+	assert.Equal(t, *now, *status.OpenedAt)
+
+	var openErr *CircuitOpenError
+	require.True(t, errors.As(call(b, nil), &openErr))
+	assert.Equal(t, 30*time.Second, openErr.RetryAfter)
+}
+
+func TestCircuitBreakerIgnoresOutcomesOfEarlierState(t *testing.T) {
+	b, _ := testBreaker()
+	slow, err := b.allow()
+	require.NoError(t, err)
+	openBreaker(t, b)
+
+	slow(callSucceeded)
+	slow, err = b.allow()
+	assert.Error(t, err, "the outcome of a call started while closed should not count once open")
+	assert.Nil(t, slow)
+}
+
+func TestWithCircuitBreaker(t *testing.T) {
+	es := &esService{}
+	WithCircuitBreaker(NewCircuitBreakerConfig(0.5, 20, 10, time.Second, 1))(es)
+	assert.Equal(t, CircuitClosed, es.CircuitBreakerStatus().State)
+
+	WithCircuitBreaker(NewCircuitBreakerConfig(0, 20, 10, time.Second, 1))(es)
+	assert.Equal(t, CircuitDisabled, es.CircuitBreakerStatus().State)
+	assert.NoError(t, es.breaker.guard(func() error { return nil }), "a disabled breaker should let the calls through")
+}
+
+func TestServiceRefusesCallsWhileCircuitOpen(t *testing.T) {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	storage := &flakyStorage{Storage: NewMemoryStorage(indexName), err: errUnavailable, failures: 2}
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig,
+		WithStorage(storage),
+		WithRetryPolicy(RetryPolicy{}),
+		WithCircuitBreaker(NewCircuitBreakerConfig(1, 2, 2, time.Hour, 1)),
+	).(*esService)
+	defer es.CloseBulkProcessor()
+
+	for i := 0; i < 2; i++ {
+		_, err := es.ReadData(context.Background(), "people", memoryUUID)
+		assert.Equal(t, errUnavailable, err)
+	}
+
+	_, err := es.DeleteData(newTestContext(), "people", memoryUUID)
+	var openErr *CircuitOpenError
+	require.True(t, errors.As(err, &openErr))
+	assert.True(t, openErr.RetryAfter > 59*time.Minute)
+	assert.Equal(t, 0, storage.calls["delete"], "the storage should not be called while the circuit is open")
+	assert.Equal(t, CircuitOpen, es.CircuitBreakerStatus().State)
+}
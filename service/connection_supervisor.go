@@ -0,0 +1,325 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	"github.com/rcrowley/go-metrics"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// The states of the connection to the cluster
+const (
+	// ConnectionConnecting is the state until the first client is created
+	ConnectionConnecting = "connecting"
+	// ConnectionConnected is the state while the probes of the cluster succeed
+	ConnectionConnected = "connected"
+	// ConnectionDegraded is the state while the probes fail, until the failures are sustained enough to rebuild the client
+	ConnectionDegraded = "degraded"
+	// ConnectionReconnecting is the state while the client is rebuilt
+	ConnectionReconnecting = "reconnecting"
+)
+
+const (
+	defaultProbeInterval    = 30 * time.Second
+	defaultFailureThreshold = 3
+	defaultMinBackoff       = time.Second
+	defaultMaxBackoff       = time.Minute
+	probeTimeout            = 10 * time.Second
+)
+
+var (
+	connectionProbeFailures = metrics.GetOrRegisterCounter("concept.connection.probe.failure", metrics.DefaultRegistry)
+	connectionReconnects    = metrics.GetOrRegisterCounter("concept.connection.reconnect", metrics.DefaultRegistry)
+)
+
+// ConnectionStatus describes the connection of the service to the cluster
+type ConnectionStatus struct {
+	State string `json:"state"`
+	// the failed probes or connection attempts since the last success
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastProbe           *time.Time `json:"lastProbe,omitempty"`
+	ConnectedSince      *time.Time `json:"connectedSince,omitempty"`
+	NextAttempt         *time.Time `json:"nextAttempt,omitempty"`
+	// the clients rebuilt since the service started
+	Reconnects int `json:"reconnects"`
+}
+
+// ConnectionSupervisor connects to the cluster and probes it periodically. The client is rebuilt after sustained probe failures,
+// when the cluster refuses the credentials, or when the credential files change. The connection attempts are retried
+// with exponential backoff.
+type ConnectionSupervisor struct {
+	connect          func() (*elastic.Client, error)
+	watchedFiles     []string
+	probeInterval    time.Duration
+	failureThreshold int
+	minBackoff       time.Duration
+	maxBackoff       time.Duration
+
+	mu        sync.Mutex
+	status    ConnectionStatus
+	reconnect chan struct{}
+}
+
+// SupervisorOption configures optional behaviour of the ConnectionSupervisor
+type SupervisorOption func(*ConnectionSupervisor)
+
+// WithProbeInterval probes the cluster at the interval given, if positive
+func WithProbeInterval(interval time.Duration) SupervisorOption {
+	return func(s *ConnectionSupervisor) {
+		if interval > 0 {
+			s.probeInterval = interval
+		}
+	}
+}
+
+// WithFailureThreshold rebuilds the client after the number of consecutive probe failures given, if positive
+func WithFailureThreshold(failures int) SupervisorOption {
+	return func(s *ConnectionSupervisor) {
+		if failures > 0 {
+			s.failureThreshold = failures
+		}
+	}
+}
+
+// WithReconnectBackoff waits min after the first failed connection attempt, twice as long after every other one, up to max
+func WithReconnectBackoff(min time.Duration, max time.Duration) SupervisorOption {
+	return func(s *ConnectionSupervisor) {
+		if min > 0 {
+			s.minBackoff = min
+		}
+		if max >= s.minBackoff {
+			s.maxBackoff = max
+		}
+	}
+}
+
+// NewConnectionSupervisor returns a supervisor of the connection to the cluster of the config, in the region given.
+// The CA bundle and the client certificate of the config are watched for changes.
+func NewConnectionSupervisor(region string, config EsAccessConfig, options ...SupervisorOption) *ConnectionSupervisor {
+	s := &ConnectionSupervisor{
+		connect: func() (*elastic.Client, error) {
+			return NewElasticClient(region, config)
+		},
+		watchedFiles:     config.credentialFiles(),
+		probeInterval:    defaultProbeInterval,
+		failureThreshold: defaultFailureThreshold,
+		minBackoff:       defaultMinBackoff,
+		maxBackoff:       defaultMaxBackoff,
+		status:           ConnectionStatus{State: ConnectionConnecting},
+		reconnect:        make(chan struct{}, 1),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// WithConnectionSupervisor reports the status of the connection kept by the supervisor
+func WithConnectionSupervisor(supervisor *ConnectionSupervisor) EsServiceOption {
+	return func(es *esService) {
+		es.supervisor = supervisor
+	}
+}
+
+// Status returns the current status of the connection
+func (s *ConnectionSupervisor) Status() ConnectionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Reconnect rebuilds the client at the next opportunity, e.g. once the credentials have been changed
+func (s *ConnectionSupervisor) Reconnect() {
+	select {
+	case s.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends a client of the cluster to the channel once connected, and a new one every time the client is rebuilt.
+// The replaced clients are stopped. It returns once the context is done, closing the channel.
+func (s *ConnectionSupervisor) Run(ctx context.Context, clients chan<- *elastic.Client) {
+	defer close(clients)
+
+	var current *elastic.Client
+	defer func() {
+		if current != nil {
+			current.Stop()
+		}
+	}()
+	for {
+		client, err := s.connectWithBackoff(ctx)
+		if err != nil {
+			return
+		}
+		select {
+		case clients <- client:
+		case <-ctx.Done():
+			client.Stop()
+			return
+		}
+		if current != nil {
+			current.Stop()
+		}
+		current = client
+
+		if !s.supervise(ctx, client) {
+			return
+		}
+	}
+}
+
+// connectWithBackoff creates a client, retrying with exponential backoff until it succeeds or the context is done
+func (s *ConnectionSupervisor) connectWithBackoff(ctx context.Context) (*elastic.Client, error) {
+	backoff := s.minBackoff
+	for {
+		client, err := s.connect()
+		if err == nil {
+			s.connected()
+			return client, nil
+		}
+
+		next := time.Now().Add(backoff)
+		s.update(func(status *ConnectionStatus) {
+			status.ConsecutiveFailures++
+			status.LastError = err.Error()
+			status.NextAttempt = &next
+		})
+		log.WithError(err).Errorf("could not connect to ElasticSearch, retrying in %v", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff, s.maxBackoff)
+	}
+}
+
+// supervise probes the cluster with the client, and returns true once the client has to be rebuilt,
+// or false if the context is done first
+func (s *ConnectionSupervisor) supervise(ctx context.Context, client *elastic.Client) bool {
+	ticker := time.NewTicker(s.probeInterval)
+	defer ticker.Stop()
+
+	files := modificationTimes(s.watchedFiles)
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-s.reconnect:
+			s.reconnecting("reconnection requested")
+			return true
+		case <-ticker.C:
+		}
+
+		if changed := modificationTimes(s.watchedFiles); changed != files {
+			s.reconnecting("the credential files have changed")
+			return true
+		}
+
+		err := probe(ctx, client)
+		now := time.Now()
+		if err == nil {
+			s.update(func(status *ConnectionStatus) {
+				status.State = ConnectionConnected
+				status.ConsecutiveFailures = 0
+				status.LastError = ""
+				status.LastProbe = &now
+			})
+			continue
+		}
+
+		connectionProbeFailures.Inc(1)
+		var failures int
+		s.update(func(status *ConnectionStatus) {
+			status.State = ConnectionDegraded
+			status.ConsecutiveFailures++
+			status.LastError = err.Error()
+			status.LastProbe = &now
+			failures = status.ConsecutiveFailures
+		})
+		log.WithError(err).Warnf("probe of ElasticSearch failed %d times in a row", failures)
+
+		if isAuthenticationFailure(err) {
+			s.reconnecting("the cluster refused the credentials")
+			return true
+		}
+		if failures >= s.failureThreshold {
+			s.reconnecting(fmt.Sprintf("%d consecutive probes failed", failures))
+			return true
+		}
+	}
+}
+
+func (s *ConnectionSupervisor) connected() {
+	now := time.Now()
+	s.update(func(status *ConnectionStatus) {
+		if status.State == ConnectionReconnecting {
+			status.Reconnects++
+			connectionReconnects.Inc(1)
+		}
+		status.State = ConnectionConnected
+		status.ConsecutiveFailures = 0
+		status.LastError = ""
+		status.ConnectedSince = &now
+		status.NextAttempt = nil
+	})
+	log.Info("connected to ElasticSearch")
+}
+
+func (s *ConnectionSupervisor) reconnecting(reason string) {
+	s.update(func(status *ConnectionStatus) {
+		status.State = ConnectionReconnecting
+	})
+	log.Warnf("rebuilding the ElasticSearch client: %s", reason)
+}
+
+func (s *ConnectionSupervisor) update(change func(status *ConnectionStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(&s.status)
+}
+
+// probe checks that the cluster answers the requests of the client
+func probe(ctx context.Context, client *elastic.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	_, err := client.ClusterHealth().Do(ctx)
+	return err
+}
+
+// isAuthenticationFailure returns true if the cluster refused the credentials of the request,
+// e.g. because they have expired or have been rotated
+func isAuthenticationFailure(err error) bool {
+	return elastic.IsStatusCode(err, http.StatusUnauthorized) || elastic.IsStatusCode(err, http.StatusForbidden)
+}
+
+func nextBackoff(backoff time.Duration, max time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// modificationTimes returns a summary of the modification times of the files, which changes whenever one of them does
+func modificationTimes(files []string) string {
+	var summary string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			summary += file + ":missing;"
+			continue
+		}
+		summary += fmt.Sprintf("%s:%d:%d;", file, info.ModTime().UnixNano(), info.Size())
+	}
+	return summary
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// clusterStub answers the probes with the status code set, 200 by default
+type clusterStub struct {
+	*httptest.Server
+	status int32
+}
+
+func newClusterStub() *clusterStub {
+	stub := &clusterStub{status: http.StatusOK}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := int(atomic.LoadInt32(&stub.status)); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		esClusterStub(w, r)
+	}))
+	return stub
+}
+
+func (c *clusterStub) answer(status int) {
+	atomic.StoreInt32(&c.status, int32(status))
+}
+
+// testSupervisor returns a supervisor of the stub, whose first connection attempts fail
+type testSupervisor struct {
+	*ConnectionSupervisor
+	mu       sync.Mutex
+	attempts []time.Time
+}
+
+func newTestSupervisor(url string, failedAttempts int, options ...SupervisorOption) *testSupervisor {
+	options = append([]SupervisorOption{WithProbeInterval(5 * time.Millisecond), WithReconnectBackoff(5*time.Millisecond, 10*time.Millisecond)}, options...)
+	s := &testSupervisor{ConnectionSupervisor: NewConnectionSupervisor("local", NewAccessConfig("", "", url, false), options...)}
+	s.connect = func() (*elastic.Client, error) {
+		s.mu.Lock()
+		s.attempts = append(s.attempts, time.Now())
+		attempt := len(s.attempts)
+		s.mu.Unlock()
+
+		if attempt <= failedAttempts {
+			return nil, errors.New("no Elasticsearch node available")
+		}
+		return newClient(NewAccessConfig("", "", url, false), http.DefaultTransport, elastic.SetHealthcheck(false))
+	}
+	return s
+}
+
+func (s *testSupervisor) connectionAttempts() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.attempts...)
+}
+
+func receiveClient(t *testing.T, clients chan *elastic.Client) *elastic.Client {
+	select {
+	case client := <-clients:
+		require.NotNil(t, client)
+		return client
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no client received")
+		return nil
+	}
+}
+
+func assertNoClient(t *testing.T, clients chan *elastic.Client) {
+	select {
+	case <-clients:
+		assert.Fail(t, "the client should not have been rebuilt")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func waitForStatus(t *testing.T, s *ConnectionSupervisor, condition func(status ConnectionStatus) bool) ConnectionStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := s.Status(); condition(status) {
+			return status
+		}
+		time.Sleep(time.Millisecond)
+	}
+	require.FailNow(t, "the connection did not reach the expected status", "%+v", s.Status())
+	return ConnectionStatus{}
+}
+
+func TestConnectionSupervisorConnectsWithBackoff(t *testing.T) {
+	es := newClusterStub()
+	defer es.Close()
+	s := newTestSupervisor(es.URL, 3)
+	assert.Equal(t, ConnectionConnecting, s.Status().State)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clients := make(chan *elastic.Client)
+	go s.Run(ctx, clients)
+
+	receiveClient(t, clients)
+	status := s.Status()
+	assert.Equal(t, ConnectionConnected, status.State)
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+	assert.Empty(t, status.LastError)
+	assert.NotNil(t, status.ConnectedSince)
+	assert.Nil(t, status.NextAttempt)
+	assert.Equal(t, 0, status.Reconnects, "the first connection is not a reconnection")
+
+	attempts := s.connectionAttempts()
+	require.Len(t, attempts, 4)
+	for i, backoff := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond} {
+		assert.True(t, attempts[i+1].Sub(attempts[i]) >= backoff, "attempt %d should wait at least %v", i+2, backoff)
+	}
+}
+
+func TestConnectionSupervisorReportsFailedConnections(t *testing.T) {
+	s := newTestSupervisor("http://localhost:9200", 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clients := make(chan *elastic.Client)
+	go s.Run(ctx, clients)
+
+	status := waitForStatus(t, s.ConnectionSupervisor, func(status ConnectionStatus) bool { return status.ConsecutiveFailures >= 2 })
+	assert.Equal(t, ConnectionConnecting, status.State)
+	assert.Equal(t, "no Elasticsearch node available", status.LastError)
+	assert.NotNil(t, status.NextAttempt)
+}
+
+func TestConnectionSupervisorRebuildsAfterSustainedFailures(t *testing.T) {
+	es := newClusterStub()
+	defer es.Close()
+	s := newTestSupervisor(es.URL, 0, WithFailureThreshold(3))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clients := make(chan *elastic.Client)
+	go s.Run(ctx, clients)
+
+	first := receiveClient(t, clients)
+	es.answer(http.StatusServiceUnavailable)
+	second := receiveClient(t, clients)
+	assert.NotEqual(t, first, second)
+	assert.False(t, first.IsRunning(), "the replaced client should be stopped")
+
+	es.answer(http.StatusOK)
+	status := waitForStatus(t, s.ConnectionSupervisor, func(status ConnectionStatus) bool {
+		return status.LastProbe != nil && status.State == ConnectionConnected
+	})
+	assert.Equal(t, 1, status.Reconnects)
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+}
+
+func TestConnectionSupervisorKeepsClientBelowFailureThreshold(t *testing.T) {
+	es := newClusterStub()
+	defer es.Close()
+	s := newTestSupervisor(es.URL, 0, WithFailureThreshold(1000))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clients := make(chan *elastic.Client)
+	go s.Run(ctx, clients)
+	receiveClient(t, clients)
+
+	es.answer(http.StatusServiceUnavailable)
+	status := waitForStatus(t, s.ConnectionSupervisor, func(status ConnectionStatus) bool { return status.ConsecutiveFailures >= 2 })
+	assert.Equal(t, ConnectionDegraded, status.State)
+	assert.Contains(t, status.LastError, "503")
+
+	es.answer(http.StatusOK)
+	waitForStatus(t, s.ConnectionSupervisor, func(status ConnectionStatus) bool { return status.State == ConnectionConnected })
+	assertNoClient(t, clients)
+	assert.Equal(t, 0, s.Status().Reconnects)
+}
+
+func TestConnectionSupervisorRebuildsOnRefusedCredentials(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		es := newClusterStub()
+		s := newTestSupervisor(es.URL, 0, WithFailureThreshold(1000))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		clients := make(chan *elastic.Client)
+		go s.Run(ctx, clients)
+		receiveClient(t, clients)
+
+		es.answer(status)
+		receiveClient(t, clients)
+		assert.Equal(t, 1, s.Status().Reconnects)
+
+		cancel()
+		es.Close()
+	}
+}
+
+func TestConnectionSupervisorRebuildsOnChangedCredentialFiles(t *testing.T) {
+	es := newClusterStub()
+	defer es.Close()
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caBundle, []byte("first"), 0600))
+
+	s := newTestSupervisor(es.URL, 0)
+	s.watchedFiles = []string{caBundle}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clients := make(chan *elastic.Client)
+	go s.Run(ctx, clients)
+	receiveClient(t, clients)
+	assertNoClient(t, clients)
+
+	require.NoError(t, ioutil.WriteFile(caBundle, []byte("rotated"), 0600))
+	receiveClient(t, clients)
+	assert.Equal(t, 1, s.Status().Reconnects)
+}
+
+func TestConnectionSupervisorReconnectsOnRequest(t *testing.T) {
+	es := newClusterStub()
+	defer es.Close()
+	s := newTestSupervisor(es.URL, 0, WithProbeInterval(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clients := make(chan *elastic.Client)
+	go s.Run(ctx, clients)
+	receiveClient(t, clients)
+
+	s.Reconnect()
+	receiveClient(t, clients)
+	assert.Len(t, s.connectionAttempts(), 2)
+}
+
+func TestConnectionSupervisorStopsWithContext(t *testing.T) {
+	es := newClusterStub()
+	defer es.Close()
+	s := newTestSupervisor(es.URL, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	clients := make(chan *elastic.Client)
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx, clients)
+		close(stopped)
+	}()
+	client := receiveClient(t, clients)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the supervisor did not stop")
+	}
+	_, open := <-clients
+	assert.False(t, open, "the channel of the clients should be closed")
+	assert.False(t, client.IsRunning())
+}
+
+func TestNextBackoff(t *testing.T) {
+	assert.Equal(t, 2*time.Second, nextBackoff(time.Second, time.Minute))
+	assert.Equal(t, 32*time.Second, nextBackoff(16*time.Second, time.Minute))
+	assert.Equal(t, time.Minute, nextBackoff(32*time.Second, time.Minute))
+	assert.Equal(t, time.Minute, nextBackoff(time.Minute, time.Minute))
+}
+
+func TestConnectionStatusOfService(t *testing.T) {
+	es := &esService{}
+	status, err := es.ConnectionStatus()
+	require.NoError(t, err)
+	assert.Equal(t, ConnectionConnecting, status.State)
+
+	es.elasticClient = &elastic.Client{}
+	status, err = es.ConnectionStatus()
+	require.NoError(t, err)
+	assert.Equal(t, ConnectionConnected, status.State)
+
+	supervisor := NewConnectionSupervisor("local", NewAccessConfig("", "", "", false))
+	WithConnectionSupervisor(supervisor)(es)
+	status, err = es.ConnectionStatus()
+	require.NoError(t, err)
+	assert.Equal(t, ConnectionConnecting, status.State, "the status of the supervisor should be reported")
+
+	es.storage = NewMemoryStorage("concepts")
+	_, err = es.ConnectionStatus()
+	assert.Equal(t, ErrStorageUnsupported, err)
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/rcrowley/go-metrics"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const (
+	contentHashField = "contentHash"
+	// UnchangedResult is the result of the index response LoadData returns when the stored document has the same content,
+	// as Elasticsearch reports the updates which did not change the document
+	UnchangedResult = "noop"
+)
+
+var unchangedWrites = metrics.GetOrRegisterCounter("concept.write.unchanged", metrics.DefaultRegistry)
+
+// contentHashed is implemented by the models which keep the hash of their content in the index
+type contentHashed interface {
+	setContentHash(hash string)
+}
+
+func (m *EsConceptModel) setContentHash(hash string) {
+	m.ContentHash = hash
+}
+
+// contentHash returns a hash of the document, ignoring the fields which change on every write
+func contentHash(doc EsModel) (string, error) {
+	fields := toFieldMap(doc)
+	for _, field := range volatileFields {
+		delete(fields, field)
+	}
+
+	// maps are marshalled with sorted keys, so the hash does not depend on the field order
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// hasContentHash tells whether the stored document has been written with the same content
+func hasContentHash(readResult *elastic.GetResult, hash string) bool {
+	stored, found := toFieldMap(storedSource(readResult))[contentHashField]
+	return found && stored == hash
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentHashIgnoresVolatileFields(t *testing.T) {
+	first, err := contentHash(&EsConceptModel{PrefLabel: "Label", LastModified: "2017-01-01T00:00:00Z", PublishReference: "tid_1"})
+	require.NoError(t, err)
+	second, err := contentHash(&EsConceptModel{PrefLabel: "Label", LastModified: "2018-01-01T00:00:00Z", PublishReference: "tid_2", ContentHash: first})
+	require.NoError(t, err)
+	other, err := contentHash(&EsConceptModel{PrefLabel: "Other label", LastModified: "2017-01-01T00:00:00Z", PublishReference: "tid_1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, other)
+}
+
+func TestLoadDataSkipsUnchangedConcept(t *testing.T) {
+	testUUID := uuid.NewV4().String()
+	hash, err := contentHash(&EsConceptModel{PrefLabel: "Label"})
+	require.NoError(t, err)
+
+	getES := newGetESMock(map[string]string{
+		testUUID: fmt.Sprintf(`{"prefLabel":"Label","lastModified":"2017-01-01T00:00:00Z","contentHash":"%s"}`, hash),
+	})
+	defer getES.Close()
+	var writes int
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut || r.Method == http.MethodPost {
+			writes++
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		getES.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer es.Close()
+
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now}
+	skipped := unchangedWrites.Count()
+
+	up, resp, err := service.LoadData(newTestContext(), organisationsType, testUUID, &EsConceptModel{PrefLabel: "Label", LastModified: "2018-01-01T00:00:00Z"})
+	require.NoError(t, err)
+
+	assert.False(t, up)
+	assert.Equal(t, UnchangedResult, resp.Result)
+	assert.Equal(t, 0, writes, "unchanged concept should not be written")
+	assert.Equal(t, skipped+1, unchangedWrites.Count())
+}
+
+func TestPlanLoadDataStoresContentHash(t *testing.T) {
+	testUUID := uuid.NewV4().String()
+	es := newGetESMock(map[string]string{
+		testUUID: `{"prefLabel":"Old label","contentHash":"stale"}`,
+	})
+	defer es.Close()
+
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now}
+	payload := &EsConceptModel{PrefLabel: "New label"}
+
+	plan, err := service.PlanLoadData(newTestContext(), organisationsType, testUUID, payload)
+	require.NoError(t, err)
+
+	hash, err := contentHash(&EsConceptModel{PrefLabel: "New label"})
+	require.NoError(t, err)
+	assert.False(t, plan.Unchanged)
+	require.NotNil(t, plan.Index)
+	assert.Equal(t, hash, plan.Index.Document.(*EsConceptModel).ContentHash)
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"encoding/json"
+	"path"
+	"sync"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// Converter holds the behaviour specific to a concept type: how concepts are converted into the documents
+// stored in the index, which checks they must pass and which stored data survives when they are rewritten.
+type Converter interface {
+	// Convert converts a concept in the original concept model
+	Convert(concept ConceptModel, conceptType string, publishRef string) EsModel
+	// ConvertAggregate converts a concept in the aggregate concept model
+	ConvertAggregate(concept AggregateConceptModel, conceptType string, publishRef string) EsModel
+	// Validate checks an aggregate concept before it is converted
+	Validate(concept AggregateConceptModel) error
+	// PatchData extracts the data of a stored document which is not sourced from the payload, e.g. metrics,
+	// so that it can be written back after the document has been replaced
+	PatchData(stored json.RawMessage) (PayloadPatch, error)
+	// Upgrade decodes a stored document into the current model of the documents, e.g. to reindex it
+	Upgrade(stored json.RawMessage) (EsModel, error)
+}
+
+type converterRegistry struct {
+	sync.RWMutex
+	byType       map[string]Converter
+	byDirectType map[string]map[string]Converter
+	fallback     Converter
+}
+
+var converters = &converterRegistry{
+	byType:       make(map[string]Converter),
+	byDirectType: make(map[string]map[string]Converter),
+	fallback:     DefaultConverter{},
+}
+
+// RegisterConverter sets the converter of a concept type, replacing any previous one
+func RegisterConverter(conceptType string, converter Converter) {
+	converters.Lock()
+	defer converters.Unlock()
+
+	converters.byType[conceptType] = converter
+}
+
+// RegisterDirectTypeConverter sets the converter of the concepts of a type which have the given direct type, e.g. PublicCompany.
+// It takes precedence over the converter of the concept type.
+func RegisterDirectTypeConverter(conceptType string, directType string, converter Converter) {
+	converters.Lock()
+	defer converters.Unlock()
+
+	if converters.byDirectType[conceptType] == nil {
+		converters.byDirectType[conceptType] = make(map[string]Converter)
+	}
+	converters.byDirectType[conceptType][directType] = converter
+}
+
+// ConverterFor returns the converter of the most specific registration for the concept type and direct type,
+// or the DefaultConverter when there is none
+func ConverterFor(conceptType string, directType string) Converter {
+	converters.RLock()
+	defer converters.RUnlock()
+
+	if c, found := converters.byDirectType[conceptType][directType]; found {
+		return c
+	}
+	if c, found := converters.byType[conceptType]; found {
+		return c
+	}
+	return converters.fallback
+}
+
+// storedDirectType returns the direct type of a stored document, e.g. PublicCompany for http://www.ft.com/ontology/company/PublicCompany
+func storedDirectType(readResult *elastic.GetResult) string {
+	return sourceDirectType(storedSource(readResult))
+}
+
+// sourceDirectType returns the direct type of the source of a document
+func sourceDirectType(source interface{}) string {
+	directType, _ := toFieldMap(source)["directType"].(string)
+	if directType == "" {
+		return ""
+	}
+	return path.Base(directType)
+}
+
+// DefaultConverter converts concepts into EsConceptModel documents and preserves their metrics
+type DefaultConverter struct{}
+
+func (DefaultConverter) Convert(concept ConceptModel, conceptType string, publishRef string) EsModel {
+	return newESConceptModel(concept.UUID, conceptType, concept.DirectType, concept.Aliases, concept.GetAuthorities(), concept.PrefLabel, publishRef, concept.IsDeprecated, concept.ScopeNote)
+}
+
+func (DefaultConverter) ConvertAggregate(concept AggregateConceptModel, conceptType string, publishRef string) EsModel {
+	return getEsConcept(concept, conceptType, publishRef)
+}
+
+func (DefaultConverter) Validate(concept AggregateConceptModel) error {
+	return nil
+}
+
+func (DefaultConverter) PatchData(stored json.RawMessage) (PayloadPatch, error) {
+	esConcept := new(EsConceptModel)
+	if err := json.Unmarshal(stored, esConcept); err != nil {
+		return nil, err
+	}
+	return &EsConceptModelPatch{Metrics: esConcept.Metrics}, nil
+}
+
+func (DefaultConverter) Upgrade(stored json.RawMessage) (EsModel, error) {
+	esConcept := new(EsConceptModel)
+	if err := json.Unmarshal(stored, esConcept); err != nil {
+		return nil, err
+	}
+	return esConcept, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+type testConverter struct {
+	DefaultConverter
+	name string
+}
+
+func (c testConverter) ConvertAggregate(concept AggregateConceptModel, conceptType string, publishRef string) EsModel {
+	esModel := getEsConcept(concept, conceptType, publishRef)
+	esModel.ScopeNote = c.name
+	return esModel
+}
+
+func (c testConverter) PatchData(stored json.RawMessage) (PayloadPatch, error) {
+	return c.name, nil
+}
+
+func TestConverterForPrefersDirectType(t *testing.T) {
+	assert.Equal(t, PublicCompanyConverter{}, ConverterFor(organisation, directTypePublicCompany))
+	assert.Equal(t, DefaultConverter{}, ConverterFor(organisation, "PrivateCompany"))
+	assert.Equal(t, PeopleConverter{}, ConverterFor(person, "Person"))
+	assert.Equal(t, MembershipConverter{}, ConverterFor(memberships, ""))
+	assert.Equal(t, DefaultConverter{}, ConverterFor("genres", "Genre"))
+}
+
+func TestRegisteredConverterIsUsed(t *testing.T) {
+	withTestConverters(func() {
+		RegisterConverter("financial-instruments", testConverter{name: "by type"})
+		RegisterDirectTypeConverter("financial-instruments", "Share", testConverter{name: "by direct type"})
+
+		esModel := ConvertAggregateConceptToESConceptModel(AggregateConceptModel{PrefUUID: "uuid", DirectType: "FinancialInstrument", PrefLabel: "Bond"}, "financial-instruments", "tid_test")
+		assert.Equal(t, "by type", esModel.(*EsConceptModel).ScopeNote)
+
+		esModel = ConvertAggregateConceptToESConceptModel(AggregateConceptModel{PrefUUID: "uuid", DirectType: "Share", PrefLabel: "Share"}, "financial-instruments", "tid_test")
+		assert.Equal(t, "by direct type", esModel.(*EsConceptModel).ScopeNote)
+	})
+}
+
+func TestGetPatchDataUsesConverterOfStoredDirectType(t *testing.T) {
+	withTestConverters(func() {
+		RegisterDirectTypeConverter("financial-instruments", "Share", testConverter{name: "share patch"})
+
+		source := json.RawMessage(`{"directType":"http://www.ft.com/ontology/Share"}`)
+		patch := getPatchData(nil, newWriteLog(newTestContext(), "financial-instruments", "uuid"), "financial-instruments", &elastic.GetResult{Found: true, Source: &source})
+		assert.Equal(t, "share patch", patch)
+	})
+}
+
+func TestGetPatchDataForMissingDocument(t *testing.T) {
+	patch := getPatchData(nil, newWriteLog(newTestContext(), person, "uuid"), person, &elastic.GetResult{Found: false})
+	assert.Nil(t, patch)
+}
+
+func TestMembershipConverterValidation(t *testing.T) {
+	c := ConverterFor(memberships, "Membership")
+
+	assert.NoError(t, c.Validate(AggregateConceptModel{PersonUUID: "person", OrganisationUUID: "organisation"}))
+	assert.Equal(t, errIncompleteMembership, c.Validate(AggregateConceptModel{OrganisationUUID: "organisation"}))
+	assert.Equal(t, errIncompleteMembership, c.Validate(AggregateConceptModel{PersonUUID: "person"}))
+}
+
+func TestPeopleConverterPreservesFTAuthor(t *testing.T) {
+	patch, err := ConverterFor(person, "Person").PatchData(json.RawMessage(`{"isFTAuthor":"true","metrics":{"annotationsCount":3}}`))
+	require.NoError(t, err)
+	assert.Equal(t, &EsPersonConceptPatch{Metrics: &ConceptMetrics{AnnotationsCount: 3}, IsFTAuthor: "true"}, patch)
+
+	_, err = ConverterFor(person, "Person").PatchData(json.RawMessage(`not json`))
+	assert.Error(t, err)
+}
+
+// withTestConverters restores the registered converters once the test function has completed
+func withTestConverters(test func()) {
+	converters.Lock()
+	byType := make(map[string]Converter)
+	for k, v := range converters.byType {
+		byType[k] = v
+	}
+	byDirectType := make(map[string]map[string]Converter)
+	for k, v := range converters.byDirectType {
+		byDirectType[k] = v
+	}
+	converters.Unlock()
+
+	defer func() {
+		converters.Lock()
+		converters.byType = byType
+		converters.byDirectType = byDirectType
+		converters.Unlock()
+	}()
+	test()
+}
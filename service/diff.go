@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"sort"
+)
+
+// volatileFields change on every write, so they are ignored when comparing documents
+var volatileFields = []string{"lastModified", "publishReference", contentHashField}
+
+// DocumentDiff lists the differences between the stored document of a concept and the document a write would produce
+type DocumentDiff struct {
+	ConceptType string `json:"conceptType"`
+	UUID        string `json:"uuid"`
+	// Dropped is true when the write would be ignored, see WritePlan
+	Dropped bool `json:"dropped"`
+	// Found is true when there is a stored document for the concept
+	Found       bool              `json:"found"`
+	Differences []FieldDifference `json:"differences"`
+}
+
+// FieldDifference is a field whose stored value differs from the candidate one.
+// A nil value means the field is missing from that document.
+type FieldDifference struct {
+	Path      string      `json:"path"`
+	Stored    interface{} `json:"stored"`
+	Candidate interface{} `json:"candidate"`
+}
+
+// DiffData compares the stored document with the document LoadData would write for the given payload.
+// For memberships the person document the membership applies to is compared.
+func (es *esService) DiffData(ctx context.Context, conceptType string, uuid string, payload EsModel) (*DocumentDiff, error) {
+	plan, err := es.PlanLoadData(ctx, conceptType, uuid, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &DocumentDiff{ConceptType: conceptType, UUID: uuid, Dropped: plan.Dropped, Differences: []FieldDifference{}}
+	switch {
+	case plan.Unchanged:
+		diff.Found = true
+		return diff, nil
+	case plan.Index != nil:
+		diff.ConceptType, diff.UUID = plan.Index.ConceptType, plan.Index.UUID
+	case len(plan.Patches) > 0:
+		diff.ConceptType, diff.UUID = plan.Patches[0].ConceptType, plan.Patches[0].UUID
+	default:
+		return diff, nil
+	}
+
+	current, err := es.ReadData(ctx, diff.ConceptType, diff.UUID)
+	if err != nil {
+		return nil, err
+	}
+	diff.Found = current.Found
+
+	stored := toFieldMap(storedSource(current))
+	candidate := stored
+	if plan.Index != nil {
+		candidate = toFieldMap(plan.Index.Document)
+	}
+	for _, p := range plan.Patches {
+		candidate = mergeFields(candidate, toFieldMap(p.Patch))
+	}
+
+	for _, field := range volatileFields {
+		delete(stored, field)
+		delete(candidate, field)
+	}
+
+	diff.Differences = diffFields("", stored, candidate, diff.Differences)
+	sort.Slice(diff.Differences, func(i, j int) bool {
+		return diff.Differences[i].Path < diff.Differences[j].Path
+	})
+	return diff, nil
+}
+
+// mergeFields returns a copy of the document with the patch fields applied on top, like an Elasticsearch partial update
+func mergeFields(doc map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		patchObject, isObject := v.(map[string]interface{})
+		docObject, docIsObject := merged[k].(map[string]interface{})
+		if isObject && docIsObject {
+			merged[k] = mergeFields(docObject, patchObject)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// diffFields walks both documents and appends a difference for every leaf field which differs.
+// Arrays are compared as a whole.
+func diffFields(prefix string, stored map[string]interface{}, candidate map[string]interface{}, diffs []FieldDifference) []FieldDifference {
+	keys := make(map[string]struct{})
+	for k := range stored {
+		keys[k] = struct{}{}
+	}
+	for k := range candidate {
+		keys[k] = struct{}{}
+	}
+
+	for k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+
+		s, c := stored[k], candidate[k]
+		storedObject, storedIsObject := s.(map[string]interface{})
+		candidateObject, candidateIsObject := c.(map[string]interface{})
+		if storedIsObject && candidateIsObject {
+			diffs = diffFields(path, storedObject, candidateObject, diffs)
+			continue
+		}
+
+		if !reflect.DeepEqual(s, c) {
+			diffs = append(diffs, FieldDifference{Path: path, Stored: s, Candidate: c})
+		}
+	}
+	return diffs
+}
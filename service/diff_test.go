@@ -0,0 +1,90 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffDataIgnoresVolatileFields(t *testing.T) {
+	testUUID := uuid.NewV4().String()
+	es := newGetESMock(map[string]string{
+		testUUID: `{"id":"","apiUrl":"","directType":"","prefLabel":"Old label","aliases":["a","b"],"lastModified":"2017-01-01T00:00:00Z","publishReference":"tid_old","isFTAuthor":"true","metrics":{"annotationsCount":10,"prevWeekAnnotationsCount":1}}`,
+	})
+	defer es.Close()
+
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now}
+	payload := &EsPersonConceptModel{
+		EsConceptModel: &EsConceptModel{PrefLabel: "New label", Aliases: []string{"a", "c"}, LastModified: "2018-01-01T00:00:00Z", PublishReference: "tid_new"},
+		IsFTAuthor:     "false",
+	}
+
+	diff, err := service.DiffData(newTestContext(), person, testUUID, payload)
+	require.NoError(t, err)
+
+	assert.True(t, diff.Found)
+	assert.False(t, diff.Dropped)
+	assert.Equal(t, []FieldDifference{
+		{Path: "aliases", Stored: []interface{}{"a", "b"}, Candidate: []interface{}{"a", "c"}},
+		{Path: "prefLabel", Stored: "Old label", Candidate: "New label"},
+	}, diff.Differences, "preserved metrics and author flag should not differ")
+}
+
+func TestDiffDataForNewConcept(t *testing.T) {
+	es := newGetESMock(map[string]string{})
+	defer es.Close()
+
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now}
+	testUUID := uuid.NewV4().String()
+
+	diff, err := service.DiffData(newTestContext(), organisationsType, testUUID, &EsConceptModel{PrefLabel: "Label", LastModified: "2018-01-01T00:00:00Z"})
+	require.NoError(t, err)
+
+	assert.False(t, diff.Found)
+	assert.Contains(t, diff.Differences, FieldDifference{Path: "prefLabel", Candidate: "Label"})
+	assert.NotContains(t, diff.Differences, FieldDifference{Path: "lastModified", Candidate: "2018-01-01T00:00:00Z"})
+}
+
+func TestDiffDataForFTMembership(t *testing.T) {
+	personUUID := uuid.NewV4().String()
+	es := newGetESMock(map[string]string{
+		personUUID: `{"prefLabel":"Person","isFTAuthor":"false"}`,
+	})
+	defer es.Close()
+
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now}
+	membership := &EsMembershipModel{Id: uuid.NewV4().String(), PersonId: personUUID, OrganisationId: ftOrgUUID, Memberships: []string{journalistUUID}}
+
+	diff, err := service.DiffData(newTestContext(), memberships, membership.Id, membership)
+	require.NoError(t, err)
+
+	assert.Equal(t, person, diff.ConceptType)
+	assert.Equal(t, personUUID, diff.UUID)
+	assert.Equal(t, []FieldDifference{{Path: "isFTAuthor", Stored: "false", Candidate: "true"}}, diff.Differences)
+}
+
+func TestDiffDataDroppedMembership(t *testing.T) {
+	es := newGetESMock(map[string]string{})
+	defer es.Close()
+
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now}
+	membership := &EsMembershipModel{Id: uuid.NewV4().String(), PersonId: uuid.NewV4().String(), OrganisationId: uuid.NewV4().String()}
+
+	diff, err := service.DiffData(newTestContext(), memberships, membership.Id, membership)
+	require.NoError(t, err)
+
+	assert.True(t, diff.Dropped)
+	assert.Empty(t, diff.Differences)
+}
+
+func TestDiffFieldsNestedObjects(t *testing.T) {
+	stored := map[string]interface{}{"metrics": map[string]interface{}{"annotationsCount": 1.0, "prevWeekAnnotationsCount": 2.0}}
+	candidate := map[string]interface{}{"metrics": map[string]interface{}{"annotationsCount": 3.0, "prevWeekAnnotationsCount": 2.0}}
+
+	diffs := diffFields("", stored, candidate, nil)
+
+	assert.Equal(t, []FieldDifference{{Path: "metrics.annotationsCount", Stored: 1.0, Candidate: 3.0}}, diffs)
+}
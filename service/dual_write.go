@@ -0,0 +1,316 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	"github.com/rcrowley/go-metrics"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const (
+	secondaryIndexField = "secondaryIndex"
+	// aliasSwitchCheckInterval is how frequently the alias of the index is checked while dual writing
+	aliasSwitchCheckInterval = 30 * time.Second
+	// aliasSwitchCheckTimeout bounds the time a check of the alias waits for Elasticsearch
+	aliasSwitchCheckTimeout = 10 * time.Second
+)
+
+var (
+	divergentWrites       = metrics.GetOrRegisterCounter("concept.dualwrite.divergence.write", metrics.DefaultRegistry)
+	divergentDeletes      = metrics.GetOrRegisterCounter("concept.dualwrite.divergence.delete", metrics.DefaultRegistry)
+	divergentBulkRequests = metrics.GetOrRegisterCounter("concept.dualwrite.divergence.bulk", metrics.DefaultRegistry)
+)
+
+// DualWriteStatus describes the writes to the secondary index
+type DualWriteStatus struct {
+	SecondaryIndex string `json:"secondaryIndex,omitempty"`
+	Active         bool   `json:"active"`
+	StoppedReason  string `json:"stoppedReason,omitempty"`
+	// the writes, deletes and bulk requests applied to one index only, since the service started
+	DivergentWrites       int64 `json:"divergentWrites"`
+	DivergentDeletes      int64 `json:"divergentDeletes"`
+	DivergentBulkRequests int64 `json:"divergentBulkRequests"`
+}
+
+// dualWrite applies the writes to a secondary index as well, e.g. the new versioned index being filled by a reindex,
+// until the alias of the index is switched to it or it is stopped.
+type dualWrite struct {
+	sync.Mutex
+	index         string
+	active        bool
+	stoppedReason string
+}
+
+// primaryRequest is a bulk request to the index while dual writing, paired with the same request to the secondary index
+type primaryRequest struct {
+	elastic.BulkableRequest
+	outcome *pairedOutcome
+}
+
+// secondaryRequest is a bulk request to the secondary index, paired with the same request to the index
+type secondaryRequest struct {
+	elastic.BulkableRequest
+	outcome *pairedOutcome
+}
+
+// pairedOutcome collects the outcomes of the requests to the index and to the secondary index, which may be sent in
+// different bulk requests, so that they are compared once both are known
+type pairedOutcome struct {
+	sync.Mutex
+	primaryDone, secondaryDone bool
+	primaryErr, secondaryErr   error
+}
+
+// record sets the outcome of one of the requests. Once both outcomes are known and differ, it returns true with the error
+// of the request which failed.
+func (o *pairedOutcome) record(secondary bool, err error) (bool, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	if secondary {
+		o.secondaryDone, o.secondaryErr = true, err
+	} else {
+		o.primaryDone, o.primaryErr = true, err
+	}
+	if !o.primaryDone || !o.secondaryDone || (o.primaryErr == nil) == (o.secondaryErr == nil) {
+		return false, nil
+	}
+	if o.primaryErr != nil {
+		return true, o.primaryErr
+	}
+	return true, o.secondaryErr
+}
+
+// WithSecondaryIndex applies the writes to the secondary index as well. Nothing is done if the name is empty.
+func WithSecondaryIndex(name string) EsServiceOption {
+	return func(es *esService) {
+		if name == "" {
+			return
+		}
+		es.dualWrite = &dualWrite{index: name, active: true}
+	}
+}
+
+// secondaryIndex returns the name of the secondary index, or nothing if the writes are not applied to it
+func (d *dualWrite) secondaryIndex() string {
+	if d == nil {
+		return ""
+	}
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.active {
+		return ""
+	}
+	return d.index
+}
+
+func (d *dualWrite) stop(reason string) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.active {
+		return
+	}
+	log.WithField(secondaryIndexField, d.index).WithField("reason", reason).Info("Writes to the secondary index stopped")
+	d.active = false
+	d.stoppedReason = reason
+}
+
+func (d *dualWrite) status() DualWriteStatus {
+	status := DualWriteStatus{
+		DivergentWrites:       divergentWrites.Count(),
+		DivergentDeletes:      divergentDeletes.Count(),
+		DivergentBulkRequests: divergentBulkRequests.Count(),
+	}
+	if d == nil {
+		return status
+	}
+	d.Lock()
+	defer d.Unlock()
+
+	status.SecondaryIndex = d.index
+	status.Active = d.active
+	status.StoppedReason = d.stoppedReason
+	return status
+}
+
+// DualWriteStatus returns the status of the writes to the secondary index
+func (es *esService) DualWriteStatus() DualWriteStatus {
+	return es.dualWrite.status()
+}
+
+// StopDualWrite stops applying the writes to the secondary index
+func (es *esService) StopDualWrite() DualWriteStatus {
+	es.dualWrite.stop("stopped by an administrator")
+	return es.dualWrite.status()
+}
+
+// RefreshDualWrite stops applying the writes to the secondary index once the alias of the index has been switched to it
+func (es *esService) RefreshDualWrite(ctx context.Context) error {
+	secondary := es.dualWrite.secondaryIndex()
+	if secondary == "" {
+		return nil
+	}
+
+	es.RLock()
+	defer es.RUnlock()
+
+	if err := es.checkElasticClient(); err != nil {
+		return err
+	}
+
+	var indices []string
+	err := es.callStorage(ctx, readRetries, func() (err error) {
+		indices, err = es.store().aliasIndices(ctx, es.indexName)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, index := range indices {
+		if index == secondary {
+			es.dualWrite.stop("the alias has been switched to the secondary index")
+		}
+	}
+	return nil
+}
+
+// watchDualWrite refreshes the dual write at every check interval, until it is stopped or the context is done
+func (es *esService) watchDualWrite(ctx context.Context, checkInterval time.Duration) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for es.dualWrite.secondaryIndex() != "" {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := es.refreshDualWrite(ctx); err != nil && err != ErrNoElasticClient && err != ErrStorageUnsupported {
+				log.WithError(err).Warn("Failed to check whether the alias has been switched to the secondary index")
+			}
+		}
+	}
+}
+
+// refreshDualWrite refreshes the dual write, waiting for Elasticsearch up to the check timeout
+func (es *esService) refreshDualWrite(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, aliasSwitchCheckTimeout)
+	defer cancel()
+
+	return es.RefreshDualWrite(ctx)
+}
+
+// writeToSecondary applies a write to the secondary index, retried and guarded by the circuit breaker as the write to the index,
+// and records a divergence if its outcome differs from the write to the index
+func (es *esService) writeToSecondary(ctx context.Context, loadDataLog *logrus.Entry, conceptType string, uuid string, payload EsModel, primaryErr error) {
+	secondary := es.dualWrite.secondaryIndex()
+	if secondary == "" {
+		return
+	}
+
+	err := es.callStorage(ctx, writeRetries, func() error {
+		_, err := es.documents().Index(ctx, secondary, conceptType, uuid, payload)
+		return err
+	})
+
+	if (err == nil) != (primaryErr == nil) {
+		divergentWrites.Inc(1)
+		loadDataLog.WithError(err).WithField(secondaryIndexField, secondary).Warn("The write does not have the same outcome on the secondary index")
+	}
+}
+
+// deleteFromSecondary applies a delete to the secondary index, retried and guarded by the circuit breaker as the delete from the index,
+// and records a divergence if its outcome differs from the delete from the index
+func (es *esService) deleteFromSecondary(ctx context.Context, deleteDataLog *logrus.Entry, conceptType string, uuid string, primaryErr error) {
+	secondary := es.dualWrite.secondaryIndex()
+	if secondary == "" {
+		return
+	}
+
+	err := es.callStorage(ctx, deleteRetries, func() error {
+		_, err := es.documents().Delete(ctx, secondary, conceptType, uuid)
+		return err
+	})
+	if elastic.IsNotFound(err) {
+		// the concept may not have been copied yet
+		err = nil
+	}
+
+	if (err == nil) != (primaryErr == nil) {
+		divergentDeletes.Inc(1)
+		deleteDataLog.WithError(err).WithField(secondaryIndexField, secondary).Warn("The delete does not have the same outcome on the secondary index")
+	}
+}
+
+// addBulkRequest adds the request of the workload built for the index, and for the secondary index while dual writing.
+// It must be called with the lock held.
+func (es *esService) addBulkRequest(workload Workload, request func(index string) elastic.BulkableRequest) error {
+	secondary := es.dualWrite.secondaryIndex()
+	if secondary == "" {
+		return es.addToBulkProcessor(workload, request(es.indexName))
+	}
+
+	outcome := &pairedOutcome{}
+	if err := es.addToBulkProcessor(workload, &primaryRequest{request(es.indexName), outcome}); err != nil {
+		return err
+	}
+	return es.addToBulkProcessor(workload, &secondaryRequest{request(secondary), outcome})
+}
+
+// primaryRequests returns the requests to the index, without the ones to the secondary index
+func primaryRequests(requests []elastic.BulkableRequest) []elastic.BulkableRequest {
+	primaries := make([]elastic.BulkableRequest, 0, len(requests))
+	for _, r := range requests {
+		if _, secondary := r.(*secondaryRequest); !secondary {
+			primaries = append(primaries, r)
+		}
+	}
+	return primaries
+}
+
+// recordPairedOutcomes records the outcomes of the paired requests of a bulk request, and a divergence for every pair
+// whose requests did not have the same outcome. The requests parked while the index is blocked for writes have no outcome
+// until they are sent again.
+func (es *esService) recordPairedOutcomes(requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	for i, r := range requests {
+		var outcome *pairedOutcome
+		var secondary bool
+		switch paired := r.(type) {
+		case *primaryRequest:
+			outcome = paired.outcome
+		case *secondaryRequest:
+			outcome, secondary = paired.outcome, true
+		}
+		if outcome == nil {
+			continue
+		}
+
+		itemErr, uuid := err, ""
+		if err == nil && response != nil && i < len(response.Items) {
+			for _, result := range response.Items[i] {
+				uuid = result.Id
+				if result.Error != nil {
+					itemErr = &elastic.Error{Status: result.Status, Details: result.Error}
+				}
+			}
+		}
+		if !secondary && es.writeBlock != nil && IsWriteBlockError(itemErr) {
+			// parked, sent again once the block is lifted
+			continue
+		}
+
+		if diverged, failure := outcome.record(secondary, itemErr); diverged {
+			divergentBulkRequests.Inc(1)
+			log.WithField(uuidField, uuid).WithField(secondaryIndexField, es.dualWrite.index).
+				WithError(failure).Warn("The bulk request does not have the same outcome on the secondary index")
+		}
+	}
+}
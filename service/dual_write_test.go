@@ -0,0 +1,324 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const secondaryIndexName = "concept-new"
+
+// dualWriteESMock records the requests it receives, and fails the writes to the failing index.
+// The unavailable index is unavailable for the given number of requests.
+type dualWriteESMock struct {
+	sync.Mutex
+	failingIndex     string
+	unavailableIndex string
+	unavailable      int
+	aliasIndex       string
+	requests         []string
+	bulkBodies       []string
+	*httptest.Server
+}
+
+func newDualWriteESMock(failingIndex string) *dualWriteESMock {
+	m := &dualWriteESMock{failingIndex: failingIndex, aliasIndex: indexName}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	return m
+}
+
+func (m *dualWriteESMock) serve(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	m.requests = append(m.requests, r.Method+" "+r.URL.Path)
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/_bulk":
+		body, _ := ioutil.ReadAll(r.Body)
+		m.bulkBodies = append(m.bulkBodies, string(body))
+		fmt.Fprint(w, `{"took":1,"errors":false,"items":[{"index":{"status":201}}]}`)
+	case len(segments) == 2 && segments[1] == "_aliases":
+		fmt.Fprintf(w, `{"%s":{"aliases":{"%s":{}}}}`, m.aliasIndex, indexName)
+	case segments[0] == m.unavailableIndex && m.unavailable > 0:
+		m.unavailable--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"error":{"type":"unavailable_shards_exception","reason":"unavailable"},"status":503}`)
+	case segments[0] == m.failingIndex:
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error":{"type":"exception","reason":"failure"},"status":500}`)
+	case r.Method == http.MethodGet:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"_index":"%s","_type":"%s","_id":"%s","found":false}`, segments[0], segments[1], segments[2])
+	case r.Method == http.MethodDelete && segments[0] == secondaryIndexName:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"_index":"%s","_type":"%s","_id":"%s","found":false,"result":"not_found"}`, segments[0], segments[1], segments[2])
+	case r.Method == http.MethodDelete:
+		fmt.Fprintf(w, `{"_index":"%s","_type":"%s","_id":"%s","found":true,"result":"deleted"}`, segments[0], segments[1], segments[2])
+	default:
+		fmt.Fprintf(w, `{"_index":"%s","_type":"%s","_id":"%s","_version":1,"result":"created","created":true}`, segments[0], segments[1], segments[2])
+	}
+}
+
+func (m *dualWriteESMock) received() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string(nil), m.requests...)
+}
+
+func (m *dualWriteESMock) receivedBulkBodies() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string(nil), m.bulkBodies...)
+}
+
+func newDualWriteTestService(t *testing.T, url string) *esService {
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, time.Second)
+	ec := getElasticClient(t, url)
+	es := &esService{
+		elasticClient:       ec,
+		indexName:           indexName,
+		bulkProcessorConfig: &bulkProcessorConfig,
+		getCurrentTime:      time.Now,
+	}
+	WithSecondaryIndex(secondaryIndexName)(es)
+	require.NoError(t, es.resetBulkProcessor(), "require a bulk processor")
+	t.Cleanup(func() { es.bulkProcessor.Close() })
+	return es
+}
+
+func TestDualWrite(t *testing.T) {
+	m := newDualWriteESMock("")
+	defer m.Close()
+	es := newDualWriteTestService(t, m.URL)
+	writes := divergentWrites.Count()
+	deletes := divergentDeletes.Count()
+	uuid := "a8e6da3e-1a88-4d3b-9a4a-8a8c1c0a6b7e"
+
+	_, _, _, err := writeTestDocument(es, organisationsType, uuid)
+	require.NoError(t, err)
+	_, err = es.DeleteData(newTestContext(), organisationsType, uuid)
+	require.NoError(t, err)
+
+	received := m.received()
+	assert.Contains(t, received, "PUT /concept/organisations/"+uuid)
+	assert.Contains(t, received, "PUT /concept-new/organisations/"+uuid)
+	assert.Contains(t, received, "DELETE /concept/organisations/"+uuid)
+	assert.Contains(t, received, "DELETE /concept-new/organisations/"+uuid)
+	assert.Equal(t, writes, divergentWrites.Count())
+	assert.Equal(t, deletes, divergentDeletes.Count(), "a concept missing from the secondary index should not diverge")
+}
+
+func TestDualWriteDivergence(t *testing.T) {
+	m := newDualWriteESMock(secondaryIndexName)
+	defer m.Close()
+	es := newDualWriteTestService(t, m.URL)
+	writes := divergentWrites.Count()
+	deletes := divergentDeletes.Count()
+	uuid := "a8e6da3e-1a88-4d3b-9a4a-8a8c1c0a6b7e"
+
+	_, _, _, err := writeTestDocument(es, organisationsType, uuid)
+	assert.NoError(t, err, "a failure of the secondary index should not fail the write")
+	_, err = es.DeleteData(newTestContext(), organisationsType, uuid)
+	assert.NoError(t, err, "a failure of the secondary index should not fail the delete")
+
+	assert.Equal(t, writes+1, divergentWrites.Count())
+	assert.Equal(t, deletes+1, divergentDeletes.Count())
+	status := es.DualWriteStatus()
+	assert.Equal(t, divergentWrites.Count(), status.DivergentWrites)
+	assert.Equal(t, divergentDeletes.Count(), status.DivergentDeletes)
+}
+
+func TestDualWriteRetriesSecondaryIndex(t *testing.T) {
+	m := newDualWriteESMock("")
+	defer m.Close()
+	es := newDualWriteTestService(t, m.URL)
+	es.retryPolicy = testRetryPolicy
+	uuid := "a8e6da3e-1a88-4d3b-9a4a-8a8c1c0a6b7e"
+
+	m.Lock()
+	m.unavailableIndex, m.unavailable = secondaryIndexName, 2
+	m.Unlock()
+	writes, retries := divergentWrites.Count(), writeRetries.Count()
+	_, _, _, err := writeTestDocument(es, organisationsType, uuid)
+	require.NoError(t, err)
+	assert.Equal(t, retries+2, writeRetries.Count(), "the write to the unavailable secondary index should be retried")
+
+	m.Lock()
+	m.unavailable = 1
+	m.Unlock()
+	deletes, retries := divergentDeletes.Count(), deleteRetries.Count()
+	_, err = es.DeleteData(newTestContext(), organisationsType, uuid)
+	require.NoError(t, err)
+	assert.Equal(t, retries+1, deleteRetries.Count(), "the delete from the unavailable secondary index should be retried")
+
+	assert.Equal(t, writes, divergentWrites.Count())
+	assert.Equal(t, deletes, divergentDeletes.Count())
+}
+
+func TestDualWriteBulkRequests(t *testing.T) {
+	m := newDualWriteESMock("")
+	defer m.Close()
+	es := newDualWriteTestService(t, m.URL)
+
+	es.LoadBulkData(context.Background(), organisationsType, "1", map[string]string{"id": "1"})
+	es.PatchUpdateConcept(newTestContext(), organisationsType, "1", &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 1}})
+	require.NoError(t, es.bulkProcessor.Flush())
+
+	bodies := strings.Join(m.receivedBulkBodies(), "")
+	assert.Equal(t, 1, strings.Count(bodies, `{"index":{"_id":"1","_index":"concept","_type":"organisations"}}`))
+	assert.Equal(t, 1, strings.Count(bodies, `{"index":{"_id":"1","_index":"concept-new","_type":"organisations"}}`))
+	assert.Equal(t, 1, strings.Count(bodies, `{"update":{"_id":"1","_index":"concept","_type":"organisations"}}`))
+	assert.Equal(t, 1, strings.Count(bodies, `{"update":{"_id":"1","_index":"concept-new","_type":"organisations"}}`))
+}
+
+func newPairedTestRequests(id string) (*primaryRequest, *secondaryRequest) {
+	outcome := &pairedOutcome{}
+	return &primaryRequest{elastic.NewBulkIndexRequest().Index(indexName).Id(id), outcome},
+		&secondaryRequest{elastic.NewBulkIndexRequest().Index(secondaryIndexName).Id(id), outcome}
+}
+
+func bulkItem(index string, id string, status int, errorType string) map[string]*elastic.BulkResponseItem {
+	item := &elastic.BulkResponseItem{Index: index, Id: id, Status: status}
+	if errorType != "" {
+		item.Error = &elastic.ErrorDetails{Type: errorType}
+	}
+	return map[string]*elastic.BulkResponseItem{"index": item}
+}
+
+func TestAfterBulkCountsSecondaryFailures(t *testing.T) {
+	es := &esService{writeBlock: &writeBlock{checkInterval: time.Second, maxParked: 10}, dualWrite: &dualWrite{index: secondaryIndexName, active: true}}
+	bulk := divergentBulkRequests.Count()
+
+	primary, secondary := newPairedTestRequests("1")
+	response := &elastic.BulkResponse{
+		Errors: true,
+		Items: []map[string]*elastic.BulkResponseItem{
+			bulkItem(indexName, "1", http.StatusCreated, ""),
+			bulkItem(secondaryIndexName, "1", http.StatusForbidden, clusterBlockException),
+		},
+	}
+	es.afterBulk(1, []elastic.BulkableRequest{primary, secondary}, response, nil)
+
+	assert.Equal(t, bulk+1, divergentBulkRequests.Count())
+	assert.Equal(t, 0, es.writeBlock.parkedCount(), "the block of the secondary index should not park the requests")
+	assert.NoError(t, es.checkWriteBlock())
+}
+
+func TestAfterBulkComparesPairedOutcomesAcrossBulkRequests(t *testing.T) {
+	es := &esService{writeBlock: &writeBlock{checkInterval: time.Second, maxParked: 10}, dualWrite: &dualWrite{index: secondaryIndexName, active: true}}
+	bulk := divergentBulkRequests.Count()
+
+	diverging, divergingSecondary := newPairedTestRequests("1")
+	failing, failingSecondary := newPairedTestRequests("2")
+	applied, appliedSecondary := newPairedTestRequests("3")
+	es.afterBulk(1, []elastic.BulkableRequest{diverging, failing, applied}, &elastic.BulkResponse{
+		Errors: true,
+		Items: []map[string]*elastic.BulkResponseItem{
+			bulkItem(indexName, "1", http.StatusCreated, ""),
+			bulkItem(indexName, "2", http.StatusBadRequest, "mapper_parsing_exception"),
+			bulkItem(indexName, "3", http.StatusCreated, ""),
+		},
+	}, nil)
+	assert.Equal(t, bulk, divergentBulkRequests.Count(), "the outcomes should be compared once both are known")
+
+	es.afterBulk(2, []elastic.BulkableRequest{divergingSecondary, failingSecondary, appliedSecondary}, &elastic.BulkResponse{
+		Errors: true,
+		Items: []map[string]*elastic.BulkResponseItem{
+			bulkItem(secondaryIndexName, "1", http.StatusBadRequest, "mapper_parsing_exception"),
+			bulkItem(secondaryIndexName, "2", http.StatusBadRequest, "mapper_parsing_exception"),
+			bulkItem(secondaryIndexName, "3", http.StatusCreated, ""),
+		},
+	}, nil)
+	assert.Equal(t, bulk+1, divergentBulkRequests.Count(), "only the request applied to one index should diverge")
+}
+
+func TestAfterBulkParksOnlyPrimaryRequestsWhenRefused(t *testing.T) {
+	es := &esService{writeBlock: &writeBlock{checkInterval: time.Second, maxParked: 10}, dualWrite: &dualWrite{index: secondaryIndexName, active: true}}
+	bulk := divergentBulkRequests.Count()
+
+	primary, secondary := newPairedTestRequests("1")
+	es.afterBulk(1, []elastic.BulkableRequest{primary, secondary}, nil, &elastic.Error{Status: http.StatusForbidden, Details: &elastic.ErrorDetails{Type: clusterBlockException}})
+
+	assert.Equal(t, []elastic.BulkableRequest{primary}, es.writeBlock.parked, "the writes to the secondary index should not be parked")
+	assert.Equal(t, bulk, divergentBulkRequests.Count(), "the parked request should have no outcome until it is sent again")
+
+	es.afterBulk(2, []elastic.BulkableRequest{primary}, &elastic.BulkResponse{
+		Items: []map[string]*elastic.BulkResponseItem{bulkItem(indexName, "1", http.StatusCreated, "")},
+	}, nil)
+	assert.Equal(t, bulk+1, divergentBulkRequests.Count())
+}
+
+func TestStopDualWrite(t *testing.T) {
+	m := newDualWriteESMock("")
+	defer m.Close()
+	es := newDualWriteTestService(t, m.URL)
+
+	status := es.StopDualWrite()
+	assert.Equal(t, secondaryIndexName, status.SecondaryIndex)
+	assert.False(t, status.Active)
+	assert.Equal(t, "stopped by an administrator", status.StoppedReason)
+
+	_, _, _, err := writeTestDocument(es, organisationsType, "a8e6da3e-1a88-4d3b-9a4a-8a8c1c0a6b7e")
+	require.NoError(t, err)
+	assert.NotContains(t, m.received(), "PUT /concept-new/organisations/a8e6da3e-1a88-4d3b-9a4a-8a8c1c0a6b7e")
+}
+
+func TestRefreshDualWriteStopsOnceTheAliasIsSwitched(t *testing.T) {
+	m := newDualWriteESMock("")
+	defer m.Close()
+	es := newDualWriteTestService(t, m.URL)
+
+	require.NoError(t, es.RefreshDualWrite(context.Background()))
+	assert.True(t, es.DualWriteStatus().Active, "the dual write should go on while the alias is on the old index")
+
+	m.Lock()
+	m.aliasIndex = secondaryIndexName
+	m.Unlock()
+	require.NoError(t, es.RefreshDualWrite(context.Background()))
+
+	status := es.DualWriteStatus()
+	assert.False(t, status.Active)
+	assert.Equal(t, "the alias has been switched to the secondary index", status.StoppedReason)
+}
+
+func TestDualWriteDisabled(t *testing.T) {
+	es := &esService{}
+	WithSecondaryIndex("")(es)
+
+	assert.Nil(t, es.dualWrite)
+	assert.NoError(t, es.RefreshDualWrite(context.Background()))
+	assert.Equal(t, DualWriteStatus{DivergentWrites: divergentWrites.Count(), DivergentDeletes: divergentDeletes.Count(), DivergentBulkRequests: divergentBulkRequests.Count()}, es.StopDualWrite())
+}
+
+func TestCloseStopsDualWriteCheck(t *testing.T) {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithSecondaryIndex(secondaryIndexName)).(*esService)
+
+	closed := make(chan error)
+	go func() { closed <- es.CloseBulkProcessor() }()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the dual write check should be stopped")
+	}
+	assert.True(t, es.DualWriteStatus().Active, "closing the service should not stop the dual write")
+}
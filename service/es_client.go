@@ -1,65 +1,282 @@
 package service
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"time"
 
 	log "github.com/Financial-Times/go-logger"
-	"github.com/smartystreets/go-aws-auth"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"gopkg.in/olivere/elastic.v5"
 )
 
+const (
+	// signingService is the name of the Amazon Elasticsearch Service in the signatures
+	signingService = "es"
+	// credentialsExpiryWindow is how long before they expire the credentials of an assumed role are renewed
+	credentialsExpiryWindow = 5 * time.Minute
+	assumedRoleDuration     = time.Hour
+)
+
 type EsAccessConfig struct {
-	accessKey    string
-	secretKey    string
-	esEndpoint   string
-	traceLogging bool
+	accessKey       string
+	secretKey       string
+	sessionToken    string
+	roleARN         string
+	roleSessionName string
+	esEndpoint      string
+	traceLogging    bool
+
+	username   string
+	password   string
+	apiKey     string
+	caBundle   string
+	clientCert string
+	clientKey  string
+
+	// stsEndpoint replaces the endpoint of STS if set, e.g. with a local STS in tests
+	stsEndpoint string
 }
 
+// NewAccessConfig returns the config of a client signing with the access key, or with the credentials of
+// the AWS credential chain if the access key is empty: environment, shared files, web identity, container or instance role.
 func NewAccessConfig(accessKey string, secretKey string, endpoint string, tracelogging bool) EsAccessConfig {
 	return EsAccessConfig{accessKey: accessKey, secretKey: secretKey, esEndpoint: endpoint, traceLogging: tracelogging}
 }
 
+// WithSessionToken returns a copy of the config, whose access key is temporary and signs with the session token
+func (c EsAccessConfig) WithSessionToken(sessionToken string) EsAccessConfig {
+	c.sessionToken = sessionToken
+	return c
+}
+
+// WithAssumedRole returns a copy of the config signing with the credentials of the role, assumed with the credentials
+// of the config. The credentials of the role are renewed before they expire. The role is not assumed if the ARN is empty.
+func (c EsAccessConfig) WithAssumedRole(roleARN string, sessionName string) EsAccessConfig {
+	c.roleARN = roleARN
+	c.roleSessionName = sessionName
+	return c
+}
+
+// WithBasicAuth returns a copy of the config authenticating with the username and the password, if the username is not empty
+func (c EsAccessConfig) WithBasicAuth(username string, password string) EsAccessConfig {
+	c.username = username
+	c.password = password
+	return c
+}
+
+// WithAPIKey returns a copy of the config authenticating with the API key, encoded as Elasticsearch returns it, if not empty
+func (c EsAccessConfig) WithAPIKey(apiKey string) EsAccessConfig {
+	c.apiKey = apiKey
+	return c
+}
+
+// WithTLS returns a copy of the config trusting the certificates of the PEM CA bundle in addition to the system ones,
+// and presenting the PEM client certificate and key. Empty paths are ignored.
+func (c EsAccessConfig) WithTLS(caBundle string, clientCert string, clientKey string) EsAccessConfig {
+	c.caBundle = caBundle
+	c.clientCert = clientCert
+	c.clientKey = clientKey
+	return c
+}
+
+// validate checks that the authentication methods of the config can be combined
+func (c EsAccessConfig) validate(signed bool) error {
+	if c.username != "" && c.apiKey != "" {
+		return errors.New("basic authentication and API key are exclusive")
+	}
+	if signed && (c.username != "" || c.apiKey != "") {
+		return errors.New("basic authentication and API key can not be used with the amazon signing mechanism")
+	}
+	if (c.clientCert == "") != (c.clientKey == "") {
+		return errors.New("the client certificate and its key must be given together")
+	}
+	return nil
+}
+
+// credentialFiles returns the paths of the files of the config whose changes require a new client
+func (c EsAccessConfig) credentialFiles() []string {
+	var files []string
+	for _, file := range []string{c.caBundle, c.clientCert, c.clientKey} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// newTransport returns the transport of the requests to the cluster, with the CA bundle and the client certificate of the config
+func newTransport(config EsAccessConfig) (http.RoundTripper, error) {
+	if config.caBundle == "" && config.clientCert == "" {
+		return http.DefaultTransport, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.caBundle != "" {
+		pem, err := ioutil.ReadFile(config.caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading the CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in the CA bundle %s", config.caBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.clientCert, config.clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// apiKeyTransport authenticates the requests with an Elasticsearch API key
+type apiKeyTransport struct {
+	apiKey    string
+	transport http.RoundTripper
+}
+
+func (a apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a round tripper must not change the request
+	authenticated := req.Clone(req.Context())
+	authenticated.Header.Set("Authorization", "ApiKey "+a.apiKey)
+	return a.transport.RoundTrip(authenticated)
+}
+
+// AWSSigningTransport signs the requests with AWS Signature Version 4, with the current credentials of the signer
 type AWSSigningTransport struct {
-	HTTPClient  *http.Client
-	Credentials awsauth.Credentials
+	HTTPClient *http.Client
+	Signer     *v4.Signer
+	Region     string
 }
 
-// RoundTrip implementation
+// RoundTrip implementation. The request is signed with the current time and the current credentials, so that retries are signed again.
 func (a AWSSigningTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	return a.HTTPClient.Do(awsauth.Sign4(req, a.Credentials))
+	// a round tripper must not change the request
+	signed := req.Clone(req.Context())
+
+	var body io.ReadSeeker
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	if _, err := a.Signer.Sign(signed, body, signingService, a.Region, time.Now()); err != nil {
+		return nil, err
+	}
+	return a.HTTPClient.Do(signed)
+}
+
+// newCredentials returns the credentials of the config, which are retrieved again once they expire
+func newCredentials(config EsAccessConfig, region string) (*credentials.Credentials, error) {
+	awsConfig := aws.NewConfig().
+		WithRegion(region).
+		WithCredentialsChainVerboseErrors(true)
+	if config.stsEndpoint != "" {
+		awsConfig.EndpointResolver = endpoints.ResolverFunc(func(service, region string, options ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+			if service == sts.EndpointsID {
+				return endpoints.ResolvedEndpoint{URL: config.stsEndpoint, SigningRegion: region}, nil
+			}
+			return endpoints.DefaultResolver().EndpointFor(service, region, options...)
+		})
+	}
+	if config.accessKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(config.accessKey, config.secretKey, config.sessionToken)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{Config: *awsConfig, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+	if config.roleARN == "" {
+		return sess.Config.Credentials, nil
+	}
+
+	return stscreds.NewCredentials(sess, config.roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = config.roleSessionName
+		p.Duration = assumedRoleDuration
+		p.ExpiryWindow = credentialsExpiryWindow
+	}), nil
 }
 
-func newAmazonClient(config EsAccessConfig) (*elastic.Client, error) {
+func newAmazonClient(config EsAccessConfig, region string) (*elastic.Client, error) {
+	creds, err := newCredentials(config, region)
+	if err != nil {
+		return nil, err
+	}
+	// fail early if no credentials are available, rather than on every request
+	value, err := creds.Get()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
 
 	signingTransport := AWSSigningTransport{
-		Credentials: awsauth.Credentials{
-			AccessKeyID:     config.accessKey,
-			SecretAccessKey: config.secretKey,
-		},
-		HTTPClient: http.DefaultClient,
+		Signer:     v4.NewSigner(creds),
+		Region:     region,
+		HTTPClient: &http.Client{Transport: transport},
 	}
-	signingClient := &http.Client{Transport: http.RoundTripper(signingTransport)}
 
-	log.Infof("connecting with AWSSigningTransport to %s", config.esEndpoint)
-	return newClient(config.esEndpoint, config.traceLogging,
-		elastic.SetScheme("https"),
-		elastic.SetHttpClient(signingClient),
-	)
+	log.Infof("connecting with AWSSigningTransport to %s, with credentials from %s", config.esEndpoint, value.ProviderName)
+	return newClient(config, signingTransport, elastic.SetScheme("https"))
 }
 
 func newSimpleClient(config EsAccessConfig) (*elastic.Client, error) {
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
 	log.Infof("connecting with default transport to %s", config.esEndpoint)
-	return newClient(config.esEndpoint, config.traceLogging)
+	return newClient(config, transport)
 }
 
-func newClient(endpoint string, traceLogging bool, options ...elastic.ClientOptionFunc) (*elastic.Client, error) {
+// newClient returns a client of the endpoint of the config sending its requests with the transport, built by newTransport
+// with the certificates of the config, and authenticating with the basic authentication or the API key of the config.
+// The options given are applied last.
+func newClient(config EsAccessConfig, transport http.RoundTripper, options ...elastic.ClientOptionFunc) (*elastic.Client, error) {
+	if config.apiKey != "" {
+		transport = apiKeyTransport{apiKey: config.apiKey, transport: transport}
+	}
+
 	optionFuncs := []elastic.ClientOptionFunc{
-		elastic.SetURL(endpoint),
+		elastic.SetURL(config.esEndpoint),
 		elastic.SetSniff(false), //needs to be disabled due to EAS behavior. Healthcheck still operates as normal.
+		elastic.SetHttpClient(&http.Client{Transport: transport}),
+	}
+	if config.username != "" {
+		optionFuncs = append(optionFuncs, elastic.SetBasicAuth(config.username, config.password))
 	}
 	optionFuncs = append(optionFuncs, options...)
 
-	if traceLogging {
+	if config.traceLogging {
 		optionFuncs = append(optionFuncs, elastic.SetTraceLog(log.Logger()))
 	}
 
@@ -67,9 +284,12 @@ func newClient(endpoint string, traceLogging bool, options ...elastic.ClientOpti
 }
 
 func NewElasticClient(region string, config EsAccessConfig) (*elastic.Client, error) {
+	if err := config.validate(region != "local"); err != nil {
+		return nil, err
+	}
 	if region == "local" {
 		return newSimpleClient(config)
 	} else {
-		return newAmazonClient(config)
+		return newAmazonClient(config, region)
 	}
 }
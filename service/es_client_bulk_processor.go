@@ -1,7 +1,6 @@
 package service
 
 import (
-	"context"
 	"fmt"
 	"time"
 
@@ -20,14 +19,30 @@ func NewBulkProcessorConfig(nrWorkers int, nrOfRequests int, bulkSize int, flush
 	return BulkProcessorConfig{nrWorkers: nrWorkers, nrOfRequests: nrOfRequests, bulkSize: bulkSize, flushInterval: flushInterval}
 }
 
-func newBulkProcessor(client *elastic.Client, bulkConfig *BulkProcessorConfig) (*elastic.BulkProcessor, error) {
-	return client.BulkProcessor().Name("BackgroundWorker-1").
+// With returns a copy of the config with the given settings replaced. Zero values are ignored.
+func (c BulkProcessorConfig) With(nrWorkers int, nrOfRequests int, bulkSize int, flushInterval time.Duration) BulkProcessorConfig {
+	if nrWorkers > 0 {
+		c.nrWorkers = nrWorkers
+	}
+	if nrOfRequests > 0 {
+		c.nrOfRequests = nrOfRequests
+	}
+	if bulkSize > 0 {
+		c.bulkSize = bulkSize
+	}
+	if flushInterval > 0 {
+		c.flushInterval = flushInterval
+	}
+	return c
+}
+
+// bulkProcessorService returns the service creating a bulk processor of the name given, with the settings given
+func bulkProcessorService(client *elastic.Client, name string, bulkConfig *BulkProcessorConfig) *elastic.BulkProcessorService {
+	return client.BulkProcessor().Name(name).
 		Workers(bulkConfig.nrWorkers).
 		BulkActions(bulkConfig.nrOfRequests).
 		BulkSize(bulkConfig.bulkSize).
-		FlushInterval(bulkConfig.flushInterval).
-		After(handleBulkFailures).
-		Do(context.Background())
+		FlushInterval(bulkConfig.flushInterval)
 }
 
 func handleBulkFailures(executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
@@ -0,0 +1,479 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const testRegion = "eu-west-1"
+
+// sigV4Verifier checks the signature of the requests as AWS does, with the credentials it knows by access key
+type sigV4Verifier struct {
+	sync.Mutex
+	service     string
+	credentials map[string]credentials.Value
+	verified    []string
+}
+
+func newSigV4Verifier(service string, known ...credentials.Value) *sigV4Verifier {
+	v := &sigV4Verifier{service: service, credentials: make(map[string]credentials.Value)}
+	for _, c := range known {
+		v.credentials[c.AccessKeyID] = c
+	}
+	return v
+}
+
+func (v *sigV4Verifier) trust(c credentials.Value) {
+	v.Lock()
+	defer v.Unlock()
+	v.credentials[c.AccessKeyID] = c
+}
+
+// verifiedKeys returns the access keys of the requests whose signature is valid
+func (v *sigV4Verifier) verifiedKeys() []string {
+	v.Lock()
+	defer v.Unlock()
+	return append([]string(nil), v.verified...)
+}
+
+// verify signs the request again with the secret key of its access key, and compares the signatures
+func (v *sigV4Verifier) verify(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	scope := strings.SplitN(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 Credential="), ",", 2)[0]
+	parts := strings.Split(scope, "/")
+	if len(parts) != 5 {
+		return fmt.Errorf("invalid authorization %q", auth)
+	}
+	if parts[2] != testRegion || parts[3] != v.service {
+		return fmt.Errorf("signed for %s in %s", parts[3], parts[2])
+	}
+
+	v.Lock()
+	known, found := v.credentials[parts[0]]
+	v.Unlock()
+	if !found {
+		return fmt.Errorf("unknown access key %s", parts[0])
+	}
+	if r.Header.Get("X-Amz-Security-Token") != known.SessionToken {
+		return fmt.Errorf("invalid security token")
+	}
+	signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return err
+	}
+	if time.Since(signedAt) > 5*time.Minute {
+		return fmt.Errorf("signature expired")
+	}
+
+	expected, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return err
+	}
+	signedHeaders := strings.SplitN(strings.SplitN(auth, "SignedHeaders=", 2)[1], ",", 2)[0]
+	for _, h := range strings.Split(signedHeaders, ";") {
+		if h != "host" {
+			expected.Header[http.CanonicalHeaderKey(h)] = r.Header.Values(h)
+		}
+	}
+	signer := v4.NewSigner(credentials.NewStaticCredentialsFromCreds(known))
+	if _, err := signer.Sign(expected, bytes.NewReader(body), v.service, testRegion, signedAt); err != nil {
+		return err
+	}
+	if expected.Header.Get("Authorization") != auth {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	v.Lock()
+	v.verified = append(v.verified, known.AccessKeyID)
+	v.Unlock()
+	return nil
+}
+
+// handler answers the requests whose signature is valid, and refuses the others as AWS does
+func (v *sigV4Verifier) handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := v.verify(r, body); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"message":"%s"}`, err.Error())
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+func esClusterStub(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodPut:
+		fmt.Fprint(w, `{"_index":"concept","_type":"people","_id":"1","_version":1,"result":"created"}`)
+	default:
+		fmt.Fprint(w, `{"cluster_name":"test","status":"green"}`)
+	}
+}
+
+func signingTestClient(t *testing.T, url string, creds *credentials.Credentials) *elastic.Client {
+	transport := AWSSigningTransport{Signer: v4.NewSigner(creds), Region: testRegion, HTTPClient: http.DefaultClient}
+	client, err := newClient(NewAccessConfig("", "", url, false), transport, elastic.SetHealthcheck(false))
+	require.NoError(t, err)
+	return client
+}
+
+// isolateAWSConfig ignores the AWS configuration of the environment
+func isolateAWSConfig(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+func TestAWSSigningTransportWithSessionToken(t *testing.T) {
+	isolateAWSConfig(t)
+	temporary := credentials.Value{AccessKeyID: "ASIATEMPORARY", SecretAccessKey: "temporary-secret", SessionToken: "session-token"}
+	verifier := newSigV4Verifier(signingService, temporary)
+	es := httptest.NewServer(verifier.handler(esClusterStub))
+	defer es.Close()
+
+	creds, err := newCredentials(NewAccessConfig(temporary.AccessKeyID, temporary.SecretAccessKey, es.URL, false).WithSessionToken(temporary.SessionToken), testRegion)
+	require.NoError(t, err)
+	client := signingTestClient(t, es.URL, creds)
+
+	_, err = client.ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+	_, err = client.Index().Index("concept").Type("people").Id("1").BodyJson(map[string]string{"prefLabel": "Jane Doe"}).Do(context.Background())
+	require.NoError(t, err, "a request with a body should be signed with its payload")
+	assert.Equal(t, []string{"ASIATEMPORARY", "ASIATEMPORARY"}, verifier.verifiedKeys())
+
+	creds, err = newCredentials(NewAccessConfig(temporary.AccessKeyID, "wrong-secret", es.URL, false).WithSessionToken(temporary.SessionToken), testRegion)
+	require.NoError(t, err)
+	_, err = signingTestClient(t, es.URL, creds).ClusterHealth().Do(context.Background())
+	assert.True(t, elastic.IsStatusCode(err, http.StatusForbidden), "a request signed with another secret should be refused")
+}
+
+func TestAWSCredentialChainFromEnvironment(t *testing.T) {
+	isolateAWSConfig(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAENVIRONMENT")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "environment-secret")
+
+	creds, err := newCredentials(NewAccessConfig("", "", "", false), testRegion)
+	require.NoError(t, err)
+	value, err := creds.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "AKIAENVIRONMENT", value.AccessKeyID)
+	assert.Equal(t, session.EnvProviderName, value.ProviderName)
+}
+
+// rotatingProvider returns new credentials once the current ones have expired
+type rotatingProvider struct {
+	sync.Mutex
+	generation int
+	expired    bool
+}
+
+func (p *rotatingProvider) Retrieve() (credentials.Value, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.generation++
+	p.expired = false
+	return rotatedCredentials(p.generation), nil
+}
+
+func (p *rotatingProvider) IsExpired() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.expired
+}
+
+func (p *rotatingProvider) expire() {
+	p.Lock()
+	defer p.Unlock()
+	p.expired = true
+}
+
+func rotatedCredentials(generation int) credentials.Value {
+	return credentials.Value{AccessKeyID: fmt.Sprintf("ASIAROTATED%d", generation), SecretAccessKey: fmt.Sprintf("secret-%d", generation), SessionToken: fmt.Sprintf("token-%d", generation)}
+}
+
+func TestAWSSigningTransportRenewsExpiredCredentials(t *testing.T) {
+	verifier := newSigV4Verifier(signingService, rotatedCredentials(1), rotatedCredentials(2))
+	es := httptest.NewServer(verifier.handler(esClusterStub))
+	defer es.Close()
+
+	provider := &rotatingProvider{}
+	client := signingTestClient(t, es.URL, credentials.NewCredentials(provider))
+
+	_, err := client.ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+	_, err = client.ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+	provider.expire()
+	_, err = client.ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"ASIAROTATED1", "ASIAROTATED1", "ASIAROTATED2"}, verifier.verifiedKeys())
+}
+
+// stsStub assumes roles, whose credentials are trusted by the verifier of Elasticsearch and expire after the validity given
+type stsStub struct {
+	sync.Mutex
+	trusted  *sigV4Verifier
+	validity time.Duration
+	assumed  []string
+}
+
+func (s *stsStub) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	action := r.Form.Get("Action")
+	s.assumed = append(s.assumed, fmt.Sprintf("%s %s %s %s", action, r.Form.Get("RoleArn"), r.Form.Get("RoleSessionName"), r.Form.Get("DurationSeconds")))
+	role := credentials.Value{
+		AccessKeyID:     fmt.Sprintf("ASIAROLE%d", len(s.assumed)),
+		SecretAccessKey: fmt.Sprintf("role-secret-%d", len(s.assumed)),
+		SessionToken:    fmt.Sprintf("role-token-%d", len(s.assumed)),
+	}
+	s.trusted.trust(role)
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>%[2]s</AccessKeyId>
+      <SecretAccessKey>%[3]s</SecretAccessKey>
+      <SessionToken>%[4]s</SessionToken>
+      <Expiration>%[5]s</Expiration>
+    </Credentials>
+    <AssumedRoleUser><Arn>%[6]s</Arn><AssumedRoleId>AROA:%[7]d</AssumedRoleId></AssumedRoleUser>
+  </%[1]sResult>
+  <ResponseMetadata><RequestId>%[7]d</RequestId></ResponseMetadata>
+</%[1]sResponse>`, action, role.AccessKeyID, role.SecretAccessKey, role.SessionToken,
+		time.Now().Add(s.validity).UTC().Format(time.RFC3339), r.Form.Get("RoleArn"), len(s.assumed))
+}
+
+func (s *stsStub) assumedRoles() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.assumed...)
+}
+
+func TestAWSSigningTransportWithAssumedRole(t *testing.T) {
+	isolateAWSConfig(t)
+	verifier := newSigV4Verifier(signingService)
+	es := httptest.NewServer(verifier.handler(esClusterStub))
+	defer es.Close()
+
+	base := credentials.Value{AccessKeyID: "AKIABASE", SecretAccessKey: "base-secret"}
+	stsVerifier := newSigV4Verifier("sts", base)
+	// the credentials of the role expire within the expiry window, so they are renewed for every request
+	stub := &stsStub{trusted: verifier, validity: credentialsExpiryWindow - time.Minute}
+	sts := httptest.NewServer(stsVerifier.handler(stub.serve))
+	defer sts.Close()
+
+	config := NewAccessConfig(base.AccessKeyID, base.SecretAccessKey, es.URL, false).
+		WithAssumedRole("arn:aws:iam::123456789012:role/concept-rw", "concept-rw-test")
+	config.stsEndpoint = sts.URL
+	creds, err := newCredentials(config, testRegion)
+	require.NoError(t, err)
+	client := signingTestClient(t, es.URL, creds)
+
+	_, err = client.ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+	_, err = client.ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"AKIABASE", "AKIABASE"}, stsVerifier.verifiedKeys(), "the role should be assumed with the base credentials")
+	assert.Equal(t, []string{
+		"AssumeRole arn:aws:iam::123456789012:role/concept-rw concept-rw-test 3600",
+		"AssumeRole arn:aws:iam::123456789012:role/concept-rw concept-rw-test 3600",
+	}, stub.assumedRoles())
+	assert.Equal(t, []string{"ASIAROLE1", "ASIAROLE2"}, verifier.verifiedKeys(), "the role should be assumed again before its credentials expire")
+}
+
+func TestAWSCredentialChainWithWebIdentity(t *testing.T) {
+	isolateAWSConfig(t)
+	verifier := newSigV4Verifier(signingService)
+	es := httptest.NewServer(verifier.handler(esClusterStub))
+	defer es.Close()
+
+	stub := &stsStub{trusted: verifier, validity: time.Hour}
+	sts := httptest.NewServer(http.HandlerFunc(stub.serve))
+	defer sts.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("web-identity-token"), 0600))
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/concept-rw-sa")
+
+	config := NewAccessConfig("", "", es.URL, false)
+	config.stsEndpoint = sts.URL
+	creds, err := newCredentials(config, testRegion)
+	require.NoError(t, err)
+
+	_, err = signingTestClient(t, es.URL, creds).ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stub.assumedRoles(), 1)
+	assert.True(t, strings.HasPrefix(stub.assumedRoles()[0], "AssumeRoleWithWebIdentity arn:aws:iam::123456789012:role/concept-rw-sa"))
+	assert.Equal(t, []string{"ASIAROLE1"}, verifier.verifiedKeys())
+}
+
+func TestElasticClientWithBasicAuth(t *testing.T) {
+	var authenticated []bool
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		authenticated = append(authenticated, ok && username == "elastic" && password == "changeme")
+		esClusterStub(w, r)
+	}))
+	defer es.Close()
+
+	client, err := NewElasticClient("local", NewAccessConfig("", "", es.URL, false).WithBasicAuth("elastic", "changeme"))
+	require.NoError(t, err)
+	_, err = client.ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+
+	require.NotEmpty(t, authenticated)
+	for _, ok := range authenticated {
+		assert.True(t, ok, "every request should be authenticated")
+	}
+}
+
+func TestElasticClientWithAPIKey(t *testing.T) {
+	var authorizations []string
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		esClusterStub(w, r)
+	}))
+	defer es.Close()
+
+	client, err := NewElasticClient("local", NewAccessConfig("", "", es.URL, false).WithAPIKey("VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw=="))
+	require.NoError(t, err)
+	_, err = client.ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+
+	require.NotEmpty(t, authorizations)
+	for _, authorization := range authorizations {
+		assert.Equal(t, "ApiKey VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw==", authorization)
+	}
+}
+
+// writeClientCertificate writes a self-signed client certificate and its key in PEM files, and returns their paths with the certificate
+func writeClientCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "concept-rw-elasticsearch"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile, cert
+}
+
+func TestElasticClientWithCABundleAndClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCertificate(t, dir)
+
+	es := httptest.NewUnstartedServer(http.HandlerFunc(esClusterStub))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	es.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	es.StartTLS()
+	defer es.Close()
+
+	caBundle := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: es.Certificate().Raw}), 0600))
+
+	client, err := NewElasticClient("local", NewAccessConfig("", "", es.URL, false).WithTLS(caBundle, certFile, keyFile))
+	require.NoError(t, err)
+	health, err := client.ClusterHealth().Do(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "green", health.Status)
+
+	for _, tc := range []struct {
+		config EsAccessConfig
+		msg    string
+	}{
+		{NewAccessConfig("", "", es.URL, false).WithTLS(caBundle, "", ""), "the cluster should refuse the client without certificate"},
+		{NewAccessConfig("", "", es.URL, false).WithTLS("", certFile, keyFile), "the client should not trust the cluster without the CA bundle"},
+	} {
+		transport, err := newTransport(tc.config)
+		require.NoError(t, err)
+		_, err = newClient(tc.config, transport, elastic.SetHealthcheckTimeoutStartup(100*time.Millisecond))
+		assert.Error(t, err, tc.msg)
+	}
+}
+
+func TestElasticClientConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeClientCertificate(t, dir)
+	notPEM := filepath.Join(dir, "ca.txt")
+	require.NoError(t, ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600))
+
+	config := NewAccessConfig("", "", "http://localhost:9200", false)
+	var tests = []struct {
+		name   string
+		region string
+		config EsAccessConfig
+	}{
+		{"Basic authentication and API key", "local", config.WithBasicAuth("elastic", "changeme").WithAPIKey("key")},
+		{"Basic authentication with signing", testRegion, config.WithBasicAuth("elastic", "changeme")},
+		{"API key with signing", testRegion, config.WithAPIKey("key")},
+		{"Client certificate without key", "local", config.WithTLS("", certFile, "")},
+		{"Client key without certificate", "local", config.WithTLS("", "", keyFile)},
+		{"Missing CA bundle", "local", config.WithTLS(filepath.Join(dir, "missing.pem"), "", "")},
+		{"CA bundle without certificate", "local", config.WithTLS(notPEM, "", "")},
+		{"Invalid client certificate", "local", config.WithTLS("", keyFile, keyFile)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewElasticClient(test.region, test.config)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const clusterBlockException = "cluster_block_exception"
+
+// IsWriteBlockError tells whether a write failed because the index is blocked for writes,
+// e.g. by index.blocks.read_only_allow_delete when the disk of a node is full.
+func IsWriteBlockError(err error) bool {
+	var blockedErr *WriteBlockedError
+	if errors.As(err, &blockedErr) {
+		return true
+	}
+
+	var esErr *elastic.Error
+	if !errors.As(err, &esErr) {
+		return false
+	}
+	return esErr.Status == http.StatusForbidden && esErr.Details != nil && esErr.Details.Type == clusterBlockException
+}
+
+// IsStaleVersion tells whether a write failed because the document has been changed since it was read
+func IsStaleVersion(err error) bool {
+	var esErr *elastic.Error
+	return errors.As(err, &esErr) && esErr.Status == http.StatusConflict
+}
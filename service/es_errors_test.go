@@ -0,0 +1,27 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+func TestIsWriteBlockError(t *testing.T) {
+	readOnly := &elastic.Error{Status: http.StatusForbidden, Details: &elastic.ErrorDetails{Type: "cluster_block_exception", Reason: "blocked by: [FORBIDDEN/12/index read-only / allow delete (api)];"}}
+
+	assert.True(t, IsWriteBlockError(readOnly))
+	assert.True(t, IsWriteBlockError(fmt.Errorf("write failed: %w", readOnly)))
+	assert.True(t, IsWriteBlockError(&WriteBlockedError{RetryAfter: time.Second}))
+	assert.False(t, IsWriteBlockError(&elastic.Error{Status: http.StatusForbidden}))
+	assert.False(t, IsWriteBlockError(ErrNoElasticClient))
+}
+
+func TestIsStaleVersion(t *testing.T) {
+	assert.True(t, IsStaleVersion(&elastic.Error{Status: http.StatusConflict, Details: &elastic.ErrorDetails{Type: "version_conflict_engine_exception"}}))
+	assert.False(t, IsStaleVersion(&elastic.Error{Status: http.StatusInternalServerError}))
+	assert.False(t, IsStaleVersion(ErrNoElasticClient))
+}
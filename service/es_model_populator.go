@@ -15,52 +15,14 @@ const (
 	directTypePublicCompany = "PublicCompany"
 )
 
+// ConvertConceptToESConceptModel converts a concept in the original model with the converter registered for its type
 func ConvertConceptToESConceptModel(concept ConceptModel, conceptType string, publishRef string) EsModel {
-	esModel := newESConceptModel(concept.UUID, conceptType, concept.DirectType, concept.Aliases, concept.GetAuthorities(), concept.PrefLabel, publishRef, concept.IsDeprecated, concept.ScopeNote)
-
-	switch conceptType {
-	case person: // person type should not come through as the old model.
-		esPersonModel := &EsPersonConceptModel{
-			EsConceptModel: esModel,
-		}
-		return esPersonModel
-	default:
-		return esModel
-	}
+	return ConverterFor(conceptType, concept.DirectType).Convert(concept, conceptType, publishRef)
 }
 
-func ConvertAggregateConceptToESConceptModel(concept AggregateConceptModel, conceptType string, publishRef string) (esModel EsModel) {
-
-	switch conceptType {
-	case memberships:
-		ms := make([]string, len(concept.MembershipRoles))
-		for i, m := range concept.MembershipRoles {
-			ms[i] = m.RoleUUID
-		}
-		esModel = &EsMembershipModel{
-			Id:             concept.PrefUUID,
-			PersonId:       concept.PersonUUID,
-			OrganisationId: concept.OrganisationUUID,
-			Memberships:    ms,
-		}
-	case person:
-		esModel = &EsPersonConceptModel{
-			EsConceptModel: getEsConcept(concept, conceptType, publishRef),
-			IsFTAuthor:     defaultIsFTAuthor, // default as controlled by memberships concept
-		}
-	case organisation:
-		esConceptModel := getEsConcept(concept, conceptType, publishRef)
-
-		if concept.DirectType == directTypePublicCompany {
-			esConceptModel.CountryCode = concept.CountryCode
-			esConceptModel.CountryOfIncorporation = concept.CountryOfIncorporation
-		}
-		esModel = esConceptModel
-	default:
-		esModel = getEsConcept(concept, conceptType, publishRef)
-	}
-
-	return esModel
+// ConvertAggregateConceptToESConceptModel converts an aggregate concept with the converter registered for its type
+func ConvertAggregateConceptToESConceptModel(concept AggregateConceptModel, conceptType string, publishRef string) EsModel {
+	return ConverterFor(conceptType, concept.DirectType).ConvertAggregate(concept, conceptType, publishRef)
 }
 
 func getEsConcept(concept AggregateConceptModel, conceptType string, publishRef string) *EsConceptModel {
@@ -4,15 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/rcrowley/go-metrics"
 	"github.com/sirupsen/logrus"
 
-	"io"
-
+	"github.com/Financial-Times/concept-rw-elasticsearch/events"
 	log "github.com/Financial-Times/go-logger"
 	tid "github.com/Financial-Times/transactionid-utils-go"
 	"gopkg.in/olivere/elastic.v5"
@@ -41,30 +42,95 @@ const (
 type esService struct {
 	sync.RWMutex
 	elasticClient       *elastic.Client
-	bulkProcessor       *elastic.BulkProcessor
+	storage             Storage
+	bulkProcessor       bulkQueue
 	indexName           string
 	bulkProcessorConfig *BulkProcessorConfig
 	getCurrentTime      func() time.Time
+	changePublisher     events.Publisher
+	writeBlock          *writeBlock
+	dualWrite           *dualWrite
+	reindexer           reindexer
+	mapping             mappingCheck
+	reconciler          reconciler
+	backend             Backend
+	supervisor          *ConnectionSupervisor
+	retryPolicy         RetryPolicy
+	breaker             *circuitBreaker
+	pendingBulk         pendingCounter
+	bulkQueueLimit      int
+	lanes               map[Workload]*bulkLane
+	stopWatchers        context.CancelFunc
+	watchers            sync.WaitGroup
 }
 
+// EsServiceOption configures optional behaviour of the EsService
+type EsServiceOption func(*esService)
+
 type EsService interface {
 	LoadData(ctx context.Context, conceptType string, uuid string, payload EsModel) (bool, *elastic.IndexResponse, error)
-	ReadData(conceptType string, uuid string) (*elastic.GetResult, error)
+	PlanLoadData(ctx context.Context, conceptType string, uuid string, payload EsModel) (*WritePlan, error)
+	DiffData(ctx context.Context, conceptType string, uuid string, payload EsModel) (*DocumentDiff, error)
+	ReadData(ctx context.Context, conceptType string, uuid string) (*elastic.GetResult, error)
 	DeleteData(ctx context.Context, conceptType string, uuid string) (*elastic.DeleteResponse, error)
-	LoadBulkData(conceptType string, uuid string, payload interface{})
+	LoadBulkData(ctx context.Context, conceptType string, uuid string, payload interface{}) error
 	CleanupData(ctx context.Context, concept Concept)
+	PlanCleanupData(ctx context.Context, concept Concept) ([]PlannedDelete, error)
 	PatchUpdateConcept(ctx context.Context, conceptType string, uuid string, payload PayloadPatch)
 	CloseBulkProcessor() error
-	GetClusterHealth() (*elastic.ClusterHealthResponse, error)
-	IsIndexReadOnly() (bool, string, error)
+	SetBulkProcessorConfig(config *BulkProcessorConfig) error
+	SetBulkLaneConfig(workload Workload, config *BulkProcessorConfig) error
+	GetClusterHealth(ctx context.Context) (*elastic.ClusterHealthResponse, error)
+	IsIndexReadOnly(ctx context.Context) (bool, string, error)
 	GetAllIds(ctx context.Context) chan EsIDTypePair
+	DualWriteStatus() DualWriteStatus
+	StopDualWrite() DualWriteStatus
+	StartReindex(target string) (ReindexStatus, error)
+	ReindexStatus() (ReindexStatus, error)
+	CancelReindex() (ReindexStatus, error)
+	MappingStatus() (MappingStatus, error)
+	ConnectionStatus() (ConnectionStatus, error)
+	CircuitBreakerStatus() CircuitBreakerStatus
+	CheckMapping(ctx context.Context, index string) (MappingStatus, error)
+	ApplyMapping(ctx context.Context, index string) (MappingStatus, error)
+	StartReconciliation(reference ReferenceSource, deleteExtras bool) (ReconciliationReport, error)
+	ReconciliationReport() (ReconciliationReport, error)
+	CancelReconciliation() (ReconciliationReport, error)
 }
 
-func NewEsService(ch chan *elastic.Client, indexName string, bulkProcessorConfig *BulkProcessorConfig) EsService {
-	es := &esService{bulkProcessorConfig: bulkProcessorConfig, indexName: indexName, getCurrentTime: time.Now}
+func NewEsService(ch chan *elastic.Client, indexName string, bulkProcessorConfig *BulkProcessorConfig, options ...EsServiceOption) EsService {
+	es := &esService{bulkProcessorConfig: bulkProcessorConfig, indexName: indexName, getCurrentTime: time.Now, writeBlock: newWriteBlock(), retryPolicy: DefaultRetryPolicy}
+	es.pendingBulk.gauge = bulkQueued
+	for _, option := range options {
+		option(es)
+	}
+	watchCtx, stopWatchers := context.WithCancel(context.Background())
+	es.stopWatchers = stopWatchers
+	if es.writeBlock != nil {
+		es.watchers.Add(1)
+		go func() {
+			defer es.watchers.Done()
+			es.watchWriteBlock(watchCtx)
+		}()
+	}
+	if es.dualWrite != nil {
+		es.watchers.Add(1)
+		go func() {
+			defer es.watchers.Done()
+			es.watchDualWrite(watchCtx, aliasSwitchCheckInterval)
+		}()
+	}
+	if es.storage != nil {
+		es.Lock()
+		if err := es.resetBulkProcessors(); err != nil {
+			log.Errorf("Creating bulk processor failed with error=[%v]", err)
+		}
+		es.Unlock()
+	}
 	go func() {
 		for ec := range ch {
 			es.setElasticClient(ec)
+			es.refreshMappingStatus(context.Background())
 		}
 	}()
 	return es
@@ -76,42 +142,118 @@ func (es *esService) setElasticClient(ec *elastic.Client) {
 
 	es.elasticClient = ec
 
+	if err := es.resetBulkProcessors(); err != nil {
+		log.Errorf("Creating bulk processor failed with error=[%v]", err)
+	}
+}
+
+// ConnectionStatus returns the status of the connection to the cluster, as reported by the supervisor if any
+func (es *esService) ConnectionStatus() (ConnectionStatus, error) {
+	if es.storage != nil {
+		return ConnectionStatus{}, ErrStorageUnsupported
+	}
+	if es.supervisor != nil {
+		return es.supervisor.Status(), nil
+	}
+
+	es.RLock()
+	defer es.RUnlock()
+	if es.elasticClient == nil {
+		return ConnectionStatus{State: ConnectionConnecting}, nil
+	}
+	return ConnectionStatus{State: ConnectionConnected}, nil
+}
+
+// CircuitBreakerStatus returns the state of the circuit breaker of the reads, writes and deletes
+func (es *esService) CircuitBreakerStatus() CircuitBreakerStatus {
+	return es.breaker.status()
+}
+
+// callStorage calls the storage through the circuit breaker, retrying the transient failures with the retry policy.
+// The retries of a call count as a single call for the circuit breaker.
+func (es *esService) callStorage(ctx context.Context, retries metrics.Counter, operation func() error) error {
+	return es.breaker.guard(func() error {
+		return es.retryPolicy.do(ctx, retries, operation)
+	})
+}
+
+// SetBulkProcessorConfig replaces the bulk processor with one using the new settings.
+// The requests queued in the current bulk processor are flushed first.
+func (es *esService) SetBulkProcessorConfig(config *BulkProcessorConfig) error {
+	es.Lock()
+	defer es.Unlock()
+
+	if es.bulkProcessorConfig != nil && *es.bulkProcessorConfig == *config {
+		return nil
+	}
+	// keep a copy, so that the caller cannot change the settings without a rebuild
+	bulkProcessorConfig := *config
+	es.bulkProcessorConfig = &bulkProcessorConfig
+
+	if es.checkStorage() != nil {
+		// the bulk processor is created once the client is available
+		return nil
+	}
+	return es.resetBulkProcessor()
+}
+
+// resetBulkProcessors recreates the default bulk processor and the ones of the lanes with the current client.
+// It must be called with the write lock held.
+func (es *esService) resetBulkProcessors() error {
+	if err := es.resetBulkProcessor(); err != nil {
+		return err
+	}
+	for _, lane := range es.dedicatedLanes() {
+		if err := es.resetLane(lane); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetBulkProcessor closes the current default bulk processor and creates a new one with the current client and settings.
+// It must be called with the write lock held.
+func (es *esService) resetBulkProcessor() error {
 	if es.bulkProcessor != nil {
-		err := es.CloseBulkProcessor()
+		err := es.bulkProcessor.Close()
 		if err != nil {
 			log.Errorf("Error closing bulk processor: %v", err)
 		}
 	}
 
-	if es.bulkProcessorConfig != nil {
-		bulkProcessor, err := newBulkProcessor(ec, es.bulkProcessorConfig)
-		if err != nil {
-			log.Errorf("Creating bulk processor failed with error=[%v]", err)
-		}
-		es.bulkProcessor = bulkProcessor
+	if es.bulkProcessorConfig == nil {
+		return nil
 	}
+
+	bulkProcessor, err := es.newBulkQueue(defaultBulkProcessorName, es.bulkProcessorConfig, es.afterBulk)
+	if err != nil {
+		es.bulkProcessor = nil
+		return err
+	}
+	es.bulkProcessor = bulkProcessor
+	return nil
 }
 
-func (es *esService) GetClusterHealth() (*elastic.ClusterHealthResponse, error) {
+func (es *esService) GetClusterHealth(ctx context.Context) (*elastic.ClusterHealthResponse, error) {
 	es.RLock()
 	defer es.RUnlock()
 
-	if err := es.checkElasticClient(); err != nil {
+	if err := es.checkStorage(); err != nil {
 		return nil, err
 	}
 
-	return es.elasticClient.ClusterHealth().Do(context.Background())
+	return es.documents().ClusterHealth(ctx)
 }
 
-func (es *esService) IsIndexReadOnly() (bool, string, error) {
+func (es *esService) IsIndexReadOnly(ctx context.Context) (bool, string, error) {
 	es.RLock()
 	defer es.RUnlock()
 
-	if err := es.checkElasticClient(); err != nil {
+	if err := es.checkStorage(); err != nil {
 		return false, "", err
 	}
 
-	resp, err := es.elasticClient.IndexGetSettings(es.indexName).Do(context.Background())
+	resp, err := es.documents().IndexSettings(ctx, es.indexName)
 	if err != nil {
 		return false, "", err
 	}
@@ -126,11 +268,33 @@ func (es *esService) IsIndexReadOnly() (bool, string, error) {
 	return false, "", errors.New("no index settings found")
 }
 
+// indexBlocks are the settings of the blocks preventing the writes to an index
+var indexBlocks = []string{"write", "read_only", "read_only_allow_delete"}
+
 func (es *esService) isIndexReadOnly(settings map[string]interface{}) (bool, error) {
-	indexSettings := settings["index"].(map[string]interface{})
-	if block, hasBlockSetting := indexSettings["blocks"]; hasBlockSetting {
-		if writeBlocked, hasWriteBlockSetting := block.(map[string]interface{})["write"]; hasWriteBlockSetting {
-			readOnly, err := strconv.ParseBool(writeBlocked.(string))
+	indexSettings, ok := settings["index"].(map[string]interface{})
+	if !ok {
+		return false, errors.New("no index settings found")
+	}
+	block, hasBlockSetting := indexSettings["blocks"]
+	if !hasBlockSetting {
+		return false, nil
+	}
+	blocks, ok := block.(map[string]interface{})
+	if !ok {
+		return false, fmt.Errorf("unexpected index blocks setting %v", block)
+	}
+	for _, name := range indexBlocks {
+		blocked, hasBlock := blocks[name]
+		if !hasBlock {
+			continue
+		}
+		value, ok := blocked.(string)
+		if !ok {
+			return false, fmt.Errorf("unexpected %s block setting %v", name, blocked)
+		}
+		readOnly, err := strconv.ParseBool(value)
+		if err != nil || readOnly {
 			return readOnly, err
 		}
 	}
@@ -150,67 +314,80 @@ func isFtAuthor(memberships []string) bool {
 func (es *esService) LoadData(ctx context.Context, conceptType string, uuid string, payload EsModel) (
 	updated bool, resp *elastic.IndexResponse, err error) {
 
-	loadDataLog := log.WithField(conceptTypeField, conceptType).
-		WithField(uuidField, uuid).
-		WithField(operationField, writeOperation)
-
-	transactionID, err := tid.GetTransactionIDFromContext(ctx)
-	if err != nil {
-		transactionID = tidNotFound
-		err = nil
-	}
-	loadDataLog = loadDataLog.WithField(tid.TransactionIDKey, transactionID)
+	loadDataLog := newWriteLog(ctx, conceptType, uuid)
 
 	es.RLock()
 	defer es.RUnlock()
 
-	if err = es.checkElasticClient(); err != nil {
+	if err = es.checkStorage(); err != nil {
 		loadDataLog.WithError(err).WithField(statusField, unknownStatus).Error("Failed operation to Elasticsearch")
 		return updated, resp, err
 	}
 
-	var readResult *elastic.GetResult
-	// Check if membership is FT
-	if conceptType == memberships {
-		emm := payload.(*EsMembershipModel)
-		if emm.OrganisationId != ftOrgUUID || len(emm.Memberships) < 1 || !isFtAuthor(emm.Memberships) { // drop as not FT Author
-			return updated, resp, err
-		}
-		readResult, err = es.ReadData(person, emm.PersonId)
-		uuid = emm.PersonId // membership is for person
-	} else {
-		readResult, err = es.ReadData(conceptType, uuid)
+	if err = es.checkWriteBlock(); err != nil {
+		loadDataLog.WithError(err).Warn("Write refused")
+		return updated, resp, err
 	}
 
-	patchData := getPatchData(err, loadDataLog, conceptType, readResult)
+	plan, err := es.planLoadData(ctx, loadDataLog, conceptType, uuid, payload)
+	if err != nil {
+		return updated, resp, err
+	}
 
-	if readResult != nil && !readResult.Found && conceptType == memberships {
-		//we write a dummy person
-		p := EsPersonConceptModel{
-			EsConceptModel: &EsConceptModel{
-				Id:           uuid,
-				LastModified: es.getCurrentTime().Format(time.RFC3339),
-			},
-			IsFTAuthor: "true",
-		}
-		logDebugPersonData(loadDataLog, &p, "Writing a dummy person")
-		return es.writeToEs(ctx, loadDataLog, person, uuid, p)
+	if plan.Unchanged {
+		unchangedWrites.Inc(1)
+		return false, &elastic.IndexResponse{Index: es.indexName, Type: conceptType, Id: uuid, Result: UnchangedResult}, nil
 	}
 
-	if conceptType != memberships {
-		updated, resp, err = es.writeToEs(ctx, loadDataLog, conceptType, uuid, payload)
+	return es.applyWritePlan(ctx, loadDataLog, plan)
+}
+
+func newWriteLog(ctx context.Context, conceptType string, uuid string) *logrus.Entry {
+	transactionID, err := tid.GetTransactionIDFromContext(ctx)
+	if err != nil {
+		transactionID = tidNotFound
 	}
 
-	//check if patchData is empty
-	if patchData != nil {
-		if conceptType == memberships {
-			// `patchData` is for a person
-			logDebugPatchData(loadDataLog, patchData, "patch for person ")
-			es.PatchUpdateConcept(ctx, person, uuid, patchData)
+	return log.WithField(conceptTypeField, conceptType).
+		WithField(uuidField, uuid).
+		WithField(operationField, writeOperation).
+		WithField(tid.TransactionIDKey, transactionID)
+}
+
+func (es *esService) applyWritePlan(ctx context.Context, loadDataLog *logrus.Entry, plan *WritePlan) (updated bool, resp *elastic.IndexResponse, err error) {
+	if plan.Index != nil {
+		updated, resp, err = es.writeToEs(ctx, loadDataLog, plan.Index.ConceptType, plan.Index.UUID, plan.Index.Document)
+		if updated {
+			var patches []interface{}
+			for _, p := range plan.Patches {
+				patches = append(patches, p.Patch)
+			}
+			es.publishChange(ctx, events.ChangeEvent{
+				Type:        writeEventType(plan.current),
+				UUID:        plan.Index.UUID,
+				ConceptType: plan.Index.ConceptType,
+				Changes:     diffKeyFields(storedSource(plan.current), plan.Index.Document, patches...),
+			})
+		}
+	}
+
+	for _, p := range plan.Patches {
+		if plan.Index == nil {
+			// a membership patches the person it belongs to
+			logDebugPatchData(loadDataLog, p.Patch, "patch for person ")
 		} else {
-			logDebugPatchData(loadDataLog, patchData, "patch for concept ")
-			es.PatchUpdateConcept(ctx, conceptType, uuid, patchData)
+			logDebugPatchData(loadDataLog, p.Patch, "patch for concept ")
 		}
+		var event *events.ChangeEvent
+		if plan.Index == nil {
+			event = &events.ChangeEvent{
+				Type:        events.Updated,
+				UUID:        p.UUID,
+				ConceptType: p.ConceptType,
+				Changes:     diffKeyFields(storedSource(plan.current), storedSource(plan.current), p.Patch),
+			}
+		}
+		es.addPatch(ctx, SyncFollowUps, p.ConceptType, p.UUID, p.Patch, event)
 		updated = true
 	}
 	return updated, resp, err
@@ -218,12 +395,11 @@ func (es *esService) LoadData(ctx context.Context, conceptType string, uuid stri
 
 func (es *esService) writeToEs(ctx context.Context, loadDataLog *logrus.Entry, conceptType string, uuid string, payload EsModel) (updated bool, resp *elastic.IndexResponse, err error) {
 	loadDataLog.Debugf("Writing: %s", uuid)
-	resp, err = es.elasticClient.Index().
-		Index(es.indexName).
-		Type(conceptType).
-		Id(uuid).
-		BodyJson(payload).
-		Do(ctx)
+	err = es.callStorage(ctx, writeRetries, func() error {
+		resp, err = es.documents().Index(ctx, es.indexName, conceptType, uuid, payload)
+		return err
+	})
+	es.writeToSecondary(ctx, loadDataLog, conceptType, uuid, payload, err)
 
 	if err != nil {
 		status := unknownStatus
@@ -232,7 +408,7 @@ func (es *esService) writeToEs(ctx context.Context, loadDataLog *logrus.Entry, c
 			status = strconv.Itoa(esErr.Status)
 		}
 		loadDataLog.WithError(err).WithField(statusField, status).Error("Failed operation to Elasticsearch")
-		return false, resp, err
+		return false, resp, es.writeBlockError(err)
 	}
 	return true, resp, nil
 }
@@ -241,39 +417,37 @@ func getPatchData(err error, loadDataLog *logrus.Entry, conceptType string, read
 	if err != nil {
 		loadDataLog.WithError(err).Error("Failed operation to Elasticsearch, could not retrieve current values before write")
 		return patchData
-	} else {
-		//we need to write the annotation count separately as it is sourced from neo.
-		//there is a race condition between the dataload and the patchData patch this will be solved by querying for the latest patchData
-		//from neo before writing the patchData back
-		switch conceptType {
-		case person, memberships:
-			esConcept := new(EsPersonConceptModel)
-			if readResult.Found {
-				if err := json.Unmarshal(*readResult.Source, esConcept); err != nil {
-					loadDataLog.WithError(err).Error("Failed to read patchData from Elasticsearch")
-					return patchData
-				} else {
-					if conceptType == memberships {
-						return &EsPersonConceptPatch{Metrics: esConcept.Metrics, IsFTAuthor: "true"} // we only process FT members who are FT authors
-					}
-					return &EsPersonConceptPatch{Metrics: esConcept.Metrics, IsFTAuthor: esConcept.IsFTAuthor}
-				}
-			}
-		default:
-			esConcept := new(EsConceptModel)
-			if readResult.Found {
-				if err := json.Unmarshal(*readResult.Source, esConcept); err != nil {
-					loadDataLog.WithError(err).Error("Failed to read patchData from Elasticsearch")
-					return patchData
-				}
-				return &EsConceptModelPatch{Metrics: esConcept.Metrics}
-			}
-		}
+	}
+
+	//we need to write the annotation count separately as it is sourced from neo.
+	//there is a race condition between the dataload and the patchData patch this will be solved by querying for the latest patchData
+	//from neo before writing the patchData back
+	if !readResult.Found || readResult.Source == nil {
+		return patchData
+	}
+
+	patchData, err = ConverterFor(conceptType, storedDirectType(readResult)).PatchData(*readResult.Source)
+	if err != nil {
+		loadDataLog.WithError(err).Error("Failed to read patchData from Elasticsearch")
+		return nil
 	}
 	return patchData
 }
 
+// checkStorage returns an error if the concepts cannot be read or written, because no client is available yet
+func (es *esService) checkStorage() error {
+	if es.storage == nil && es.elasticClient == nil {
+		return ErrNoElasticClient
+	}
+
+	return nil
+}
+
+// checkElasticClient returns an error if the operations managing the Elasticsearch cluster are not available
 func (es *esService) checkElasticClient() error {
+	if es.storage != nil {
+		return ErrStorageUnsupported
+	}
 	if es.elasticClient == nil {
 		return ErrNoElasticClient
 	}
@@ -281,19 +455,24 @@ func (es *esService) checkElasticClient() error {
 	return nil
 }
 
-func (es *esService) ReadData(conceptType string, uuid string) (*elastic.GetResult, error) {
+func (es *esService) ReadData(ctx context.Context, conceptType string, uuid string) (*elastic.GetResult, error) {
 	es.RLock()
 	defer es.RUnlock()
 
-	if err := es.checkElasticClient(); err != nil {
+	return es.readData(ctx, conceptType, uuid)
+}
+
+// readData must be called with the lock held
+func (es *esService) readData(ctx context.Context, conceptType string, uuid string) (*elastic.GetResult, error) {
+	if err := es.checkStorage(); err != nil {
 		return nil, err
 	}
 
-	resp, err := es.elasticClient.Get().
-		Index(es.indexName).
-		Type(conceptType).
-		Id(uuid).
-		Do(context.Background())
+	var resp *elastic.GetResult
+	err := es.callStorage(ctx, readRetries, func() (err error) {
+		resp, err = es.documents().Get(ctx, es.indexName, conceptType, uuid)
+		return err
+	})
 
 	if elastic.IsNotFound(err) {
 		return &elastic.GetResult{Found: false}, nil
@@ -310,45 +489,56 @@ func (es *esService) CleanupData(ctx context.Context, concept Concept) {
 	}
 	cleanupDataLog = cleanupDataLog.WithTransactionID(transactionID)
 
-	conceptTypeMap, err := es.findConceptTypes(ctx, concept.ConcordedUUIDs())
+	deletes, err := es.PlanCleanupData(ctx, concept)
 	if err != nil {
 		cleanupDataLog.WithError(err).Error("Impossible to find concorded concepts in elasticsearch")
 		return
 	}
 
-	for concordedUUID, conceptType := range conceptTypeMap {
-		cleanupDataLog.WithField(concordedUUIDField, concordedUUID).
-			WithField(conceptTypeField, conceptType).
+	for _, d := range deletes {
+		cleanupDataLog.WithField(concordedUUIDField, d.UUID).
+			WithField(conceptTypeField, d.ConceptType).
 			Info("Cleaning up concorded uuids")
-		_, err := es.DeleteData(ctx, conceptType, concordedUUID)
+		resp, err := es.deleteData(ctx, d.ConceptType, d.UUID)
 		if err != nil {
-			cleanupDataLog.WithError(err).WithField(concordedUUIDField, concordedUUID).
-				WithField(conceptTypeField, conceptType).
+			cleanupDataLog.WithError(err).WithField(concordedUUIDField, d.UUID).
+				WithField(conceptTypeField, d.ConceptType).
 				Error("Failed to delete concorded uuid.")
+			continue
+		}
+		if resp.Found {
+			es.publishChange(ctx, events.ChangeEvent{
+				Type:        events.Concorded,
+				UUID:        d.UUID,
+				ConceptType: d.ConceptType,
+				PrefUUID:    concept.PreferredUUID(),
+			})
 		}
 	}
 }
 
 func (es *esService) findConceptTypes(ctx context.Context, uuids []string) (map[string]string, error) {
-	if err := es.checkElasticClient(); err != nil {
+	if err := es.checkStorage(); err != nil {
 		return nil, err
 	}
 
-	query := elastic.NewIdsQuery().Ids(uuids...)
-	result, err := es.elasticClient.Search(es.indexName).Query(query).Do(ctx)
-	if err != nil {
-		return nil, err
-	}
+	var types map[string]string
+	err := es.callStorage(ctx, readRetries, func() (err error) {
+		types, err = es.documents().FindConceptTypes(ctx, es.indexName, uuids)
+		return err
+	})
+	return types, err
+}
 
-	conceptTypeMap := make(map[string]string)
-	for _, hit := range result.Hits.Hits {
-		conceptTypeMap[hit.Id] = hit.Type
+func (es *esService) DeleteData(ctx context.Context, conceptType string, uuid string) (*elastic.DeleteResponse, error) {
+	resp, err := es.deleteData(ctx, conceptType, uuid)
+	if err == nil && resp.Found {
+		es.publishChange(ctx, events.ChangeEvent{Type: events.Deleted, UUID: uuid, ConceptType: conceptType})
 	}
-
-	return conceptTypeMap, nil
+	return resp, err
 }
 
-func (es *esService) DeleteData(ctx context.Context, conceptType string, uuid string) (*elastic.DeleteResponse, error) {
+func (es *esService) deleteData(ctx context.Context, conceptType string, uuid string) (*elastic.DeleteResponse, error) {
 	deleteDataLog := log.WithField(conceptTypeField, conceptType).
 		WithField(uuidField, uuid).
 		WithField(operationField, deleteOperation)
@@ -359,22 +549,27 @@ func (es *esService) DeleteData(ctx context.Context, conceptType string, uuid st
 	}
 	deleteDataLog = deleteDataLog.WithField(tid.TransactionIDKey, transactionID)
 
-	if err := es.checkElasticClient(); err != nil {
+	if err := es.checkStorage(); err != nil {
 		deleteDataLog.WithError(err).
 			WithField(statusField, unknownStatus).
 			Error("Failed operation to Elasticsearch")
 		return nil, err
 	}
 
-	resp, err := es.elasticClient.Delete().
-		Index(es.indexName).
-		Type(conceptType).
-		Id(uuid).
-		Do(ctx)
+	if err := es.checkWriteBlock(); err != nil {
+		deleteDataLog.WithError(err).Warn("Delete refused")
+		return nil, err
+	}
 
+	var resp *elastic.DeleteResponse
+	err = es.callStorage(ctx, deleteRetries, func() (err error) {
+		resp, err = es.documents().Delete(ctx, es.indexName, conceptType, uuid)
+		return err
+	})
 	if elastic.IsNotFound(err) {
-		return &elastic.DeleteResponse{Found: false}, nil
+		resp, err = &elastic.DeleteResponse{Found: false}, nil
 	}
+	es.deleteFromSecondary(ctx, deleteDataLog, conceptType, uuid, err)
 
 	if err != nil {
 		var status string
@@ -387,32 +582,92 @@ func (es *esService) DeleteData(ctx context.Context, conceptType string, uuid st
 		deleteDataLog.WithError(err).
 			WithField(statusField, status).
 			Error("Failed operation to Elasticsearch")
+		return resp, es.writeBlockError(err)
 	}
 
 	return resp, err
 }
 
-func (es *esService) LoadBulkData(conceptType string, uuid string, payload interface{}) {
-	r := elastic.NewBulkIndexRequest().Index(es.indexName).Type(conceptType).Id(uuid).Doc(payload)
-
+// LoadBulkData queues the write of a concept in the bulk processor, unless too many requests are queued already.
+// The write is announced once applied to the index, as a creation or an update depending on what Elasticsearch reports,
+// with all the key fields of the payload as the previous document is not read.
+func (es *esService) LoadBulkData(ctx context.Context, conceptType string, uuid string, payload interface{}) error {
 	es.RLock()
 	defer es.RUnlock()
 
-	es.bulkProcessor.Add(r)
+	if err := es.checkBulkQueue(); err != nil {
+		newWriteLog(ctx, conceptType, uuid).WithError(err).Warn("Bulk write refused")
+		return err
+	}
+
+	err := es.addBulkRequest(BulkLoads, func(index string) elastic.BulkableRequest {
+		r := es.store().indexRequest(index, conceptType, uuid, payload)
+		if index != es.indexName {
+			return r
+		}
+		return es.withChangeEvent(ctx, r, events.ChangeEvent{
+			Type:        events.Updated,
+			UUID:        uuid,
+			ConceptType: conceptType,
+			Changes:     diffKeyFields(nil, payload),
+		})
+	})
+	if err != nil {
+		newWriteLog(ctx, conceptType, uuid).WithError(err).Error("Failed to queue the bulk write")
+	}
+	return err
 }
 
 // PatchUpdateConcept updates a concept document with metrics. See https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-update.html#_updates_with_a_partial_document
+// The change is announced once the update has been applied, with the values of the patch as the previous ones are not read.
 func (es *esService) PatchUpdateConcept(ctx context.Context, conceptType string, uuid string, payload PayloadPatch) {
-	r := elastic.NewBulkUpdateRequest().Index(es.indexName).Id(uuid).Type(conceptType).Doc(payload)
-
 	es.RLock()
 	defer es.RUnlock()
 
-	es.bulkProcessor.Add(r)
+	es.addPatch(ctx, MetricPatches, conceptType, uuid, payload, &events.ChangeEvent{
+		Type:        events.Updated,
+		UUID:        uuid,
+		ConceptType: conceptType,
+		Changes:     diffKeyFields(nil, nil, payload),
+	})
+}
+
+// addPatch queues the update of a concept, or logs why it could not be queued. The event, if any, is published once the update
+// has been applied to the index. It must be called with the lock held.
+func (es *esService) addPatch(ctx context.Context, workload Workload, conceptType string, uuid string, payload PayloadPatch, event *events.ChangeEvent) {
+	err := es.addBulkRequest(workload, func(index string) elastic.BulkableRequest {
+		r := es.store().updateRequest(index, conceptType, uuid, payload)
+		if event == nil || index != es.indexName {
+			return r
+		}
+		return es.withChangeEvent(ctx, r, *event)
+	})
+	if err != nil {
+		log.WithError(err).WithField("conceptType", conceptType).WithField("uuid", uuid).Error("Failed to queue the update of the concept")
+	}
 }
 
+// CloseBulkProcessor closes the default bulk processor and the ones of the lanes, once their queued requests have been sent
+// It also stops the background checks of the service.
 func (es *esService) CloseBulkProcessor() error {
-	return es.bulkProcessor.Close()
+	if es.stopWatchers != nil {
+		es.stopWatchers()
+		es.watchers.Wait()
+	}
+
+	var err error
+	if es.bulkProcessor != nil {
+		err = es.bulkProcessor.Close()
+	}
+	for _, lane := range es.dedicatedLanes() {
+		if lane.processor == nil {
+			continue
+		}
+		if laneErr := lane.processor.Close(); laneErr != nil && err == nil {
+			err = laneErr
+		}
+	}
+	return err
 }
 
 func (es *esService) GetAllIds(ctx context.Context) chan EsIDTypePair {
@@ -421,43 +676,31 @@ func (es *esService) GetAllIds(ctx context.Context) chan EsIDTypePair {
 	go func() {
 		defer close(ids)
 
-		r := elastic.NewScrollService(es.elasticClient).
-			Index(es.indexName).
-			Query(elastic.NewMatchAllQuery()).
-			Sort("_doc", true).
-			Size(1000).
-			FetchSource(false)
-
-		es.RLock()
-		defer es.RUnlock()
-
-		var err error
-		for {
-			r, err = es.processScrollPage(ctx, r, ids)
-			if r == nil || err != nil {
-				return
-			}
+		err := es.forEachId(ctx, func(id EsIDTypePair) {
+			ids <- id
+		})
+		if err != nil {
+			log.Error("error while fetching ids", err)
 		}
 	}()
 
 	return ids
 }
 
-func (es *esService) processScrollPage(ctx context.Context, r *elastic.ScrollService, ch chan EsIDTypePair) (*elastic.ScrollService, error) {
-	res, err := r.Do(ctx)
-	if err == io.EOF {
-		return nil, nil
-	} else if err != nil {
-		log.Error("error while fetching ids", err)
-		return nil, err
-	}
+// forEachId calls handle with the id and the type of every document of the index
+func (es *esService) forEachId(ctx context.Context, handle func(id EsIDTypePair)) error {
+	es.RLock()
+	defer es.RUnlock()
 
-	scrollId := res.ScrollId
-	for _, c := range res.Hits.Hits {
-		ch <- EsIDTypePair{ID: c.Id, Type: c.Type}
+	if err := es.checkStorage(); err != nil {
+		return err
 	}
-
-	return elastic.NewScrollService(es.elasticClient).ScrollId(scrollId), nil
+	return es.documents().Scroll(ctx, es.indexName, false, func(res *elastic.SearchResult) error {
+		for _, c := range res.Hits.Hits {
+			handle(EsIDTypePair{ID: c.Id, Type: c.Type})
+		}
+		return nil
+	})
 }
 
 func logDebugPatchData(log *logrus.Entry, payload PayloadPatch, msg string) {
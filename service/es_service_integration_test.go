@@ -15,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Financial-Times/concept-rw-elasticsearch/events"
 	"github.com/Financial-Times/go-logger"
 	uuid "github.com/satori/go.uuid"
 	testLog "github.com/sirupsen/logrus/hooks/test"
@@ -50,10 +51,8 @@ func TestWrite(t *testing.T) {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, time.Second)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 
 	testUUID := uuid.NewV4().String()
 	_, up, resp, err := writeTestDocument(service, organisationsType, testUUID)
@@ -72,10 +71,8 @@ func TestWriteMakesPersonAnFTColumnist(t *testing.T) {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, 100*time.Millisecond)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 	testUUID := uuid.NewV4().String()
 	op, _, _, err := writeTestPersonDocument(service, peopleType, testUUID, "false")
 	defer deleteTestDocument(t, service, peopleType, testUUID)
@@ -99,7 +96,7 @@ func TestWriteMakesPersonAnFTColumnist(t *testing.T) {
 	require.NoError(t, err, "require successful write")
 	assert.True(t, up, "author was updated")
 
-	p, err := service.ReadData(peopleType, testUUID)
+	p, err := service.ReadData(context.Background(), peopleType, testUUID)
 	assert.NoError(t, err, "expected successful read")
 	var actual EsPersonConceptModel
 	assert.NoError(t, json.Unmarshal(*p.Source, &actual))
@@ -113,12 +110,10 @@ func TestWriteMakesPersonAnFTJournalist(t *testing.T) {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, 100*time.Millisecond)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 	testUUID := uuid.NewV4().String()
-	_, _, _, err = writeTestPersonDocument(service, peopleType, testUUID, "false")
+	_, _, _, err := writeTestPersonDocument(service, peopleType, testUUID, "false")
 	defer deleteTestDocument(t, service, peopleType, testUUID)
 	require.NoError(t, err, "expected successful write")
 	ctx := context.Background()
@@ -139,7 +134,7 @@ func TestWriteMakesPersonAnFTJournalist(t *testing.T) {
 	require.NoError(t, err, "require successful write")
 	assert.True(t, up, "Journalist updated")
 
-	p, err := service.ReadData(peopleType, testUUID)
+	p, err := service.ReadData(context.Background(), peopleType, testUUID)
 	assert.NoError(t, err, "expected successful read")
 	var actual EsPersonConceptModel
 	assert.NoError(t, json.Unmarshal(*p.Source, &actual))
@@ -156,10 +151,8 @@ func TestWriteDummyPersonWhenMembershipArrives(t *testing.T) {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, 100*time.Millisecond)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, getTimeFunc}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: getTimeFunc}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 	testUUID := uuid.NewV4().String()
 	ctx := context.Background()
 
@@ -178,7 +171,7 @@ func TestWriteDummyPersonWhenMembershipArrives(t *testing.T) {
 	err = service.bulkProcessor.Flush() // wait for the bulk processor to write the data
 	require.NoError(t, err, "require successful write")
 	assert.True(t, up, "Journalist updated")
-	p, err := service.ReadData(peopleType, testUUID)
+	p, err := service.ReadData(context.Background(), peopleType, testUUID)
 	assert.NoError(t, err, "expected successful read")
 	var actual EsPersonConceptModel
 	assert.NoError(t, json.Unmarshal(*p.Source, &actual))
@@ -191,10 +184,8 @@ func TestWritePersonAfterMembership(t *testing.T) {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, 100*time.Millisecond)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 	testUUID := uuid.NewV4().String()
 	ctx := context.Background()
 
@@ -220,7 +211,7 @@ func TestWritePersonAfterMembership(t *testing.T) {
 	require.NoError(t, err, "require successful write")
 	assert.True(t, up, "Journalist updated")
 
-	p, err := service.ReadData(peopleType, testUUID)
+	p, err := service.ReadData(context.Background(), peopleType, testUUID)
 	assert.NoError(t, err, "expected successful read")
 	var actual EsPersonConceptModel
 	assert.NoError(t, json.Unmarshal(*p.Source, &actual))
@@ -248,7 +239,7 @@ func TestFTAuthorWriteOrder(t *testing.T) {
 	flushChangesToIndex(t, service)
 
 	var p1 EsPersonConceptModel
-	esResult, _ := service.ReadData(peopleType, testUUID)
+	esResult, _ := service.ReadData(context.Background(), peopleType, testUUID)
 	require.NoError(t, json.Unmarshal(*esResult.Source, &p1))
 
 	deleteTestDocument(t, service, peopleType, testUUID)
@@ -260,7 +251,7 @@ func TestFTAuthorWriteOrder(t *testing.T) {
 	flushChangesToIndex(t, service)
 
 	var p2 EsPersonConceptModel
-	esResult, _ = service.ReadData(peopleType, testUUID)
+	esResult, _ = service.ReadData(context.Background(), peopleType, testUUID)
 	require.NoError(t, json.Unmarshal(*esResult.Source, &p2))
 
 	deleteTestDocument(t, service, peopleType, testUUID)
@@ -274,12 +265,10 @@ func TestWriteMakesDoesNotMakePersonAnFTAuthor(t *testing.T) {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, 100*time.Millisecond)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 	testUUID := uuid.NewV4().String()
-	_, _, _, err = writeTestPersonDocument(service, peopleType, testUUID, "false")
+	_, _, _, err := writeTestPersonDocument(service, peopleType, testUUID, "false")
 	defer deleteTestDocument(t, service, peopleType, testUUID)
 
 	require.NoError(t, err, "expected successful write")
@@ -329,7 +318,7 @@ func TestWriteMakesDoesNotMakePersonAnFTAuthor(t *testing.T) {
 			require.NoError(t, err, "require successful write")
 			assert.False(t, up, "should not have updated person")
 
-			p, err := service.ReadData(peopleType, testUUID)
+			p, err := service.ReadData(context.Background(), peopleType, testUUID)
 			assert.NoError(t, err, "expected successful read")
 			var actual EsPersonConceptModel
 			assert.NoError(t, json.Unmarshal(*p.Source, &actual))
@@ -342,10 +331,8 @@ func TestWritePreservesPatchableDataForPerson(t *testing.T) {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, 100*time.Millisecond)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 
 	testUUID := uuid.NewV4().String()
 	payload, _, _, err := writeTestPersonDocument(service, peopleType, testUUID, "true")
@@ -359,7 +346,7 @@ func TestWritePreservesPatchableDataForPerson(t *testing.T) {
 	err = service.bulkProcessor.Flush() // wait for the bulk processor to write the data
 	require.NoError(t, err, "require successful metrics write")
 
-	p, err := service.ReadData(peopleType, testUUID)
+	p, err := service.ReadData(context.Background(), peopleType, testUUID)
 	assert.NoError(t, err, "expected successful read")
 	var previous EsPersonConceptModel
 	assert.NoError(t, json.Unmarshal(*p.Source, &previous))
@@ -375,7 +362,7 @@ func TestWritePreservesPatchableDataForPerson(t *testing.T) {
 	require.NoError(t, err, "expected successful flush")
 	assert.True(t, up, "person should have been updated")
 
-	p, err = service.ReadData(peopleType, testUUID)
+	p, err = service.ReadData(context.Background(), peopleType, testUUID)
 	assert.NoError(t, err, "expected successful read")
 	var actual EsPersonConceptModel
 	assert.NoError(t, json.Unmarshal(*p.Source, &actual))
@@ -391,13 +378,11 @@ func TestWritePreservesMetrics(t *testing.T) {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, 100*time.Millisecond)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 
 	testUUID := uuid.NewV4().String()
-	_, _, _, err = writeTestDocument(service, organisationsType, testUUID)
+	_, _, _, err := writeTestDocument(service, organisationsType, testUUID)
 	defer deleteTestDocument(t, service, organisationsType, testUUID)
 
 	require.NoError(t, err, "require successful concept write")
@@ -411,7 +396,7 @@ func TestWritePreservesMetrics(t *testing.T) {
 	err = service.bulkProcessor.Flush() // wait for the bulk processor to write the data
 	require.NoError(t, err, "require successful concept update")
 
-	actual, err := service.ReadData(organisationsType, testUUID)
+	actual, err := service.ReadData(context.Background(), organisationsType, testUUID)
 	assert.NoError(t, err, "expected successful concept read")
 	m := make(map[string]interface{})
 	assert.NoError(t, json.Unmarshal(*actual.Source, &m))
@@ -428,9 +413,9 @@ func TestWritePreservesMetrics(t *testing.T) {
 func TestIsReadOnly(t *testing.T) {
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	service := &esService{sync.RWMutex{}, ec, nil, indexName, nil, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, getCurrentTime: time.Now}
 	defer ec.Stop()
-	readOnly, name, err := service.IsIndexReadOnly()
+	readOnly, name, err := service.IsIndexReadOnly(context.Background())
 	assert.False(t, readOnly, "index should not be read-only")
 	assert.Equal(t, name, indexName, "index name should be returned")
 	assert.NoError(t, err, "read-only check should not return an error")
@@ -438,7 +423,7 @@ func TestIsReadOnly(t *testing.T) {
 	setReadOnly(t, ec, indexName, true)
 	defer setReadOnly(t, ec, indexName, false)
 
-	readOnly, name, err = service.IsIndexReadOnly()
+	readOnly, name, err = service.IsIndexReadOnly(context.Background())
 	assert.True(t, readOnly, "index should be read-only")
 	assert.Equal(t, name, indexName, "index name should be returned")
 	assert.NoError(t, err, "read-only check should not return an error")
@@ -447,9 +432,9 @@ func TestIsReadOnly(t *testing.T) {
 func TestIsReadOnlyIndexNotFound(t *testing.T) {
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	service := &esService{sync.RWMutex{}, ec, nil, "foo", nil, time.Now}
+	service := &esService{elasticClient: ec, indexName: "foo", getCurrentTime: time.Now}
 	defer ec.Stop()
-	readOnly, name, err := service.IsIndexReadOnly()
+	readOnly, name, err := service.IsIndexReadOnly(context.Background())
 	assert.False(t, readOnly, "index should not be read-only")
 	assert.Empty(t, name, "no index name should be returned")
 	assert.Error(t, err, "index should not be found")
@@ -459,10 +444,8 @@ func TestRead(t *testing.T) {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, time.Second)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 	defer ec.Stop()
 
 	testUUID := uuid.NewV4().String()
@@ -473,7 +456,7 @@ func TestRead(t *testing.T) {
 	_, err = ec.Refresh(indexName).Do(context.Background())
 	require.NoError(t, err, "expected successful flush")
 
-	resp, err := service.ReadData(organisationsType, testUUID)
+	resp, err := service.ReadData(context.Background(), organisationsType, testUUID)
 
 	assert.NoError(t, err, "expected no error for ES read")
 	assert.True(t, resp.Found, "should find a result")
@@ -506,7 +489,7 @@ func TestPassClientThroughChannel(t *testing.T) {
 
 	assert.NoError(t, err, "expected successful write")
 
-	resp, err := service.ReadData(organisationsType, testUUID)
+	resp, err := service.ReadData(context.Background(), organisationsType, testUUID)
 
 	assert.NoError(t, err, "expected no error for ES read")
 	assert.True(t, resp.Found, "should find a result")
@@ -524,10 +507,8 @@ func TestDelete(t *testing.T) {
 	esURL := getElasticSearchTestURL()
 
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 
 	testUUID := uuid.NewV4().String()
 	_, _, resp, err := writeTestDocument(service, organisationsType, testUUID)
@@ -542,7 +523,7 @@ func TestDelete(t *testing.T) {
 	require.NoError(t, err)
 	assert.True(t, deleteResp.Found)
 
-	getResp, err := service.ReadData(organisationsType, testUUID)
+	getResp, err := service.ReadData(context.Background(), organisationsType, testUUID)
 	assert.NoError(t, err)
 	assert.False(t, getResp.Found)
 }
@@ -557,7 +538,7 @@ func TestDeleteNotFoundConcept(t *testing.T) {
 	)
 	assert.NoError(t, err, "expected no error for ES client")
 
-	service := &esService{sync.RWMutex{}, ec, nil, indexName, nil, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, getCurrentTime: time.Now}
 
 	testUUID := uuid.NewV4().String()
 	resp, _ := service.DeleteData(newTestContext(), organisationsType+"s", testUUID)
@@ -572,10 +553,8 @@ func TestCleanup(t *testing.T) {
 	esURL := getElasticSearchTestURL()
 
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 
 	testUUID1 := uuid.NewV4().String()
 	_, _, resp, err := writeTestDocument(service, organisationsType, testUUID1)
@@ -612,15 +591,15 @@ func TestCleanup(t *testing.T) {
 
 	service.CleanupData(newTestContext(), concept)
 
-	getResp, err := service.ReadData(peopleType, testUUID2)
+	getResp, err := service.ReadData(context.Background(), peopleType, testUUID2)
 	assert.NoError(t, err)
 	assert.False(t, getResp.Found)
 
-	getResp, err = service.ReadData(organisationsType, testUUID3)
+	getResp, err = service.ReadData(context.Background(), organisationsType, testUUID3)
 	assert.NoError(t, err)
 	assert.False(t, getResp.Found)
 
-	getResp, err = service.ReadData(organisationsType, testUUID1)
+	getResp, err = service.ReadData(context.Background(), organisationsType, testUUID1)
 	assert.NoError(t, err)
 	assert.True(t, getResp.Found)
 }
@@ -630,10 +609,8 @@ func TestDeprecationFlagTrue(t *testing.T) {
 	esURL := getElasticSearchTestURL()
 
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 
 	testUUID := uuid.NewV4().String()
 	payload := EsConceptModel{
@@ -657,7 +634,7 @@ func TestDeprecationFlagTrue(t *testing.T) {
 	assert.Equal(t, organisationsType, resp.Type, "concept type")
 	assert.Equal(t, testUUID, resp.Id, "document id")
 
-	readResp, err := service.ReadData(organisationsType, testUUID)
+	readResp, err := service.ReadData(context.Background(), organisationsType, testUUID)
 
 	assert.NoError(t, err, "expected no error for ES read")
 	assert.True(t, readResp.Found, "should find a result")
@@ -674,10 +651,8 @@ func TestDeprecationFlagFalse(t *testing.T) {
 	esURL := getElasticSearchTestURL()
 
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 
 	testUUID := uuid.NewV4().String()
 	payload := EsConceptModel{
@@ -700,7 +675,7 @@ func TestDeprecationFlagFalse(t *testing.T) {
 	assert.Equal(t, organisationsType, resp.Type, "concept type")
 	assert.Equal(t, testUUID, resp.Id, "document id")
 
-	readResp, err := service.ReadData(organisationsType, testUUID)
+	readResp, err := service.ReadData(context.Background(), organisationsType, testUUID)
 
 	assert.NoError(t, err, "expected no error for ES read")
 	assert.True(t, readResp.Found, "should find a result")
@@ -718,10 +693,8 @@ func TestMetricsUpdated(t *testing.T) {
 	esURL := getElasticSearchTestURL()
 
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 
 	testUUID := uuid.NewV4().String()
 	payload := EsConceptModel{
@@ -749,7 +722,7 @@ func TestMetricsUpdated(t *testing.T) {
 
 	service.bulkProcessor.Flush() // wait for the bulk processor to write the data
 
-	readResp, err := service.ReadData(organisationsType, testUUID)
+	readResp, err := service.ReadData(context.Background(), organisationsType, testUUID)
 
 	assert.NoError(t, err, "expected no error for ES read")
 	assert.True(t, readResp.Found, "should find a result")
@@ -770,9 +743,8 @@ func TestGetAllIds(t *testing.T) {
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, time.Second)
-	bulkProcessor, _ := newBulkProcessor(ec, &bulkProcessorConfig)
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 
 	max := 1001
 	expected := make([]string, max)
@@ -821,20 +793,50 @@ func TestGetAllIds(t *testing.T) {
 	assert.Equal(t, 0, notFound, "UUIDs not found")
 }
 
+func TestWritePublishesChangeEvents(t *testing.T) {
+	service := getTestESService(t)
+	publisher := events.NewInMemoryPublisher()
+	service.changePublisher = publisher
+
+	testUUID := uuid.NewV4().String()
+	payload, _, _, err := writeTestDocument(service, organisationsType, testUUID)
+	require.NoError(t, err)
+	flushChangesToIndex(t, service)
+
+	payload.PrefLabel = "Updated PrefLabel"
+	_, _, err = service.LoadData(newTestContext(), organisationsType, testUUID, payload)
+	require.NoError(t, err)
+	flushChangesToIndex(t, service)
+
+	deleteTestDocument(t, service, organisationsType, testUUID)
+
+	published := publisher.Events()
+	require.Len(t, published, 3)
+
+	assert.Equal(t, events.Created, published[0].Type)
+	assert.Equal(t, testUUID, published[0].UUID)
+	assert.Equal(t, organisationsType, published[0].ConceptType)
+	assert.Equal(t, testTID, published[0].TransactionID)
+
+	assert.Equal(t, events.Updated, published[1].Type)
+	assert.Equal(t, events.FieldChange{Before: fmt.Sprintf("Test concept %s %s", organisationsType, testUUID), After: "Updated PrefLabel"}, published[1].Changes["prefLabel"])
+	assert.Len(t, published[1].Changes, 1)
+
+	assert.Equal(t, events.Deleted, published[2].Type)
+}
+
 func getTestESService(t *testing.T) *esService {
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, 100*time.Millisecond)
 	esURL := getElasticSearchTestURL()
 	ec := getElasticClient(t, esURL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	return &esService{
+	es := &esService{
 		elasticClient:       ec,
-		bulkProcessor:       bulkProcessor,
 		indexName:           indexName,
 		bulkProcessorConfig: &bulkProcessorConfig,
 		getCurrentTime:      time.Now,
 	}
+	require.NoError(t, es.resetBulkProcessor(), "require a bulk processor")
+	return es
 }
 
 func getElasticSearchTestURL() string {
@@ -875,7 +877,7 @@ func writeTestPersonDocument(es EsService, conceptType string, uuid string, isFT
 func waitForClientInjection(service EsService) error {
 	var err error
 	for i := 0; i < 10; i++ {
-		_, err = service.GetClusterHealth()
+		_, err = service.GetClusterHealth(context.Background())
 		if err == nil {
 			return nil
 		}
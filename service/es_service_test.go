@@ -2,10 +2,10 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"sync"
 	"testing"
 	"time"
 
@@ -34,9 +34,9 @@ func init() {
 }
 
 func TestNoElasticClient(t *testing.T) {
-	service := esService{sync.RWMutex{}, nil, nil, "test", nil, time.Now}
+	service := esService{indexName: "test", getCurrentTime: time.Now}
 
-	_, err := service.ReadData("any", "any")
+	_, err := service.ReadData(context.Background(), "any", "any")
 
 	assert.Equal(t, ErrNoElasticClient, err, "error response")
 }
@@ -47,10 +47,8 @@ func TestWriteWithGenericError(t *testing.T) {
 	defer es.Close()
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, time.Second)
 	ec := getElasticClient(t, es.URL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 	testUUID := uuid.NewV4().String()
 	_, up, _, err := writeTestDocument(service, organisationsType, testUUID)
 	assert.EqualError(t, err, "unexpected end of JSON input")
@@ -72,10 +70,8 @@ func TestWriteWithESError(t *testing.T) {
 	defer es.Close()
 	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, time.Second)
 	ec := getElasticClient(t, es.URL)
-	bulkProcessor, err := newBulkProcessor(ec, &bulkProcessorConfig)
-	require.NoError(t, err, "require a bulk processor")
-
-	service := &esService{sync.RWMutex{}, ec, bulkProcessor, indexName, &bulkProcessorConfig, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, bulkProcessorConfig: &bulkProcessorConfig, getCurrentTime: time.Now}
+	require.NoError(t, service.resetBulkProcessor(), "require a bulk processor")
 	testUUID := uuid.NewV4().String()
 	_, up, _, err := writeTestDocument(service, organisationsType, testUUID)
 
@@ -101,7 +97,7 @@ func TestDeleteWithESError(t *testing.T) {
 	)
 	assert.NoError(t, err, "expected no error for ES client")
 
-	service := &esService{sync.RWMutex{}, ec, nil, indexName, nil, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, getCurrentTime: time.Now}
 
 	testUUID := uuid.NewV4().String()
 	_, err = service.DeleteData(newTestContext(), organisationsType+"s", testUUID)
@@ -126,7 +122,7 @@ func TestDeleteWithGenericError(t *testing.T) {
 		elastic.SetSniff(false),
 	)
 	assert.NoError(t, err, "expected no error for ES client")
-	service := &esService{sync.RWMutex{}, ec, nil, indexName, nil, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, getCurrentTime: time.Now}
 
 	testUUID := uuid.NewV4().String()
 
@@ -153,7 +149,7 @@ func TestCleanupErrorLogging(t *testing.T) {
 	)
 	assert.NoError(t, err, "expected no error for ES client")
 
-	service := &esService{sync.RWMutex{}, ec, nil, indexName, nil, time.Now}
+	service := &esService{elasticClient: ec, indexName: indexName, getCurrentTime: time.Now}
 
 	testUUID1 := uuid.NewV4().String()
 	testUUID2 := uuid.NewV4().String()
@@ -214,3 +210,83 @@ func writeTestDocument(es EsService, conceptType string, uuid string) (EsConcept
 func newTestContext() context.Context {
 	return tid.TransactionAwareContext(context.Background(), testTID)
 }
+
+func TestSetBulkProcessorConfig(t *testing.T) {
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer es.Close()
+	initialConfig := NewBulkProcessorConfig(1, 1, 1, time.Second)
+	service := &esService{indexName: indexName, bulkProcessorConfig: &initialConfig, getCurrentTime: time.Now}
+
+	sameConfig := initialConfig
+	require.NoError(t, service.SetBulkProcessorConfig(&sameConfig))
+	assert.Equal(t, &initialConfig, service.bulkProcessorConfig, "an equal config should be ignored")
+
+	newConfig := initialConfig.With(2, 0, 0, 0)
+	require.NoError(t, service.SetBulkProcessorConfig(&newConfig))
+	assert.Equal(t, NewBulkProcessorConfig(2, 1, 1, time.Second), *service.bulkProcessorConfig)
+	assert.Nil(t, service.bulkProcessor, "no bulk processor should be created without a client")
+
+	ec := getElasticClient(t, es.URL)
+	service.setElasticClient(ec)
+	previous := service.bulkProcessor
+	require.NotNil(t, previous)
+
+	newConfig = newConfig.With(0, 0, 0, 2*time.Second)
+	require.NoError(t, service.SetBulkProcessorConfig(&newConfig))
+	assert.True(t, previous != service.bulkProcessor, "the bulk processor should be rebuilt")
+	require.NoError(t, service.CloseBulkProcessor())
+}
+
+func TestCallsStopWithContext(t *testing.T) {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithStorage(NewMemoryStorage(indexName))).(*esService)
+	defer es.CloseBulkProcessor()
+
+	ctx, cancel := context.WithDeadline(newTestContext(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	_, err := es.ReadData(ctx, "people", memoryUUID)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "read: %v", err)
+	_, _, err = es.LoadData(ctx, "people", memoryUUID, &EsConceptModel{Id: memoryUUID})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "write: %v", err)
+	_, err = es.DeleteData(ctx, "people", memoryUUID)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "delete: %v", err)
+	_, err = es.GetClusterHealth(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "cluster health: %v", err)
+	_, _, err = es.IsIndexReadOnly(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "index settings: %v", err)
+}
+
+func TestIsIndexReadOnly(t *testing.T) {
+	es := &esService{}
+	for _, block := range []string{"write", "read_only", "read_only_allow_delete"} {
+		readOnly, err := es.isIndexReadOnly(map[string]interface{}{
+			"index": map[string]interface{}{"blocks": map[string]interface{}{block: "true"}},
+		})
+		require.NoError(t, err, block)
+		assert.True(t, readOnly, block)
+	}
+
+	readOnly, err := es.isIndexReadOnly(map[string]interface{}{
+		"index": map[string]interface{}{"blocks": map[string]interface{}{"write": "false", "read_only_allow_delete": "false"}},
+	})
+	require.NoError(t, err)
+	assert.False(t, readOnly)
+
+	readOnly, err = es.isIndexReadOnly(map[string]interface{}{"index": map[string]interface{}{}})
+	require.NoError(t, err)
+	assert.False(t, readOnly)
+
+	_, err = es.isIndexReadOnly(map[string]interface{}{})
+	assert.Error(t, err, "the settings should have the index ones")
+
+	_, err = es.isIndexReadOnly(map[string]interface{}{
+		"index": map[string]interface{}{"blocks": map[string]interface{}{"write": true}},
+	})
+	assert.Error(t, err, "the blocks should be strings")
+
+	_, err = es.isIndexReadOnly(map[string]interface{}{"index": map[string]interface{}{"blocks": "write"}})
+	assert.Error(t, err)
+}
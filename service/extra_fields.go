@@ -0,0 +1,49 @@
+package service
+
+import "sort"
+
+// extraFields are the optional fields of aggregate concepts which can be indexed for any concept type
+var extraFields = map[string]func(*EsConceptModel, AggregateConceptModel){
+	"countryCode": func(esModel *EsConceptModel, concept AggregateConceptModel) {
+		esModel.CountryCode = concept.CountryCode
+	},
+	"countryOfIncorporation": func(esModel *EsConceptModel, concept AggregateConceptModel) {
+		esModel.CountryOfIncorporation = concept.CountryOfIncorporation
+	},
+}
+
+// ExtraFields returns the names of the optional fields which can be indexed with AddExtraFields
+func ExtraFields() []string {
+	var names []string
+	for name := range extraFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsExtraField tells whether the field can be indexed with AddExtraFields
+func IsExtraField(name string) bool {
+	_, found := extraFields[name]
+	return found
+}
+
+// AddExtraFields copies the given optional fields of an aggregate concept into its converted document,
+// on top of the fields its converter writes. Documents which are not concepts, e.g. memberships, are left as they are.
+func AddExtraFields(esModel EsModel, concept AggregateConceptModel, fields []string) {
+	var esConceptModel *EsConceptModel
+	switch m := esModel.(type) {
+	case *EsConceptModel:
+		esConceptModel = m
+	case *EsPersonConceptModel:
+		esConceptModel = m.EsConceptModel
+	default:
+		return
+	}
+
+	for _, field := range fields {
+		if set, found := extraFields[field]; found {
+			set(esConceptModel, concept)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package service
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// embeddedMapping holds the settings and the mapping of the types of the index the service writes to
+//
+//go:embed mapping.json
+var embeddedMapping []byte
+
+var (
+	ErrMappingNotChecked = errors.New("the mapping of the index has not been checked yet")
+	ErrMappingConflict   = errors.New("the mapping of the index conflicts with the embedded mapping")
+)
+
+// MappingConflict is a field mapped with another type in the index than in the embedded mapping
+type MappingConflict struct {
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// MappingStatus compares the mapping of an index with the embedded mapping. Fields are named after their type, e.g. people.isFTAuthor,
+// unless the index is typeless.
+type MappingStatus struct {
+	Index     string    `json:"index"`
+	Exists    bool      `json:"exists"`
+	CheckedAt time.Time `json:"checkedAt"`
+	// the types and fields of the embedded mapping which are missing from the index
+	MissingTypes  []string `json:"missingTypes,omitempty"`
+	MissingFields []string `json:"missingFields,omitempty"`
+	// the fields mapped with another type
+	Conflicts []MappingConflict `json:"conflicts,omitempty"`
+	// the fields of the index which are not in the embedded mapping, e.g. mapped dynamically
+	UnexpectedFields []string `json:"unexpectedFields,omitempty"`
+}
+
+// InSync tells whether the index maps every type and field of the embedded mapping, the way it does
+func (s MappingStatus) InSync() bool {
+	return s.Exists && len(s.MissingTypes) == 0 && len(s.MissingFields) == 0 && len(s.Conflicts) == 0
+}
+
+// additive tells whether the missing types and fields can be added to the index without conflicts
+func (s MappingStatus) additive() bool {
+	return len(s.Conflicts) == 0
+}
+
+// mappingCheck caches the status of the mapping of the index the service writes to
+type mappingCheck struct {
+	sync.Mutex
+	status *MappingStatus
+}
+
+type indexMapping struct {
+	Settings map[string]interface{} `json:"settings"`
+	Mappings map[string]typeMapping `json:"mappings"`
+}
+
+type typeMapping struct {
+	Dynamic    interface{}            `json:"dynamic,omitempty"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func parseEmbeddedMapping() (*indexMapping, error) {
+	m := new(indexMapping)
+	if err := json.Unmarshal(embeddedMapping, m); err != nil {
+		return nil, fmt.Errorf("invalid embedded mapping: %w", err)
+	}
+	return m, nil
+}
+
+// MappingStatus returns the status of the mapping of the index checked last
+func (es *esService) MappingStatus() (MappingStatus, error) {
+	if es.storage != nil {
+		return MappingStatus{}, ErrStorageUnsupported
+	}
+
+	es.mapping.Lock()
+	defer es.mapping.Unlock()
+
+	if es.mapping.status == nil {
+		return MappingStatus{}, ErrMappingNotChecked
+	}
+	return *es.mapping.status, nil
+}
+
+// CheckMapping compares the mapping of an index with the embedded mapping. The index the service writes to is checked if the name is empty.
+func (es *esService) CheckMapping(ctx context.Context, index string) (MappingStatus, error) {
+	es.RLock()
+	defer es.RUnlock()
+
+	return es.checkMapping(ctx, index)
+}
+
+// checkMapping must be called with the lock held
+func (es *esService) checkMapping(ctx context.Context, index string) (MappingStatus, error) {
+	if index == "" {
+		index = es.indexName
+	}
+	if err := es.checkElasticClient(); err != nil {
+		return MappingStatus{}, err
+	}
+
+	embedded, err := parseEmbeddedMapping()
+	if err != nil {
+		return MappingStatus{}, err
+	}
+	store := es.store()
+	expected := store.expectedMappings(embedded)
+
+	status := MappingStatus{Index: index, CheckedAt: time.Now()}
+	live, err := store.getMapping(ctx, index)
+	switch {
+	case elastic.IsNotFound(err):
+		for t := range expected {
+			// a typeless index has no types
+			if t != "" {
+				status.MissingTypes = append(status.MissingTypes, t)
+			}
+		}
+		sort.Strings(status.MissingTypes)
+	case err != nil:
+		return MappingStatus{}, err
+	default:
+		status.Exists = true
+		compareMappings(&status, expected, live)
+	}
+
+	if index == es.indexName {
+		es.mapping.Lock()
+		es.mapping.status = &status
+		es.mapping.Unlock()
+	}
+	return status, nil
+}
+
+// ApplyMapping creates an index with the embedded settings and mapping, or adds the types and fields of the embedded
+// mapping missing from an existing index. Nothing is changed if a field is mapped with another type, ErrMappingConflict is returned instead.
+// The index the service writes to is updated if the name is empty.
+func (es *esService) ApplyMapping(ctx context.Context, index string) (MappingStatus, error) {
+	es.RLock()
+	defer es.RUnlock()
+
+	status, err := es.checkMapping(ctx, index)
+	if err != nil {
+		return status, err
+	}
+	if !status.additive() {
+		return status, ErrMappingConflict
+	}
+
+	embedded, err := parseEmbeddedMapping()
+	if err != nil {
+		return status, err
+	}
+	store := es.store()
+
+	applyLog := log.WithField("index", status.Index)
+	if !status.Exists {
+		if err := store.createIndex(ctx, status.Index, embedded); err != nil {
+			return status, err
+		}
+		applyLog.Info("Index created with the embedded mapping")
+		return es.checkMapping(ctx, status.Index)
+	}
+
+	expected := store.expectedMappings(embedded)
+	for _, t := range typesToUpdate(status, expected) {
+		if err := store.putMapping(ctx, status.Index, t, expected[t]); err != nil {
+			return status, err
+		}
+		applyLog.WithField(conceptTypeField, t).Info("Mapping of the type updated with the embedded mapping")
+	}
+	return es.checkMapping(ctx, status.Index)
+}
+
+// refreshMappingStatus checks the mapping of the index the service writes to, and logs the differences
+func (es *esService) refreshMappingStatus(ctx context.Context) {
+	status, err := es.CheckMapping(ctx, "")
+	if err != nil {
+		log.WithError(err).Warn("Failed to check the mapping of the index")
+		return
+	}
+	if !status.InSync() {
+		log.WithField("index", status.Index).
+			WithField("missingTypes", status.MissingTypes).
+			WithField("missingFields", status.MissingFields).
+			WithField("conflicts", status.Conflicts).
+			Warn("The mapping of the index differs from the embedded mapping")
+	}
+}
+
+// liveMappings extracts the mappings of the types of a get mapping response. Only the first index is read if the name is an alias.
+func liveMappings(resp map[string]interface{}) map[string]typeMapping {
+	names := make([]string, 0, len(resp))
+	for name := range resp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil
+	}
+
+	var index struct {
+		Mappings map[string]typeMapping `json:"mappings"`
+	}
+	data, err := json.Marshal(resp[names[0]])
+	if err == nil {
+		err = json.Unmarshal(data, &index)
+	}
+	if err != nil {
+		return nil
+	}
+	return index.Mappings
+}
+
+func compareMappings(status *MappingStatus, expected map[string]typeMapping, actual map[string]typeMapping) {
+	for t, expectedType := range expected {
+		actualType, found := actual[t]
+		if !found {
+			status.MissingTypes = append(status.MissingTypes, t)
+			continue
+		}
+
+		expectedFields := flattenProperties(t, expectedType.Properties)
+		actualFields := flattenProperties(t, actualType.Properties)
+		for field, expectedKind := range expectedFields {
+			actualKind, found := actualFields[field]
+			switch {
+			case !found:
+				status.MissingFields = append(status.MissingFields, field)
+			case actualKind != expectedKind:
+				status.Conflicts = append(status.Conflicts, MappingConflict{Field: field, Expected: expectedKind, Actual: actualKind})
+			}
+		}
+		for field := range actualFields {
+			if _, found := expectedFields[field]; !found {
+				status.UnexpectedFields = append(status.UnexpectedFields, field)
+			}
+		}
+	}
+
+	sort.Strings(status.MissingTypes)
+	sort.Strings(status.MissingFields)
+	sort.Strings(status.UnexpectedFields)
+	sort.Slice(status.Conflicts, func(i, j int) bool { return status.Conflicts[i].Field < status.Conflicts[j].Field })
+}
+
+// flattenProperties returns the type of every field of a mapping, including the sub-fields and the fields of objects.
+// The fields are named after the prefix, if any.
+func flattenProperties(prefix string, properties map[string]interface{}) map[string]string {
+	fields := make(map[string]string)
+	for name, p := range properties {
+		field, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		kind, _ := field["type"].(string)
+		if nested, ok := field["properties"].(map[string]interface{}); ok {
+			if kind == "" {
+				kind = "object"
+			}
+			for k, v := range flattenProperties(path, nested) {
+				fields[k] = v
+			}
+		}
+		if multiFields, ok := field["fields"].(map[string]interface{}); ok {
+			for k, v := range flattenProperties(path, multiFields) {
+				fields[k] = v
+			}
+		}
+		fields[path] = kind
+	}
+	return fields
+}
+
+// typesToUpdate returns the types of the status which are missing or miss fields
+func typesToUpdate(status MappingStatus, expected map[string]typeMapping) []string {
+	types := make(map[string]bool)
+	for _, t := range status.MissingTypes {
+		types[t] = true
+	}
+	for _, field := range status.MissingFields {
+		for t := range expected {
+			if t == "" || strings.HasPrefix(field, t+".") {
+				types[t] = true
+			}
+		}
+	}
+
+	var names []string
+	for t := range types {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mappingESMock serves the mappings of its indices, and records the created indices and the updated types
+type mappingESMock struct {
+	sync.Mutex
+	mappings map[string]map[string]interface{}
+	created  map[string]string
+	updated  []string
+	*httptest.Server
+}
+
+func newMappingESMock(t *testing.T, mappings map[string]string) *mappingESMock {
+	m := &mappingESMock{mappings: make(map[string]map[string]interface{}), created: make(map[string]string)}
+	for index, mapping := range mappings {
+		var types map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(mapping), &types))
+		m.mappings[index] = types
+	}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	return m
+}
+
+func (m *mappingESMock) serve(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodHead:
+	case r.Method == http.MethodGet && len(path) == 3 && path[1] == "_mapping":
+		types, found := m.mappings[path[0]]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error":{"type":"index_not_found_exception","reason":"no such index","index":"%s"},"status":404}`, path[0])
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{path[0]: map[string]interface{}{"mappings": types}})
+	case r.Method == http.MethodPut && len(path) == 1:
+		body, _ := ioutil.ReadAll(r.Body)
+		m.created[path[0]] = string(body)
+		var index struct {
+			Mappings map[string]interface{} `json:"mappings"`
+		}
+		json.Unmarshal(body, &index)
+		m.mappings[path[0]] = index.Mappings
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	case r.Method == http.MethodPut && len(path) == 3 && path[1] == "_mapping":
+		var types map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&types); err != nil || types[path[2]] == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.updated = append(m.updated, path[2])
+		m.mappings[path[0]][path[2]] = types[path[2]]
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// embeddedTypes returns the embedded mapping of every type, with the changes of the types given
+func embeddedTypes(t *testing.T, changes map[string]string) string {
+	expected, err := parseEmbeddedMapping()
+	require.NoError(t, err)
+
+	types := make(map[string]interface{})
+	for name, mapping := range expected.Mappings {
+		types[name] = mapping
+	}
+	for name, mapping := range changes {
+		if mapping == "" {
+			delete(types, name)
+			continue
+		}
+		types[name] = json.RawMessage(mapping)
+	}
+	data, err := json.Marshal(types)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func newMappingTestService(t *testing.T, url string) *esService {
+	return &esService{elasticClient: getElasticClient(t, url), indexName: indexName}
+}
+
+func TestEmbeddedMapping(t *testing.T) {
+	expected, err := parseEmbeddedMapping()
+	require.NoError(t, err)
+
+	for _, conceptType := range []string{"alphaville-series", "brands", "genres", "locations", "organisations", "people", "sections", "subjects", "topics"} {
+		require.Contains(t, expected.Mappings, conceptType)
+		fields := flattenProperties(conceptType, expected.Mappings[conceptType].Properties)
+		assert.Equal(t, "keyword", fields[conceptType+".id"])
+		assert.Equal(t, "completion", fields[conceptType+".prefLabel.mentionsCompletion"])
+		assert.Equal(t, "object", fields[conceptType+".metrics"])
+		assert.Equal(t, "integer", fields[conceptType+".metrics.annotationsCount"])
+	}
+	assert.Contains(t, flattenProperties("people", expected.Mappings["people"].Properties), "people.isFTAuthor")
+}
+
+func TestCheckMapping(t *testing.T) {
+	m := newMappingESMock(t, map[string]string{
+		indexName: embeddedTypes(t, map[string]string{
+			"topics":        "",
+			"people":        `{"properties":{"id":{"type":"keyword"},"prefLabel":{"type":"text"},"isFTAuthor":{"type":"boolean"},"legacy":{"type":"keyword"}}}`,
+			"organisations": `{"properties":{"id":{"type":"keyword"}}}`,
+		}),
+	})
+	defer m.Close()
+	es := newMappingTestService(t, m.URL)
+
+	_, err := es.MappingStatus()
+	assert.Equal(t, ErrMappingNotChecked, err)
+
+	status, err := es.CheckMapping(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, indexName, status.Index)
+	assert.True(t, status.Exists)
+	assert.False(t, status.InSync())
+	assert.Equal(t, []string{"topics"}, status.MissingTypes)
+	assert.Contains(t, status.MissingFields, "people.prefLabel.raw")
+	assert.Contains(t, status.MissingFields, "organisations.metrics.annotationsCount")
+	assert.NotContains(t, status.MissingFields, "people.id")
+	assert.Equal(t, []MappingConflict{{Field: "people.isFTAuthor", Expected: "keyword", Actual: "boolean"}}, status.Conflicts)
+	assert.Equal(t, []string{"people.legacy"}, status.UnexpectedFields)
+
+	cached, err := es.MappingStatus()
+	require.NoError(t, err)
+	assert.Equal(t, status, cached, "the status of the index should be cached")
+
+	status, err = es.CheckMapping(context.Background(), "concept-0.0.2")
+	require.NoError(t, err)
+	assert.False(t, status.Exists)
+	assert.Len(t, status.MissingTypes, 9)
+	cached, _ = es.MappingStatus()
+	assert.Equal(t, indexName, cached.Index, "only the status of the index the service writes to should be cached")
+}
+
+func TestCheckMappingInSync(t *testing.T) {
+	m := newMappingESMock(t, map[string]string{indexName: embeddedTypes(t, nil)})
+	defer m.Close()
+
+	status, err := newMappingTestService(t, m.URL).CheckMapping(context.Background(), "")
+	require.NoError(t, err)
+	assert.True(t, status.InSync())
+	assert.Empty(t, status.UnexpectedFields)
+}
+
+func TestApplyMappingCreatesIndex(t *testing.T) {
+	m := newMappingESMock(t, nil)
+	defer m.Close()
+
+	status, err := newMappingTestService(t, m.URL).ApplyMapping(context.Background(), "concept-0.0.2")
+	require.NoError(t, err)
+	assert.True(t, status.InSync())
+	assert.JSONEq(t, string(embeddedMapping), m.created["concept-0.0.2"], "the index should be created with the embedded settings and mapping")
+}
+
+func TestApplyMappingAddsMissingFields(t *testing.T) {
+	m := newMappingESMock(t, map[string]string{
+		indexName: embeddedTypes(t, map[string]string{
+			"topics":        "",
+			"organisations": `{"properties":{"id":{"type":"keyword"}}}`,
+		}),
+	})
+	defer m.Close()
+
+	status, err := newMappingTestService(t, m.URL).ApplyMapping(context.Background(), "")
+	require.NoError(t, err)
+	assert.True(t, status.InSync())
+	assert.Equal(t, []string{"organisations", "topics"}, m.updated)
+	assert.Empty(t, m.created)
+}
+
+func TestApplyMappingRefusesConflicts(t *testing.T) {
+	m := newMappingESMock(t, map[string]string{
+		indexName: embeddedTypes(t, map[string]string{
+			"topics": "",
+			"people": `{"properties":{"isFTAuthor":{"type":"boolean"}}}`,
+		}),
+	})
+	defer m.Close()
+
+	status, err := newMappingTestService(t, m.URL).ApplyMapping(context.Background(), "")
+	assert.Equal(t, ErrMappingConflict, err)
+	assert.Len(t, status.Conflicts, 1)
+	assert.Empty(t, m.updated, "nothing should be changed if a field conflicts")
+}
+
+func TestMappingWithoutClient(t *testing.T) {
+	es := &esService{indexName: indexName}
+	_, err := es.CheckMapping(context.Background(), "")
+	assert.Equal(t, ErrNoElasticClient, err)
+	_, err = es.ApplyMapping(context.Background(), "")
+	assert.Equal(t, ErrNoElasticClient, err)
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+func init() {
+	RegisterConverter(memberships, MembershipConverter{})
+}
+
+var errIncompleteMembership = errors.New("membership without person or organisation")
+
+// MembershipConverter converts memberships into EsMembershipModel documents. Memberships are not stored,
+// they flag the person they belong to as an FT author, so the data they preserve is the one of the person.
+type MembershipConverter struct {
+	DefaultConverter
+}
+
+func (MembershipConverter) ConvertAggregate(concept AggregateConceptModel, conceptType string, publishRef string) EsModel {
+	ms := make([]string, len(concept.MembershipRoles))
+	for i, m := range concept.MembershipRoles {
+		ms[i] = m.RoleUUID
+	}
+	return &EsMembershipModel{
+		Id:             concept.PrefUUID,
+		PersonId:       concept.PersonUUID,
+		OrganisationId: concept.OrganisationUUID,
+		Memberships:    ms,
+	}
+}
+
+func (MembershipConverter) Validate(concept AggregateConceptModel) error {
+	if concept.PersonUUID == "" || concept.OrganisationUUID == "" {
+		return errIncompleteMembership
+	}
+	return nil
+}
+
+func (MembershipConverter) PatchData(stored json.RawMessage) (PayloadPatch, error) {
+	esConcept := new(EsPersonConceptModel)
+	if err := json.Unmarshal(stored, esConcept); err != nil {
+		return nil, err
+	}
+	return &EsPersonConceptPatch{Metrics: esConcept.Metrics, IsFTAuthor: "true"}, nil // we only process FT members who are FT authors
+}
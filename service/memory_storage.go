@@ -0,0 +1,423 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const memoryScrollPageSize = 1000
+
+// MemoryStorage keeps the concepts in memory, for development and tests. It behaves as an Elasticsearch 5 cluster
+// with typed indices: reading a missing document or index, updating a document changed by a concurrent write,
+// and writing to an index blocked for writes fail with the errors Elasticsearch responds with.
+// An index is created by its first write, as Elasticsearch does by default.
+type MemoryStorage struct {
+	sync.RWMutex
+	indices map[string]*memoryIndex
+}
+
+type memoryIndex struct {
+	docs         map[memoryKey]*memoryDocument
+	writeBlocked bool
+}
+
+type memoryKey struct {
+	conceptType string
+	uuid        string
+}
+
+type memoryDocument struct {
+	source  json.RawMessage
+	version int64
+}
+
+// NewMemoryStorage returns an empty storage with the indices given
+func NewMemoryStorage(indices ...string) *MemoryStorage {
+	s := &MemoryStorage{indices: make(map[string]*memoryIndex)}
+	for _, index := range indices {
+		s.indices[index] = newMemoryIndex()
+	}
+	return s
+}
+
+func newMemoryIndex() *memoryIndex {
+	return &memoryIndex{docs: make(map[memoryKey]*memoryDocument)}
+}
+
+// SetWriteBlock blocks or unblocks the writes to an index, as the index.blocks.write setting does
+func (s *MemoryStorage) SetWriteBlock(index string, blocked bool) error {
+	s.Lock()
+	defer s.Unlock()
+
+	idx, found := s.indices[index]
+	if !found {
+		return indexNotFoundError(index)
+	}
+	idx.writeBlocked = blocked
+	return nil
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, index string, conceptType string, uuid string) (*elastic.GetResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	idx, found := s.indices[index]
+	if !found {
+		return nil, indexNotFoundError(index)
+	}
+	doc, found := idx.docs[memoryKey{conceptType, uuid}]
+	if !found {
+		return nil, &elastic.Error{Status: http.StatusNotFound}
+	}
+
+	source := doc.copySource()
+	version := doc.version
+	return &elastic.GetResult{Index: index, Type: conceptType, Id: uuid, Version: &version, Source: &source, Found: true}, nil
+}
+
+func (s *MemoryStorage) Index(ctx context.Context, index string, conceptType string, uuid string, doc interface{}) (*elastic.IndexResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	source, err := objectSource(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	idx, found := s.indices[index]
+	if !found {
+		idx = newMemoryIndex()
+		s.indices[index] = idx
+	}
+	if idx.writeBlocked {
+		return nil, writeBlockedError()
+	}
+
+	key := memoryKey{conceptType, uuid}
+	result := "created"
+	version := int64(1)
+	if current, found := idx.docs[key]; found {
+		result = "updated"
+		version = current.version + 1
+	}
+	idx.docs[key] = &memoryDocument{source: source, version: version}
+	return &elastic.IndexResponse{Index: index, Type: conceptType, Id: uuid, Version: version, Result: result, Status: http.StatusOK}, nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, index string, conceptType string, uuid string) (*elastic.DeleteResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	notFound := &elastic.DeleteResponse{Index: index, Type: conceptType, Id: uuid, Result: "not_found", Found: false}
+	idx, found := s.indices[index]
+	if !found {
+		return notFound, nil
+	}
+	if idx.writeBlocked {
+		return nil, writeBlockedError()
+	}
+
+	key := memoryKey{conceptType, uuid}
+	current, found := idx.docs[key]
+	if !found {
+		return notFound, nil
+	}
+	delete(idx.docs, key)
+	return &elastic.DeleteResponse{Index: index, Type: conceptType, Id: uuid, Version: current.version + 1, Result: "deleted", Found: true}, nil
+}
+
+// Update merges the fields of doc as Elasticsearch does, without retrying on a conflict: the update fails with a version conflict
+// if the document is changed while the fields are merged. The version of the document is unchanged if the fields already have the values of doc.
+func (s *MemoryStorage) Update(ctx context.Context, index string, conceptType string, uuid string, doc interface{}) (*elastic.UpdateResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	patch, err := objectSource(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	key := memoryKey{conceptType, uuid}
+	current, err := s.readForUpdate(index, key)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := mergeSources(current.source, patch)
+	if err != nil {
+		return nil, err
+	}
+	return s.applyUpdate(index, key, current.version, merged)
+}
+
+// applyUpdate stores the merged document, unless the document has been changed since the version it was merged from was read
+func (s *MemoryStorage) applyUpdate(index string, key memoryKey, version int64, merged json.RawMessage) (*elastic.UpdateResponse, error) {
+	conceptType, uuid := key.conceptType, key.uuid
+	s.Lock()
+	defer s.Unlock()
+
+	idx, found := s.indices[index]
+	if !found {
+		return nil, indexNotFoundError(index)
+	}
+	if idx.writeBlocked {
+		return nil, writeBlockedError()
+	}
+	stored, found := idx.docs[key]
+	if !found {
+		return nil, documentMissingError(index, key)
+	}
+	if stored.version != version {
+		return nil, &elastic.Error{Status: http.StatusConflict, Details: &elastic.ErrorDetails{
+			Type:   "version_conflict_engine_exception",
+			Reason: fmt.Sprintf("[%s][%s]: version conflict, current version [%d] is different than the one provided [%d]", conceptType, uuid, stored.version, version),
+			Index:  index,
+		}}
+	}
+
+	resp := &elastic.UpdateResponse{Index: index, Type: conceptType, Id: uuid, Version: int(stored.version), Result: UnchangedResult}
+	if !bytes.Equal(merged, stored.source) {
+		idx.docs[key] = &memoryDocument{source: merged, version: stored.version + 1}
+		resp.Version++
+		resp.Result = "updated"
+	}
+	return resp, nil
+}
+
+// readForUpdate returns a copy of the document to update
+func (s *MemoryStorage) readForUpdate(index string, key memoryKey) (memoryDocument, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	idx, found := s.indices[index]
+	if !found {
+		return memoryDocument{}, indexNotFoundError(index)
+	}
+	if idx.writeBlocked {
+		return memoryDocument{}, writeBlockedError()
+	}
+	doc, found := idx.docs[key]
+	if !found {
+		return memoryDocument{}, documentMissingError(index, key)
+	}
+	return memoryDocument{source: doc.copySource(), version: doc.version}, nil
+}
+
+func (s *MemoryStorage) FindConceptTypes(ctx context.Context, index string, uuids []string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	idx, found := s.indices[index]
+	if !found {
+		return nil, indexNotFoundError(index)
+	}
+
+	wanted := make(map[string]bool, len(uuids))
+	for _, uuid := range uuids {
+		wanted[uuid] = true
+	}
+	conceptTypeMap := make(map[string]string)
+	for _, key := range idx.sortedKeys() {
+		if wanted[key.uuid] {
+			conceptTypeMap[key.uuid] = key.conceptType
+		}
+	}
+	return conceptTypeMap, nil
+}
+
+// Scroll returns the documents as they were when the scroll started, ordered by uuid and type
+func (s *MemoryStorage) Scroll(ctx context.Context, index string, fetchSource bool, handle func(res *elastic.SearchResult) error) error {
+	hits, err := s.snapshot(index, fetchSource)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(hits); start += memoryScrollPageSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + memoryScrollPageSize
+		if end > len(hits) {
+			end = len(hits)
+		}
+		res := &elastic.SearchResult{
+			ScrollId: fmt.Sprintf("memory-%s-%d", index, start),
+			Hits:     &elastic.SearchHits{TotalHits: int64(len(hits)), Hits: hits[start:end]},
+		}
+		if err := handle(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) snapshot(index string, fetchSource bool) ([]*elastic.SearchHit, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	idx, found := s.indices[index]
+	if !found {
+		return nil, indexNotFoundError(index)
+	}
+
+	keys := idx.sortedKeys()
+	hits := make([]*elastic.SearchHit, 0, len(keys))
+	for _, key := range keys {
+		hit := &elastic.SearchHit{Index: index, Type: key.conceptType, Id: key.uuid}
+		if fetchSource {
+			source := idx.docs[key].copySource()
+			hit.Source = &source
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+func (s *MemoryStorage) ClusterHealth(ctx context.Context) (*elastic.ClusterHealthResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	return &elastic.ClusterHealthResponse{
+		ClusterName:                 "memory",
+		Status:                      "green",
+		NumberOfNodes:               1,
+		NumberOfDataNodes:           1,
+		ActivePrimaryShards:         len(s.indices),
+		ActiveShards:                len(s.indices),
+		ActiveShardsPercentAsNumber: 100,
+	}, nil
+}
+
+// IndexSettings returns the settings as strings, as Elasticsearch does
+func (s *MemoryStorage) IndexSettings(ctx context.Context, index string) (map[string]*elastic.IndicesGetSettingsResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.RLock()
+	defer s.RUnlock()
+
+	idx, found := s.indices[index]
+	if !found {
+		return nil, indexNotFoundError(index)
+	}
+
+	settings := map[string]interface{}{
+		"number_of_shards":   "1",
+		"number_of_replicas": "0",
+		"provided_name":      index,
+	}
+	if idx.writeBlocked {
+		settings["blocks"] = map[string]interface{}{"write": "true"}
+	}
+	return map[string]*elastic.IndicesGetSettingsResponse{
+		index: {Settings: map[string]interface{}{"index": settings}},
+	}, nil
+}
+
+func (idx *memoryIndex) sortedKeys() []memoryKey {
+	keys := make([]memoryKey, 0, len(idx.docs))
+	for key := range idx.docs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].uuid != keys[j].uuid {
+			return keys[i].uuid < keys[j].uuid
+		}
+		return keys[i].conceptType < keys[j].conceptType
+	})
+	return keys
+}
+
+func (d *memoryDocument) copySource() json.RawMessage {
+	return append(json.RawMessage(nil), d.source...)
+}
+
+// objectSource returns the JSON of a document, which must be an object as Elasticsearch requires
+func objectSource(doc interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return nil, &elastic.Error{Status: http.StatusBadRequest, Details: &elastic.ErrorDetails{
+			Type:   "mapper_parsing_exception",
+			Reason: "failed to parse, document is empty or not an object",
+		}}
+	}
+	return json.Marshal(fields)
+}
+
+// mergeSources merges the fields of the patch into the source: objects are merged recursively, other values are replaced
+func mergeSources(source json.RawMessage, patch json.RawMessage) (json.RawMessage, error) {
+	var current, changes map[string]interface{}
+	if err := json.Unmarshal(source, &current); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeObjects(current, changes))
+}
+
+func mergeObjects(current map[string]interface{}, changes map[string]interface{}) map[string]interface{} {
+	if current == nil {
+		current = make(map[string]interface{})
+	}
+	for k, v := range changes {
+		currentObject, currentIsObject := current[k].(map[string]interface{})
+		changedObject, changeIsObject := v.(map[string]interface{})
+		if currentIsObject && changeIsObject {
+			current[k] = mergeObjects(currentObject, changedObject)
+			continue
+		}
+		current[k] = v
+	}
+	return current
+}
+
+func indexNotFoundError(index string) error {
+	return &elastic.Error{Status: http.StatusNotFound, Details: &elastic.ErrorDetails{
+		Type:         "index_not_found_exception",
+		Reason:       "no such index",
+		ResourceType: "index_or_alias",
+		ResourceId:   index,
+		Index:        index,
+	}}
+}
+
+func documentMissingError(index string, key memoryKey) error {
+	return &elastic.Error{Status: http.StatusNotFound, Details: &elastic.ErrorDetails{
+		Type:   "document_missing_exception",
+		Reason: fmt.Sprintf("[%s][%s]: document missing", key.conceptType, key.uuid),
+		Index:  index,
+	}}
+}
+
+func writeBlockedError() error {
+	return &elastic.Error{Status: http.StatusForbidden, Details: &elastic.ErrorDetails{
+		Type:   clusterBlockException,
+		Reason: "blocked by: [FORBIDDEN/8/index write (api)];",
+	}}
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const memoryUUID = "3b7cdfea-6d8a-4b17-9a1b-3bd4e2b2f8c1"
+
+func TestMemoryStorageDocuments(t *testing.T) {
+	s := NewMemoryStorage()
+	ctx := context.Background()
+
+	_, err := s.Get(ctx, indexName, "people", memoryUUID)
+	assert.True(t, elastic.IsNotFound(err), "a missing index should not be found")
+
+	resp, err := s.Index(ctx, indexName, "people", memoryUUID, map[string]interface{}{"prefLabel": "Jane"})
+	require.NoError(t, err)
+	assert.Equal(t, "created", resp.Result)
+	assert.Equal(t, int64(1), resp.Version)
+
+	resp, err = s.Index(ctx, indexName, "people", memoryUUID, map[string]interface{}{"prefLabel": "Jane Doe"})
+	require.NoError(t, err)
+	assert.Equal(t, "updated", resp.Result)
+	assert.Equal(t, int64(2), resp.Version)
+
+	read, err := s.Get(ctx, indexName, "people", memoryUUID)
+	require.NoError(t, err)
+	assert.True(t, read.Found)
+	assert.Equal(t, int64(2), *read.Version)
+	assert.JSONEq(t, `{"prefLabel":"Jane Doe"}`, string(*read.Source))
+
+	_, err = s.Get(ctx, indexName, "organisations", memoryUUID)
+	assert.True(t, elastic.IsNotFound(err), "the concept should not be found with another type")
+
+	_, err = s.Index(ctx, indexName, "people", memoryUUID, []string{"not", "an", "object"})
+	assert.True(t, elastic.IsStatusCode(err, 400), "a document should be an object")
+
+	deleted, err := s.Delete(ctx, indexName, "organisations", memoryUUID)
+	require.NoError(t, err)
+	assert.False(t, deleted.Found)
+
+	deleted, err = s.Delete(ctx, indexName, "people", memoryUUID)
+	require.NoError(t, err)
+	assert.True(t, deleted.Found)
+	assert.Equal(t, "deleted", deleted.Result)
+
+	_, err = s.Get(ctx, indexName, "people", memoryUUID)
+	assert.True(t, elastic.IsNotFound(err))
+}
+
+func TestMemoryStorageUpdate(t *testing.T) {
+	s := NewMemoryStorage(indexName)
+	ctx := context.Background()
+
+	_, err := s.Update(ctx, indexName, "people", memoryUUID, map[string]interface{}{"isFTAuthor": "true"})
+	var esErr *elastic.Error
+	require.True(t, errors.As(err, &esErr))
+	assert.Equal(t, 404, esErr.Status)
+	assert.Equal(t, "document_missing_exception", esErr.Details.Type)
+
+	_, err = s.Index(ctx, indexName, "people", memoryUUID, map[string]interface{}{
+		"prefLabel": "Jane",
+		"metrics":   map[string]interface{}{"annotationsCount": 1, "prevWeekAnnotationsCount": 2},
+		"aliases":   []string{"J"},
+	})
+	require.NoError(t, err)
+
+	updated, err := s.Update(ctx, indexName, "people", memoryUUID, map[string]interface{}{
+		"metrics": map[string]interface{}{"annotationsCount": 3},
+		"aliases": []string{"JD"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "updated", updated.Result)
+	assert.Equal(t, 2, updated.Version)
+	read, err := s.Get(ctx, indexName, "people", memoryUUID)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"prefLabel":"Jane","metrics":{"annotationsCount":3,"prevWeekAnnotationsCount":2},"aliases":["JD"]}`, string(*read.Source),
+		"the objects should be merged, the other values replaced")
+
+	updated, err = s.Update(ctx, indexName, "people", memoryUUID, map[string]interface{}{"prefLabel": "Jane"})
+	require.NoError(t, err)
+	assert.Equal(t, "noop", updated.Result)
+	assert.Equal(t, 2, updated.Version)
+}
+
+func TestMemoryStorageUpdateVersionConflict(t *testing.T) {
+	s := NewMemoryStorage(indexName)
+	ctx := context.Background()
+	_, err := s.Index(ctx, indexName, "people", memoryUUID, map[string]interface{}{"prefLabel": "Jane"})
+	require.NoError(t, err)
+
+	// a write lands between the read and the merge of the update
+	key := memoryKey{"people", memoryUUID}
+	current, err := s.readForUpdate(indexName, key)
+	require.NoError(t, err)
+	_, err = s.Index(ctx, indexName, "people", memoryUUID, map[string]interface{}{"prefLabel": "Jane Doe"})
+	require.NoError(t, err)
+	merged, err := mergeSources(current.source, json.RawMessage(`{"isFTAuthor":"true"}`))
+	require.NoError(t, err)
+
+	_, err = s.applyUpdate(indexName, key, current.version, merged)
+	assert.True(t, IsStaleVersion(err), "an update should conflict with a concurrent write")
+	assert.Contains(t, err.Error(), "version_conflict_engine_exception")
+
+	read, err := s.Get(ctx, indexName, "people", memoryUUID)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"prefLabel":"Jane Doe"}`, string(*read.Source), "the concurrent write should be kept")
+}
+
+func TestMemoryStorageWriteBlock(t *testing.T) {
+	s := NewMemoryStorage(indexName)
+	ctx := context.Background()
+	_, err := s.Index(ctx, indexName, "people", memoryUUID, map[string]interface{}{"prefLabel": "Jane"})
+	require.NoError(t, err)
+
+	assert.True(t, elastic.IsNotFound(s.SetWriteBlock("missing", true)))
+	require.NoError(t, s.SetWriteBlock(indexName, true))
+
+	settings, err := s.IndexSettings(ctx, indexName)
+	require.NoError(t, err)
+	readOnly, err := (&esService{}).isIndexReadOnly(settings[indexName].Settings)
+	require.NoError(t, err)
+	assert.True(t, readOnly)
+
+	_, err = s.Index(ctx, indexName, "people", memoryUUID, map[string]interface{}{"prefLabel": "Jane Doe"})
+	assert.True(t, IsWriteBlockError(err))
+	_, err = s.Update(ctx, indexName, "people", memoryUUID, map[string]interface{}{"prefLabel": "Jane Doe"})
+	assert.True(t, IsWriteBlockError(err))
+	_, err = s.Delete(ctx, indexName, "people", memoryUUID)
+	assert.True(t, IsWriteBlockError(err))
+
+	read, err := s.Get(ctx, indexName, "people", memoryUUID)
+	require.NoError(t, err, "the index should still be readable")
+	assert.JSONEq(t, `{"prefLabel":"Jane"}`, string(*read.Source))
+
+	require.NoError(t, s.SetWriteBlock(indexName, false))
+	settings, err = s.IndexSettings(ctx, indexName)
+	require.NoError(t, err)
+	readOnly, err = (&esService{}).isIndexReadOnly(settings[indexName].Settings)
+	require.NoError(t, err)
+	assert.False(t, readOnly)
+}
+
+func TestMemoryStorageScroll(t *testing.T) {
+	s := NewMemoryStorage(indexName)
+	ctx := context.Background()
+	for i := 0; i < 1500; i++ {
+		_, err := s.Index(ctx, indexName, "brands", fmt.Sprintf("%04d", i), map[string]interface{}{"id": i})
+		require.NoError(t, err)
+	}
+	_, err := s.Index(ctx, indexName, "people", "0001", map[string]interface{}{"id": 1})
+	require.NoError(t, err)
+
+	types, err := s.FindConceptTypes(ctx, indexName, []string{"0002", "9999"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"0002": "brands"}, types)
+
+	var pages []int
+	var ids []EsIDTypePair
+	err = s.Scroll(ctx, indexName, false, func(res *elastic.SearchResult) error {
+		pages = append(pages, len(res.Hits.Hits))
+		assert.Equal(t, int64(1501), res.Hits.TotalHits)
+		for _, hit := range res.Hits.Hits {
+			assert.Nil(t, hit.Source)
+			ids = append(ids, EsIDTypePair{ID: hit.Id, Type: hit.Type})
+		}
+		// the scroll returns the documents as they were when it started
+		_, err := s.Index(ctx, indexName, "topics", "new", map[string]interface{}{})
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1000, 501}, pages)
+	assert.Equal(t, EsIDTypePair{ID: "0001", Type: "brands"}, ids[1])
+	assert.Equal(t, EsIDTypePair{ID: "0001", Type: "people"}, ids[2])
+
+	err = s.Scroll(ctx, indexName, true, func(res *elastic.SearchResult) error {
+		require.NotNil(t, res.Hits.Hits[0].Source)
+		assert.JSONEq(t, `{"id":0}`, string(*res.Hits.Hits[0].Source))
+		return errTestStop
+	})
+	assert.Equal(t, errTestStop, err)
+
+	err = s.Scroll(ctx, "missing", false, func(res *elastic.SearchResult) error { return nil })
+	assert.True(t, elastic.IsNotFound(err))
+}
+
+var errTestStop = errors.New("stop")
+
+func TestMemoryStorageService(t *testing.T) {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithStorage(NewMemoryStorage(indexName))).(*esService)
+	defer es.CloseBulkProcessor()
+
+	health, err := es.GetClusterHealth(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "green", health.Status)
+
+	_, updated, _, err := writeTestDocument(es, "people", memoryUUID)
+	require.NoError(t, err)
+	assert.True(t, updated)
+
+	read, err := es.ReadData(context.Background(), "people", memoryUUID)
+	require.NoError(t, err)
+	assert.True(t, read.Found)
+
+	es.PatchUpdateConcept(newTestContext(), "people", memoryUUID, &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 4}})
+	es.LoadBulkData(context.Background(), "brands", "bulk-brand", EsConceptModel{Id: "bulk-brand", PrefLabel: "Brand"})
+	require.NoError(t, es.bulkProcessor.Flush())
+
+	read, err = es.ReadData(context.Background(), "people", memoryUUID)
+	require.NoError(t, err)
+	var person EsConceptModel
+	require.NoError(t, json.Unmarshal(*read.Source, &person))
+	require.NotNil(t, person.Metrics)
+	assert.Equal(t, 4, person.Metrics.AnnotationsCount)
+
+	var ids []EsIDTypePair
+	for id := range es.GetAllIds(context.Background()) {
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []EsIDTypePair{{ID: memoryUUID, Type: "people"}, {ID: "bulk-brand", Type: "brands"}}, ids)
+
+	deleted, err := es.DeleteData(newTestContext(), "people", memoryUUID)
+	require.NoError(t, err)
+	assert.True(t, deleted.Found)
+
+	_, err = es.CheckMapping(context.Background(), "")
+	assert.Equal(t, ErrStorageUnsupported, err)
+	_, err = es.MappingStatus()
+	assert.Equal(t, ErrStorageUnsupported, err)
+	_, err = es.StartReindex("concepts-new")
+	assert.Equal(t, ErrStorageUnsupported, err)
+}
+
+func TestMemoryStorageServiceWriteBlock(t *testing.T) {
+	storage := NewMemoryStorage(indexName)
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithStorage(storage), WithWriteBlockCheck(time.Hour, 10)).(*esService)
+	defer es.CloseBulkProcessor()
+
+	require.NoError(t, storage.SetWriteBlock(indexName, true))
+	es.LoadBulkData(context.Background(), "brands", "parked-brand", EsConceptModel{Id: "parked-brand"})
+	require.NoError(t, es.bulkProcessor.Flush())
+	assert.Equal(t, 1, es.writeBlock.parkedCount(), "the bulk request refused by the block should be parked")
+
+	_, _, _, err := writeTestDocument(es, "people", memoryUUID)
+	var blockedErr *WriteBlockedError
+	require.True(t, errors.As(err, &blockedErr), "the write should be refused while the index is blocked")
+
+	require.NoError(t, storage.SetWriteBlock(indexName, false))
+	require.NoError(t, es.RefreshWriteBlock(context.Background()))
+	require.NoError(t, es.bulkProcessor.Flush())
+	assert.Equal(t, 0, es.writeBlock.parkedCount())
+
+	read, err := es.ReadData(context.Background(), "brands", "parked-brand")
+	require.NoError(t, err)
+	assert.True(t, read.Found, "the parked request should be applied once the index is unblocked")
+}
@@ -71,6 +71,7 @@ type EsConceptModel struct {
 	CountryCode            string          `json:"countryCode,omitempty"`
 	CountryOfIncorporation string          `json:"countryOfIncorporation,omitempty"`
 	Metrics                *ConceptMetrics `json:"metrics,omitempty"`
+	ContentHash            string          `json:"contentHash,omitempty"`
 }
 
 type EsMembershipModel struct {
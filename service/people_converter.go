@@ -0,0 +1,43 @@
+package service
+
+import "encoding/json"
+
+func init() {
+	RegisterConverter(person, PeopleConverter{})
+}
+
+// PeopleConverter converts people into EsPersonConceptModel documents. The FT author flag is controlled by
+// memberships, so it is preserved together with the metrics.
+type PeopleConverter struct {
+	DefaultConverter
+}
+
+func (c PeopleConverter) Convert(concept ConceptModel, conceptType string, publishRef string) EsModel {
+	// person type should not come through as the old model.
+	return &EsPersonConceptModel{
+		EsConceptModel: c.DefaultConverter.Convert(concept, conceptType, publishRef).(*EsConceptModel),
+	}
+}
+
+func (PeopleConverter) ConvertAggregate(concept AggregateConceptModel, conceptType string, publishRef string) EsModel {
+	return &EsPersonConceptModel{
+		EsConceptModel: getEsConcept(concept, conceptType, publishRef),
+		IsFTAuthor:     defaultIsFTAuthor, // default as controlled by memberships concept
+	}
+}
+
+func (PeopleConverter) PatchData(stored json.RawMessage) (PayloadPatch, error) {
+	esConcept := new(EsPersonConceptModel)
+	if err := json.Unmarshal(stored, esConcept); err != nil {
+		return nil, err
+	}
+	return &EsPersonConceptPatch{Metrics: esConcept.Metrics, IsFTAuthor: esConcept.IsFTAuthor}, nil
+}
+
+func (PeopleConverter) Upgrade(stored json.RawMessage) (EsModel, error) {
+	esConcept := new(EsPersonConceptModel)
+	if err := json.Unmarshal(stored, esConcept); err != nil {
+		return nil, err
+	}
+	return esConcept, nil
+}
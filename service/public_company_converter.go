@@ -0,0 +1,17 @@
+package service
+
+func init() {
+	RegisterDirectTypeConverter(organisation, directTypePublicCompany, PublicCompanyConverter{})
+}
+
+// PublicCompanyConverter adds the country of public companies to their documents
+type PublicCompanyConverter struct {
+	DefaultConverter
+}
+
+func (PublicCompanyConverter) ConvertAggregate(concept AggregateConceptModel, conceptType string, publishRef string) EsModel {
+	esConceptModel := getEsConcept(concept, conceptType, publishRef)
+	esConceptModel.CountryCode = concept.CountryCode
+	esConceptModel.CountryOfIncorporation = concept.CountryOfIncorporation
+	return esConceptModel
+}
@@ -0,0 +1,408 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	tid "github.com/Financial-Times/transactionid-utils-go"
+)
+
+// The states of a reconciliation
+const (
+	ReconciliationRunning   = "running"
+	ReconciliationCompleted = "completed"
+	ReconciliationFailed    = "failed"
+	ReconciliationCancelled = "cancelled"
+)
+
+// DefaultMaxExtraRatio is the share of the documents of the index above which the extra documents are not deleted
+const DefaultMaxExtraRatio = 0.1
+
+// maxReportedDocuments is the number of documents listed in each section of a reconciliation report, the counts include them all
+const maxReportedDocuments = 1000
+
+var (
+	ErrReconciliationRunning = errors.New("a reconciliation is already running")
+	ErrNoReconciliation      = errors.New("no reconciliation has been started")
+	ErrNoReferenceSource     = errors.New("no reference set has been uploaded, and no reference source is configured")
+	ErrEmptyReference        = errors.New("the reference set is empty, the extra documents are not deleted")
+	ErrTooManyExtras         = errors.New("too many documents of the index are extra, they are not deleted")
+)
+
+// ReferenceSource provides the reference set of concepts the index is reconciled with
+type ReferenceSource interface {
+	ReferenceSet(ctx context.Context) (ReferenceSet, error)
+}
+
+// ReferenceSet is the type of every concept which should be in the index, by uuid
+type ReferenceSet map[string]string
+
+// ReferenceSet returns the set itself, so that an uploaded set is a reference source
+func (s ReferenceSet) ReferenceSet(ctx context.Context) (ReferenceSet, error) {
+	return s, nil
+}
+
+type referenceLine struct {
+	UUID string `json:"uuid"`
+	Type string `json:"type"`
+}
+
+// ParseReferenceSet reads a reference set of one {"uuid":"...","type":"..."} object per line, the format of /__ids?includeTypes=true
+func ParseReferenceSet(r io.Reader) (ReferenceSet, error) {
+	set := make(ReferenceSet)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var ref referenceLine
+		if err := json.Unmarshal(scanner.Bytes(), &ref); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if ref.UUID == "" || ref.Type == "" {
+			return nil, fmt.Errorf("line %d: the uuid and the type are required", line)
+		}
+		if t, found := set[ref.UUID]; found && t != ref.Type {
+			return nil, fmt.Errorf("line %d: %s is listed with the types %s and %s", line, ref.UUID, t, ref.Type)
+		}
+		set[ref.UUID] = ref.Type
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// HTTPReferenceSource fetches the reference set from a URL serving it in the format read by ParseReferenceSet
+type HTTPReferenceSource struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewHTTPReferenceSource(url string, httpClient *http.Client) *HTTPReferenceSource {
+	return &HTTPReferenceSource{url: url, httpClient: httpClient}
+}
+
+func (s *HTTPReferenceSource) ReferenceSet(ctx context.Context) (ReferenceSet, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the reference source responded with status %d", resp.StatusCode)
+	}
+	return ParseReferenceSet(resp.Body)
+}
+
+// WithReferenceSource sets the source of the reference set used when none is uploaded. Nothing is done if the source is nil.
+func WithReferenceSource(source ReferenceSource) EsServiceOption {
+	return func(es *esService) {
+		if source == nil {
+			return
+		}
+		es.reconciler.source = source
+	}
+}
+
+// WithReconciliationDeleteLimit sets the share of the documents of the index, between 0 and 1, above which the extra documents
+// are not deleted, in case the reference set is incomplete. DefaultMaxExtraRatio is used if it is not positive.
+func WithReconciliationDeleteLimit(maxExtraRatio float64) EsServiceOption {
+	return func(es *esService) {
+		es.reconciler.maxExtraRatio = maxExtraRatio
+	}
+}
+
+// WrongTypeDocument is a concept of the reference set indexed with other types only
+type WrongTypeDocument struct {
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	IndexedTypes []string `json:"indexedTypes"`
+}
+
+// ReconciliationReport lists the differences between the index and the reference set
+type ReconciliationReport struct {
+	State        string     `json:"state"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+	DeleteExtras bool       `json:"deleteExtras"`
+	// the number of concepts of the reference set, and of documents of the index
+	Referenced int64 `json:"referenced"`
+	Indexed    int64 `json:"indexed"`
+	// Missing are the concepts of the reference set which are not indexed
+	Missing      []EsIDTypePair `json:"missing,omitempty"`
+	MissingCount int64          `json:"missingCount"`
+	// Extra are the documents of the index whose uuid and type are not in the reference set
+	Extra      []EsIDTypePair `json:"extra,omitempty"`
+	ExtraCount int64          `json:"extraCount"`
+	// WrongType are the concepts of the reference set indexed with other types only
+	WrongType      []WrongTypeDocument `json:"wrongType,omitempty"`
+	WrongTypeCount int64               `json:"wrongTypeCount"`
+	// Deleted is the number of extra documents deleted
+	Deleted      int64    `json:"deleted"`
+	DeleteErrors []string `json:"deleteErrors,omitempty"`
+	// Error is the error which stopped the reconciliation, if any
+	Error string `json:"error,omitempty"`
+}
+
+// reconciler holds the current or last reconciliation
+type reconciler struct {
+	sync.Mutex
+	source        ReferenceSource
+	maxExtraRatio float64
+	job           *reconciliationJob
+}
+
+type reconciliationJob struct {
+	sync.Mutex
+	report ReconciliationReport
+	cancel context.CancelFunc
+	// maxExtraRatio is the share of the documents of the index above which the extra documents are not deleted
+	maxExtraRatio float64
+}
+
+// StartReconciliation starts comparing the documents of the index with the reference set, or with the configured reference source if it is nil.
+// The extra documents are deleted if deleteExtras is true.
+func (es *esService) StartReconciliation(reference ReferenceSource, deleteExtras bool) (ReconciliationReport, error) {
+	es.RLock()
+	err := es.checkStorage()
+	es.RUnlock()
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+
+	es.reconciler.Lock()
+	defer es.reconciler.Unlock()
+
+	if reference == nil {
+		reference = es.reconciler.source
+	}
+	if reference == nil {
+		return ReconciliationReport{}, ErrNoReferenceSource
+	}
+	if es.reconciler.job != nil && es.reconciler.job.currentReport().State == ReconciliationRunning {
+		return ReconciliationReport{}, ErrReconciliationRunning
+	}
+
+	ctx, cancel := context.WithCancel(tid.TransactionAwareContext(context.Background(), tid.NewTransactionID()))
+	job := &reconciliationJob{
+		report:        ReconciliationReport{State: ReconciliationRunning, StartedAt: time.Now(), DeleteExtras: deleteExtras},
+		cancel:        cancel,
+		maxExtraRatio: es.reconciler.maxExtraRatio,
+	}
+	if job.maxExtraRatio <= 0 {
+		job.maxExtraRatio = DefaultMaxExtraRatio
+	}
+
+	log.WithField("deleteExtras", deleteExtras).Info("Reconciliation started")
+	es.reconciler.job = job
+	go job.run(ctx, es, reference)
+	return job.currentReport(), nil
+}
+
+// ReconciliationReport returns the report of the current or last reconciliation
+func (es *esService) ReconciliationReport() (ReconciliationReport, error) {
+	es.reconciler.Lock()
+	defer es.reconciler.Unlock()
+
+	if es.reconciler.job == nil {
+		return ReconciliationReport{}, ErrNoReconciliation
+	}
+	return es.reconciler.job.currentReport(), nil
+}
+
+// CancelReconciliation stops the current reconciliation. The extra documents already deleted are not restored.
+func (es *esService) CancelReconciliation() (ReconciliationReport, error) {
+	es.reconciler.Lock()
+	job := es.reconciler.job
+	es.reconciler.Unlock()
+
+	if job == nil {
+		return ReconciliationReport{}, ErrNoReconciliation
+	}
+	job.cancel()
+	return job.currentReport(), nil
+}
+
+func (j *reconciliationJob) run(ctx context.Context, es *esService, source ReferenceSource) {
+	defer j.cancel()
+
+	reference, err := source.ReferenceSet(ctx)
+	if err != nil {
+		j.finish(ctx, fmt.Errorf("failed to read the reference set: %w", err))
+		return
+	}
+	if len(reference) == 0 && j.report.DeleteExtras {
+		j.finish(ctx, ErrEmptyReference)
+		return
+	}
+
+	indexed := make(map[string][]string)
+	err = es.forEachId(ctx, func(id EsIDTypePair) {
+		indexed[id.ID] = append(indexed[id.ID], id.Type)
+	})
+	if err != nil {
+		j.finish(ctx, fmt.Errorf("failed to read the ids of the index: %w", err))
+		return
+	}
+
+	extra := j.compare(reference, indexed)
+	if j.report.DeleteExtras {
+		if err := j.checkExtras(len(extra)); err != nil {
+			j.finish(ctx, err)
+			return
+		}
+		for _, doc := range extra {
+			if ctx.Err() != nil {
+				break
+			}
+			j.deleteExtra(ctx, es, doc)
+		}
+	}
+	j.finish(ctx, ctx.Err())
+}
+
+// compare fills the report with the differences between the reference set and the indexed types by uuid, and returns every extra document
+func (j *reconciliationJob) compare(reference ReferenceSet, indexed map[string][]string) []EsIDTypePair {
+	j.Lock()
+	defer j.Unlock()
+
+	r := &j.report
+	r.Referenced = int64(len(reference))
+	var extra []EsIDTypePair
+	for id, types := range indexed {
+		r.Indexed += int64(len(types))
+		expected, referenced := reference[id]
+		if !referenced {
+			for _, t := range types {
+				extra = append(extra, EsIDTypePair{ID: id, Type: t})
+			}
+			continue
+		}
+
+		if !contains(types, expected) {
+			r.WrongTypeCount++
+			if len(r.WrongType) < maxReportedDocuments {
+				sort.Strings(types)
+				r.WrongType = append(r.WrongType, WrongTypeDocument{ID: id, Type: expected, IndexedTypes: types})
+			}
+			continue
+		}
+		// the documents lingering under another type than the referenced one
+		for _, t := range types {
+			if t != expected {
+				extra = append(extra, EsIDTypePair{ID: id, Type: t})
+			}
+		}
+	}
+
+	for id, t := range reference {
+		if _, found := indexed[id]; found {
+			continue
+		}
+		r.MissingCount++
+		if len(r.Missing) < maxReportedDocuments {
+			r.Missing = append(r.Missing, EsIDTypePair{ID: id, Type: t})
+		}
+	}
+
+	sortIDTypePairs(extra)
+	r.ExtraCount = int64(len(extra))
+	r.Extra = extra
+	if len(extra) > maxReportedDocuments {
+		r.Extra = append([]EsIDTypePair(nil), extra[:maxReportedDocuments]...)
+	}
+	sortIDTypePairs(r.Missing)
+	sort.Slice(r.WrongType, func(i, k int) bool { return r.WrongType[i].ID < r.WrongType[k].ID })
+	return extra
+}
+
+// checkExtras returns an error if the extra documents are too large a share of the documents of the index to be deleted
+func (j *reconciliationJob) checkExtras(extra int) error {
+	j.Lock()
+	indexed := j.report.Indexed
+	j.Unlock()
+
+	if float64(extra) > j.maxExtraRatio*float64(indexed) {
+		return fmt.Errorf("%w: %d of the %d documents, more than %v%%", ErrTooManyExtras, extra, indexed, j.maxExtraRatio*100)
+	}
+	return nil
+}
+
+func (j *reconciliationJob) deleteExtra(ctx context.Context, es *esService, doc EsIDTypePair) {
+	_, err := es.DeleteData(ctx, doc.Type, doc.ID)
+
+	j.Lock()
+	defer j.Unlock()
+	if err != nil {
+		if len(j.report.DeleteErrors) < maxReportedDocuments {
+			j.report.DeleteErrors = append(j.report.DeleteErrors, fmt.Sprintf("%s %s: %v", doc.Type, doc.ID, err))
+		}
+		return
+	}
+	j.report.Deleted++
+}
+
+func (j *reconciliationJob) finish(ctx context.Context, err error) {
+	j.Lock()
+	defer j.Unlock()
+
+	now := time.Now()
+	j.report.FinishedAt = &now
+	switch {
+	case ctx.Err() != nil && err != nil:
+		j.report.State = ReconciliationCancelled
+	case err != nil:
+		j.report.State = ReconciliationFailed
+		j.report.Error = err.Error()
+	default:
+		j.report.State = ReconciliationCompleted
+	}
+
+	log.WithField("state", j.report.State).WithField("missing", j.report.MissingCount).WithField("extra", j.report.ExtraCount).
+		WithField("wrongType", j.report.WrongTypeCount).WithField("deleted", j.report.Deleted).
+		Info("Reconciliation finished")
+}
+
+// currentReport returns a copy of the report
+func (j *reconciliationJob) currentReport() ReconciliationReport {
+	j.Lock()
+	defer j.Unlock()
+
+	report := j.report
+	report.DeleteErrors = append([]string(nil), j.report.DeleteErrors...)
+	return report
+}
+
+func sortIDTypePairs(ids []EsIDTypePair) {
+	sort.Slice(ids, func(i, k int) bool {
+		if ids[i].ID != ids[k].ID {
+			return ids[i].ID < ids[k].ID
+		}
+		return ids[i].Type < ids[k].Type
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reconcileESMock serves the ids of the index in a single scroll page, and records the deleted documents
+type reconcileESMock struct {
+	sync.Mutex
+	ids     []EsIDTypePair
+	deleted []string
+	*httptest.Server
+}
+
+func newReconcileESMock(ids ...EsIDTypePair) *reconcileESMock {
+	m := &reconcileESMock{ids: ids}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	return m
+}
+
+func (m *reconcileESMock) serve(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodHead:
+	case r.URL.Path == "/"+indexName+"/_search":
+		var hits []string
+		for _, id := range m.ids {
+			hits = append(hits, fmt.Sprintf(`{"_index":"%s","_type":"%s","_id":"%s"}`, indexName, id.Type, id.ID))
+		}
+		fmt.Fprintf(w, `{"_scroll_id":"scroll-1","hits":{"total":%d,"hits":[%s]}}`, len(hits), strings.Join(hits, ","))
+	case r.URL.Path == "/_search/scroll":
+		fmt.Fprint(w, `{"_scroll_id":"scroll-1","hits":{"total":0,"hits":[]}}`)
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/"+indexName+"/"):
+		doc := strings.TrimPrefix(r.URL.Path, "/"+indexName+"/")
+		if strings.HasSuffix(doc, "/failing") {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error":{"type":"exception","reason":"failure"},"status":500}`)
+			return
+		}
+		m.deleted = append(m.deleted, doc)
+		fmt.Fprint(w, `{"found":true,"result":"deleted"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *reconcileESMock) deletedDocs() []string {
+	m.Lock()
+	defer m.Unlock()
+
+	deleted := append([]string(nil), m.deleted...)
+	sort.Strings(deleted)
+	return deleted
+}
+
+func waitForReconciliation(t *testing.T, es *esService) ReconciliationReport {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		report, err := es.ReconciliationReport()
+		require.NoError(t, err)
+		if report.State != ReconciliationRunning || time.Now().After(deadline) {
+			return report
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+var reconciledIds = []EsIDTypePair{
+	{ID: "1", Type: "people"},
+	{ID: "2", Type: "organisations"},
+	{ID: "3", Type: "people"},
+	{ID: "3", Type: "organisations"},
+	{ID: "4", Type: "brands"},
+	{ID: "failing", Type: "topics"},
+}
+
+const reconciledReference = `{"uuid":"1","type":"people"}
+{"uuid":"2","type":"people"}
+{"uuid":"3","type":"people"}
+
+{"uuid":"5","type":"genres"}
+`
+
+func TestParseReferenceSet(t *testing.T) {
+	set, err := ParseReferenceSet(strings.NewReader(reconciledReference))
+	require.NoError(t, err)
+	assert.Equal(t, ReferenceSet{"1": "people", "2": "people", "3": "people", "5": "genres"}, set)
+
+	_, err = ParseReferenceSet(strings.NewReader(`{"uuid":"1","type":"people"}` + "\n{"))
+	assert.EqualError(t, err, "line 2: unexpected end of JSON input")
+	_, err = ParseReferenceSet(strings.NewReader(`{"uuid":"1"}`))
+	assert.EqualError(t, err, "line 1: the uuid and the type are required")
+	_, err = ParseReferenceSet(strings.NewReader(`{"uuid":"1","type":"people"}` + "\n" + `{"uuid":"1","type":"brands"}`))
+	assert.EqualError(t, err, "line 2: 1 is listed with the types people and brands")
+}
+
+func TestReconciliation(t *testing.T) {
+	m := newReconcileESMock(reconciledIds...)
+	defer m.Close()
+	es := &esService{elasticClient: getElasticClient(t, m.URL), indexName: indexName}
+
+	reference, err := ParseReferenceSet(strings.NewReader(reconciledReference))
+	require.NoError(t, err)
+	report, err := es.StartReconciliation(reference, false)
+	require.NoError(t, err)
+	assert.Equal(t, ReconciliationRunning, report.State)
+
+	report = waitForReconciliation(t, es)
+	assert.Equal(t, ReconciliationCompleted, report.State)
+	assert.Equal(t, int64(4), report.Referenced)
+	assert.Equal(t, int64(6), report.Indexed)
+	assert.Equal(t, []EsIDTypePair{{ID: "5", Type: "genres"}}, report.Missing)
+	assert.Equal(t, int64(1), report.MissingCount)
+	assert.Equal(t, []EsIDTypePair{{ID: "3", Type: "organisations"}, {ID: "4", Type: "brands"}, {ID: "failing", Type: "topics"}}, report.Extra)
+	assert.Equal(t, int64(3), report.ExtraCount)
+	assert.Equal(t, []WrongTypeDocument{{ID: "2", Type: "people", IndexedTypes: []string{"organisations"}}}, report.WrongType)
+	assert.Equal(t, int64(1), report.WrongTypeCount)
+	assert.Empty(t, m.deletedDocs(), "the extra documents should not be deleted")
+}
+
+func TestReconciliationDeletesExtras(t *testing.T) {
+	m := newReconcileESMock(reconciledIds...)
+	defer m.Close()
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, reconciledReference)
+	}))
+	defer stub.Close()
+
+	es := &esService{elasticClient: getElasticClient(t, m.URL), indexName: indexName}
+	WithReferenceSource(NewHTTPReferenceSource(stub.URL, stub.Client()))(es)
+	WithReconciliationDeleteLimit(0.5)(es)
+
+	_, err := es.StartReconciliation(nil, true)
+	require.NoError(t, err)
+
+	report := waitForReconciliation(t, es)
+	assert.Equal(t, ReconciliationCompleted, report.State)
+	assert.True(t, report.DeleteExtras)
+	assert.Equal(t, int64(2), report.Deleted)
+	require.Len(t, report.DeleteErrors, 1)
+	assert.Contains(t, report.DeleteErrors[0], "topics failing:")
+	assert.Equal(t, []string{"brands/4", "organisations/3"}, m.deletedDocs())
+}
+
+func TestReconciliationKeepsTooManyExtras(t *testing.T) {
+	m := newReconcileESMock(reconciledIds...)
+	defer m.Close()
+	es := &esService{elasticClient: getElasticClient(t, m.URL), indexName: indexName}
+
+	reference, err := ParseReferenceSet(strings.NewReader(reconciledReference))
+	require.NoError(t, err)
+	_, err = es.StartReconciliation(reference, true)
+	require.NoError(t, err)
+
+	report := waitForReconciliation(t, es)
+	assert.Equal(t, ReconciliationFailed, report.State)
+	assert.Equal(t, "too many documents of the index are extra, they are not deleted: 3 of the 6 documents, more than 10%", report.Error)
+	assert.Equal(t, int64(3), report.ExtraCount, "the report should be complete")
+	assert.Equal(t, int64(0), report.Deleted)
+	assert.Empty(t, m.deletedDocs())
+}
+
+func TestReconciliationErrors(t *testing.T) {
+	es := &esService{indexName: indexName}
+	_, err := es.StartReconciliation(ReferenceSet{}, false)
+	assert.Equal(t, ErrNoElasticClient, err)
+
+	m := newReconcileESMock(reconciledIds...)
+	defer m.Close()
+	es = &esService{elasticClient: getElasticClient(t, m.URL), indexName: indexName}
+
+	_, err = es.ReconciliationReport()
+	assert.Equal(t, ErrNoReconciliation, err)
+	_, err = es.StartReconciliation(nil, false)
+	assert.Equal(t, ErrNoReferenceSource, err)
+
+	_, err = es.StartReconciliation(ReferenceSet{}, true)
+	require.NoError(t, err)
+	report := waitForReconciliation(t, es)
+	assert.Equal(t, ReconciliationFailed, report.State)
+	assert.Equal(t, ErrEmptyReference.Error(), report.Error)
+	assert.Empty(t, m.deletedDocs(), "nothing should be deleted if the reference set is empty")
+
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer stub.Close()
+	_, err = es.StartReconciliation(NewHTTPReferenceSource(stub.URL, stub.Client()), false)
+	require.NoError(t, err)
+	report = waitForReconciliation(t, es)
+	assert.Equal(t, ReconciliationFailed, report.State)
+	assert.Equal(t, "failed to read the reference set: the reference source responded with status 502", report.Error)
+}
+
+func TestCancelReconciliation(t *testing.T) {
+	release := make(chan struct{})
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer stub.Close()
+	defer close(release)
+
+	m := newReconcileESMock(reconciledIds...)
+	defer m.Close()
+	es := &esService{elasticClient: getElasticClient(t, m.URL), indexName: indexName}
+
+	_, err := es.CancelReconciliation()
+	assert.Equal(t, ErrNoReconciliation, err)
+
+	_, err = es.StartReconciliation(NewHTTPReferenceSource(stub.URL, stub.Client()), true)
+	require.NoError(t, err)
+	_, err = es.StartReconciliation(ReferenceSet{}, false)
+	assert.Equal(t, ErrReconciliationRunning, err)
+
+	_, err = es.CancelReconciliation()
+	require.NoError(t, err)
+	report := waitForReconciliation(t, es)
+	assert.Equal(t, ReconciliationCancelled, report.State)
+	assert.Empty(t, m.deletedDocs())
+}
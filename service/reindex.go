@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// The states of a reindex
+const (
+	ReindexRunning   = "running"
+	ReindexCompleted = "completed"
+	ReindexFailed    = "failed"
+	ReindexCancelled = "cancelled"
+)
+
+// maxReindexErrors is the number of the most recent errors kept in the status of a reindex
+const maxReindexErrors = 100
+
+var (
+	ErrReindexRunning       = errors.New("a reindex is already running")
+	ErrNoReindex            = errors.New("no reindex has been started")
+	ErrInvalidReindexTarget = errors.New("the target index is required, and must differ from the index")
+	ErrNoBulkConfig         = errors.New("no bulk processor is configured")
+)
+
+// ReindexStatus reports the progress of a reindex
+type ReindexStatus struct {
+	SourceIndex string     `json:"sourceIndex"`
+	TargetIndex string     `json:"targetIndex"`
+	State       string     `json:"state"`
+	StartedAt   time.Time  `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	// Total is the number of documents of the source index when the reindex started
+	Total   int64 `json:"total"`
+	Read    int64 `json:"read"`
+	Written int64 `json:"written"`
+	Failed  int64 `json:"failed"`
+	// DocumentsPerSecond is the number of documents written per second since the reindex started
+	DocumentsPerSecond float64 `json:"documentsPerSecond"`
+	// Error is the error which stopped the reindex, if any
+	Error string `json:"error,omitempty"`
+	// Errors are the most recent errors of the documents which could not be reindexed
+	Errors []string `json:"errors,omitempty"`
+}
+
+// reindexer holds the current or last reindex
+type reindexer struct {
+	sync.Mutex
+	job *reindexJob
+}
+
+// reindexJob copies every document of the source index into the target index, upgraded to the current model by the converter of its type
+type reindexJob struct {
+	sync.Mutex
+	status ReindexStatus
+	cancel context.CancelFunc
+	// pending counts the documents queued in the bulk processor of the reindex, which waits for them to be sent once it reaches the limit
+	pending    pendingCounter
+	queueLimit int
+}
+
+// StartReindex starts copying every document of the index into the target index, upgraded to the current model.
+// The documents are sent with the settings of the bulk loads, and no more of them are queued than the bulk queue limit.
+func (es *esService) StartReindex(target string) (ReindexStatus, error) {
+	es.RLock()
+	client, store, source, bulkProcessorConfig := es.elasticClient, es.store(), es.indexName, es.bulkProcessorConfig
+	if lane := es.laneOf(BulkLoads); lane != nil {
+		config := lane.config
+		bulkProcessorConfig = &config
+	}
+	err := es.checkElasticClient()
+	es.RUnlock()
+
+	if err != nil {
+		return ReindexStatus{}, err
+	}
+	if bulkProcessorConfig == nil {
+		return ReindexStatus{}, ErrNoBulkConfig
+	}
+	if target == "" || target == source {
+		return ReindexStatus{}, ErrInvalidReindexTarget
+	}
+
+	es.reindexer.Lock()
+	defer es.reindexer.Unlock()
+
+	if es.reindexer.job != nil && es.reindexer.job.currentStatus().State == ReindexRunning {
+		return ReindexStatus{}, ErrReindexRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &reindexJob{
+		status:     ReindexStatus{SourceIndex: source, TargetIndex: target, State: ReindexRunning, StartedAt: time.Now()},
+		cancel:     cancel,
+		queueLimit: es.bulkQueueLimit,
+	}
+	bulkProcessor, err := bulkProcessorService(client, "Reindex", bulkProcessorConfig).
+		Backoff(bulkBackoff{es.retryPolicy}).
+		After(job.afterBulk).
+		Do(context.Background())
+	if err != nil {
+		cancel()
+		return ReindexStatus{}, err
+	}
+
+	log.WithField("sourceIndex", source).WithField("targetIndex", target).Info("Reindex started")
+	es.reindexer.job = job
+	go job.run(ctx, store, bulkProcessor)
+	return job.currentStatus(), nil
+}
+
+// ReindexStatus returns the status of the current or last reindex
+func (es *esService) ReindexStatus() (ReindexStatus, error) {
+	es.reindexer.Lock()
+	defer es.reindexer.Unlock()
+
+	if es.reindexer.job == nil {
+		return ReindexStatus{}, ErrNoReindex
+	}
+	return es.reindexer.job.currentStatus(), nil
+}
+
+// CancelReindex stops the current reindex. The documents already sent to the target index are kept.
+func (es *esService) CancelReindex() (ReindexStatus, error) {
+	es.reindexer.Lock()
+	job := es.reindexer.job
+	es.reindexer.Unlock()
+
+	if job == nil {
+		return ReindexStatus{}, ErrNoReindex
+	}
+	job.cancel()
+	return job.currentStatus(), nil
+}
+
+func (j *reindexJob) run(ctx context.Context, store documentStore, bulkProcessor *elastic.BulkProcessor) {
+	defer j.cancel()
+
+	err := store.Scroll(ctx, j.status.SourceIndex, true, func(res *elastic.SearchResult) error {
+		j.setTotal(res.Hits.TotalHits)
+		for _, hit := range res.Hits.Hits {
+			doc, err := upgradeDocument(hit)
+			j.read()
+			if err != nil {
+				j.failed(1, fmt.Sprintf("%s %s: %v", hit.Type, hit.Id, err))
+				continue
+			}
+			if err := j.throttle(bulkProcessor); err != nil {
+				return err
+			}
+			j.pending.add(1)
+			bulkProcessor.Add(store.indexRequest(j.status.TargetIndex, hit.Type, hit.Id, doc))
+		}
+		return nil
+	})
+
+	// the documents already read are written, even if the reindex has been cancelled
+	if closeErr := bulkProcessor.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	j.finish(ctx, err)
+}
+
+// upgradeDocument decodes the source of a document with the converter of its type.
+// The content hash is cleared, so that the next write of the concept is not skipped.
+func upgradeDocument(hit *elastic.SearchHit) (EsModel, error) {
+	if hit.Source == nil {
+		return nil, errors.New("the document has no source")
+	}
+
+	doc, err := ConverterFor(hit.Type, sourceDirectType(hit.Source)).Upgrade(*hit.Source)
+	if err != nil {
+		return nil, err
+	}
+	if hashed, ok := doc.(contentHashed); ok {
+		hashed.setContentHash("")
+	}
+	return doc, nil
+}
+
+// throttle sends the queued documents, waiting for them to be written, once as many of them as the limit are queued
+func (j *reindexJob) throttle(bulkProcessor *elastic.BulkProcessor) error {
+	if _, full := j.pending.full(j.queueLimit); !full {
+		return nil
+	}
+	return bulkProcessor.Flush()
+}
+
+func (j *reindexJob) afterBulk(executionID int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	j.pending.add(-len(requests))
+	if err != nil {
+		j.failed(int64(len(requests)), err.Error())
+		return
+	}
+
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Error != nil {
+				j.failed(1, fmt.Sprintf("%s %s: %s", result.Type, result.Id, result.Error.Reason))
+				continue
+			}
+			j.written()
+		}
+	}
+}
+
+func (j *reindexJob) setTotal(total int64) {
+	j.Lock()
+	defer j.Unlock()
+	j.status.Total = total
+}
+
+func (j *reindexJob) read() {
+	j.Lock()
+	defer j.Unlock()
+	j.status.Read++
+}
+
+func (j *reindexJob) written() {
+	j.Lock()
+	defer j.Unlock()
+	j.status.Written++
+}
+
+func (j *reindexJob) failed(count int64, msg string) {
+	j.Lock()
+	defer j.Unlock()
+
+	j.status.Failed += count
+	j.status.Errors = append(j.status.Errors, msg)
+	if len(j.status.Errors) > maxReindexErrors {
+		j.status.Errors = j.status.Errors[len(j.status.Errors)-maxReindexErrors:]
+	}
+}
+
+func (j *reindexJob) finish(ctx context.Context, err error) {
+	j.Lock()
+	defer j.Unlock()
+
+	now := time.Now()
+	j.status.FinishedAt = &now
+	switch {
+	case ctx.Err() != nil && err != nil:
+		j.status.State = ReindexCancelled
+	case err != nil:
+		j.status.State = ReindexFailed
+		j.status.Error = err.Error()
+	default:
+		j.status.State = ReindexCompleted
+	}
+
+	log.WithField("sourceIndex", j.status.SourceIndex).WithField("targetIndex", j.status.TargetIndex).
+		WithField("state", j.status.State).WithField("written", j.status.Written).WithField("failed", j.status.Failed).
+		Info("Reindex finished")
+}
+
+// currentStatus returns a copy of the status, with the throughput so far
+func (j *reindexJob) currentStatus() ReindexStatus {
+	j.Lock()
+	defer j.Unlock()
+
+	status := j.status
+	status.Errors = append([]string(nil), j.status.Errors...)
+	end := time.Now()
+	if status.FinishedAt != nil {
+		end = *status.FinishedAt
+	}
+	if elapsed := end.Sub(status.StartedAt).Seconds(); elapsed > 0 {
+		status.DocumentsPerSecond = float64(status.Written) / elapsed
+	}
+	return status
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reindexTarget = "concept-0.0.2"
+
+// reindexESMock serves the documents of the index in a single scroll page, and records the bulk requests it receives
+type reindexESMock struct {
+	sync.Mutex
+	hits     string
+	release  chan struct{}
+	bulkDocs map[string]map[string]interface{}
+	bulks    int
+	*httptest.Server
+}
+
+func newReindexESMock(hits string) *reindexESMock {
+	m := &reindexESMock{hits: hits, bulkDocs: make(map[string]map[string]interface{})}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	return m
+}
+
+func (m *reindexESMock) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodHead:
+	case r.URL.Path == "/"+indexName+"/_search":
+		if m.release != nil {
+			select {
+			case <-m.release:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fmt.Fprintf(w, `{"_scroll_id":"scroll-1","hits":{"total":3,"hits":[%s]}}`, m.hits)
+	case r.URL.Path == "/_search/scroll":
+		fmt.Fprint(w, `{"_scroll_id":"scroll-1","hits":{"total":3,"hits":[]}}`)
+	case r.URL.Path == "/_bulk":
+		m.bulk(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *reindexESMock) bulk(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.bulks++
+	var items []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		action := map[string]map[string]string{}
+		doc := map[string]interface{}{}
+		if json.Unmarshal(scanner.Bytes(), &action) != nil || !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &doc) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		index := action["index"]
+		m.bulkDocs[index["_index"]+"/"+index["_type"]+"/"+index["_id"]] = doc
+		items = append(items, fmt.Sprintf(`{"index":{"_index":"%s","_type":"%s","_id":"%s","status":201}}`, index["_index"], index["_type"], index["_id"]))
+	}
+	fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+}
+
+func (m *reindexESMock) docs() map[string]map[string]interface{} {
+	m.Lock()
+	defer m.Unlock()
+
+	docs := make(map[string]map[string]interface{})
+	for k, v := range m.bulkDocs {
+		docs[k] = v
+	}
+	return docs
+}
+
+func newReindexTestService(t *testing.T, url string) *esService {
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 10, 1000000, time.Second)
+	return &esService{
+		elasticClient:       getElasticClient(t, url),
+		indexName:           indexName,
+		bulkProcessorConfig: &bulkProcessorConfig,
+		getCurrentTime:      time.Now,
+	}
+}
+
+func waitForReindex(t *testing.T, es *esService) ReindexStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := es.ReindexStatus()
+		require.NoError(t, err)
+		if status.State != ReindexRunning || time.Now().After(deadline) {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	m := newReindexESMock(`
+		{"_index":"concept","_type":"people","_id":"1","_source":{"id":"1","prefLabel":"Person","directType":"http://www.ft.com/ontology/person/Person","isFTAuthor":"true","contentHash":"abc"}},
+		{"_index":"concept","_type":"organisations","_id":"2","_source":{"id":"2","prefLabel":"Company","directType":"http://www.ft.com/ontology/company/PublicCompany","countryCode":"GB","obsoleteField":true}},
+		{"_index":"concept","_type":"organisations","_id":"3","_source":{"id":"3","prefLabel":3}}`)
+	defer m.Close()
+	es := newReindexTestService(t, m.URL)
+
+	status, err := es.StartReindex(reindexTarget)
+	require.NoError(t, err)
+	assert.Equal(t, ReindexRunning, status.State)
+	assert.Equal(t, indexName, status.SourceIndex)
+	assert.Equal(t, reindexTarget, status.TargetIndex)
+
+	status = waitForReindex(t, es)
+	assert.Equal(t, ReindexCompleted, status.State)
+	assert.Equal(t, int64(3), status.Total)
+	assert.Equal(t, int64(3), status.Read)
+	assert.Equal(t, int64(2), status.Written)
+	assert.Equal(t, int64(1), status.Failed)
+	require.Len(t, status.Errors, 1)
+	assert.Contains(t, status.Errors[0], "organisations 3: json: cannot unmarshal number")
+	assert.NotNil(t, status.FinishedAt)
+
+	docs := m.docs()
+	require.Len(t, docs, 2)
+	person := docs[reindexTarget+"/people/1"]
+	assert.Equal(t, "true", person["isFTAuthor"], "the person should be upgraded with the people converter")
+	assert.NotContains(t, person, "contentHash", "the content hash should be cleared")
+	company := docs[reindexTarget+"/organisations/2"]
+	assert.Equal(t, "GB", company["countryCode"])
+	assert.NotContains(t, company, "obsoleteField", "the fields missing from the model should be dropped")
+}
+
+func TestCancelReindex(t *testing.T) {
+	m := newReindexESMock(`{"_index":"concept","_type":"people","_id":"1","_source":{"id":"1"}}`)
+	m.release = make(chan struct{})
+	defer m.Close()
+	defer close(m.release)
+	es := newReindexTestService(t, m.URL)
+
+	_, err := es.CancelReindex()
+	assert.Equal(t, ErrNoReindex, err)
+
+	_, err = es.StartReindex(reindexTarget)
+	require.NoError(t, err)
+	_, err = es.StartReindex(reindexTarget)
+	assert.Equal(t, ErrReindexRunning, err)
+
+	_, err = es.CancelReindex()
+	require.NoError(t, err)
+	status := waitForReindex(t, es)
+	assert.Equal(t, ReindexCancelled, status.State)
+	assert.Equal(t, int64(0), status.Written)
+
+	_, err = es.StartReindex(reindexTarget)
+	assert.NoError(t, err, "a reindex should start once the previous one has been cancelled")
+	es.CancelReindex()
+	waitForReindex(t, es)
+}
+
+func TestStartReindexErrors(t *testing.T) {
+	es := &esService{indexName: indexName}
+	_, err := es.StartReindex(reindexTarget)
+	assert.Equal(t, ErrNoElasticClient, err)
+
+	m := newReindexESMock("")
+	defer m.Close()
+	es = newReindexTestService(t, m.URL)
+	_, err = es.StartReindex("")
+	assert.Equal(t, ErrInvalidReindexTarget, err)
+	_, err = es.StartReindex(indexName)
+	assert.Equal(t, ErrInvalidReindexTarget, err)
+	_, err = es.ReindexStatus()
+	assert.Equal(t, ErrNoReindex, err)
+
+	es.bulkProcessorConfig = nil
+	_, err = es.StartReindex(reindexTarget)
+	assert.Equal(t, ErrNoBulkConfig, err)
+}
+
+func TestReindexWaitsForTheQueuedDocuments(t *testing.T) {
+	m := newReindexESMock(`
+		{"_index":"concept","_type":"people","_id":"1","_source":{"id":"1","prefLabel":"Person","directType":"http://www.ft.com/ontology/person/Person"}},
+		{"_index":"concept","_type":"people","_id":"2","_source":{"id":"2","prefLabel":"Person","directType":"http://www.ft.com/ontology/person/Person"}},
+		{"_index":"concept","_type":"people","_id":"3","_source":{"id":"3","prefLabel":"Person","directType":"http://www.ft.com/ontology/person/Person"}}`)
+	defer m.Close()
+	es := newReindexTestService(t, m.URL)
+	WithBulkQueueLimit(2)(es)
+	WithBulkLane(NewBulkProcessorConfig(1, 100, 1000000, time.Hour), BulkLoads)(es)
+
+	_, err := es.StartReindex(reindexTarget)
+	require.NoError(t, err)
+	status := waitForReindex(t, es)
+	assert.Equal(t, ReindexCompleted, status.State)
+	assert.Equal(t, int64(3), status.Written)
+
+	m.Lock()
+	defer m.Unlock()
+	assert.Equal(t, 2, m.bulks, "the queued documents should be sent once they reach the limit, rather than at the flush interval of the bulk loads")
+}
+
+func TestReindexKeepsTheMostRecentErrors(t *testing.T) {
+	job := &reindexJob{}
+	for i := 0; i < maxReindexErrors+5; i++ {
+		job.failed(1, fmt.Sprintf("error %d", i))
+	}
+
+	status := job.currentStatus()
+	assert.Equal(t, int64(maxReindexErrors+5), status.Failed)
+	require.Len(t, status.Errors, maxReindexErrors)
+	assert.Equal(t, "error 5", status.Errors[0])
+}
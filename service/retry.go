@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	"github.com/rcrowley/go-metrics"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var (
+	writeRetries  = metrics.GetOrRegisterCounter("concept.retry.write", metrics.DefaultRegistry)
+	readRetries   = metrics.GetOrRegisterCounter("concept.retry.read", metrics.DefaultRegistry)
+	deleteRetries = metrics.GetOrRegisterCounter("concept.retry.delete", metrics.DefaultRegistry)
+	bulkRetries   = metrics.GetOrRegisterCounter("concept.retry.bulk", metrics.DefaultRegistry)
+)
+
+// DefaultRetryableStatuses are the statuses of the transient failures of Elasticsearch, e.g. when it rejects the requests under load
+var DefaultRetryableStatuses = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// DefaultRetryPolicy makes up to 5 attempts, waiting up to 100ms after the first one and 1.5s in total
+var DefaultRetryPolicy = NewRetryPolicy(5, 100*time.Millisecond, 5*time.Second, DefaultRetryableStatuses...)
+
+// RetryPolicy retries the operations failing with a retryable status, waiting with exponential backoff and full jitter between the attempts:
+// a random time up to the initial backoff after the first attempt, up to twice as long after every other one, capped by the max backoff.
+// The zero value makes a single attempt.
+type RetryPolicy struct {
+	maxAttempts       int
+	initialBackoff    time.Duration
+	maxBackoff        time.Duration
+	retryableStatuses []int
+}
+
+// NewRetryPolicy returns a policy making up to maxAttempts attempts of the operations failing with one of the statuses given
+func NewRetryPolicy(maxAttempts int, initialBackoff time.Duration, maxBackoff time.Duration, retryableStatuses ...int) RetryPolicy {
+	if maxBackoff < initialBackoff {
+		maxBackoff = initialBackoff
+	}
+	return RetryPolicy{
+		maxAttempts:       maxAttempts,
+		initialBackoff:    initialBackoff,
+		maxBackoff:        maxBackoff,
+		retryableStatuses: append([]int(nil), retryableStatuses...),
+	}
+}
+
+// WithRetryPolicy retries the reads, writes, deletes and bulk requests with the policy
+func WithRetryPolicy(policy RetryPolicy) EsServiceOption {
+	return func(es *esService) {
+		es.retryPolicy = policy
+	}
+}
+
+// Next returns the time to wait before the retry given, the first one being 1, and whether the operation is retried
+func (p RetryPolicy) Next(retry int) (time.Duration, bool) {
+	if retry < 1 || retry >= p.maxAttempts {
+		return 0, false
+	}
+	return p.backoff(retry), true
+}
+
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	limit := p.initialBackoff
+	for i := 1; i < retry && limit < p.maxBackoff; i++ {
+		limit *= 2
+	}
+	if limit > p.maxBackoff {
+		limit = p.maxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+// isRetryable returns true if the error is a transient failure of Elasticsearch
+func (p RetryPolicy) isRetryable(err error) bool {
+	var esErr *elastic.Error
+	if !errors.As(err, &esErr) {
+		return false
+	}
+	for _, status := range p.retryableStatuses {
+		if esErr.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// do calls the operation until it succeeds, fails with an error which is not retryable, the attempts are exhausted or the context is done.
+// The retries are counted by the counter given.
+func (p RetryPolicy) do(ctx context.Context, retries metrics.Counter, operation func() error) error {
+	for retry := 1; ; retry++ {
+		err := operation()
+		if err == nil || !p.isRetryable(err) {
+			return err
+		}
+		wait, ok := p.Next(retry)
+		if !ok {
+			return err
+		}
+
+		retries.Inc(1)
+		log.WithError(err).Warnf("Elasticsearch operation failed, retrying in %v", wait)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// bulkBackoff is the backoff of a bulk processor, which retries the failed bulk requests with the policy
+type bulkBackoff struct {
+	RetryPolicy
+}
+
+func (b bulkBackoff) Next(retry int) (time.Duration, bool) {
+	wait, ok := b.RetryPolicy.Next(retry)
+	if ok {
+		bulkRetries.Inc(1)
+	}
+	return wait, ok
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var testRetryPolicy = NewRetryPolicy(3, time.Millisecond, 2*time.Millisecond, DefaultRetryableStatuses...)
+
+func TestRetryPolicyNext(t *testing.T) {
+	policy := NewRetryPolicy(4, 10*time.Millisecond, 25*time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		for retry, limit := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 25 * time.Millisecond} {
+			wait, ok := policy.Next(retry)
+			assert.True(t, ok)
+			assert.True(t, wait >= 0 && wait <= limit, "retry %d waited %v, more than %v", retry, wait, limit)
+		}
+	}
+
+	_, ok := policy.Next(4)
+	assert.False(t, ok, "the attempts should be exhausted")
+	_, ok = RetryPolicy{}.Next(1)
+	assert.False(t, ok, "the zero value should make a single attempt")
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	policy := NewRetryPolicy(2, time.Second, time.Second)
+	waits := make(map[time.Duration]bool)
+	for i := 0; i < 10; i++ {
+		wait, _ := policy.Next(1)
+		waits[wait] = true
+	}
+	assert.True(t, len(waits) > 1, "the waits should be random")
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	unavailable := &elastic.Error{Status: http.StatusServiceUnavailable}
+	var tests = []struct {
+		name     string
+		errors   []error
+		attempts int
+		err      error
+		retries  int64
+	}{
+		{"Success", nil, 1, nil, 0},
+		{"Transient failure", []error{unavailable, &elastic.Error{Status: http.StatusTooManyRequests}}, 3, nil, 2},
+		{"Exhausted attempts", []error{unavailable, unavailable, unavailable, unavailable}, 3, unavailable, 2},
+		{"Not retryable status", []error{&elastic.Error{Status: http.StatusBadRequest}}, 1, &elastic.Error{Status: http.StatusBadRequest}, 0},
+		{"Not an Elasticsearch error", []error{ErrNoElasticClient}, 1, ErrNoElasticClient, 0},
+		{"Wrapped error", []error{fmt.Errorf("indexing: %w", unavailable)}, 2, nil, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retries := writeRetries.Count()
+			attempts := 0
+			err := testRetryPolicy.do(context.Background(), writeRetries, func() error {
+				attempts++
+				if attempts <= len(test.errors) {
+					return test.errors[attempts-1]
+				}
+				return nil
+			})
+
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.attempts, attempts)
+			assert.Equal(t, test.retries, writeRetries.Count()-retries)
+		})
+	}
+}
+
+func TestRetryPolicyStopsWithContext(t *testing.T) {
+	policy := NewRetryPolicy(3, time.Hour, time.Hour, DefaultRetryableStatuses...)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := policy.do(ctx, writeRetries, func() error {
+		attempts++
+		return &elastic.Error{Status: http.StatusServiceUnavailable}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+// flakyStorage fails the first calls of every operation with the error given
+type flakyStorage struct {
+	Storage
+	err      error
+	failures int
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (s *flakyStorage) fail(operation string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.calls == nil {
+		s.calls = make(map[string]int)
+	}
+	s.calls[operation]++
+	if s.calls[operation] <= s.failures {
+		return s.err
+	}
+	return nil
+}
+
+func (s *flakyStorage) Get(ctx context.Context, index string, conceptType string, uuid string) (*elastic.GetResult, error) {
+	if err := s.fail("get"); err != nil {
+		return nil, err
+	}
+	return s.Storage.Get(ctx, index, conceptType, uuid)
+}
+
+func (s *flakyStorage) Index(ctx context.Context, index string, conceptType string, uuid string, doc interface{}) (*elastic.IndexResponse, error) {
+	if err := s.fail("index"); err != nil {
+		return nil, err
+	}
+	return s.Storage.Index(ctx, index, conceptType, uuid, doc)
+}
+
+func (s *flakyStorage) Delete(ctx context.Context, index string, conceptType string, uuid string) (*elastic.DeleteResponse, error) {
+	if err := s.fail("delete"); err != nil {
+		return nil, err
+	}
+	return s.Storage.Delete(ctx, index, conceptType, uuid)
+}
+
+func TestServiceRetriesTransientFailures(t *testing.T) {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	storage := &flakyStorage{Storage: NewMemoryStorage(indexName), err: &elastic.Error{Status: http.StatusTooManyRequests}, failures: 2}
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithStorage(storage), WithRetryPolicy(testRetryPolicy)).(*esService)
+	defer es.CloseBulkProcessor()
+
+	reads, writes, deletes := readRetries.Count(), writeRetries.Count(), deleteRetries.Count()
+
+	_, updated, _, err := writeTestDocument(es, "people", memoryUUID)
+	require.NoError(t, err)
+	assert.True(t, updated)
+
+	read, err := es.ReadData(context.Background(), "people", memoryUUID)
+	require.NoError(t, err)
+	assert.True(t, read.Found)
+
+	deleted, err := es.DeleteData(newTestContext(), "people", memoryUUID)
+	require.NoError(t, err)
+	assert.True(t, deleted.Found)
+
+	assert.Equal(t, int64(2), readRetries.Count()-reads, "the read before the write should be retried")
+	assert.Equal(t, int64(2), writeRetries.Count()-writes)
+	assert.Equal(t, int64(2), deleteRetries.Count()-deletes)
+}
+
+func TestServiceGivesUpAfterMaxAttempts(t *testing.T) {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	storage := &flakyStorage{Storage: NewMemoryStorage(indexName), err: &elastic.Error{Status: http.StatusServiceUnavailable}, failures: 3}
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithStorage(storage), WithRetryPolicy(testRetryPolicy)).(*esService)
+	defer es.CloseBulkProcessor()
+
+	_, err := es.DeleteData(newTestContext(), "people", memoryUUID)
+	assert.True(t, elastic.IsStatusCode(err, http.StatusServiceUnavailable))
+	assert.Equal(t, 3, storage.calls["delete"])
+}
+
+func TestBulkProcessorRetriesTransientFailures(t *testing.T) {
+	var bulkRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			esClusterStub(w, r)
+			return
+		}
+		if atomic.AddInt32(&bulkRequests, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"took":1,"errors":false,"items":[{"index":{"_index":"concept","_type":"people","_id":"1","_version":1,"status":201}}]}`)
+	}))
+	defer server.Close()
+	ec, err := newClient(NewAccessConfig("", "", server.URL, false), http.DefaultTransport, elastic.SetHealthcheck(false))
+	require.NoError(t, err)
+
+	ecc := make(chan *elastic.Client)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithRetryPolicy(testRetryPolicy)).(*esService)
+	ecc <- ec
+	close(ecc)
+	waitForBulkProcessor(t, es)
+
+	retries := bulkRetries.Count()
+	es.LoadBulkData(context.Background(), "people", "1", EsConceptModel{Id: "1"})
+	es.RLock()
+	require.NoError(t, es.bulkProcessor.Flush())
+	es.RUnlock()
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&bulkRequests))
+	assert.Equal(t, int64(1), bulkRetries.Count()-retries)
+	require.NoError(t, es.CloseBulkProcessor())
+}
+
+func waitForBulkProcessor(t *testing.T, es *esService) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		es.RLock()
+		ready := es.bulkProcessor != nil
+		es.RUnlock()
+		if ready {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	require.FailNow(t, "no bulk processor")
+}
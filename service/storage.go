@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// Backend is the kind of cluster the concepts are stored in
+type Backend string
+
+const (
+	// TypedBackend stores the concepts of each type under the mapping type of the same name, as Elasticsearch 5 and 6 do
+	TypedBackend Backend = "v5"
+	// TypelessBackend stores the concept type in the conceptType field of the documents, for Elasticsearch 7, 8 and OpenSearch
+	TypelessBackend Backend = "typeless"
+)
+
+// ParseBackend returns the backend of the name, the typed backend if it is empty
+func ParseBackend(name string) (Backend, error) {
+	switch Backend(name) {
+	case "", TypedBackend:
+		return TypedBackend, nil
+	case TypelessBackend:
+		return TypelessBackend, nil
+	}
+	return "", fmt.Errorf("unknown backend %q, expected %s or %s", name, TypedBackend, TypelessBackend)
+}
+
+// WithBackend sets how the concepts are stored. The typed backend is used by default.
+func WithBackend(backend Backend) EsServiceOption {
+	return func(es *esService) {
+		es.backend = backend
+	}
+}
+
+// ErrStorageUnsupported is returned by the operations which need an Elasticsearch cluster, e.g. the mapping management,
+// when the concepts are kept in another storage
+var ErrStorageUnsupported = errors.New("the operation is not supported by the storage")
+
+// Storage is the storage of the concept documents, addressed by index, concept type and uuid.
+// The errors are *elastic.Error values with the status and the details Elasticsearch would respond with.
+type Storage interface {
+	// Get returns a not found error if the concept is not stored with the type
+	Get(ctx context.Context, index string, conceptType string, uuid string) (*elastic.GetResult, error)
+	Index(ctx context.Context, index string, conceptType string, uuid string, doc interface{}) (*elastic.IndexResponse, error)
+	// Delete returns a response not found, or a not found error, if the concept is not stored with the type
+	Delete(ctx context.Context, index string, conceptType string, uuid string) (*elastic.DeleteResponse, error)
+	// Update merges the fields of doc into the stored document, or returns a not found error if there is none
+	Update(ctx context.Context, index string, conceptType string, uuid string, doc interface{}) (*elastic.UpdateResponse, error)
+	// FindConceptTypes returns the type of the concepts stored, by uuid
+	FindConceptTypes(ctx context.Context, index string, uuids []string) (map[string]string, error)
+	// Scroll calls handle with every page of the documents of the index, until they have all been handled or handle fails.
+	// The type of the hits is the concept type.
+	Scroll(ctx context.Context, index string, fetchSource bool, handle func(res *elastic.SearchResult) error) error
+	ClusterHealth(ctx context.Context) (*elastic.ClusterHealthResponse, error)
+	IndexSettings(ctx context.Context, index string) (map[string]*elastic.IndicesGetSettingsResponse, error)
+}
+
+// WithStorage keeps the concepts in the storage instead of the Elasticsearch cluster of the client,
+// e.g. a MemoryStorage. The operations managing the cluster return ErrStorageUnsupported.
+func WithStorage(storage Storage) EsServiceOption {
+	return func(es *esService) {
+		es.storage = storage
+	}
+}
+
+// documentStore is the storage of an Elasticsearch cluster, which also builds the requests of the bulk processor and manages the mapping
+type documentStore interface {
+	Storage
+	indexRequest(index string, conceptType string, uuid string, doc interface{}) elastic.BulkableRequest
+	updateRequest(index string, conceptType string, uuid string, doc interface{}) elastic.BulkableRequest
+
+	// expectedMappings returns the mapping of the types of the index for the embedded mapping
+	expectedMappings(embedded *indexMapping) map[string]typeMapping
+	// getMapping returns the mapping of the types of the index, or a not found error if the index does not exist
+	getMapping(ctx context.Context, index string) (map[string]typeMapping, error)
+	createIndex(ctx context.Context, index string, embedded *indexMapping) error
+	putMapping(ctx context.Context, index string, conceptType string, mapping typeMapping) error
+	// aliasIndices returns the indices of the alias
+	aliasIndices(ctx context.Context, alias string) ([]string, error)
+}
+
+func newDocumentStore(backend Backend, client *elastic.Client) documentStore {
+	if backend == TypelessBackend {
+		return &typelessStore{clusterStore{client: client}}
+	}
+	return &typedStore{clusterStore{client: client}}
+}
+
+// store returns the document store of the current client. It must be called with the lock held.
+// Without a client, it only builds bulk requests, which are typed for the storage the service has been created with.
+func (es *esService) store() documentStore {
+	if es.storage != nil {
+		return newDocumentStore(TypedBackend, nil)
+	}
+	return newDocumentStore(es.backend, es.elasticClient)
+}
+
+// documents returns the storage the concepts are kept in: the storage the service has been created with, or the store of the current client.
+// It must be called with the lock held.
+func (es *esService) documents() Storage {
+	if es.storage != nil {
+		return es.storage
+	}
+	return es.store()
+}
+
+// clusterStore reads the state of the cluster, whatever the backend
+type clusterStore struct {
+	client *elastic.Client
+}
+
+func (s *clusterStore) ClusterHealth(ctx context.Context) (*elastic.ClusterHealthResponse, error) {
+	return s.client.ClusterHealth().Do(ctx)
+}
+
+func (s *clusterStore) IndexSettings(ctx context.Context, index string) (map[string]*elastic.IndicesGetSettingsResponse, error) {
+	return s.client.IndexGetSettings(index).Do(ctx)
+}
+
+func (s *clusterStore) aliasIndices(ctx context.Context, alias string) ([]string, error) {
+	aliases, err := s.client.Aliases().Index(alias).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return aliases.IndicesByAlias(alias), nil
+}
+
+// typedStore uses the concept type as the mapping type
+type typedStore struct {
+	clusterStore
+}
+
+func (s *typedStore) Get(ctx context.Context, index string, conceptType string, uuid string) (*elastic.GetResult, error) {
+	return s.client.Get().
+		Index(index).
+		Type(conceptType).
+		Id(uuid).
+		Do(ctx)
+}
+
+func (s *typedStore) Index(ctx context.Context, index string, conceptType string, uuid string, doc interface{}) (*elastic.IndexResponse, error) {
+	return s.client.Index().
+		Index(index).
+		Type(conceptType).
+		Id(uuid).
+		BodyJson(doc).
+		Do(ctx)
+}
+
+func (s *typedStore) Delete(ctx context.Context, index string, conceptType string, uuid string) (*elastic.DeleteResponse, error) {
+	return s.client.Delete().
+		Index(index).
+		Type(conceptType).
+		Id(uuid).
+		Do(ctx)
+}
+
+func (s *typedStore) Update(ctx context.Context, index string, conceptType string, uuid string, doc interface{}) (*elastic.UpdateResponse, error) {
+	return s.client.Update().
+		Index(index).
+		Type(conceptType).
+		Id(uuid).
+		Doc(doc).
+		Do(ctx)
+}
+
+func (s *typedStore) indexRequest(index string, conceptType string, uuid string, doc interface{}) elastic.BulkableRequest {
+	return elastic.NewBulkIndexRequest().Index(index).Type(conceptType).Id(uuid).Doc(doc)
+}
+
+func (s *typedStore) updateRequest(index string, conceptType string, uuid string, doc interface{}) elastic.BulkableRequest {
+	return elastic.NewBulkUpdateRequest().Index(index).Id(uuid).Type(conceptType).Doc(doc)
+}
+
+func (s *typedStore) FindConceptTypes(ctx context.Context, index string, uuids []string) (map[string]string, error) {
+	query := elastic.NewIdsQuery().Ids(uuids...)
+	result, err := s.client.Search(index).Query(query).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conceptTypeMap := make(map[string]string)
+	for _, hit := range result.Hits.Hits {
+		conceptTypeMap[hit.Id] = hit.Type
+	}
+	return conceptTypeMap, nil
+}
+
+func (s *typedStore) Scroll(ctx context.Context, index string, fetchSource bool, handle func(res *elastic.SearchResult) error) error {
+	r := elastic.NewScrollService(s.client).
+		Index(index).
+		Query(elastic.NewMatchAllQuery()).
+		Sort("_doc", true).
+		Size(1000).
+		FetchSource(fetchSource)
+
+	for {
+		res, err := r.Do(ctx)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := handle(res); err != nil {
+			return err
+		}
+		r = elastic.NewScrollService(s.client).ScrollId(res.ScrollId)
+	}
+}
+
+func (s *typedStore) expectedMappings(embedded *indexMapping) map[string]typeMapping {
+	return embedded.Mappings
+}
+
+func (s *typedStore) getMapping(ctx context.Context, index string) (map[string]typeMapping, error) {
+	resp, err := s.client.GetMapping().Index(index).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return liveMappings(resp), nil
+}
+
+func (s *typedStore) createIndex(ctx context.Context, index string, embedded *indexMapping) error {
+	_, err := s.client.CreateIndex(index).BodyString(string(embeddedMapping)).Do(ctx)
+	return err
+}
+
+func (s *typedStore) putMapping(ctx context.Context, index string, conceptType string, mapping typeMapping) error {
+	body, err := json.Marshal(map[string]typeMapping{conceptType: mapping})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutMapping().Index(index).Type(conceptType).BodyString(string(body)).Do(ctx)
+	return err
+}
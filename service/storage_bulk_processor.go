@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// bulkQueue queues the bulk requests until they are sent, as *elastic.BulkProcessor does
+type bulkQueue interface {
+	Add(request elastic.BulkableRequest)
+	Flush() error
+	Close() error
+}
+
+// storageBulkProcessor applies the bulk requests to a storage one by one, in the background.
+// The requests are committed in batches of the configured number of requests, or at every flush interval,
+// and the after function is called with the outcome of every batch, as if it had been sent to Elasticsearch.
+type storageBulkProcessor struct {
+	storage      Storage
+	after        elastic.BulkAfterFunc
+	nrOfRequests int
+
+	mu          sync.Mutex
+	pending     []elastic.BulkableRequest
+	executionID int64
+	committing  sync.Mutex
+
+	full    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func newStorageBulkProcessor(storage Storage, bulkConfig *BulkProcessorConfig, after elastic.BulkAfterFunc) *storageBulkProcessor {
+	p := &storageBulkProcessor{
+		storage:      storage,
+		after:        after,
+		nrOfRequests: bulkConfig.nrOfRequests,
+		full:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	go p.run(bulkConfig.flushInterval)
+	return p
+}
+
+func (p *storageBulkProcessor) run(flushInterval time.Duration) {
+	defer close(p.stopped)
+
+	var tick <-chan time.Time
+	if flushInterval > 0 {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-p.full:
+			p.commit()
+		case <-tick:
+			p.commit()
+		}
+	}
+}
+
+func (p *storageBulkProcessor) Add(request elastic.BulkableRequest) {
+	p.mu.Lock()
+	p.pending = append(p.pending, request)
+	full := p.nrOfRequests > 0 && len(p.pending) >= p.nrOfRequests
+	p.mu.Unlock()
+
+	if full {
+		select {
+		case p.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush applies the queued requests, and returns once they have been applied
+func (p *storageBulkProcessor) Flush() error {
+	p.commit()
+	return nil
+}
+
+// Close stops the processor once the queued requests have been applied
+func (p *storageBulkProcessor) Close() error {
+	p.once.Do(func() {
+		close(p.stop)
+		<-p.stopped
+	})
+	p.commit()
+	return nil
+}
+
+func (p *storageBulkProcessor) commit() {
+	p.committing.Lock()
+	defer p.committing.Unlock()
+
+	p.mu.Lock()
+	requests := p.pending
+	p.pending = nil
+	p.executionID++
+	executionID := p.executionID
+	p.mu.Unlock()
+
+	if len(requests) == 0 {
+		return
+	}
+
+	start := time.Now()
+	response := &elastic.BulkResponse{}
+	for _, r := range requests {
+		action, item := p.apply(r)
+		if item.Error != nil {
+			response.Errors = true
+		}
+		response.Items = append(response.Items, map[string]*elastic.BulkResponseItem{action: item})
+	}
+	response.Took = int(time.Since(start) / time.Millisecond)
+	p.after(executionID, requests, response, nil)
+}
+
+// bulkAction is the action line of a bulk request
+type bulkAction struct {
+	Index string `json:"_index"`
+	Type  string `json:"_type"`
+	ID    string `json:"_id"`
+}
+
+// apply applies a bulk request to the storage, and returns its action and the outcome Elasticsearch would report
+func (p *storageBulkProcessor) apply(r elastic.BulkableRequest) (string, *elastic.BulkResponseItem) {
+	lines, err := r.Source()
+	if err != nil || len(lines) == 0 {
+		return "index", bulkItemFailure(&bulkAction{}, fmt.Errorf("invalid bulk request: %v", err))
+	}
+	var actions map[string]*bulkAction
+	if err := json.Unmarshal([]byte(lines[0]), &actions); err != nil || len(actions) != 1 {
+		return "index", bulkItemFailure(&bulkAction{}, fmt.Errorf("invalid bulk action %s", lines[0]))
+	}
+
+	var action string
+	meta := &bulkAction{}
+	for a, m := range actions {
+		action = a
+		if m != nil {
+			meta = m
+		}
+	}
+
+	item := &elastic.BulkResponseItem{Index: meta.Index, Type: meta.Type, Id: meta.ID, Status: http.StatusOK}
+	ctx := context.Background()
+	switch {
+	case action == "delete":
+		resp, err := p.storage.Delete(ctx, meta.Index, meta.Type, meta.ID)
+		if err != nil {
+			return action, bulkItemFailure(meta, err)
+		}
+		item.Result, item.Found, item.Version = resp.Result, resp.Found, resp.Version
+		if !resp.Found {
+			item.Status = http.StatusNotFound
+		}
+	case len(lines) < 2:
+		return action, bulkItemFailure(meta, fmt.Errorf("the %s action has no document", action))
+	case action == "index":
+		resp, err := p.storage.Index(ctx, meta.Index, meta.Type, meta.ID, json.RawMessage(lines[1]))
+		if err != nil {
+			return action, bulkItemFailure(meta, err)
+		}
+		item.Result, item.Version = resp.Result, resp.Version
+		if resp.Result == createdResult {
+			item.Status = http.StatusCreated
+		}
+	case action == "update":
+		var update struct {
+			Doc json.RawMessage `json:"doc"`
+		}
+		if err := json.Unmarshal([]byte(lines[1]), &update); err != nil || update.Doc == nil {
+			return action, bulkItemFailure(meta, errors.New("the update has no partial document"))
+		}
+		resp, err := p.storage.Update(ctx, meta.Index, meta.Type, meta.ID, update.Doc)
+		if err != nil {
+			return action, bulkItemFailure(meta, err)
+		}
+		item.Result, item.Version = resp.Result, int64(resp.Version)
+	default:
+		return action, bulkItemFailure(meta, fmt.Errorf("unsupported bulk action %s", action))
+	}
+	return action, item
+}
+
+// bulkItemFailure returns the outcome of a failed request, with the status and the details of the error of the storage
+func bulkItemFailure(meta *bulkAction, err error) *elastic.BulkResponseItem {
+	item := &elastic.BulkResponseItem{Index: meta.Index, Type: meta.Type, Id: meta.ID}
+	var esErr *elastic.Error
+	if errors.As(err, &esErr) && esErr.Details != nil {
+		item.Status, item.Error = esErr.Status, esErr.Details
+		return item
+	}
+	item.Status = http.StatusBadRequest
+	item.Error = &elastic.ErrorDetails{Type: "illegal_argument_exception", Reason: err.Error()}
+	return item
+}
@@ -0,0 +1,439 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const (
+	// conceptTypeDocField is the field of the documents holding the concept type in a typeless index
+	conceptTypeDocField = "conceptType"
+	typelessDocType     = "_doc"
+	scrollKeepAlive     = "1m"
+)
+
+// typelessStore stores the concept type in a field of the documents, so that the uuid is the id of the document.
+// The requests are sent to the typeless endpoints of Elasticsearch 7, 8 and OpenSearch, and their responses decoded
+// into the v5 client types. The concept type is removed from the sources it returns.
+type typelessStore struct {
+	clusterStore
+}
+
+// typelessDocument is a document with the sequence number and primary term used for optimistic concurrency control
+type typelessDocument struct {
+	elastic.GetResult
+	SeqNo       *int64 `json:"_seq_no,omitempty"`
+	PrimaryTerm *int64 `json:"_primary_term,omitempty"`
+	conceptType string
+}
+
+func documentPath(index string, uuid string) string {
+	return fmt.Sprintf("/%s/%s/%s", url.PathEscape(index), typelessDocType, url.PathEscape(uuid))
+}
+
+func (s *typelessStore) getDocument(ctx context.Context, index string, uuid string) (*typelessDocument, error) {
+	resp, err := s.client.PerformRequest(ctx, http.MethodGet, documentPath(index, uuid), nil, nil, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := new(typelessDocument)
+	if resp.StatusCode == http.StatusNotFound {
+		return doc, nil
+	}
+	if err := json.Unmarshal(resp.Body, doc); err != nil {
+		return nil, err
+	}
+	if doc.Source != nil {
+		doc.conceptType, doc.Source, err = splitConceptType(*doc.Source)
+	}
+	return doc, err
+}
+
+func (s *typelessStore) Get(ctx context.Context, index string, conceptType string, uuid string) (*elastic.GetResult, error) {
+	doc, err := s.getDocument(ctx, index, uuid)
+	if err != nil {
+		return nil, err
+	}
+	if !doc.Found || doc.conceptType != conceptType {
+		return &elastic.GetResult{Index: index, Type: conceptType, Id: uuid, Found: false}, nil
+	}
+
+	doc.Type = conceptType
+	return &doc.GetResult, nil
+}
+
+// ConceptTypeConflictError is returned by the writes of a concept whose uuid is stored with another type. The typeless backend
+// keeps a single document per uuid, that the write would replace.
+type ConceptTypeConflictError struct {
+	UUID        string
+	ConceptType string
+	StoredType  string
+}
+
+func (e *ConceptTypeConflictError) Error() string {
+	return fmt.Sprintf("the concept %s is stored as %s, it can not be written as %s", e.UUID, e.StoredType, e.ConceptType)
+}
+
+// Index refuses to replace a document of another type. The document is written only if it is still missing, or still has
+// the sequence number it had when its type was checked.
+func (s *typelessStore) Index(ctx context.Context, index string, conceptType string, uuid string, doc interface{}) (*elastic.IndexResponse, error) {
+	current, err := s.getDocument(ctx, index, uuid)
+	if err != nil {
+		return nil, err
+	}
+	if current.Found && current.conceptType != "" && current.conceptType != conceptType {
+		return nil, &ConceptTypeConflictError{UUID: uuid, ConceptType: conceptType, StoredType: current.conceptType}
+	}
+	body, err := withConceptType(doc, conceptType)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	switch {
+	case !current.Found:
+		params.Set("op_type", "create")
+	case current.SeqNo != nil && current.PrimaryTerm != nil:
+		params.Set("if_seq_no", strconv.FormatInt(*current.SeqNo, 10))
+		params.Set("if_primary_term", strconv.FormatInt(*current.PrimaryTerm, 10))
+	}
+	resp, err := s.client.PerformRequest(ctx, http.MethodPut, documentPath(index, uuid), params, string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := new(elastic.IndexResponse)
+	if err := json.Unmarshal(resp.Body, indexed); err != nil {
+		return nil, err
+	}
+	indexed.Type = conceptType
+	return indexed, nil
+}
+
+// Delete deletes the document only if it still has the sequence number it had when its type was checked
+func (s *typelessStore) Delete(ctx context.Context, index string, conceptType string, uuid string) (*elastic.DeleteResponse, error) {
+	doc, err := s.getDocument(ctx, index, uuid)
+	if err != nil {
+		return nil, err
+	}
+	notFound := &elastic.DeleteResponse{Index: index, Type: conceptType, Id: uuid, Found: false}
+	if !doc.Found || doc.conceptType != conceptType {
+		return notFound, nil
+	}
+
+	params := url.Values{}
+	if doc.SeqNo != nil && doc.PrimaryTerm != nil {
+		params.Set("if_seq_no", strconv.FormatInt(*doc.SeqNo, 10))
+		params.Set("if_primary_term", strconv.FormatInt(*doc.PrimaryTerm, 10))
+	}
+	resp, err := s.client.PerformRequest(ctx, http.MethodDelete, documentPath(index, uuid), params, nil, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return notFound, nil
+	}
+
+	deleted := new(elastic.DeleteResponse)
+	if err := json.Unmarshal(resp.Body, deleted); err != nil {
+		return nil, err
+	}
+	deleted.Type = conceptType
+	deleted.Found = deleted.Result == "deleted"
+	return deleted, nil
+}
+
+// Update updates the document only if it still has the sequence number it had when its type was checked
+func (s *typelessStore) Update(ctx context.Context, index string, conceptType string, uuid string, doc interface{}) (*elastic.UpdateResponse, error) {
+	current, err := s.getDocument(ctx, index, uuid)
+	if err != nil {
+		return nil, err
+	}
+	if !current.Found || current.conceptType != conceptType {
+		return nil, &elastic.Error{Status: http.StatusNotFound, Details: &elastic.ErrorDetails{
+			Type:   "document_missing_exception",
+			Reason: fmt.Sprintf("[%s][%s]: document missing", conceptType, uuid),
+			Index:  index,
+		}}
+	}
+
+	params := url.Values{}
+	if current.SeqNo != nil && current.PrimaryTerm != nil {
+		params.Set("if_seq_no", strconv.FormatInt(*current.SeqNo, 10))
+		params.Set("if_primary_term", strconv.FormatInt(*current.PrimaryTerm, 10))
+	}
+	path := fmt.Sprintf("/%s/_update/%s", url.PathEscape(index), url.PathEscape(uuid))
+	resp, err := s.client.PerformRequest(ctx, http.MethodPost, path, params, map[string]interface{}{"doc": doc})
+	if err != nil {
+		return nil, err
+	}
+
+	updated := new(elastic.UpdateResponse)
+	if err := json.Unmarshal(resp.Body, updated); err != nil {
+		return nil, err
+	}
+	updated.Type = conceptType
+	return updated, nil
+}
+
+func (s *typelessStore) indexRequest(index string, conceptType string, uuid string, doc interface{}) elastic.BulkableRequest {
+	return &typelessIndexRequest{index: index, conceptType: conceptType, uuid: uuid, doc: doc}
+}
+
+// updateRequest leaves the concept type of the document unchanged
+func (s *typelessStore) updateRequest(index string, conceptType string, uuid string, doc interface{}) elastic.BulkableRequest {
+	return elastic.NewBulkUpdateRequest().Index(index).Id(uuid).Doc(doc)
+}
+
+func (s *typelessStore) FindConceptTypes(ctx context.Context, index string, uuids []string) (map[string]string, error) {
+	body := map[string]interface{}{
+		"query":   map[string]interface{}{"ids": map[string]interface{}{"values": uuids}},
+		"_source": conceptTypeDocField,
+		"size":    len(uuids),
+	}
+	res, err := s.search(ctx, "/"+url.PathEscape(index)+"/_search", url.Values{}, body, false)
+	if err != nil {
+		return nil, err
+	}
+
+	conceptTypeMap := make(map[string]string)
+	for _, hit := range res.Hits.Hits {
+		conceptTypeMap[hit.Id] = hit.Type
+	}
+	return conceptTypeMap, nil
+}
+
+func (s *typelessStore) Scroll(ctx context.Context, index string, fetchSource bool, handle func(res *elastic.SearchResult) error) error {
+	var source interface{} = conceptTypeDocField
+	if fetchSource {
+		source = true
+	}
+	body := map[string]interface{}{
+		"query":   map[string]interface{}{"match_all": map[string]interface{}{}},
+		"sort":    []string{"_doc"},
+		"size":    1000,
+		"_source": source,
+	}
+
+	var scrollID string
+	defer func() {
+		if scrollID != "" {
+			s.clearScroll(scrollID)
+		}
+	}()
+
+	res, err := s.search(ctx, "/"+url.PathEscape(index)+"/_search", url.Values{"scroll": {scrollKeepAlive}}, body, fetchSource)
+	for {
+		if err != nil {
+			return err
+		}
+		if res.ScrollId != "" {
+			scrollID = res.ScrollId
+		}
+		if len(res.Hits.Hits) == 0 {
+			return nil
+		}
+
+		if err := handle(res); err != nil {
+			return err
+		}
+		res, err = s.search(ctx, "/_search/scroll", url.Values{}, map[string]interface{}{"scroll": scrollKeepAlive, "scroll_id": scrollID}, fetchSource)
+	}
+}
+
+// search returns the hits with the concept type as their type. The total number of hits is requested as a number, as the v5 client decodes it.
+func (s *typelessStore) search(ctx context.Context, path string, params url.Values, body interface{}, fetchSource bool) (*elastic.SearchResult, error) {
+	params.Set("rest_total_hits_as_int", "true")
+	resp, err := s.client.PerformRequest(ctx, http.MethodPost, path, params, body)
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(elastic.SearchResult)
+	if err := json.Unmarshal(resp.Body, res); err != nil {
+		return nil, err
+	}
+	if res.Hits == nil {
+		res.Hits = &elastic.SearchHits{}
+	}
+	for _, hit := range res.Hits.Hits {
+		if hit.Source == nil {
+			continue
+		}
+		if hit.Type, hit.Source, err = splitConceptType(*hit.Source); err != nil {
+			return nil, err
+		}
+		if !fetchSource {
+			hit.Source = nil
+		}
+	}
+	return res, nil
+}
+
+// clearScroll releases the scroll context once the scroll is over, even if it has been cancelled
+func (s *typelessStore) clearScroll(scrollID string) {
+	s.client.PerformRequest(context.Background(), http.MethodDelete, "/_search/scroll", nil, map[string]interface{}{"scroll_id": []string{scrollID}}, http.StatusNotFound)
+}
+
+// expectedMappings merges the properties of every type of the embedded mapping in a single mapping, with the concept type field
+func (s *typelessStore) expectedMappings(embedded *indexMapping) map[string]typeMapping {
+	merged := typeMapping{Properties: map[string]interface{}{conceptTypeDocField: map[string]interface{}{"type": "keyword"}}}
+
+	types := make([]string, 0, len(embedded.Mappings))
+	for t := range embedded.Mappings {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		if merged.Dynamic == nil {
+			merged.Dynamic = embedded.Mappings[t].Dynamic
+		}
+		for name, property := range embedded.Mappings[t].Properties {
+			if _, found := merged.Properties[name]; !found {
+				merged.Properties[name] = property
+			}
+		}
+	}
+	return map[string]typeMapping{"": merged}
+}
+
+func (s *typelessStore) getMapping(ctx context.Context, index string) (map[string]typeMapping, error) {
+	resp, err := s.client.PerformRequest(ctx, http.MethodGet, "/"+url.PathEscape(index)+"/_mapping", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var indices map[string]struct {
+		Mappings typeMapping `json:"mappings"`
+	}
+	if err := json.Unmarshal(resp.Body, &indices); err != nil {
+		return nil, err
+	}
+	// only the first index is read if the name is an alias
+	names := make([]string, 0, len(indices))
+	for name := range indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return map[string]typeMapping{"": {}}, nil
+	}
+	return map[string]typeMapping{"": indices[names[0]].Mappings}, nil
+}
+
+func (s *typelessStore) createIndex(ctx context.Context, index string, embedded *indexMapping) error {
+	body := map[string]interface{}{
+		"settings": typelessSettings(embedded.Settings),
+		"mappings": s.expectedMappings(embedded)[""],
+	}
+	_, err := s.client.PerformRequest(ctx, http.MethodPut, "/"+url.PathEscape(index), nil, body)
+	return err
+}
+
+func (s *typelessStore) putMapping(ctx context.Context, index string, conceptType string, mapping typeMapping) error {
+	_, err := s.client.PerformRequest(ctx, http.MethodPut, "/"+url.PathEscape(index)+"/_mapping", nil, mapping)
+	return err
+}
+
+// typelessSettings removes the index.mapper settings, which typeless clusters refuse. Dynamic mapping is disabled by the mapping itself.
+func typelessSettings(settings map[string]interface{}) map[string]interface{} {
+	typeless := make(map[string]interface{})
+	for k, v := range settings {
+		typeless[k] = v
+	}
+
+	indexSettings, ok := settings["index"].(map[string]interface{})
+	if !ok {
+		return typeless
+	}
+	typelessIndex := make(map[string]interface{})
+	for k, v := range indexSettings {
+		if k != "mapper" {
+			typelessIndex[k] = v
+		}
+	}
+	if len(typelessIndex) == 0 {
+		delete(typeless, "index")
+	} else {
+		typeless["index"] = typelessIndex
+	}
+	return typeless
+}
+
+// typelessIndexRequest is a bulk index request without a mapping type, with the concept type in the document
+type typelessIndexRequest struct {
+	index       string
+	conceptType string
+	uuid        string
+	doc         interface{}
+}
+
+func (r *typelessIndexRequest) Source() ([]string, error) {
+	action, err := json.Marshal(map[string]map[string]string{"index": {"_index": r.index, "_id": r.uuid}})
+	if err != nil {
+		return nil, err
+	}
+	doc, err := withConceptType(r.doc, r.conceptType)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(action), string(doc)}, nil
+}
+
+func (r *typelessIndexRequest) String() string {
+	lines, err := r.Source()
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withConceptType returns the document with the concept type field
+func withConceptType(doc interface{}, conceptType string) (json.RawMessage, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		fields = make(map[string]json.RawMessage)
+	}
+
+	fields[conceptTypeDocField], _ = json.Marshal(conceptType)
+	return json.Marshal(fields)
+}
+
+// splitConceptType returns the concept type of a document source, and the source without it
+func splitConceptType(source json.RawMessage) (string, *json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(source, &fields); err != nil {
+		return "", nil, err
+	}
+
+	var conceptType string
+	if raw, found := fields[conceptTypeDocField]; found {
+		if err := json.Unmarshal(raw, &conceptType); err != nil {
+			return "", nil, err
+		}
+		delete(fields, conceptTypeDocField)
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", nil, err
+	}
+	stripped := json.RawMessage(data)
+	return conceptType, &stripped, nil
+}
@@ -0,0 +1,389 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// typelessESMock behaves as a typeless Elasticsearch 7 cluster holding a single index
+type typelessESMock struct {
+	sync.Mutex
+	docs          map[string]map[string]interface{}
+	seqNo         int64
+	seqNos        map[string]int64
+	mapping       map[string]interface{}
+	createdBody   map[string]interface{}
+	deleteParams  []string
+	clearedScroll bool
+	t             *testing.T
+	*httptest.Server
+}
+
+func newTypelessESMock(t *testing.T) *typelessESMock {
+	m := &typelessESMock{docs: make(map[string]map[string]interface{}), seqNos: make(map[string]int64), t: t}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	return m
+}
+
+func (m *typelessESMock) put(id string, doc map[string]interface{}) {
+	m.seqNo++
+	m.docs[id] = doc
+	m.seqNos[id] = m.seqNo
+}
+
+func (m *typelessESMock) doc(id string) map[string]interface{} {
+	m.Lock()
+	defer m.Unlock()
+	return m.docs[id]
+}
+
+func (m *typelessESMock) serve(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodHead:
+	case len(path) == 3 && path[0] == indexName && path[1] == typelessDocType:
+		m.document(w, r, path[2])
+	case len(path) == 3 && path[0] == indexName && path[1] == "_update":
+		m.update(w, r, path[2])
+	case len(path) == 2 && path[1] == "_search", r.URL.Path == "/_search/scroll" && r.Method == http.MethodPost:
+		m.search(w, r)
+	case r.URL.Path == "/_search/scroll" && r.Method == http.MethodDelete:
+		m.clearedScroll = true
+		fmt.Fprint(w, `{"succeeded":true}`)
+	case r.URL.Path == "/_bulk":
+		m.bulk(w, r)
+	case len(path) == 2 && path[1] == "_mapping" && r.Method == http.MethodGet:
+		if m.mapping == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error":{"type":"index_not_found_exception","reason":"no such index [%s]"},"status":404}`, path[0])
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{path[0] + "-v7": map[string]interface{}{"mappings": m.mapping}})
+	case len(path) == 2 && path[1] == "_mapping" && r.Method == http.MethodPut:
+		var update map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&update)
+		for name, property := range update["properties"].(map[string]interface{}) {
+			m.mapping["properties"].(map[string]interface{})[name] = property
+		}
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	case len(path) == 1 && r.Method == http.MethodPut:
+		json.NewDecoder(r.Body).Decode(&m.createdBody)
+		m.mapping = m.createdBody["mappings"].(map[string]interface{})
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *typelessESMock) document(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		doc, found := m.docs[id]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"_index":"%s","_type":"_doc","_id":"%s","found":false}`, indexName, id)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"_index": indexName, "_type": "_doc", "_id": id, "_version": 1, "_seq_no": m.seqNos[id], "_primary_term": 1, "found": true, "_source": doc,
+		})
+	case http.MethodPut:
+		_, found := m.docs[id]
+		query := r.URL.Query()
+		if query.Get("op_type") == "create" && found || query.Get("if_seq_no") != "" && query.Get("if_seq_no") != strconv.FormatInt(m.seqNos[id], 10) {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"error":{"type":"version_conflict_engine_exception","reason":"version conflict"},"status":409}`)
+			return
+		}
+		var doc map[string]interface{}
+		require.NoError(m.t, json.NewDecoder(r.Body).Decode(&doc))
+		m.put(id, doc)
+		result := "created"
+		if found {
+			result = "updated"
+		}
+		fmt.Fprintf(w, `{"_index":"%s","_type":"_doc","_id":"%s","_version":1,"result":"%s","_seq_no":%d,"_primary_term":1}`, indexName, id, result, m.seqNo)
+	case http.MethodDelete:
+		m.deleteParams = append(m.deleteParams, r.URL.RawQuery)
+		if _, found := m.docs[id]; !found {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"_index":"%s","_type":"_doc","_id":"%s","result":"not_found"}`, indexName, id)
+			return
+		}
+		if r.URL.Query().Get("if_seq_no") != strconv.FormatInt(m.seqNos[id], 10) {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"error":{"type":"version_conflict_engine_exception","reason":"version conflict"},"status":409}`)
+			return
+		}
+		delete(m.docs, id)
+		fmt.Fprintf(w, `{"_index":"%s","_type":"_doc","_id":"%s","_version":2,"result":"deleted"}`, indexName, id)
+	}
+}
+
+func (m *typelessESMock) update(w http.ResponseWriter, r *http.Request, id string) {
+	if r.URL.Query().Get("if_seq_no") != strconv.FormatInt(m.seqNos[id], 10) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"error":{"type":"version_conflict_engine_exception","reason":"version conflict"},"status":409}`)
+		return
+	}
+	var update struct {
+		Doc map[string]interface{} `json:"doc"`
+	}
+	require.NoError(m.t, json.NewDecoder(r.Body).Decode(&update))
+	for k, v := range update.Doc {
+		m.docs[id][k] = v
+	}
+	m.seqNo++
+	m.seqNos[id] = m.seqNo
+	fmt.Fprintf(w, `{"_index":"%s","_type":"_doc","_id":"%s","_version":2,"result":"updated"}`, indexName, id)
+}
+
+// search answers the ids queries, and scrolls all the documents in a single page
+func (m *typelessESMock) search(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("rest_total_hits_as_int") != "true" {
+		fmt.Fprint(w, `{"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`)
+		return
+	}
+
+	var body struct {
+		Query struct {
+			Ids *struct {
+				Values []string `json:"values"`
+			} `json:"ids"`
+		} `json:"query"`
+		Source   interface{} `json:"_source"`
+		ScrollID string      `json:"scroll_id"`
+	}
+	require.NoError(m.t, json.NewDecoder(r.Body).Decode(&body))
+
+	var ids []string
+	switch {
+	case body.ScrollID != "":
+	case body.Query.Ids != nil:
+		ids = body.Query.Ids.Values
+	default:
+		for id := range m.docs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+
+	var hits []map[string]interface{}
+	for _, id := range ids {
+		doc, found := m.docs[id]
+		if !found {
+			continue
+		}
+		source := doc
+		if body.Source == conceptTypeDocField {
+			source = map[string]interface{}{conceptTypeDocField: doc[conceptTypeDocField]}
+		}
+		hits = append(hits, map[string]interface{}{"_index": indexName, "_type": "_doc", "_id": id, "_source": source})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"_scroll_id": "scroll-1", "hits": map[string]interface{}{"total": len(hits), "hits": hits}})
+}
+
+func (m *typelessESMock) bulk(w http.ResponseWriter, r *http.Request) {
+	var items []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		action := map[string]map[string]string{}
+		doc := map[string]interface{}{}
+		if json.Unmarshal(scanner.Bytes(), &action) != nil || !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &doc) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		for op, meta := range action {
+			if _, typed := meta["_type"]; typed {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":{"type":"illegal_argument_exception","reason":"Action/metadata line [1] contains an unknown parameter [_type]"},"status":400}`)
+				return
+			}
+			switch op {
+			case "index":
+				m.put(meta["_id"], doc)
+			case "update":
+				for k, v := range doc["doc"].(map[string]interface{}) {
+					m.docs[meta["_id"]][k] = v
+				}
+			}
+			items = append(items, fmt.Sprintf(`{"%s":{"_index":"%s","_type":"_doc","_id":"%s","status":200}}`, op, meta["_index"], meta["_id"]))
+		}
+	}
+	fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+}
+
+const typelessUUID = "8ae6b5a4-a6f8-4d5e-9a3e-2cc8d2b1b6e2"
+
+func newTypelessTestService(t *testing.T, url string) *esService {
+	return &esService{elasticClient: getElasticClient(t, url), indexName: indexName, getCurrentTime: time.Now, backend: TypelessBackend}
+}
+
+func TestParseBackend(t *testing.T) {
+	backend, err := ParseBackend("")
+	require.NoError(t, err)
+	assert.Equal(t, TypedBackend, backend)
+	backend, err = ParseBackend("typeless")
+	require.NoError(t, err)
+	assert.Equal(t, TypelessBackend, backend)
+	_, err = ParseBackend("v7")
+	assert.EqualError(t, err, `unknown backend "v7", expected v5 or typeless`)
+}
+
+func TestTypelessWriteReadDelete(t *testing.T) {
+	m := newTypelessESMock(t)
+	defer m.Close()
+	es := newTypelessTestService(t, m.URL)
+
+	payload, updated, resp, err := writeTestDocument(es, "people", typelessUUID)
+	require.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, "people", resp.Type)
+	assert.Equal(t, "people", m.doc(typelessUUID)[conceptTypeDocField], "the concept type should be stored in the document")
+
+	read, err := es.ReadData(context.Background(), "people", typelessUUID)
+	require.NoError(t, err)
+	require.True(t, read.Found)
+	assert.Equal(t, "people", read.Type)
+	var source map[string]interface{}
+	require.NoError(t, json.Unmarshal(*read.Source, &source))
+	assert.NotContains(t, source, conceptTypeDocField, "the concept type should be removed from the source")
+	assert.Equal(t, payload.PrefLabel, source["prefLabel"])
+
+	read, err = es.ReadData(context.Background(), organisationsType, typelessUUID)
+	require.NoError(t, err)
+	assert.False(t, read.Found, "the concept should not be found with another type")
+
+	_, err = es.documents().Update(context.Background(), indexName, organisationsType, typelessUUID, map[string]interface{}{"prefLabel": "Other"})
+	assert.True(t, elastic.IsNotFound(err), "the concept should not be updated with another type")
+	updateResp, err := es.documents().Update(context.Background(), indexName, "people", typelessUUID, map[string]interface{}{"prefLabel": "Updated"})
+	require.NoError(t, err)
+	assert.Equal(t, "people", updateResp.Type)
+	assert.Equal(t, "Updated", m.doc(typelessUUID)["prefLabel"])
+	assert.Equal(t, "people", m.doc(typelessUUID)[conceptTypeDocField])
+
+	deleted, err := es.DeleteData(newTestContext(), organisationsType, typelessUUID)
+	require.NoError(t, err)
+	assert.False(t, deleted.Found)
+	assert.NotNil(t, m.doc(typelessUUID), "the concept should not be deleted with another type")
+
+	deleted, err = es.DeleteData(newTestContext(), "people", typelessUUID)
+	require.NoError(t, err)
+	assert.True(t, deleted.Found)
+	assert.Nil(t, m.doc(typelessUUID))
+	assert.Equal(t, []string{"if_primary_term=1&if_seq_no=2"}, m.deleteParams, "the delete should be conditional on the document checked")
+}
+
+func TestTypelessWriteWithAnotherType(t *testing.T) {
+	m := newTypelessESMock(t)
+	defer m.Close()
+	es := newTypelessTestService(t, m.URL)
+
+	_, _, _, err := writeTestDocument(es, "people", typelessUUID)
+	require.NoError(t, err)
+
+	_, _, _, err = writeTestDocument(es, organisationsType, typelessUUID)
+	var conflictErr *ConceptTypeConflictError
+	require.True(t, errors.As(err, &conflictErr), "the concept should not be replaced by a concept of another type")
+	assert.Equal(t, "people", conflictErr.StoredType)
+	assert.Equal(t, organisationsType, conflictErr.ConceptType)
+	assert.Equal(t, "people", m.doc(typelessUUID)[conceptTypeDocField])
+
+	resp, err := es.documents().Index(context.Background(), indexName, "people", typelessUUID, map[string]interface{}{"prefLabel": "Updated"})
+	require.NoError(t, err)
+	assert.Equal(t, "updated", resp.Result)
+	assert.Equal(t, "people", resp.Type)
+	assert.Equal(t, "Updated", m.doc(typelessUUID)["prefLabel"])
+}
+
+func TestTypelessFindConceptTypesAndIds(t *testing.T) {
+	m := newTypelessESMock(t)
+	defer m.Close()
+	m.put("1", map[string]interface{}{"id": "1", conceptTypeDocField: "people"})
+	m.put("2", map[string]interface{}{"id": "2", conceptTypeDocField: organisationsType})
+	es := newTypelessTestService(t, m.URL)
+
+	types, err := es.findConceptTypes(context.Background(), []string{"1", "2", "3"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"1": "people", "2": organisationsType}, types)
+
+	var ids []EsIDTypePair
+	for id := range es.GetAllIds(context.Background()) {
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []EsIDTypePair{{ID: "1", Type: "people"}, {ID: "2", Type: organisationsType}}, ids)
+	m.Lock()
+	assert.True(t, m.clearedScroll, "the scroll should be cleared")
+	m.Unlock()
+}
+
+func TestTypelessBulkRequests(t *testing.T) {
+	m := newTypelessESMock(t)
+	defer m.Close()
+	es := newTypelessTestService(t, m.URL)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1000000, time.Second)
+	es.bulkProcessorConfig = &bulkProcessorConfig
+	require.NoError(t, es.resetBulkProcessor())
+
+	es.LoadBulkData(context.Background(), "people", typelessUUID, EsConceptModel{Id: typelessUUID, PrefLabel: "Test"})
+	require.NoError(t, es.bulkProcessor.Flush())
+	doc := m.doc(typelessUUID)
+	require.NotNil(t, doc, "the bulk index request should be accepted")
+	assert.Equal(t, "people", doc[conceptTypeDocField])
+	assert.Equal(t, "Test", doc["prefLabel"])
+
+	es.PatchUpdateConcept(context.Background(), "people", typelessUUID, &EsConceptModelPatch{Metrics: &ConceptMetrics{AnnotationsCount: 3}})
+	require.NoError(t, es.bulkProcessor.Close())
+	assert.Equal(t, "people", m.doc(typelessUUID)[conceptTypeDocField], "the concept type should be unchanged by a patch")
+	assert.NotNil(t, m.doc(typelessUUID)["metrics"])
+}
+
+func TestTypelessMapping(t *testing.T) {
+	m := newTypelessESMock(t)
+	defer m.Close()
+	es := newTypelessTestService(t, m.URL)
+
+	status, err := es.CheckMapping(context.Background(), "")
+	require.NoError(t, err)
+	assert.False(t, status.Exists)
+	assert.Empty(t, status.MissingTypes, "a typeless index has no types")
+
+	status, err = es.ApplyMapping(context.Background(), "")
+	require.NoError(t, err)
+	assert.True(t, status.InSync())
+	assert.Equal(t, map[string]interface{}{}, m.createdBody["settings"], "typeless clusters refuse the mapper settings")
+	properties := m.mapping["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"type": "keyword"}, properties[conceptTypeDocField])
+	assert.Contains(t, properties, "isFTAuthor", "the fields of every type should be merged")
+	assert.Equal(t, false, m.mapping["dynamic"])
+
+	delete(properties, "isFTAuthor")
+	status, err = es.CheckMapping(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"isFTAuthor"}, status.MissingFields)
+
+	status, err = es.ApplyMapping(context.Background(), "")
+	require.NoError(t, err)
+	assert.True(t, status.InSync())
+	assert.Contains(t, properties, "isFTAuthor")
+}
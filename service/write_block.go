@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Financial-Times/go-logger"
+	"github.com/rcrowley/go-metrics"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const (
+	// DefaultWriteBlockCheckInterval is how frequently the write block of the index is checked by default
+	DefaultWriteBlockCheckInterval = 30 * time.Second
+	// DefaultMaxParkedWrites is the default number of bulk requests kept while the index is blocked for writes
+	DefaultMaxParkedWrites = 100000
+)
+
+var (
+	parkedWrites        = metrics.GetOrRegisterCounter("concept.write.parked", metrics.DefaultRegistry)
+	droppedParkedWrites = metrics.GetOrRegisterCounter("concept.write.parked.dropped", metrics.DefaultRegistry)
+)
+
+// WriteBlockedError is returned by the writes refused while the index is blocked for writes, e.g. during a reindex
+type WriteBlockedError struct {
+	// RetryAfter is when the write block is checked again
+	RetryAfter time.Duration
+}
+
+func (e *WriteBlockedError) Error() string {
+	return fmt.Sprintf("the index is blocked for writes, retry after %v", e.RetryAfter)
+}
+
+// writeBlock caches whether the index is blocked for writes, and parks the bulk requests sent meanwhile.
+// The parked requests are sent to the bulk processor in order once the block is lifted. Sync writes are refused
+// until every parked request has been sent, so that an older parked request cannot overwrite a newer write.
+type writeBlock struct {
+	sync.Mutex
+	checkInterval time.Duration
+	maxParked     int
+
+	blocked  bool
+	draining bool
+	parked   []elastic.BulkableRequest
+}
+
+// WithWriteBlockCheck sets how frequently the write block of the index is checked,
+// and how many bulk requests are parked at most while it is blocked. A zero interval disables the check.
+func WithWriteBlockCheck(checkInterval time.Duration, maxParked int) EsServiceOption {
+	return func(es *esService) {
+		if checkInterval <= 0 {
+			es.writeBlock = nil
+			return
+		}
+		es.writeBlock = &writeBlock{checkInterval: checkInterval, maxParked: maxParked}
+	}
+}
+
+func newWriteBlock() *writeBlock {
+	return &writeBlock{checkInterval: DefaultWriteBlockCheckInterval, maxParked: DefaultMaxParkedWrites}
+}
+
+// refuses tells whether sync writes are refused, i.e. the index is blocked or the parked requests are being sent
+func (b *writeBlock) refuses() bool {
+	if b == nil {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	return b.blocked || b.draining
+}
+
+func (b *writeBlock) err() error {
+	return &WriteBlockedError{RetryAfter: b.checkInterval}
+}
+
+func (b *writeBlock) block() {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.blocked {
+		log.Warn("The index is blocked for writes, bulk requests are parked until the block is lifted")
+	}
+	b.blocked = true
+}
+
+// unblock lifts the block, and tells whether there are parked requests to send
+func (b *writeBlock) unblock() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.blocked {
+		log.WithField("parked", len(b.parked)).Info("The index accepts writes again")
+	}
+	b.blocked = false
+	if len(b.parked) == 0 || b.draining {
+		return false
+	}
+	b.draining = true
+	return true
+}
+
+// park keeps the request if the index is blocked or the parked requests are being sent, and tells whether it did
+func (b *writeBlock) park(r elastic.BulkableRequest) bool {
+	if b == nil {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.blocked && !b.draining {
+		return false
+	}
+	b.add(r)
+	return true
+}
+
+// add must be called with the lock held
+func (b *writeBlock) add(requests ...elastic.BulkableRequest) {
+	for _, r := range requests {
+		if b.keep(r) {
+			parkedWrites.Inc(1)
+		}
+	}
+}
+
+// keep must be called with the lock held
+func (b *writeBlock) keep(r elastic.BulkableRequest) bool {
+	if len(b.parked) >= b.maxParked {
+		droppedParkedWrites.Inc(1)
+		log.WithField("request", r.String()).Error("Too many parked bulk requests, the request is dropped")
+		return false
+	}
+	b.parked = append(b.parked, r)
+	return true
+}
+
+// repark blocks the index and parks requests which failed because of the block.
+// They are older than the requests parked meanwhile, so they are put first.
+func (b *writeBlock) repark(requests []elastic.BulkableRequest) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.blocked = true
+	newer := b.parked
+	b.parked = nil
+	b.add(requests...)
+	for _, r := range newer {
+		b.keep(r)
+	}
+}
+
+// next returns the parked requests to send, or nothing once they have all been sent or the index is blocked again
+func (b *writeBlock) next() []elastic.BulkableRequest {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.blocked || len(b.parked) == 0 {
+		b.draining = false
+		return nil
+	}
+	requests := b.parked
+	b.parked = nil
+	return requests
+}
+
+// parkedCount returns the number of parked requests
+func (b *writeBlock) parkedCount() int {
+	if b == nil {
+		return 0
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	return len(b.parked)
+}
+
+// addToBulkProcessor sends a request to the bulk processor of its workload, or parks it while the index is blocked for writes.
+// It must be called with the lock held.
+func (es *esService) addToBulkProcessor(workload Workload, r elastic.BulkableRequest) error {
+	lane := es.laneOf(workload)
+	if es.writeBlock.park(lane.parked(r)) {
+		return nil
+	}
+	return es.enqueue(lane, r)
+}
+
+// checkWriteBlock returns an error if sync writes are refused because the index is blocked for writes
+func (es *esService) checkWriteBlock() error {
+	if es.writeBlock.refuses() {
+		return es.writeBlock.err()
+	}
+	return nil
+}
+
+// writeBlockError records the block if the write failed because of it, and returns the error to report
+func (es *esService) writeBlockError(err error) error {
+	if es.writeBlock == nil || !IsWriteBlockError(err) {
+		return err
+	}
+	es.writeBlock.block()
+	return es.writeBlock.err()
+}
+
+// RefreshWriteBlock checks whether the index is blocked for writes, and sends the parked requests if it is not
+func (es *esService) RefreshWriteBlock(ctx context.Context) error {
+	if es.writeBlock == nil {
+		return nil
+	}
+
+	readOnly, _, err := es.IsIndexReadOnly(ctx)
+	if err != nil {
+		return err
+	}
+
+	if readOnly {
+		es.writeBlock.block()
+		return nil
+	}
+	if es.writeBlock.unblock() {
+		es.drainParkedRequests()
+	}
+	return nil
+}
+
+func (es *esService) drainParkedRequests() {
+	es.RLock()
+	defer es.RUnlock()
+
+	sent := 0
+	for requests := es.writeBlock.next(); requests != nil; requests = es.writeBlock.next() {
+		for _, r := range requests {
+			lane, request := unpark(r)
+			if err := es.enqueue(lane, request); err != nil {
+				droppedParkedWrites.Inc(1)
+				log.WithError(err).WithField("request", request.String()).Error("Failed to send the parked bulk request, the request is dropped")
+				continue
+			}
+			sent++
+		}
+	}
+	log.WithField("sent", sent).Info("Parked bulk requests sent")
+}
+
+// watchWriteBlock refreshes the write block at every check interval, until the context is done
+func (es *esService) watchWriteBlock(ctx context.Context) {
+	ticker := time.NewTicker(es.writeBlock.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := es.RefreshWriteBlock(ctx); err != nil && err != ErrNoElasticClient {
+				log.WithError(err).Warn("Failed to check whether the index is blocked for writes")
+			}
+		}
+	}
+}
+
+// afterBulk parks the requests of the default bulk processor which failed because the index is blocked for writes,
+// and logs the other failures
+func (es *esService) afterBulk(executionID int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	es.pendingBulk.add(-len(requests))
+	es.handleBulkOutcome(nil, executionID, requests, response, err)
+}
+
+// afterLaneBulk returns the after function of the bulk processor of the lane
+func (es *esService) afterLaneBulk(lane *bulkLane) elastic.BulkAfterFunc {
+	return func(executionID int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+		lane.pending.add(-len(requests))
+		es.handleBulkOutcome(lane, executionID, requests, response, err)
+	}
+}
+
+// handleBulkOutcome parks the requests which failed because the index is blocked for writes, so that they are sent again
+// by the bulk processor of their lane, and logs the other failures
+func (es *esService) handleBulkOutcome(lane *bulkLane, executionID int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	es.recordPairedOutcomes(requests, response, err)
+	if err == nil {
+		es.publishBulkChanges(requests, response)
+	}
+	if es.writeBlock == nil {
+		handleBulkFailures(executionID, requests, response, err)
+		return
+	}
+
+	if err != nil {
+		if IsWriteBlockError(err) {
+			// the block of the secondary index does not hold the writes back
+			primaries := primaryRequests(requests)
+			es.writeBlock.repark(lane.parkedAll(primaries))
+			log.WithField("parked", len(primaries)).Warn("Bulk request refused while the index is blocked for writes, the requests are parked")
+			return
+		}
+		handleBulkFailures(executionID, requests, response, err)
+		return
+	}
+
+	var blocked []elastic.BulkableRequest
+	for i, item := range response.Items {
+		if i < len(requests) {
+			if _, secondary := requests[i].(*secondaryRequest); secondary {
+				// the block of the secondary index does not hold the writes back
+				continue
+			}
+		}
+		for _, result := range item {
+			if result.Error != nil && result.Error.Type == clusterBlockException && i < len(requests) {
+				blocked = append(blocked, requests[i])
+			}
+		}
+	}
+	if len(blocked) > 0 {
+		es.writeBlock.repark(lane.parkedAll(blocked))
+		log.WithField("parked", len(blocked)).Warn("Bulk requests refused while the index is blocked for writes, the requests are parked")
+	}
+	handleBulkFailures(executionID, requests, response, nil)
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+const clusterBlockResponse = `{"error":{"type":"cluster_block_exception","reason":"blocked by: [FORBIDDEN/8/index write (api)];"},"status":403}`
+
+// writeBlockESMock is an elasticsearch whose index can be blocked for writes. It records the ids of the bulk requests it accepts.
+type writeBlockESMock struct {
+	sync.Mutex
+	blocked bool
+	writes  int
+	bulkIDs []string
+	*httptest.Server
+}
+
+func newWriteBlockESMock(blocked bool) *writeBlockESMock {
+	m := &writeBlockESMock{blocked: blocked}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	return m
+}
+
+func (m *writeBlockESMock) serve(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodHead:
+		return
+	case strings.HasSuffix(r.URL.Path, "/_settings"):
+		fmt.Fprintf(w, `{"%s":{"settings":{"index":{"blocks":{"write":"%t"}}}}}`, indexName, m.blocked)
+		return
+	}
+
+	m.writes++
+	if r.URL.Path != "/_bulk" {
+		if m.blocked {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, clusterBlockResponse)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// a blocked index refuses each item of a bulk request
+	var items []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		action := map[string]map[string]string{}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil || action["index"] == nil {
+			continue
+		}
+		id := action["index"]["_id"]
+		if m.blocked {
+			items = append(items, fmt.Sprintf(`{"index":{"_index":"%s","_id":"%s","status":403,"error":{"type":"cluster_block_exception"}}}`, indexName, id))
+			continue
+		}
+		m.bulkIDs = append(m.bulkIDs, id)
+		items = append(items, fmt.Sprintf(`{"index":{"_index":"%s","_id":"%s","status":201}}`, indexName, id))
+	}
+	fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[%s]}`, m.blocked, strings.Join(items, ","))
+}
+
+func (m *writeBlockESMock) setBlocked(blocked bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.blocked = blocked
+}
+
+func (m *writeBlockESMock) writeCount() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.writes
+}
+
+func (m *writeBlockESMock) receivedIDs() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string(nil), m.bulkIDs...)
+}
+
+func newWriteBlockTestService(t *testing.T, url string) *esService {
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 1, 1, time.Second)
+	ec := getElasticClient(t, url)
+	es := &esService{
+		elasticClient:       ec,
+		indexName:           indexName,
+		bulkProcessorConfig: &bulkProcessorConfig,
+		getCurrentTime:      time.Now,
+		writeBlock:          &writeBlock{checkInterval: 10 * time.Second, maxParked: 10},
+	}
+	require.NoError(t, es.resetBulkProcessor(), "require a bulk processor")
+	t.Cleanup(func() { es.bulkProcessor.Close() })
+	return es
+}
+
+func waitForIDs(t *testing.T, m *writeBlockESMock, expected []string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.receivedIDs()) < len(expected) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, expected, m.receivedIDs())
+}
+
+func TestLoadDataRefusedWhileWriteBlocked(t *testing.T) {
+	m := newWriteBlockESMock(true)
+	defer m.Close()
+	es := newWriteBlockTestService(t, m.URL)
+
+	_, _, _, err := writeTestDocument(es, organisationsType, "a8e6da3e-1a88-4d3b-9a4a-8a8c1c0a6b7e")
+	var blockedErr *WriteBlockedError
+	require.True(t, errors.As(err, &blockedErr), "the write block should be reported")
+	assert.Equal(t, 10*time.Second, blockedErr.RetryAfter)
+	writes := m.writeCount()
+
+	_, _, _, err = writeTestDocument(es, organisationsType, "a8e6da3e-1a88-4d3b-9a4a-8a8c1c0a6b7e")
+	assert.IsType(t, &WriteBlockedError{}, err)
+	assert.Equal(t, writes, m.writeCount(), "writes should be refused without calling ES while the index is blocked")
+
+	_, err = es.DeleteData(newTestContext(), organisationsType, "a8e6da3e-1a88-4d3b-9a4a-8a8c1c0a6b7e")
+	assert.IsType(t, &WriteBlockedError{}, err)
+}
+
+func TestBulkRequestsParkedWhileWriteBlocked(t *testing.T) {
+	m := newWriteBlockESMock(true)
+	defer m.Close()
+	es := newWriteBlockTestService(t, m.URL)
+
+	require.NoError(t, es.RefreshWriteBlock(context.Background()))
+	for _, id := range []string{"1", "2", "3"} {
+		es.LoadBulkData(context.Background(), organisationsType, id, map[string]string{"id": id})
+	}
+	assert.Equal(t, 3, es.writeBlock.parkedCount())
+	assert.Equal(t, 0, m.writeCount(), "no bulk request should be sent while the index is blocked")
+
+	m.setBlocked(false)
+	require.NoError(t, es.RefreshWriteBlock(context.Background()))
+
+	waitForIDs(t, m, []string{"1", "2", "3"})
+	assert.Equal(t, 0, es.writeBlock.parkedCount())
+	assert.NoError(t, es.checkWriteBlock(), "writes should be accepted once the parked requests are sent")
+}
+
+func TestBulkRequestsParkedWhenRefusedByES(t *testing.T) {
+	m := newWriteBlockESMock(true)
+	defer m.Close()
+	es := newWriteBlockTestService(t, m.URL)
+
+	es.LoadBulkData(context.Background(), organisationsType, "1", map[string]string{"id": "1"})
+	deadline := time.Now().Add(5 * time.Second)
+	for es.writeBlock.parkedCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, es.writeBlock.parkedCount(), "the refused request should be parked")
+	assert.IsType(t, &WriteBlockedError{}, es.checkWriteBlock())
+
+	m.setBlocked(false)
+	require.NoError(t, es.RefreshWriteBlock(context.Background()))
+	waitForIDs(t, m, []string{"1"})
+}
+
+func TestAfterBulkReparksBlockedItemsFirst(t *testing.T) {
+	es := &esService{writeBlock: &writeBlock{checkInterval: time.Second, maxParked: 10}}
+	newer := elastic.NewBulkIndexRequest().Id("newer")
+	es.writeBlock.blocked = true
+	require.True(t, es.writeBlock.park(newer))
+
+	accepted := elastic.NewBulkIndexRequest().Id("accepted")
+	refused := elastic.NewBulkIndexRequest().Id("refused")
+	response := &elastic.BulkResponse{
+		Errors: true,
+		Items: []map[string]*elastic.BulkResponseItem{
+			{"index": {Id: "accepted", Status: http.StatusCreated}},
+			{"index": {Id: "refused", Status: http.StatusForbidden, Error: &elastic.ErrorDetails{Type: clusterBlockException}}},
+		},
+	}
+	es.afterBulk(1, []elastic.BulkableRequest{accepted, refused}, response, nil)
+
+	assert.Equal(t, []elastic.BulkableRequest{refused, newer}, es.writeBlock.parked)
+}
+
+func TestParkedWritesLimit(t *testing.T) {
+	b := &writeBlock{checkInterval: time.Second, maxParked: 2, blocked: true}
+	dropped := droppedParkedWrites.Count()
+
+	for _, id := range []string{"1", "2", "3"} {
+		assert.True(t, b.park(elastic.NewBulkIndexRequest().Id(id)))
+	}
+
+	assert.Equal(t, 2, b.parkedCount())
+	assert.Equal(t, dropped+1, droppedParkedWrites.Count())
+}
+
+func TestWriteBlockDisabled(t *testing.T) {
+	es := &esService{}
+	WithWriteBlockCheck(0, DefaultMaxParkedWrites)(es)
+
+	assert.Nil(t, es.writeBlock)
+	assert.NoError(t, es.checkWriteBlock())
+	assert.NoError(t, es.RefreshWriteBlock(context.Background()))
+	assert.False(t, es.writeBlock.park(elastic.NewBulkIndexRequest()))
+}
+
+func TestCloseStopsWriteBlockCheck(t *testing.T) {
+	ecc := make(chan *elastic.Client)
+	close(ecc)
+	bulkProcessorConfig := NewBulkProcessorConfig(1, 100, 1000000, time.Hour)
+	es := NewEsService(ecc, indexName, &bulkProcessorConfig, WithStorage(NewMemoryStorage(indexName)), WithWriteBlockCheck(time.Millisecond, 10)).(*esService)
+
+	closed := make(chan error)
+	go func() { closed <- es.CloseBulkProcessor() }()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the write block check should be stopped")
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// WritePlan describes every change a write makes to the index. It is computed by reading the current
+// state of the index only, so it can be returned to the client instead of being applied.
+type WritePlan struct {
+	// Dropped is true when the concept is ignored, e.g. memberships which do not make a person an FT author
+	Dropped bool `json:"dropped"`
+	// Unchanged is true when the stored document already has the same content, so nothing is written
+	Unchanged bool            `json:"unchanged"`
+	Index     *PlannedIndex   `json:"index,omitempty"`
+	Patches   []PlannedPatch  `json:"patches,omitempty"`
+	Deletes   []PlannedDelete `json:"deletes,omitempty"`
+
+	current *elastic.GetResult // the stored document the plan has been computed against
+}
+
+// PlannedIndex is a document which replaces the stored one
+type PlannedIndex struct {
+	ConceptType string  `json:"conceptType"`
+	UUID        string  `json:"uuid"`
+	Document    EsModel `json:"document"`
+}
+
+// PlannedPatch is a partial update of a stored document
+type PlannedPatch struct {
+	ConceptType string       `json:"conceptType"`
+	UUID        string       `json:"uuid"`
+	Patch       PayloadPatch `json:"patch"`
+}
+
+// PlannedDelete is the removal of a stored document
+type PlannedDelete struct {
+	ConceptType string `json:"conceptType"`
+	UUID        string `json:"uuid"`
+}
+
+// PlanBulkLoad returns the plan of a bulk write, which indexes the payload as it is
+func PlanBulkLoad(conceptType string, uuid string, payload EsModel) *WritePlan {
+	return &WritePlan{Index: &PlannedIndex{ConceptType: conceptType, UUID: uuid, Document: payload}}
+}
+
+// PlanLoadData works out what LoadData would write for the given payload, without writing anything
+func (es *esService) PlanLoadData(ctx context.Context, conceptType string, uuid string, payload EsModel) (*WritePlan, error) {
+	loadDataLog := newWriteLog(ctx, conceptType, uuid)
+
+	es.RLock()
+	defer es.RUnlock()
+
+	if err := es.checkStorage(); err != nil {
+		loadDataLog.WithError(err).WithField(statusField, unknownStatus).Error("Failed operation to Elasticsearch")
+		return nil, err
+	}
+
+	return es.planLoadData(ctx, loadDataLog, conceptType, uuid, payload)
+}
+
+func (es *esService) planLoadData(ctx context.Context, loadDataLog *logrus.Entry, conceptType string, uuid string, payload EsModel) (*WritePlan, error) {
+	plan := &WritePlan{}
+
+	var readResult *elastic.GetResult
+	var err error
+	// Check if membership is FT
+	if conceptType == memberships {
+		emm := payload.(*EsMembershipModel)
+		if emm.OrganisationId != ftOrgUUID || len(emm.Memberships) < 1 || !isFtAuthor(emm.Memberships) { // drop as not FT Author
+			plan.Dropped = true
+			return plan, nil
+		}
+		readResult, err = es.readData(ctx, person, emm.PersonId)
+		uuid = emm.PersonId // membership is for person
+	} else {
+		readResult, err = es.readData(ctx, conceptType, uuid)
+	}
+	plan.current = readResult
+
+	patchData := getPatchData(err, loadDataLog, conceptType, readResult)
+
+	if conceptType != memberships {
+		if doc, ok := payload.(contentHashed); ok {
+			hash, hashErr := contentHash(payload)
+			if hashErr != nil {
+				loadDataLog.WithError(hashErr).Warn("Failed to compute the content hash of the concept")
+			} else {
+				doc.setContentHash(hash)
+				if err == nil && hasContentHash(readResult, hash) {
+					loadDataLog.Debug("Concept unchanged, skipping write")
+					plan.Unchanged = true
+					return plan, nil
+				}
+			}
+		}
+		plan.Index = &PlannedIndex{ConceptType: conceptType, UUID: uuid, Document: payload}
+		if patchData != nil {
+			plan.Patches = append(plan.Patches, PlannedPatch{ConceptType: conceptType, UUID: uuid, Patch: patchData})
+		}
+		return plan, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !readResult.Found {
+		//we write a dummy person
+		p := EsPersonConceptModel{
+			EsConceptModel: &EsConceptModel{
+				Id:           uuid,
+				LastModified: es.getCurrentTime().Format(time.RFC3339),
+			},
+			IsFTAuthor: "true",
+		}
+		logDebugPersonData(loadDataLog, &p, "Dummy person for membership")
+		plan.Index = &PlannedIndex{ConceptType: person, UUID: uuid, Document: p}
+		return plan, nil
+	}
+
+	if patchData != nil {
+		// `patchData` is for a person
+		plan.Patches = append(plan.Patches, PlannedPatch{ConceptType: person, UUID: uuid, Patch: patchData})
+	}
+	return plan, nil
+}
+
+// PlanCleanupData works out which concorded concepts CleanupData would delete, without deleting them
+func (es *esService) PlanCleanupData(ctx context.Context, concept Concept) ([]PlannedDelete, error) {
+	conceptTypeMap, err := es.findConceptTypes(ctx, concept.ConcordedUUIDs())
+	if err != nil {
+		return nil, err
+	}
+
+	var deletes []PlannedDelete
+	for concordedUUID, conceptType := range conceptTypeMap {
+		deletes = append(deletes, PlannedDelete{ConceptType: conceptType, UUID: concordedUUID})
+	}
+	sort.Slice(deletes, func(i, j int) bool {
+		return deletes[i].UUID < deletes[j].UUID
+	})
+	return deletes, nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+func TestPlanLoadDataPreservesPatchableData(t *testing.T) {
+	testUUID := uuid.NewV4().String()
+	es := newGetESMock(map[string]string{
+		testUUID: `{"prefLabel":"Old label","isFTAuthor":"true","metrics":{"annotationsCount":10,"prevWeekAnnotationsCount":1}}`,
+	})
+	defer es.Close()
+
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now}
+	payload := &EsPersonConceptModel{EsConceptModel: &EsConceptModel{PrefLabel: "New label"}, IsFTAuthor: "false"}
+
+	plan, err := service.PlanLoadData(newTestContext(), person, testUUID, payload)
+	require.NoError(t, err)
+
+	assert.False(t, plan.Dropped)
+	assert.Equal(t, &PlannedIndex{ConceptType: person, UUID: testUUID, Document: payload}, plan.Index)
+	assert.Equal(t, []PlannedPatch{{
+		ConceptType: person,
+		UUID:        testUUID,
+		Patch:       &EsPersonConceptPatch{Metrics: &ConceptMetrics{AnnotationsCount: 10, PrevWeekAnnotationsCount: 1}, IsFTAuthor: "true"},
+	}}, plan.Patches)
+}
+
+func TestPlanLoadDataForNewConcept(t *testing.T) {
+	es := newGetESMock(map[string]string{})
+	defer es.Close()
+
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now}
+	testUUID := uuid.NewV4().String()
+	payload := &EsConceptModel{PrefLabel: "Label"}
+
+	plan, err := service.PlanLoadData(newTestContext(), organisationsType, testUUID, payload)
+	require.NoError(t, err)
+
+	assert.Equal(t, &PlannedIndex{ConceptType: organisationsType, UUID: testUUID, Document: payload}, plan.Index)
+	assert.Empty(t, plan.Patches)
+}
+
+func TestPlanLoadDataDropsNonFTMemberships(t *testing.T) {
+	es := newGetESMock(map[string]string{})
+	defer es.Close()
+
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: time.Now}
+	membership := &EsMembershipModel{Id: uuid.NewV4().String(), PersonId: uuid.NewV4().String(), OrganisationId: uuid.NewV4().String(), Memberships: []string{journalistUUID}}
+
+	plan, err := service.PlanLoadData(newTestContext(), memberships, membership.Id, membership)
+	require.NoError(t, err)
+
+	assert.Equal(t, &WritePlan{Dropped: true}, plan)
+}
+
+func TestPlanLoadDataForFTMembership(t *testing.T) {
+	knownPerson := uuid.NewV4().String()
+	es := newGetESMock(map[string]string{
+		knownPerson: `{"prefLabel":"Person","isFTAuthor":"false"}`,
+	})
+	defer es.Close()
+
+	now := time.Now()
+	service := &esService{elasticClient: getElasticClient(t, es.URL), indexName: indexName, getCurrentTime: func() time.Time { return now }}
+
+	unknownPerson := uuid.NewV4().String()
+	membership := &EsMembershipModel{Id: uuid.NewV4().String(), PersonId: unknownPerson, OrganisationId: ftOrgUUID, Memberships: []string{journalistUUID}}
+	plan, err := service.PlanLoadData(newTestContext(), memberships, membership.Id, membership)
+	require.NoError(t, err)
+
+	assert.Equal(t, &PlannedIndex{
+		ConceptType: person,
+		UUID:        unknownPerson,
+		Document: EsPersonConceptModel{
+			EsConceptModel: &EsConceptModel{Id: unknownPerson, LastModified: now.Format(time.RFC3339)},
+			IsFTAuthor:     "true",
+		},
+	}, plan.Index, "a dummy person should be written for an unknown person")
+	assert.Empty(t, plan.Patches)
+
+	membership.PersonId = knownPerson
+	plan, err = service.PlanLoadData(newTestContext(), memberships, membership.Id, membership)
+	require.NoError(t, err)
+
+	assert.Nil(t, plan.Index)
+	assert.Equal(t, []PlannedPatch{{ConceptType: person, UUID: knownPerson, Patch: &EsPersonConceptPatch{IsFTAuthor: "true"}}}, plan.Patches)
+}
+
+func TestPlanLoadDataWithoutElasticClient(t *testing.T) {
+	service := &esService{indexName: indexName, getCurrentTime: time.Now}
+
+	_, err := service.PlanLoadData(newTestContext(), organisationsType, uuid.NewV4().String(), &EsConceptModel{})
+	assert.Equal(t, ErrNoElasticClient, err)
+}
+
+// newGetESMock serves the given documents, by uuid, to get requests
+func newGetESMock(docs map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		id := path[len(path)-1]
+		result := elastic.GetResult{Index: indexName, Type: path[1], Id: id}
+		if doc, found := docs[id]; found {
+			source := json.RawMessage(doc)
+			result.Found = true
+			result.Source = &source
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+}